@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/secretsync"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const usage = `Usage:
+  cert-manager-sync               run the operator
+  cert-manager-sync sync [flags]  sync secrets once, print the result of each target and exit
+  cert-manager-sync plan [flags]  print what a sync would do to each target, without changing anything
+
+Run "cert-manager-sync <command> -h" for the flags of a command.
+`
+
+// runCommand runs the one-shot command named by args[0] and returns the exit
+// code of the process.
+func runCommand(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "sync":
+		return runSync(ctx, args[1:], stdout, stderr)
+	case "plan":
+		return runPlan(ctx, args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+	return 2
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runSync implements the sync command: it runs HandleSecret once for a
+// secret in the cluster, or for the secrets of local manifests, and prints
+// the status of each target. With manifests no cluster is used: the secrets,
+// and the credentials secrets and StoreCredentials they reference, are read
+// from the files and the outcome is kept in memory.
+func runSync(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	secret := fs.String("secret", "", "`namespace/name` of a secret in the cluster to sync")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig `file` of the cluster; defaults to $KUBECONFIG, then ~/.kube/config, then the in-cluster config")
+	var files stringList
+	fs.Var(&files, "f", "manifest `file` with the secrets to sync and the credentials they use, - for stdin; may be repeated")
+	force := fs.Bool("force", false, "sync every target, including those already in sync, in backoff, failed permanently or out of retries")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if (*secret == "") == (len(files) == 0) || fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "sync needs either --secret or -f")
+		fs.Usage()
+		return 2
+	}
+	var (
+		secrets []*corev1.Secret
+		restore func()
+		err     error
+	)
+	if *secret != "" {
+		secrets, restore, err = clusterSecret(ctx, *kubeconfig, *secret)
+	} else {
+		secrets, restore, err = localSecrets(files)
+	}
+	defer restore()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *force {
+		ctx = certmanagersync.WithForce(ctx)
+	}
+	code := 0
+	for _, s := range secrets {
+		if err := syncOnce(ctx, s, stdout); err != nil {
+			fmt.Fprintf(stderr, "%s/%s: %v\n", s.Namespace, s.Name, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// runPlan implements the plan command: it prints what the operator would do
+// to each target of a secret in the cluster, of every watched secret in the
+// cluster, or of the secrets of local manifests. No store is called and
+// nothing is written.
+func runPlan(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	secret := fs.String("secret", "", "`namespace/name` of a secret in the cluster to plan; all watched secrets when neither --secret nor -f is given")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig `file` of the cluster; defaults to $KUBECONFIG, then ~/.kube/config, then the in-cluster config")
+	var files stringList
+	fs.Var(&files, "f", "manifest `file` with the secrets to plan and the credentials they use, - for stdin; may be repeated")
+	output := fs.String("o", "table", "output `format`: table or json")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if (*secret != "" && len(files) > 0) || fs.NArg() > 0 || (*output != "table" && *output != "json") {
+		fmt.Fprintln(stderr, "plan takes at most one of --secret and -f, and -o table or json")
+		fs.Usage()
+		return 2
+	}
+	var (
+		secrets []*corev1.Secret
+		restore func()
+		err     error
+	)
+	switch {
+	case *secret != "":
+		secrets, restore, err = clusterSecret(ctx, *kubeconfig, *secret)
+	case len(files) > 0:
+		secrets, restore, err = localSecrets(files)
+	default:
+		secrets, restore, err = clusterSecrets(ctx, *kubeconfig)
+	}
+	defer restore()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	plans := make([]*certmanagersync.SecretPlan, 0, len(secrets))
+	code := 0
+	for _, s := range secrets {
+		p, err := planFn(ctx, s)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s/%s: %v\n", s.Namespace, s.Name, err)
+			code = 1
+			continue
+		}
+		plans = append(plans, p)
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plans); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return code
+	}
+	for _, p := range plans {
+		printPlan(stdout, p)
+	}
+	return code
+}
+
+// connectFn is the function-typed indirection for connect.
+var connectFn = connect
+
+// connect creates the clients of the cluster of kubeconfig, or of the
+// operator's own configuration when it is empty, and loads the cluster's
+// SecretSyncs, so secrets are selected and synced as the operator would. It
+// returns a func that restores the SecretSyncs it replaced.
+func connect(ctx context.Context, kubeconfig string) (func(), error) {
+	if kubeconfig != "" {
+		// CreateKubeClient falls back to the in-cluster config when the
+		// file is missing, which an explicit flag must not do.
+		if _, err := os.Stat(kubeconfig); err != nil {
+			return func() {}, fmt.Errorf("--kubeconfig: %w", err)
+		}
+		os.Setenv("KUBECONFIG", kubeconfig)
+	}
+	if err := state.CreateKubeClient(); err != nil {
+		return func() {}, fmt.Errorf("connect to cluster: %w", err)
+	}
+	installed, err := secretsync.CRDInstalled(state.KubeClient.Discovery())
+	if err != nil {
+		log.WithError(err).Warn("unable to discover the SecretSync CRD; SecretSync resources are ignored")
+		return func() {}, nil
+	}
+	if !installed {
+		return func() {}, nil
+	}
+	return secretsync.Load(ctx)
+}
+
+// clusterSecrets connects to the cluster and returns every secret the
+// operator would sync, through its annotations or a SecretSync, or delete.
+func clusterSecrets(ctx context.Context, kubeconfig string) ([]*corev1.Secret, func(), error) {
+	restore, err := connectFn(ctx, kubeconfig)
+	if err != nil {
+		return nil, restore, err
+	}
+	list, err := state.KubeClient.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, restore, err
+	}
+	var out []*corev1.Secret
+	for i := range list.Items {
+		s := &list.Items[i]
+		if secretWatched(s) || state.SecretDeletePending(s) {
+			out = append(out, s)
+		}
+	}
+	return out, restore, nil
+}
+
+// clusterSecret connects to the cluster and returns the secret named by ref.
+func clusterSecret(ctx context.Context, kubeconfig, ref string) ([]*corev1.Secret, func(), error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, func() {}, fmt.Errorf("--secret must be namespace/name, got %q", ref)
+	}
+	restore, err := connectFn(ctx, kubeconfig)
+	if err != nil {
+		return nil, restore, err
+	}
+	s, err := state.KubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, restore, err
+	}
+	return []*corev1.Secret{s}, restore, nil
+}
+
+// localSecrets reads the objects of the manifest files into in-memory
+// clients, which stand in for the cluster, and returns the secrets enabled
+// for sync, and a func that restores the clients they replaced.
+func localSecrets(files []string) ([]*corev1.Secret, func(), error) {
+	restore := func() {}
+	var (
+		objs    []runtime.Object
+		dynObjs []runtime.Object
+		secrets []*corev1.Secret
+	)
+	for _, f := range files {
+		docs, err := readManifests(f)
+		if err != nil {
+			return nil, restore, err
+		}
+		for _, u := range docs {
+			if u.GetKind() != "Secret" {
+				dynObjs = append(dynObjs, u)
+				continue
+			}
+			s, err := toSecret(u)
+			if err != nil {
+				return nil, restore, fmt.Errorf("%s: %w", f, err)
+			}
+			objs = append(objs, s)
+			if state.SecretWatched(s) {
+				secrets = append(secrets, s)
+			}
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, restore, fmt.Errorf("no secret with %s/sync-enabled set to true in %s", state.OperatorName, strings.Join(files, ", "))
+	}
+	kc, dc, rec := state.KubeClient, state.DynamicClient, state.EventRecorder
+	restore = func() { state.KubeClient, state.DynamicClient, state.EventRecorder = kc, dc, rec }
+	state.KubeClient = fake.NewSimpleClientset(objs...)
+	state.DynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), dynObjs...)
+	state.EventRecorder = &record.FakeRecorder{}
+	return secrets, restore, nil
+}
+
+// readManifests decodes the YAML or JSON documents of file, or of stdin for
+// "-".
+func readManifests(file string) ([]*unstructured.Unstructured, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var out []*unstructured.Unstructured
+	dec := yaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := dec.Decode(&u.Object); errors.Is(err, io.EOF) {
+			return out, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", file, err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+		out = append(out, u)
+	}
+}
+
+// toSecret converts u to a secret as the API server would store it: in the
+// default namespace unless it names one, with stringData merged into data.
+func toSecret(u *unstructured.Unstructured) (*corev1.Secret, error) {
+	b, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+	s := &corev1.Secret{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("decode secret %s: %w", u.GetName(), err)
+	}
+	s.Namespace = cmp.Or(s.Namespace, metav1.NamespaceDefault)
+	for k, v := range s.StringData {
+		if s.Data == nil {
+			s.Data = make(map[string][]byte, len(s.StringData))
+		}
+		s.Data[k] = []byte(v)
+	}
+	s.StringData = nil
+	return s, nil
+}
+
+// syncOnce runs HandleSecret for s and prints the status of its targets and
+// the annotations the stores reported.
+func syncOnce(ctx context.Context, s *corev1.Secret, w io.Writer) error {
+	secrets := state.KubeClient.CoreV1().Secrets(s.Namespace)
+	syncErr := handleSecretFn(ctx, s)
+	got, err := secrets.Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Join(syncErr, err)
+	}
+	printResults(w, s, got)
+	return syncErr
+}
+
+// printPlan writes the planned action of each target of p.
+func printPlan(w io.Writer, p *certmanagersync.SecretPlan) {
+	fmt.Fprintf(w, "%s/%s\n", p.Namespace, p.Name)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTORE\tACTION\tREASON\tREMOTE ID\tERROR")
+	for _, tp := range p.Targets {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", tp.Target, tp.Store, tp.Action, cmp.Or(tp.Reason, "-"), cmp.Or(tp.RemoteID, "-"), tp.Error)
+	}
+	tw.Flush()
+}
+
+// printResults writes the status of each target of got, and the store
+// annotations that differ from before.
+func printResults(w io.Writer, before, got *corev1.Secret) {
+	fmt.Fprintf(w, "%s/%s\n", got.Namespace, got.Name)
+	status := state.GetStatus(got)
+	keys := make([]string, 0, len(status.Targets))
+	for k := range status.Targets {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTORE\tSTATUS\tLAST ATTEMPT\tREMOTE ID\tERROR")
+	for _, k := range keys {
+		ts := status.Targets[k]
+		result := "pending"
+		switch {
+		case ts.LastError != "":
+			result = "failed"
+		case ts.Synced:
+			result = "synced"
+		}
+		attempt := "-"
+		if !ts.LastAttemptTime.IsZero() {
+			attempt = ts.LastAttemptTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k, ts.Store, result, attempt, cmp.Or(ts.RemoteID, "-"), ts.LastError)
+	}
+	tw.Flush()
+	var updated []string
+	for k, v := range got.Annotations {
+		if tlssecret.IsStoreAnnotation(k) && before.Annotations[k] != v {
+			updated = append(updated, fmt.Sprintf("  %s: %q", k, v))
+		}
+	}
+	if len(updated) > 0 {
+		slices.Sort(updated)
+		fmt.Fprintf(w, "annotations updated by stores:\n%s\n", strings.Join(updated, "\n"))
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/secretsync"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const cliManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: example
+  namespace: cert-manager
+  annotations:
+    cert-manager-sync.lestak.sh/sync-enabled: "true"
+    cert-manager-sync.lestak.sh/acm-region: us-east-1
+    cert-manager-sync.lestak.sh/acm-secret-name: aws-creds
+    cert-manager-sync.lestak.sh/next-retry: "2099-01-01T00:00:00Z"
+type: kubernetes.io/tls
+data:
+  tls.crt: Y2VydA==
+  tls.key: a2V5
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: aws-creds
+  namespace: cert-manager
+stringData:
+  AWS_ACCESS_KEY_ID: id
+  AWS_SECRET_ACCESS_KEY: secret
+`
+
+// withLocalClients checks that the clients and recorder replaced by a local
+// command are restored once it returns.
+func withLocalClients(t *testing.T) {
+	t.Helper()
+	kc, dc, rec := state.KubeClient, state.DynamicClient, state.EventRecorder
+	t.Cleanup(func() {
+		assert.True(t, state.KubeClient == kc && state.DynamicClient == dc && state.EventRecorder == rec, "the clients are restored")
+		state.KubeClient, state.DynamicClient, state.EventRecorder = kc, dc, rec
+	})
+}
+
+// syncCall is a call of the stubbed handleSecretFn.
+type syncCall struct {
+	secret *corev1.Secret
+	forced bool
+	creds  *corev1.Secret
+}
+
+// stubHandleSecret replaces handleSecretFn with one that records the secrets
+// it is called with, and the aws-creds secret it can read, and marks the acm
+// target synced, or failed with err.
+func stubHandleSecret(t *testing.T, err error) *[]syncCall {
+	t.Helper()
+	var calls []syncCall
+	prev := handleSecretFn
+	handleSecretFn = func(ctx context.Context, s *corev1.Secret) error {
+		creds, _ := state.KubeClient.CoreV1().Secrets("cert-manager").Get(ctx, "aws-creds", metav1.GetOptions{})
+		calls = append(calls, syncCall{secret: s, forced: certmanagersync.Forced(ctx), creds: creds})
+		ts := &state.TargetStatus{Store: "acm", Synced: true, LastAttemptTime: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), RemoteID: "arn:1"}
+		if err != nil {
+			ts.Synced, ts.LastError = false, err.Error()
+		}
+		status, merr := state.Status{Targets: map[string]*state.TargetStatus{"acm": ts}}.Marshal()
+		require.NoError(t, merr)
+		patch, merr := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{
+			state.SyncStatusAnnotation():                status,
+			state.OperatorName + "/acm-certificate-arn": "arn:1",
+		}}})
+		require.NoError(t, merr)
+		_, perr := state.KubeClient.CoreV1().Secrets(s.Namespace).Patch(ctx, s.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		require.NoError(t, perr)
+		return err
+	}
+	t.Cleanup(func() { handleSecretFn = prev })
+	return &calls
+}
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "secret.yaml")
+	require.NoError(t, os.WriteFile(f, []byte(content), 0o600))
+	return f
+}
+
+func TestRunCommand_Unknown(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, runCommand(context.Background(), []string{"nope"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "nope"`)
+
+	assert.Equal(t, 0, runCommand(context.Background(), []string{"help"}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "cert-manager-sync sync [flags]")
+}
+
+func TestRunSync_NeedsOneSource(t *testing.T) {
+	for _, args := range [][]string{nil, {"--secret", "ns/name", "-f", "secret.yaml"}, {"--secret", "name"}} {
+		var stdout, stderr bytes.Buffer
+		assert.NotZero(t, runSync(context.Background(), args, &stdout, &stderr), args)
+	}
+}
+
+func TestRunSync_LocalManifest(t *testing.T) {
+	withLocalClients(t)
+	calls := stubHandleSecret(t, nil)
+	f := writeManifest(t, cliManifest)
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, runSync(context.Background(), []string{"-f", f}, &stdout, &stderr), stderr.String())
+	require.Len(t, *calls, 1, "only the secret enabled for sync is synced")
+	s := (*calls)[0].secret
+	assert.Equal(t, "example", s.Name)
+	assert.Equal(t, []byte("cert"), s.Data["tls.crt"])
+	assert.False(t, (*calls)[0].forced, "backoff is kept without --force")
+
+	creds := (*calls)[0].creds
+	require.NotNil(t, creds, "credentials are served from the manifest")
+	assert.Equal(t, []byte("id"), creds.Data["AWS_ACCESS_KEY_ID"])
+
+	out := stdout.String()
+	assert.Contains(t, out, "cert-manager/example")
+	assert.Regexp(t, `acm\s+acm\s+synced\s+2030-01-02T03:04:05Z\s+arn:1`, out)
+	assert.Contains(t, out, `cert-manager-sync.lestak.sh/acm-certificate-arn: "arn:1"`)
+}
+
+func TestRunSync_ForceRetriesFailedTargets(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	withLocalClients(t)
+	calls := stubHandleSecret(t, nil)
+	f := writeManifest(t, cliManifest)
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, runSync(context.Background(), []string{"-f", f, "--force"}, &stdout, &stderr), stderr.String())
+	require.Len(t, *calls, 1)
+	assert.True(t, (*calls)[0].forced)
+	assert.False(t, state.CacheDisabled(), "the environment is not changed")
+}
+
+func TestRunSync_ReportsFailure(t *testing.T) {
+	withLocalClients(t)
+	stubHandleSecret(t, errors.New("throttled"))
+	f := writeManifest(t, cliManifest)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, runSync(context.Background(), []string{"-f", f}, &stdout, &stderr))
+	assert.Regexp(t, `acm\s+acm\s+failed\s+\S+\s+arn:1\s+throttled`, stdout.String())
+	assert.Contains(t, stderr.String(), "cert-manager/example: throttled")
+}
+
+func TestRunSync_NoEnabledSecret(t *testing.T) {
+	withLocalClients(t)
+	f := writeManifest(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: plain\n")
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, runSync(context.Background(), []string{"-f", f}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "no secret with cert-manager-sync.lestak.sh/sync-enabled set to true")
+}
+
+func TestRunPlan_LocalManifest(t *testing.T) {
+	withLocalClients(t)
+	calls := stubHandleSecret(t, nil)
+	f := writeManifest(t, cliManifest)
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, runPlan(context.Background(), []string{"-f", f, "-o", "json"}, &stdout, &stderr), stderr.String())
+	assert.Empty(t, *calls, "nothing is synced")
+	var plans []certmanagersync.SecretPlan
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &plans))
+	assert.Equal(t, []certmanagersync.SecretPlan{{
+		Namespace: "cert-manager",
+		Name:      "example",
+		Targets: []certmanagersync.TargetPlan{
+			{Target: "acm", Store: "acm", Action: certmanagersync.PlanBlockedByBackoff, Reason: "backoff"},
+		},
+	}}, plans)
+
+	stdout.Reset()
+	require.Equal(t, 0, runPlan(context.Background(), []string{"-f", f}, &stdout, &stderr), stderr.String())
+	assert.Regexp(t, `acm\s+acm\s+blocked-by-backoff\s+backoff\s+-`, stdout.String())
+}
+
+func TestRunPlan_InvalidFlags(t *testing.T) {
+	for _, args := range [][]string{{"--secret", "ns/name", "-f", "secret.yaml"}, {"-o", "yaml"}, {"extra"}} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, runPlan(context.Background(), args, &stdout, &stderr), args)
+	}
+}
+
+// stubCluster replaces connectFn with one serving objs, and the SecretSyncs
+// among them, from in-memory clients, and restores the clients once the test
+// ends.
+func stubCluster(t *testing.T, objs ...runtime.Object) {
+	t.Helper()
+	kc, dc := state.KubeClient, state.DynamicClient
+	prev := connectFn
+	connectFn = func(ctx context.Context, kubeconfig string) (func(), error) {
+		var secrets, dynObjs []runtime.Object
+		for _, o := range objs {
+			if _, ok := o.(*corev1.Secret); ok {
+				secrets = append(secrets, o)
+			} else {
+				dynObjs = append(dynObjs, o)
+			}
+		}
+		state.KubeClient = fake.NewSimpleClientset(secrets...)
+		state.DynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{v1alpha1.SecretSyncResource: v1alpha1.SecretSyncKind + "List"}, dynObjs...)
+		return secretsync.Load(ctx)
+	}
+	t.Cleanup(func() {
+		connectFn = prev
+		state.KubeClient, state.DynamicClient = kc, dc
+	})
+}
+
+func TestRunPlan_ClusterIncludesSecretSyncs(t *testing.T) {
+	t.Setenv("ENABLED_NAMESPACES", "")
+	t.Setenv("DISABLED_NAMESPACES", "")
+	t.Setenv("SECRETS_NAMESPACE", "")
+	stubPlan(t, nil, nil)
+	tlsData := map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")}
+	annotated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotated", Namespace: "ns", Annotations: map[string]string{
+			state.OperatorName + "/sync-enabled": "true",
+		}},
+		Data: tlsData,
+	}
+	declared := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "declared", Namespace: "ns"}, Data: tlsData}
+	plain := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "ns"}, Data: tlsData}
+	ss := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+		"kind":       v1alpha1.SecretSyncKind,
+		"metadata":   map[string]any{"name": "web", "namespace": "ns"},
+		"spec":       map[string]any{"secretName": "declared"},
+	}}
+	stubCluster(t, annotated, declared, plain, ss)
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, runPlan(context.Background(), []string{"-o", "json"}, &stdout, &stderr), stderr.String())
+	var plans []certmanagersync.SecretPlan
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &plans))
+	var names []string
+	for _, p := range plans {
+		names = append(names, p.Name)
+	}
+	assert.ElementsMatch(t, []string{"annotated", "declared"}, names)
+}
+
+func TestRunPlan_MissingKubeconfig(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	missing := filepath.Join(t.TempDir(), "config")
+	assert.Equal(t, 1, runPlan(context.Background(), []string{"--kubeconfig", missing}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "--kubeconfig")
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/certificates"
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/secretsync"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	defaultSyncWorkers         = 4
+	defaultShutdownGracePeriod = 25 * time.Second
+)
+
+// controller moves secret reconciliation off the shared informer's event
+// delivery goroutine and onto a keyed workqueue. The queue deduplicates keys,
+// so a burst of events for one secret collapses into a single reconcile, and
+// a key is never handed to two workers at the same time.
+type controller struct {
+	queue   workqueue.TypedRateLimitingInterface[string]
+	lister  corelisters.SecretLister
+	workers int
+	// gracePeriod bounds how long run waits for in-flight reconciles to
+	// finish on shutdown before cancelling them.
+	gracePeriod time.Duration
+	// driftInterval is how often watched secrets are checked for remote
+	// drift; zero disables the checks.
+	driftInterval time.Duration
+	// driftDue holds the keys queued for a drift check. The check runs in
+	// the key's next reconcile, so it never races a sync of the same secret.
+	driftMu  sync.Mutex
+	driftDue map[string]bool
+}
+
+// newController returns a controller reading secrets from the given lister.
+//
+// The per-item failure backoff deliberately starts at seconds rather than the
+// client-go default of 5ms: the exponential sync/delete backoff persisted in
+// the secret's annotations is the source of truth, and an immediate requeue
+// would re-read a stale cache entry before our own annotation patch lands.
+func newController(lister corelisters.SecretLister, workers int, gracePeriod time.Duration) *controller {
+	rl := workqueue.NewTypedItemExponentialFailureRateLimiter[string](5*time.Second, 5*time.Minute)
+	return &controller{
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(rl, workqueue.TypedRateLimitingQueueConfig[string]{
+			Name: "secrets",
+		}),
+		lister:      lister,
+		workers:     workers,
+		gracePeriod: gracePeriod,
+		driftDue:    make(map[string]bool),
+	}
+}
+
+// syncWorkers returns the number of concurrent reconcile workers, read from
+// SYNC_WORKERS. Defaults to 4 when unset or invalid.
+func syncWorkers() int {
+	v := os.Getenv("SYNC_WORKERS")
+	if v == "" {
+		return defaultSyncWorkers
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return defaultSyncWorkers
+	}
+	return n
+}
+
+// shutdownGracePeriod returns how long in-flight reconciles may run after a
+// shutdown signal, read from SHUTDOWN_GRACE_PERIOD. Defaults to 25s, which
+// fits inside the default 30s pod terminationGracePeriodSeconds.
+func shutdownGracePeriod() (time.Duration, error) {
+	return durationEnv("SHUTDOWN_GRACE_PERIOD", defaultShutdownGracePeriod)
+}
+
+// driftCheckInterval returns how often secrets are checked for remote drift,
+// read from DRIFT_CHECK_INTERVAL. Unset disables the checks.
+func driftCheckInterval() (time.Duration, error) {
+	return durationEnv("DRIFT_CHECK_INTERVAL", 0)
+}
+
+// enqueue adds the namespace/name key of a secret informer object to the
+// queue. Deleted secrets are queued too, so their metrics are dropped.
+func (c *controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// eventHandler returns the informer handlers that feed the queue.
+func (c *controller) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(_, newObj interface{}) {
+			c.enqueue(newObj)
+		},
+		DeleteFunc: c.enqueue,
+	}
+}
+
+// secretSyncEventHandler returns the SecretSync informer handlers. They
+// enqueue the secret a SecretSync references, and on update also the secret
+// it referenced before, so both are reconciled against the new targets.
+func (c *controller) secretSyncEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if key := secretsync.SecretKey(obj); key != "" {
+			c.queue.Add(key)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			enqueue(oldObj)
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// certificateEventHandler returns the cert-manager Certificate informer
+// handlers. They enqueue the secret a Certificate issues, so a secret whose
+// sync waits for its Certificate is reconciled as soon as it becomes Ready,
+// and on update also the secret it issued before.
+func (c *controller) certificateEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if key := certificates.SecretKey(obj); key != "" {
+			c.queue.Add(key)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			enqueue(oldObj)
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// run starts the workers and blocks until ctx is cancelled. On cancellation
+// the queue is shut down so no new keys are picked up, and run waits up to
+// gracePeriod for in-flight reconciles to finish; only then are their
+// contexts cancelled, aborting any store calls still running. Reconciles run
+// on a context detached from ctx so a shutdown does not interrupt a store
+// between a remote write and recording its result.
+func (c *controller) run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	l := log.WithFields(log.Fields{
+		"fn":      "controller.run",
+		"workers": c.workers,
+	})
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	var wg sync.WaitGroup
+	l.Info("starting workers")
+	for i := 0; i < c.workers; i++ {
+		wg.Go(func() {
+			defer utilruntime.HandleCrash()
+			c.runWorker(workCtx)
+		})
+	}
+	if c.driftInterval > 0 {
+		wg.Go(func() {
+			defer utilruntime.HandleCrash()
+			c.runDriftChecks(ctx)
+		})
+	}
+	<-ctx.Done()
+	l.WithField("gracePeriod", c.gracePeriod).Info("stopping workers")
+	c.queue.ShutDown()
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(c.gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		l.Info("workers drained")
+	case <-timer.C:
+		l.Warn("shutdown grace period elapsed; cancelling in-flight reconciles")
+		cancelWork()
+		<-drained
+	}
+}
+
+// runDriftChecks queues every watched secret for a drift check each
+// driftInterval until ctx is cancelled.
+func (c *controller) runDriftChecks(ctx context.Context) {
+	ticker := time.NewTicker(c.driftInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.queueDriftChecks()
+		}
+	}
+}
+
+// queueDriftChecks marks every watched secret in the cache as due for a
+// drift check and queues it.
+func (c *controller) queueDriftChecks() {
+	secrets, err := c.lister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, s := range secrets {
+		if !secretWatched(s) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(s)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		c.driftMu.Lock()
+		c.driftDue[key] = true
+		c.driftMu.Unlock()
+		c.queue.Add(key)
+	}
+}
+
+// takeDriftCheck reports whether key is due for a drift check, clearing the
+// mark.
+func (c *controller) takeDriftCheck(key string) bool {
+	c.driftMu.Lock()
+	defer c.driftMu.Unlock()
+	due := c.driftDue[key]
+	delete(c.driftDue, key)
+	return due
+}
+
+func (c *controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem pops a single key off the queue and reconciles it. It
+// returns false once the queue has been shut down. Keys still queued at
+// shutdown are dropped rather than reconciled; the informer relists them on
+// the next start.
+func (c *controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+	if c.queue.ShuttingDown() {
+		return false
+	}
+
+	requeueAfter, err := c.reconcileKey(ctx, key)
+	if err != nil {
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	if requeueAfter > 0 {
+		c.queue.AddAfter(key, requeueAfter)
+	}
+	return true
+}
+
+// reconcileKey resolves a queue key against the lister and runs
+// reconcileSecret on the cached object, preceded by a drift check when one is
+// due.
+func (c *controller) reconcileKey(ctx context.Context, key string) (time.Duration, error) {
+	l := log.WithFields(log.Fields{
+		"fn":  "reconcileKey",
+		"key": key,
+	})
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// A malformed key will never succeed; drop it.
+		utilruntime.HandleError(err)
+		return 0, nil
+	}
+	s, err := c.lister.Secrets(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		l.Debug("secret no longer exists")
+		metrics.DeleteSecret(namespace, name)
+		c.takeDriftCheck(key)
+		reportedPlans.Delete(key)
+		certmanagersync.ForgetSecret(namespace, name)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// A drift check may clear target hashes, so it is skipped in a dry run.
+	if c.takeDriftCheck(key) && !state.DryRun() && secretWatched(s) && !state.SecretDeletePending(s) {
+		resync, err := detectDriftFn(ctx, s)
+		if err != nil {
+			l.WithError(err).Warn("drift check incomplete")
+		}
+		if resync {
+			// The patch clearing the drifted targets' hashes queues the
+			// secret again; the cached copy does not have it yet.
+			return 0, nil
+		}
+	}
+	return reconcileSecret(ctx, l, s)
+}
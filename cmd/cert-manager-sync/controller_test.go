@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestController returns a controller backed by an in-memory indexer
+// pre-loaded with the given secrets.
+func newTestController(t *testing.T, secrets ...*corev1.Secret) *controller {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, s := range secrets {
+		require.NoError(t, indexer.Add(s))
+	}
+	c := newController(corelisters.NewSecretLister(indexer), 1, time.Second)
+	t.Cleanup(c.queue.ShutDown)
+	return c
+}
+
+// withRetryAfter stubs the sync backoff lookup for the duration of a test.
+func withRetryAfter(t *testing.T, d time.Duration) {
+	t.Helper()
+	prev := syncRetryAfterFn
+	syncRetryAfterFn = func(_ *corev1.Secret) time.Duration { return d }
+	t.Cleanup(func() { syncRetryAfterFn = prev })
+}
+
+func TestSyncWorkers(t *testing.T) {
+	cases := map[string]int{
+		"":    defaultSyncWorkers,
+		"8":   8,
+		"0":   defaultSyncWorkers,
+		"-1":  defaultSyncWorkers,
+		"abc": defaultSyncWorkers,
+	}
+	for in, want := range cases {
+		t.Setenv("SYNC_WORKERS", in)
+		assert.Equal(t, want, syncWorkers(), "SYNC_WORKERS=%q", in)
+	}
+}
+
+func TestController_EventsForOneSecretAreDeduplicated(t *testing.T) {
+	clearDeleteEnv(t)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	h := c.eventHandler()
+	h.OnAdd(s, false)
+	h.OnUpdate(s, s)
+	h.OnUpdate(s, s)
+	assert.Equal(t, 1, c.queue.Len())
+}
+
+func TestController_SecretSyncEventsEnqueueReferencedSecrets(t *testing.T) {
+	c := newTestController(t)
+	h := c.secretSyncEventHandler()
+	ss := func(secret string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"secretName": secret},
+		}}
+		u.SetNamespace("ns")
+		u.SetName("web")
+		return u
+	}
+	h.OnAdd(ss("a"), false)
+	h.OnUpdate(ss("a"), ss("b"))
+	h.OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/web", Obj: ss("c")})
+	assert.Equal(t, 3, c.queue.Len(), "ns/a, ns/b and ns/c are queued once each")
+}
+
+func TestController_CertificateEventsEnqueueIssuedSecrets(t *testing.T) {
+	c := newTestController(t)
+	h := c.certificateEventHandler()
+	crt := func(secret string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"secretName": secret},
+		}}
+		u.SetNamespace("ns")
+		u.SetName("web")
+		return u
+	}
+	h.OnAdd(crt("a"), false)
+	h.OnUpdate(crt("a"), crt("a"))
+	h.OnUpdate(crt("a"), crt("b"))
+	h.OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/web", Obj: crt("c")})
+	h.OnAdd(crt(""), false)
+	assert.Equal(t, 3, c.queue.Len(), "ns/a, ns/b and ns/c are queued once each")
+}
+
+func TestController_ProcessNextItem_ReconcilesSecret(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{}
+	f.install(t)
+	withRetryAfter(t, 0)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.enqueue(s)
+
+	require.True(t, c.processNextItem(context.Background()))
+	assert.Equal(t, 1, f.syncCalls)
+	assert.Equal(t, 0, c.queue.Len())
+	assert.Equal(t, 0, c.queue.NumRequeues("ns/s"))
+}
+
+func TestController_ProcessNextItem_ErrorIsRateLimited(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{syncErr: assertErr("boom")}
+	f.install(t)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.enqueue(s)
+
+	require.True(t, c.processNextItem(context.Background()))
+	assert.Equal(t, 1, f.syncCalls)
+	assert.Equal(t, 1, c.queue.NumRequeues("ns/s"))
+}
+
+func TestController_ProcessNextItem_BackoffRequeuesAfterDelay(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{}
+	f.install(t)
+	withRetryAfter(t, 50*time.Millisecond)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.enqueue(s)
+
+	require.True(t, c.processNextItem(context.Background()))
+	assert.Equal(t, 0, c.queue.Len(), "requeue should be delayed, not immediate")
+	assert.Equal(t, 0, c.queue.NumRequeues("ns/s"), "a backoff requeue is not a failure")
+	assert.Eventually(t, func() bool { return c.queue.Len() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestController_ReconcileKey_MissingSecretIsDropped(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{}
+	f.install(t)
+	c := newTestController(t)
+	d, err := c.reconcileKey(context.Background(), "ns/gone")
+	assert.NoError(t, err)
+	assert.Zero(t, d)
+	assert.Zero(t, f.syncCalls)
+}
+
+func TestController_DeletedSecretDropsMetrics(t *testing.T) {
+	clearDeleteEnv(t)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t)
+	metrics.SetSuccess("ns", "s", "acm", "acm")
+	c.eventHandler().OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/s", Obj: s})
+	require.Equal(t, 1, c.queue.Len())
+
+	require.True(t, c.processNextItem(context.Background()))
+	assert.False(t, metrics.SyncStatus.DeleteLabelValues("ns", "s", "acm", "acm", "success"), "the secret's series are dropped")
+}
+
+func TestController_DriftCheckRunsInNextReconcile(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{}
+	f.install(t)
+	withRetryAfter(t, 0)
+	unwatched := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "ns"}}
+	c := newTestController(t, watchedSecret("s", nil, nil), unwatched)
+
+	c.queueDriftChecks()
+	require.Equal(t, 1, c.queue.Len(), "only watched secrets are checked")
+	require.True(t, c.processNextItem(context.Background()))
+	assert.Equal(t, 1, f.driftCalls)
+	assert.Equal(t, 1, f.syncCalls)
+
+	_, err := c.reconcileKey(context.Background(), "ns/s")
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.driftCalls, "the check runs once per interval")
+	assert.Equal(t, 2, f.syncCalls)
+}
+
+func TestController_DriftResyncSkipsStaleReconcile(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{driftResync: true}
+	f.install(t)
+	c := newTestController(t, watchedSecret("s", nil, nil))
+
+	c.queueDriftChecks()
+	require.True(t, c.processNextItem(context.Background()))
+	assert.Equal(t, 1, f.driftCalls)
+	assert.Zero(t, f.syncCalls, "the resync patch queues the secret again")
+}
+
+func TestDriftCheckInterval(t *testing.T) {
+	t.Setenv("DRIFT_CHECK_INTERVAL", "")
+	d, err := driftCheckInterval()
+	require.NoError(t, err)
+	assert.Zero(t, d, "disabled by default")
+
+	t.Setenv("DRIFT_CHECK_INTERVAL", "6h")
+	d, err = driftCheckInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 6*time.Hour, d)
+
+	t.Setenv("DRIFT_CHECK_INTERVAL", "often")
+	_, err = driftCheckInterval()
+	assert.Error(t, err)
+}
+
+func TestController_ProcessNextItem_ReturnsFalseOnShutdown(t *testing.T) {
+	c := newTestController(t, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "ns"}})
+	c.queue.ShutDown()
+	assert.False(t, c.processNextItem(context.Background()))
+}
+
+func TestController_ProcessNextItem_DropsQueuedKeysOnShutdown(t *testing.T) {
+	clearDeleteEnv(t)
+	f := &fns{}
+	f.install(t)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.enqueue(s)
+	c.queue.ShutDown()
+	assert.False(t, c.processNextItem(context.Background()))
+	assert.Zero(t, f.syncCalls)
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	t.Setenv("SHUTDOWN_GRACE_PERIOD", "")
+	d, err := shutdownGracePeriod()
+	require.NoError(t, err)
+	assert.Equal(t, defaultShutdownGracePeriod, d)
+
+	t.Setenv("SHUTDOWN_GRACE_PERIOD", "1m")
+	d, err = shutdownGracePeriod()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, d)
+
+	t.Setenv("SHUTDOWN_GRACE_PERIOD", "never")
+	_, err = shutdownGracePeriod()
+	assert.Error(t, err)
+}
+
+// withBlockingSync stubs handleSecretFn with one that signals started and
+// then blocks until release is closed or its context is done, reporting the
+// context error it observed on result.
+func withBlockingSync(t *testing.T, release <-chan struct{}) (started <-chan struct{}, result <-chan error) {
+	t.Helper()
+	withRetryAfter(t, 0)
+	prev := handleSecretFn
+	st := make(chan struct{})
+	res := make(chan error, 1)
+	handleSecretFn = func(ctx context.Context, _ *corev1.Secret) error {
+		close(st)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		res <- ctx.Err()
+		return nil
+	}
+	t.Cleanup(func() { handleSecretFn = prev })
+	return st, res
+}
+
+func TestController_Run_DrainsInFlightReconcileOnShutdown(t *testing.T) {
+	clearDeleteEnv(t)
+	release := make(chan struct{})
+	started, result := withBlockingSync(t, release)
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.gracePeriod = time.Minute
+	c.enqueue(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(stopped)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+		t.Fatal("run returned before the in-flight reconcile finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	assert.NoError(t, <-result, "in-flight reconcile is not cancelled within the grace period")
+}
+
+func TestController_Run_CancelsInFlightReconcileAfterGracePeriod(t *testing.T) {
+	clearDeleteEnv(t)
+	started, result := withBlockingSync(t, make(chan struct{}))
+	s := watchedSecret("s", nil, nil)
+	c := newTestController(t, s)
+	c.gracePeriod = 20 * time.Millisecond
+	c.enqueue(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(stopped)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after the grace period")
+	}
+	assert.ErrorIs(t, <-result, context.Canceled)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// planFn is the function-typed indirection for certmanagersync.Plan.
+var planFn = certmanagersync.Plan
+
+// reportedPlans maps a secret's queue key to the JSON of the plan last
+// reported for it, so an unchanged plan is not reported again on every
+// informer resync.
+var reportedPlans sync.Map
+
+// planSecret is reconcileSecret in dry-run mode: it reports what would be
+// done to each target of s as log entries and a DryRun event, and changes
+// nothing, finalizers included.
+func planSecret(ctx context.Context, l *log.Entry, s *v1.Secret) (time.Duration, error) {
+	key, _ := cache.MetaNamespaceKeyFunc(s)
+	if !state.SecretDeletePending(s) && !secretWatched(s) {
+		reportedPlans.Delete(key)
+		return 0, nil
+	}
+	p, err := planFn(ctx, s)
+	if err != nil {
+		l.WithError(err).Error("dry run plan failed")
+		return 0, err
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return 0, err
+	}
+	if prev, ok := reportedPlans.Swap(key, string(b)); ok && prev == string(b) {
+		return 0, nil
+	}
+	var summary []string
+	for _, tp := range p.Targets {
+		ll := l.WithFields(log.Fields{
+			"namespace": s.Namespace,
+			"name":      s.Name,
+			"target":    tp.Target,
+			"store":     tp.Store,
+			"action":    tp.Action,
+		})
+		if tp.Reason != "" {
+			ll = ll.WithField("reason", tp.Reason)
+		}
+		if tp.RemoteID != "" {
+			ll = ll.WithField("remote_id", tp.RemoteID)
+		}
+		if tp.Error != "" {
+			ll.WithField("error", tp.Error).Warn("dry run: target would fail")
+		} else {
+			ll.Info("dry run: planned target action")
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", tp.Target, tp.Action))
+	}
+	if state.EventRecorder != nil && len(summary) > 0 {
+		state.EventRecorder.Event(s, v1.EventTypeNormal, "DryRun", "Planned "+strings.Join(summary, ", "))
+	}
+	if state.SecretDeletePending(s) {
+		return deleteRetryAfterFn(s), nil
+	}
+	return syncRetryAfterFn(s), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// stubPlan replaces planFn with one returning plan, or err, and counting its
+// calls, and gives each test a fresh recorder and set of reported plans.
+func stubPlan(t *testing.T, plan []certmanagersync.TargetPlan, err error) (*int, *record.FakeRecorder) {
+	t.Helper()
+	calls := 0
+	prev, prevRec := planFn, state.EventRecorder
+	planFn = func(_ context.Context, s *corev1.Secret) (*certmanagersync.SecretPlan, error) {
+		calls++
+		if err != nil {
+			return nil, err
+		}
+		return &certmanagersync.SecretPlan{Namespace: s.Namespace, Name: s.Name, Targets: plan}, nil
+	}
+	rec := record.NewFakeRecorder(10)
+	state.EventRecorder = rec
+	reportedPlans.Clear()
+	t.Cleanup(func() {
+		planFn, state.EventRecorder = prev, prevRec
+		reportedPlans.Clear()
+	})
+	return &calls, rec
+}
+
+func TestReconcileSecret_DryRunOnlyPlans(t *testing.T) {
+	clearDeleteEnv(t)
+	t.Setenv("DRY_RUN", "true")
+	f := &fns{}
+	f.install(t)
+	calls, rec := stubPlan(t, []certmanagersync.TargetPlan{
+		{Target: "acm", Store: "acm", Action: certmanagersync.PlanUpdate},
+		{Target: "vault", Store: "vault", Action: certmanagersync.PlanSkipUnchanged},
+	}, nil)
+	s := watchedSecret("s", map[string]string{state.DeletePolicyAnnotation(): state.DeletePolicyDelete}, nil)
+
+	_, err := reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Zero(t, f.syncCalls+f.deleteCalls+f.ensureCalls+f.removeCalls, "nothing but the plan runs")
+	require.Len(t, rec.Events, 1)
+	assert.Equal(t, "Normal DryRun Planned acm: update, vault: skip-unchanged", <-rec.Events)
+
+	_, err = reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
+	require.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	assert.Empty(t, rec.Events, "an unchanged plan is reported once")
+}
+
+func TestReconcileSecret_DryRunDeletePending(t *testing.T) {
+	clearDeleteEnv(t)
+	t.Setenv("DRY_RUN", "true")
+	f := &fns{}
+	f.install(t)
+	calls, rec := stubPlan(t, []certmanagersync.TargetPlan{
+		{Target: "acm", Store: "acm", Action: certmanagersync.PlanDelete},
+	}, nil)
+	s := watchedSecret("s", map[string]string{state.DeletePolicyAnnotation(): state.DeletePolicyDelete}, []string{state.FinalizerName()})
+	now := metav1.Now()
+	s.DeletionTimestamp = &now
+
+	_, err := reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Zero(t, f.deleteCalls+f.removeCalls, "the finalizer is kept")
+	assert.Equal(t, "Normal DryRun Planned acm: delete", <-rec.Events)
+}
+
+func TestReconcileSecret_DryRunSkipsUnwatched(t *testing.T) {
+	clearDeleteEnv(t)
+	t.Setenv("DRY_RUN", "true")
+	f := &fns{}
+	f.install(t)
+	calls, _ := stubPlan(t, nil, errors.New("unexpected"))
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: "ns", Finalizers: []string{state.FinalizerName()}}}
+
+	_, err := reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
+	require.NoError(t, err)
+	assert.Zero(t, *calls)
+	assert.Zero(t, f.removeCalls, "a stale finalizer is not removed")
+}
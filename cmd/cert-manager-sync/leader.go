@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// leaderElectionConfig holds the Lease settings read from the environment.
+type leaderElectionConfig struct {
+	Enabled       bool
+	Namespace     string
+	ID            string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// leaderElectionConfigFromEnv reads the leader election settings.
+//
+//	LEADER_ELECT                       enable leader election (default false)
+//	LEADER_ELECTION_NAMESPACE          Lease namespace (default POD_NAMESPACE, then the in-cluster namespace)
+//	LEADER_ELECTION_ID                 Lease name (default "<OPERATOR_NAME>-leader")
+//	LEADER_ELECTION_LEASE_DURATION     how long a standby waits before taking over (default 15s)
+//	LEADER_ELECTION_RENEW_DEADLINE     how long the leader retries renewal before giving up (default 10s)
+//	LEADER_ELECTION_RETRY_PERIOD       interval between acquire/renew attempts (default 2s)
+func leaderElectionConfigFromEnv(operatorName string) (leaderElectionConfig, error) {
+	cfg := leaderElectionConfig{
+		Enabled: os.Getenv("LEADER_ELECT") == "true",
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+	cfg.Namespace = cmp.Or(os.Getenv("LEADER_ELECTION_NAMESPACE"), os.Getenv("POD_NAMESPACE"))
+	if cfg.Namespace == "" {
+		if b, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			cfg.Namespace = strings.TrimSpace(string(b))
+		}
+	}
+	if cfg.Namespace == "" {
+		return cfg, errors.New("leader election enabled but no namespace set: set LEADER_ELECTION_NAMESPACE or POD_NAMESPACE")
+	}
+	cfg.ID = cmp.Or(os.Getenv("LEADER_ELECTION_ID"), operatorName+"-leader")
+	cfg.Identity = os.Getenv("POD_NAME")
+	if cfg.Identity == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return cfg, fmt.Errorf("leader election identity: %w", err)
+		}
+		cfg.Identity = h
+	}
+	var err error
+	if cfg.LeaseDuration, err = durationEnv("LEADER_ELECTION_LEASE_DURATION", defaultLeaseDuration); err != nil {
+		return cfg, err
+	}
+	if cfg.RenewDeadline, err = durationEnv("LEADER_ELECTION_RENEW_DEADLINE", defaultRenewDeadline); err != nil {
+		return cfg, err
+	}
+	if cfg.RetryPeriod, err = durationEnv("LEADER_ELECTION_RETRY_PERIOD", defaultRetryPeriod); err != nil {
+		return cfg, err
+	}
+	if cfg.LeaseDuration <= cfg.RenewDeadline {
+		return cfg, fmt.Errorf("LEADER_ELECTION_LEASE_DURATION (%s) must be greater than LEADER_ELECTION_RENEW_DEADLINE (%s)", cfg.LeaseDuration, cfg.RenewDeadline)
+	}
+	return cfg, nil
+}
+
+// durationEnv parses a time.Duration from the named env var, returning def
+// when it is unset.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %s", key, d)
+	}
+	return d, nil
+}
+
+// runLeaderElected blocks until ctx is cancelled, calling run only while this
+// replica holds the Lease. run's context is cancelled on shutdown or when
+// leadership is lost; the Lease is held until run has returned, so a standby
+// cannot take over while this replica is still draining in-flight syncs, and
+// is then released so the standby takes over without waiting out the lease
+// duration. Losing the Lease for any reason other than shutdown exits the
+// process: the workqueue cannot be safely restarted, and a fresh pod rejoins
+// the election as a standby.
+func runLeaderElected(ctx context.Context, client kubernetes.Interface, cfg leaderElectionConfig, run func(ctx context.Context)) error {
+	l := log.WithFields(log.Fields{
+		"fn":       "runLeaderElected",
+		"lease":    cfg.Namespace + "/" + cfg.ID,
+		"identity": cfg.Identity,
+	})
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		cfg.Namespace,
+		cfg.ID,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	)
+	if err != nil {
+		return err
+	}
+	// The elector runs on its own context so that it keeps renewing the
+	// Lease while run drains after ctx is cancelled.
+	electorCtx, stopElector := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopElector()
+	var leading atomic.Bool
+	stop := context.AfterFunc(ctx, func() {
+		if !leading.Load() {
+			stopElector()
+		}
+	})
+	defer stop()
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.ID,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				leading.Store(true)
+				defer stopElector()
+				l.Info("acquired leadership")
+				runCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				stop := context.AfterFunc(leaderCtx, cancel)
+				defer stop()
+				run(runCtx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					l.Info("released leadership")
+					return
+				}
+				l.Fatal("lost leadership")
+			},
+			OnNewLeader: func(identity string) {
+				if identity == cfg.Identity {
+					return
+				}
+				l.WithField("leader", identity).Info("observed new leader")
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	l.Info("waiting for leadership")
+	le.Run(electorCtx)
+	return nil
+}
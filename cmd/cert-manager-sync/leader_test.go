@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func clearLeaderEnv(t *testing.T) {
+	t.Helper()
+	for _, k := range []string{
+		"LEADER_ELECT",
+		"LEADER_ELECTION_NAMESPACE",
+		"LEADER_ELECTION_ID",
+		"LEADER_ELECTION_LEASE_DURATION",
+		"LEADER_ELECTION_RENEW_DEADLINE",
+		"LEADER_ELECTION_RETRY_PERIOD",
+		"POD_NAMESPACE",
+		"POD_NAME",
+	} {
+		t.Setenv(k, "")
+	}
+}
+
+func TestLeaderElectionConfig_DisabledByDefault(t *testing.T) {
+	clearLeaderEnv(t)
+	cfg, err := leaderElectionConfigFromEnv("cert-manager-sync.lestak.sh")
+	require.NoError(t, err)
+	assert.False(t, cfg.Enabled)
+}
+
+func TestLeaderElectionConfig_Defaults(t *testing.T) {
+	clearLeaderEnv(t)
+	t.Setenv("LEADER_ELECT", "true")
+	t.Setenv("POD_NAMESPACE", "cert-manager")
+	t.Setenv("POD_NAME", "cert-manager-sync-abc")
+	cfg, err := leaderElectionConfigFromEnv("cert-manager-sync.lestak.sh")
+	require.NoError(t, err)
+	assert.True(t, cfg.Enabled)
+	assert.Equal(t, "cert-manager", cfg.Namespace)
+	assert.Equal(t, "cert-manager-sync.lestak.sh-leader", cfg.ID)
+	assert.Equal(t, "cert-manager-sync-abc", cfg.Identity)
+	assert.Equal(t, defaultLeaseDuration, cfg.LeaseDuration)
+	assert.Equal(t, defaultRenewDeadline, cfg.RenewDeadline)
+	assert.Equal(t, defaultRetryPeriod, cfg.RetryPeriod)
+}
+
+func TestLeaderElectionConfig_Overrides(t *testing.T) {
+	clearLeaderEnv(t)
+	t.Setenv("LEADER_ELECT", "true")
+	t.Setenv("POD_NAMESPACE", "ignored")
+	t.Setenv("LEADER_ELECTION_NAMESPACE", "ops")
+	t.Setenv("LEADER_ELECTION_ID", "my-lease")
+	t.Setenv("LEADER_ELECTION_LEASE_DURATION", "30s")
+	t.Setenv("LEADER_ELECTION_RENEW_DEADLINE", "20s")
+	t.Setenv("LEADER_ELECTION_RETRY_PERIOD", "5s")
+	cfg, err := leaderElectionConfigFromEnv("cert-manager-sync.lestak.sh")
+	require.NoError(t, err)
+	assert.Equal(t, "ops", cfg.Namespace)
+	assert.Equal(t, "my-lease", cfg.ID)
+	assert.NotEmpty(t, cfg.Identity, "falls back to hostname")
+	assert.Equal(t, 30*time.Second, cfg.LeaseDuration)
+	assert.Equal(t, 20*time.Second, cfg.RenewDeadline)
+	assert.Equal(t, 5*time.Second, cfg.RetryPeriod)
+}
+
+func TestLeaderElectionConfig_Invalid(t *testing.T) {
+	cases := map[string]map[string]string{
+		"bad duration": {
+			"LEADER_ELECTION_LEASE_DURATION": "soon",
+		},
+		"negative duration": {
+			"LEADER_ELECTION_RETRY_PERIOD": "-1s",
+		},
+		"renew deadline not shorter than lease": {
+			"LEADER_ELECTION_LEASE_DURATION": "10s",
+			"LEADER_ELECTION_RENEW_DEADLINE": "10s",
+		},
+	}
+	for name, env := range cases {
+		t.Run(name, func(t *testing.T) {
+			clearLeaderEnv(t)
+			t.Setenv("LEADER_ELECT", "true")
+			t.Setenv("POD_NAMESPACE", "cert-manager")
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			_, err := leaderElectionConfigFromEnv("cert-manager-sync.lestak.sh")
+			assert.Error(t, err)
+		})
+	}
+}
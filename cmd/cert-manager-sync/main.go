@@ -4,14 +4,21 @@ import (
 	"cmp"
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/certificates"
 	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/secretsync"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/webhook"
 	log "github.com/sirupsen/logrus"
 	_ "golang.org/x/crypto/x509roots/fallback" // Embeds x509root certificates into the binary
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 )
@@ -25,60 +32,128 @@ func init() {
 	if os.Getenv("LOG_FORMAT") == "json" {
 		log.SetFormatter(&log.JSONFormatter{})
 	}
-	l := log.WithFields(
-		log.Fields{
-			"action": "init",
-		},
-	)
 	state.OperatorName = cmp.Or(os.Getenv("OPERATOR_NAME"), state.OperatorName)
-	cerr := state.CreateKubeClient()
-	if cerr != nil {
-		l.Fatal(cerr)
-	}
 }
 
 func main() {
+	// Arguments select a one-shot command; without any the operator runs.
+	if len(os.Args) > 1 {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		code := runCommand(ctx, os.Args[1:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
 	l := log.WithFields(
 		log.Fields{
 			"fn": "main",
 		},
 	)
 	l.Info("starting cert-manager-sync")
+	if err := state.CreateKubeClient(); err != nil {
+		l.Fatal(err)
+	}
+	if err := certmanagersync.VerifySchemas(); err != nil {
+		l.Fatal(err)
+	}
+	leCfg, err := leaderElectionConfigFromEnv(state.OperatorName)
+	if err != nil {
+		l.Fatal(err)
+	}
+	gracePeriod, err := shutdownGracePeriod()
+	if err != nil {
+		l.Fatal(err)
+	}
+	driftInterval, err := driftCheckInterval()
+	if err != nil {
+		l.Fatal(err)
+	}
+	if state.DryRun() {
+		l.Warn("DRY_RUN is set; targets are planned but never synced or deleted")
+	}
+	// ctx is cancelled on SIGTERM/SIGINT. Informers stop immediately; the
+	// controller then drains in-flight reconciles for up to gracePeriod.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// The metrics server keeps answering health checks while workers drain
+	// and is stopped only once they have.
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
 	if os.Getenv("ENABLE_METRICS") != "false" {
-		go metrics.Serve()
+		go metrics.Serve(metricsCtx)
 	}
+	// Every replica serves the webhook, leader or not.
+	if webhook.Enabled() {
+		go func() {
+			if err := webhook.Serve(metricsCtx); err != nil {
+				l.Fatal(err)
+			}
+		}()
+	}
+
+	// Informers run on every replica so a standby has a warm cache and a
+	// populated queue the moment it takes over; only the leader runs workers.
 	factory := informers.NewSharedInformerFactory(state.KubeClient, 30*time.Second)
-	secretInformer := factory.Core().V1().Secrets().Informer()
+	defer factory.Shutdown()
+	secrets := factory.Core().V1().Secrets()
+	secretInformer := secrets.Informer()
 
-	stopper := make(chan struct{})
-	defer close(stopper)
+	c := newController(secrets.Lister(), syncWorkers(), gracePeriod)
+	c.driftInterval = driftInterval
+	secretInformer.AddEventHandler(c.eventHandler())
+	cacheSynced := []cache.InformerSynced{secretInformer.HasSynced}
+	dynFactory := dynamicinformer.NewDynamicSharedInformerFactory(state.DynamicClient, 30*time.Second)
+	defer dynFactory.Shutdown()
 
-	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			s, ok := obj.(*v1.Secret)
-			if !ok {
-				return
-			}
-			reconcileSecret(l, s)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			s, ok := newObj.(*v1.Secret)
-			if !ok {
-				return
+	// SecretSync resources are only watched when their CRD is installed;
+	// otherwise secrets are configured through annotations alone.
+	if installed, err := secretsync.CRDInstalled(state.KubeClient.Discovery()); err != nil {
+		l.WithError(err).Warn("unable to discover the SecretSync CRD; SecretSync resources are ignored")
+	} else if installed {
+		ssInformer := dynFactory.ForResource(v1alpha1.SecretSyncResource).Informer()
+		if err := secretsync.Register(ssInformer); err != nil {
+			l.Fatal(err)
+		}
+		ssInformer.AddEventHandler(c.secretSyncEventHandler())
+		cacheSynced = append(cacheSynced, ssInformer.HasSynced)
+	} else {
+		l.Debug("SecretSync CRD not installed")
+	}
+
+	// cert-manager Certificates are watched on request, so secrets issued
+	// for them are synced once they are Ready.
+	if certificates.Enabled() {
+		if installed, err := certificates.CRDInstalled(state.KubeClient.Discovery()); err != nil {
+			l.WithError(err).Warn("unable to discover the cert-manager Certificate CRD; Certificates are ignored")
+		} else if installed {
+			crtInformer := dynFactory.ForResource(certificates.Resource).Informer()
+			if err := certificates.Register(crtInformer); err != nil {
+				l.Fatal(err)
 			}
-			reconcileSecret(l, s)
-		},
-	})
+			crtInformer.AddEventHandler(c.certificateEventHandler())
+			cacheSynced = append(cacheSynced, crtInformer.HasSynced)
+		} else {
+			l.Warn("WATCH_CERTIFICATES is enabled but the cert-manager Certificate CRD is not installed")
+		}
+	}
 
-	factory.Start(stopper)
+	factory.Start(ctx.Done())
+	dynFactory.Start(ctx.Done())
 
 	// Wait for the caches to sync
-	if !cache.WaitForCacheSync(stopper, secretInformer.HasSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), cacheSynced...) {
+		if ctx.Err() != nil {
+			l.Info("shutdown requested before caches synced")
+			return
+		}
 		panic("Timed out waiting for caches to sync")
 	}
 
-	// Run the informer
-	<-stopper
+	if !leCfg.Enabled {
+		c.run(ctx)
+	} else if err := runLeaderElected(ctx, state.KubeClient, leCfg, c.run); err != nil {
+		l.Fatal(err)
+	}
+	l.Info("shut down cleanly")
 }
 
 // Function-typed indirection so reconcileSecret can be exercised without
@@ -88,6 +163,9 @@ var (
 	handleSecretDeleteFn = certmanagersync.HandleSecretDelete
 	ensureFinalizerFn    = certmanagersync.EnsureFinalizer
 	removeFinalizerFn    = certmanagersync.RemoveFinalizer
+	syncRetryAfterFn     = certmanagersync.RetryAfter
+	deleteRetryAfterFn   = certmanagersync.DeleteRetryAfter
+	detectDriftFn        = certmanagersync.DetectDrift
 )
 
 // reconcileSecret routes a secret event to the right handler based on its
@@ -103,28 +181,44 @@ var (
 //  4. For watched secrets that have switched away from "delete", drop the
 //     finalizer so the user is not left with a stuck secret.
 //  5. Run the normal HandleSecret sync path.
-func reconcileSecret(l *log.Entry, s *v1.Secret) {
-	ctx := context.Background()
-
+//
+// With DRY_RUN set, none of this runs: the plan of the secret is reported
+// instead.
+//
+// The returned duration asks the caller to requeue the secret once the
+// persisted sync or delete backoff has elapsed; zero means no timed requeue
+// is needed. A non-nil error asks the caller to retry with rate limiting.
+func reconcileSecret(ctx context.Context, l *log.Entry, s *v1.Secret) (time.Duration, error) {
+	if state.DryRun() {
+		return planSecret(ctx, l, s)
+	}
 	if state.SecretDeletePending(s) {
-		if err := handleSecretDeleteFn(s); err != nil {
+		if err := handleSecretDeleteFn(ctx, s); err != nil {
 			l.WithError(err).WithFields(log.Fields{
 				"namespace": s.Namespace,
 				"name":      s.Name,
 			}).Error("delete reconcile error")
+			return 0, err
 		}
-		return
+		return deleteRetryAfterFn(s), nil
 	}
 
-	if !state.SecretWatched(s) {
-		// The secret may have lost its sync-enabled annotation while still
-		// carrying our finalizer; drop the finalizer so the user is not stuck.
+	if !secretWatched(s) {
+		if _, synced := s.Annotations[state.SyncStateAnnotation()]; synced {
+			// Sync was turned off; stop reporting the secret.
+			metrics.DeleteSecret(s.Namespace, s.Name)
+		}
+		certmanagersync.ForgetSecret(s.Namespace, s.Name)
+		// The secret may have lost its sync-enabled annotation or SecretSync
+		// while still carrying our finalizer; drop the finalizer so the user
+		// is not stuck.
 		if state.HasFinalizer(s) && s.DeletionTimestamp == nil {
 			if _, err := removeFinalizerFn(ctx, s); err != nil {
 				l.WithError(err).Error("failed to remove finalizer from no-longer-watched secret")
+				return 0, err
 			}
 		}
-		return
+		return 0, nil
 	}
 
 	if state.EffectiveDeletePolicy(s) == state.DeletePolicyDelete {
@@ -142,7 +236,15 @@ func reconcileSecret(l *log.Entry, s *v1.Secret) {
 		}
 	}
 
-	if err := handleSecretFn(s); err != nil {
+	if err := handleSecretFn(ctx, s); err != nil {
 		l.Error(err)
+		return 0, err
 	}
+	return syncRetryAfterFn(s), nil
+}
+
+// secretWatched reports whether the secret is configured for sync, either
+// through its annotations or by a SecretSync referencing it.
+func secretWatched(s *v1.Secret) bool {
+	return state.SecretWatched(s) || secretsync.Watched(s)
 }
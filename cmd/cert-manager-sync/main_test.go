@@ -21,6 +21,8 @@ type fns struct {
 	deleteErr   error
 	ensureErr   error
 	removeErr   error
+	driftCalls  int
+	driftResync bool
 }
 
 // install replaces the package-level handler vars with stubs that record calls
@@ -31,12 +33,13 @@ func (f *fns) install(t *testing.T) {
 	prevDel := handleSecretDeleteFn
 	prevEnsure := ensureFinalizerFn
 	prevRemove := removeFinalizerFn
+	prevDrift := detectDriftFn
 
-	handleSecretFn = func(_ *corev1.Secret) error {
+	handleSecretFn = func(_ context.Context, _ *corev1.Secret) error {
 		f.syncCalls++
 		return f.syncErr
 	}
-	handleSecretDeleteFn = func(_ *corev1.Secret) error {
+	handleSecretDeleteFn = func(_ context.Context, _ *corev1.Secret) error {
 		f.deleteCalls++
 		return f.deleteErr
 	}
@@ -48,12 +51,17 @@ func (f *fns) install(t *testing.T) {
 		f.removeCalls++
 		return f.removeErr == nil, f.removeErr
 	}
+	detectDriftFn = func(_ context.Context, _ *corev1.Secret) (bool, error) {
+		f.driftCalls++
+		return f.driftResync, nil
+	}
 
 	t.Cleanup(func() {
 		handleSecretFn = prevSync
 		handleSecretDeleteFn = prevDel
 		ensureFinalizerFn = prevEnsure
 		removeFinalizerFn = prevRemove
+		detectDriftFn = prevDrift
 	})
 }
 
@@ -93,7 +101,7 @@ func TestReconcileSecret_NotWatched_NoOp(t *testing.T) {
 	f := &fns{}
 	f.install(t)
 	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: "ns"}}
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Zero(t, f.syncCalls+f.deleteCalls+f.ensureCalls+f.removeCalls)
 }
 
@@ -102,7 +110,7 @@ func TestReconcileSecret_WatchedRetainPolicy_NoFinalizer_JustSyncs(t *testing.T)
 	f := &fns{}
 	f.install(t)
 	s := watchedSecret("s", nil, nil)
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.syncCalls)
 	assert.Equal(t, 0, f.ensureCalls)
 	assert.Equal(t, 0, f.removeCalls)
@@ -116,7 +124,7 @@ func TestReconcileSecret_WatchedDeletePolicy_AddsFinalizerThenSyncs(t *testing.T
 	s := watchedSecret("s", map[string]string{
 		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
 	}, nil)
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.ensureCalls)
 	assert.Equal(t, 1, f.syncCalls)
 }
@@ -127,7 +135,7 @@ func TestReconcileSecret_WatchedDeletePolicy_GlobalEnv(t *testing.T) {
 	f := &fns{}
 	f.install(t)
 	s := watchedSecret("s", nil, nil)
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.ensureCalls, "global env should trigger finalizer ensure")
 	assert.Equal(t, 1, f.syncCalls)
 }
@@ -139,7 +147,7 @@ func TestReconcileSecret_PolicyFlipsToRetain_RemovesFinalizer(t *testing.T) {
 	s := watchedSecret("s", map[string]string{
 		state.DeletePolicyAnnotation(): state.DeletePolicyRetain,
 	}, []string{state.FinalizerName()})
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.removeCalls)
 	assert.Equal(t, 0, f.ensureCalls)
 	assert.Equal(t, 1, f.syncCalls)
@@ -156,7 +164,7 @@ func TestReconcileSecret_NoLongerWatched_DropsFinalizer(t *testing.T) {
 			Finalizers: []string{state.FinalizerName()},
 		},
 	}
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.removeCalls, "finalizer should be removed when secret stops being watched")
 	assert.Equal(t, 0, f.syncCalls, "sync should not run for unwatched secret")
 }
@@ -170,7 +178,7 @@ func TestReconcileSecret_DeletionTimestamp_RoutesToDelete(t *testing.T) {
 		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
 	}, []string{state.FinalizerName()})
 	s.DeletionTimestamp = &now
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.deleteCalls)
 	assert.Equal(t, 0, f.syncCalls, "sync must not run for a secret being deleted")
 	assert.Equal(t, 0, f.ensureCalls)
@@ -183,7 +191,7 @@ func TestReconcileSecret_DeletionTimestamp_NoFinalizer_NoOp(t *testing.T) {
 	now := metav1.Now()
 	s := watchedSecret("s", nil, nil)
 	s.DeletionTimestamp = &now
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	// SecretDeletePending requires both timestamp and finalizer; without both, no delete handling.
 	assert.Equal(t, 0, f.deleteCalls)
 	// Sync should also not run because SecretWatched is true but the secret is already gone-ish
@@ -201,8 +209,9 @@ func TestReconcileSecret_DeleteHandlerError_Logged(t *testing.T) {
 		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
 	}, []string{state.FinalizerName()})
 	s.DeletionTimestamp = &now
-	// Should not panic; reconcileSecret swallows the error after logging.
-	reconcileSecret(log.NewEntry(log.New()), s)
+	// The error is surfaced so the controller requeues with rate limiting.
+	_, err := reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
+	assert.ErrorIs(t, err, assertErr("boom"))
 	assert.Equal(t, 1, f.deleteCalls)
 }
 
@@ -213,7 +222,7 @@ func TestReconcileSecret_EnsureFinalizerError_StillAttemptsSync(t *testing.T) {
 	s := watchedSecret("s", map[string]string{
 		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
 	}, nil)
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 1, f.ensureCalls)
 	assert.Equal(t, 1, f.syncCalls, "sync should still run even if finalizer ensure failed")
 }
@@ -227,7 +236,7 @@ func TestReconcileSecret_DeletionTimestamp_OtherFinalizerOnly_NoOp(t *testing.T)
 	now := metav1.Now()
 	s := watchedSecret("s", nil, []string{"other.example.com/foo"})
 	s.DeletionTimestamp = &now
-	reconcileSecret(log.NewEntry(log.New()), s)
+	reconcileSecret(context.Background(), log.NewEntry(log.New()), s)
 	assert.Equal(t, 0, f.deleteCalls)
 }
 
@@ -2,8 +2,12 @@ package metrics
 
 import (
 	"cmp"
+	"context"
+	"crypto/x509"
+	"errors"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -13,39 +17,256 @@ import (
 var (
 	SyncStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "cert_manager_sync_status",
-		Help: "cert-manager-sync status by namespace, secret, and store",
-	}, []string{"namespace", "secret", "store", "status"})
+		Help: "cert-manager-sync status by namespace, secret, store, and target",
+	}, []string{"namespace", "secret", "store", "target", "status"})
+	CertificateNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_certificate_not_after_timestamp_seconds",
+		Help: "Expiry of the leaf certificate of a synced secret, in seconds since the epoch",
+	}, []string{"namespace", "secret"})
+	CertificateNotBefore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_certificate_not_before_timestamp_seconds",
+		Help: "Start of the validity of the leaf certificate of a synced secret, in seconds since the epoch",
+	}, []string{"namespace", "secret"})
+	CertificateInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_certificate_info",
+		Help: "Identity of the leaf certificate of a synced secret; always 1",
+	}, []string{"namespace", "secret", "subject", "issuer", "serial"})
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_last_success_timestamp_seconds",
+		Help: "Time of the last successful sync of a secret to a target, in seconds since the epoch",
+	}, []string{"namespace", "secret", "target"})
+	FailedAttempts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_failed_attempts",
+		Help: "Consecutive failed sync attempts of a secret to a target",
+	}, []string{"namespace", "secret", "target"})
+	NextRetry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_next_retry_timestamp_seconds",
+		Help: "Time of the next sync attempt of a failing target, in seconds since the epoch",
+	}, []string{"namespace", "secret", "target"})
+	SyncAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cert_manager_sync_sync_attempts_total",
+		Help: "Store sync attempts by store and result",
+	}, []string{"store", "result"})
+	DeleteAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cert_manager_sync_delete_attempts_total",
+		Help: "Remote certificate delete attempts by store and result",
+	}, []string{"store", "result"})
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cert_manager_sync_sync_duration_seconds",
+		Help:    "Duration of store sync calls by store",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"store"})
+	Drift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_drift",
+		Help: "1 when the remote certificate of a target no longer matches the secret, 0 when it did at the last check",
+	}, []string{"namespace", "secret", "target"})
+	RemoteNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_remote_certificate_not_after_timestamp_seconds",
+		Help: "Expiry of the certificate a target serves at the last check, in seconds since the epoch",
+	}, []string{"namespace", "secret", "target"})
+	DriftChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cert_manager_sync_drift_checks_total",
+		Help: "Remote certificate drift checks by store and result",
+	}, []string{"store", "result"})
+	SyncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cert_manager_sync_sync_errors_total",
+		Help: "Failed store sync attempts by store and error category",
+	}, []string{"store", "category"})
+	CircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cert_manager_sync_circuit_state",
+		Help: "State of the circuit breaker of a store, endpoint and credential: 0 closed, 1 half-open, 2 open",
+	}, []string{"store", "endpoint", "credential"})
 )
 
+// Results of a sync or delete attempt.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	// ResultSkipped is a delete from a store that does not support it.
+	ResultSkipped = "skipped"
+	// ResultUnchanged is a sync to a store that already held the certificate,
+	// which was not written again.
+	ResultUnchanged = "unchanged"
+	// ResultDeferred is a call that was not made because the store's circuit
+	// was open or its rate limit was reached.
+	ResultDeferred = "deferred"
+)
+
+// Results of a drift check other than ResultFailure.
+const (
+	ResultInSync  = "in_sync"
+	ResultDrifted = "drifted"
+	ResultMissing = "missing"
+)
+
+// perTarget are the vectors with a series per target of a secret, which are
+// dropped when the target is removed.
+var perTarget = []*prometheus.GaugeVec{
+	SyncStatus,
+	LastSuccess,
+	FailedAttempts,
+	NextRetry,
+	Drift,
+	RemoteNotAfter,
+}
+
+// perSecret are the vectors with a series per secret, which are dropped when
+// the secret goes away.
+var perSecret = []*prometheus.GaugeVec{
+	SyncStatus,
+	CertificateNotAfter,
+	CertificateNotBefore,
+	CertificateInfo,
+	LastSuccess,
+	FailedAttempts,
+	NextRetry,
+	Drift,
+	RemoteNotAfter,
+}
+
 func InitMetrics() {
-	prometheus.MustRegister(SyncStatus)
+	prometheus.MustRegister(SyncStatus, CertificateNotAfter, CertificateNotBefore, CertificateInfo,
+		LastSuccess, FailedAttempts, NextRetry, SyncAttempts, DeleteAttempts, SyncDuration,
+		Drift, RemoteNotAfter, DriftChecks, SyncErrors, CircuitState)
+}
+
+// SetSuccess marks the secret as synced to target, a store at an index,
+// clearing an earlier failure.
+func SetSuccess(namespace, secret, store, target string) {
+	SyncStatus.DeleteLabelValues(namespace, secret, store, target, "fail")
+	SyncStatus.WithLabelValues(namespace, secret, store, target, "success").Set(1)
+}
+
+// SetFailure marks the secret as failing to sync to target, a store at an
+// index, clearing an earlier success.
+func SetFailure(namespace, secret, store, target string) {
+	SyncStatus.DeleteLabelValues(namespace, secret, store, target, "success")
+	SyncStatus.WithLabelValues(namespace, secret, store, target, "fail").Set(1)
+}
+
+// SetCertificate records the validity and identity of the leaf certificate of
+// a secret, replacing those of the certificate it held before.
+func SetCertificate(namespace, secret string, cert *x509.Certificate) {
+	CertificateInfo.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "secret": secret})
+	CertificateNotAfter.WithLabelValues(namespace, secret).Set(float64(cert.NotAfter.Unix()))
+	CertificateNotBefore.WithLabelValues(namespace, secret).Set(float64(cert.NotBefore.Unix()))
+	CertificateInfo.WithLabelValues(namespace, secret, cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.Text(16)).Set(1)
+}
+
+// SetTargetSynced records a successful sync of a secret to target at t,
+// clearing its backoff and any drift found before.
+func SetTargetSynced(namespace, secret, target string, t time.Time) {
+	LastSuccess.WithLabelValues(namespace, secret, target).Set(float64(t.Unix()))
+	SetTargetBackoff(namespace, secret, target, 0, time.Time{})
+	Drift.DeleteLabelValues(namespace, secret, target)
+	RemoteNotAfter.DeleteLabelValues(namespace, secret, target)
+}
+
+// SetDrift records the outcome of a drift check of a secret's target, and the
+// expiry of the remote certificate when there is one.
+func SetDrift(namespace, secret, target string, drifted bool, remoteNotAfter time.Time) {
+	v := 0.0
+	if drifted {
+		v = 1
+	}
+	Drift.WithLabelValues(namespace, secret, target).Set(v)
+	if remoteNotAfter.IsZero() {
+		RemoteNotAfter.DeleteLabelValues(namespace, secret, target)
+		return
+	}
+	RemoteNotAfter.WithLabelValues(namespace, secret, target).Set(float64(remoteNotAfter.Unix()))
+}
+
+// ObserveDriftCheck counts a drift check of a store's target with the given
+// result.
+func ObserveDriftCheck(store, result string) {
+	DriftChecks.WithLabelValues(store, result).Inc()
+}
+
+// SetTargetBackoff records the failed attempts of a secret's target and when
+// it is next retried. No next retry is recorded for a zero nextRetry.
+func SetTargetBackoff(namespace, secret, target string, attempts int, nextRetry time.Time) {
+	FailedAttempts.WithLabelValues(namespace, secret, target).Set(float64(attempts))
+	if nextRetry.IsZero() {
+		NextRetry.DeleteLabelValues(namespace, secret, target)
+		return
+	}
+	NextRetry.WithLabelValues(namespace, secret, target).Set(float64(nextRetry.Unix()))
+}
+
+// ObserveSync counts a sync attempt to store with the given result.
+func ObserveSync(store, result string) {
+	SyncAttempts.WithLabelValues(store, result).Inc()
+}
+
+// ObserveSyncError counts a failed sync attempt to store by the category of
+// its error.
+func ObserveSyncError(store, category string) {
+	SyncErrors.WithLabelValues(store, category).Inc()
 }
 
-func SetSuccess(namespace, secret, store string) {
-	SyncStatus.WithLabelValues(namespace, secret, store, "success").Set(1)
+// SetCircuitState records the state of the circuit breaker of a store,
+// endpoint and credential, as a storeguard.State.
+func SetCircuitState(store, endpoint, credential string, state int) {
+	CircuitState.WithLabelValues(store, endpoint, credential).Set(float64(state))
 }
 
-func SetFailure(namespace, secret, store string) {
-	SyncStatus.WithLabelValues(namespace, secret, store, "fail").Set(1)
+// ObserveSyncDuration records the duration of a store's sync call.
+func ObserveSyncDuration(store string, d time.Duration) {
+	SyncDuration.WithLabelValues(store).Observe(d.Seconds())
+}
+
+// ObserveDelete counts a remote delete attempt with the given result.
+func ObserveDelete(store, result string) {
+	DeleteAttempts.WithLabelValues(store, result).Inc()
+}
+
+// DeleteTarget drops every series of a target of a secret, for targets
+// removed from its config.
+func DeleteTarget(namespace, secret, target string) {
+	for _, v := range perTarget {
+		v.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "secret": secret, "target": target})
+	}
+}
+
+// DeleteSecret drops every series of a secret, for secrets that are deleted
+// or no longer synced.
+func DeleteSecret(namespace, secret string) {
+	for _, v := range perSecret {
+		v.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "secret": secret})
+	}
 }
 
 func init() {
 	InitMetrics()
 }
 
-func Serve() {
+// Serve runs the metrics and health server until ctx is cancelled, then
+// shuts it down, giving in-flight scrapes a few seconds to complete.
+func Serve(ctx context.Context) {
 	l := log.WithFields(log.Fields{
 		"pkg": "metrics",
 		"fn":  "Serve",
 	})
 	l.Debug("starting metrics server")
 	port := cmp.Or(os.Getenv("METRICS_PORT"), "9090")
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	stop := context.AfterFunc(ctx, func() {
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			l.WithError(err).Warn("error shutting down http server")
+		}
+	})
+	defer stop()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		l.WithError(err).Error("error starting http server")
 		os.Exit(1)
 	}
+	l.Debug("metrics server stopped")
 }
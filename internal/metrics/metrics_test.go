@@ -1,12 +1,17 @@
 package metrics
 
 import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -39,7 +44,12 @@ func TestServe(t *testing.T) {
 	logrus.SetLevel(logrus.PanicLevel)
 
 	// Start the metrics server in a separate goroutine
-	go Serve()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Serve(ctx)
+		close(done)
+	}()
 
 	// Wait a moment for the server to start
 	time.Sleep(100 * time.Millisecond)
@@ -54,6 +64,124 @@ func TestServe(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	// Cancelling the context stops the server
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after context cancellation")
+	}
+
 	// Cleanup: reset the environment variable
 	os.Unsetenv("METRICS_PORT")
 }
+
+func TestSetSuccessClearsFailure(t *testing.T) {
+	SetFailure("status-ns", "s", "acm", "acm")
+	SetSuccess("status-ns", "s", "acm", "acm")
+	assert.Equal(t, 1.0, testutil.ToFloat64(SyncStatus.WithLabelValues("status-ns", "s", "acm", "acm", "success")))
+	assert.False(t, SyncStatus.DeleteLabelValues("status-ns", "s", "acm", "acm", "fail"), "the fail series is removed")
+
+	SetFailure("status-ns", "s", "acm", "acm")
+	assert.False(t, SyncStatus.DeleteLabelValues("status-ns", "s", "acm", "acm", "success"), "the success series is removed")
+
+	SetSuccess("status-ns", "s", "acm", "acm.1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(SyncStatus.WithLabelValues("status-ns", "s", "acm", "acm", "fail")), "targets of the same store are reported apart")
+}
+
+func TestDeleteTarget(t *testing.T) {
+	SetSuccess("target-ns", "s", "acm", "acm.1")
+	SetTargetBackoff("target-ns", "s", "acm.1", 2, time.Unix(5000, 0))
+	SetDrift("target-ns", "s", "acm.1", true, time.Unix(7000, 0))
+	SetSuccess("target-ns", "s", "acm", "acm")
+
+	DeleteTarget("target-ns", "s", "acm.1")
+	for _, v := range perTarget {
+		assert.Zero(t, v.DeletePartialMatch(prometheus.Labels{"namespace": "target-ns", "secret": "s", "target": "acm.1"}))
+	}
+	assert.True(t, SyncStatus.DeleteLabelValues("target-ns", "s", "acm", "acm", "success"), "other targets are kept")
+}
+
+func TestSetCertificate(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "example.com"},
+		Issuer:       pkix.Name{CommonName: "issuer"},
+		SerialNumber: big.NewInt(255),
+		NotBefore:    time.Unix(1000, 0),
+		NotAfter:     time.Unix(2000, 0),
+	}
+	SetCertificate("cert-ns", "s", cert)
+	assert.Equal(t, 2000.0, testutil.ToFloat64(CertificateNotAfter.WithLabelValues("cert-ns", "s")))
+	assert.Equal(t, 1000.0, testutil.ToFloat64(CertificateNotBefore.WithLabelValues("cert-ns", "s")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(CertificateInfo.WithLabelValues("cert-ns", "s", "CN=example.com", "CN=issuer", "ff")))
+
+	renewed := *cert
+	renewed.SerialNumber = big.NewInt(256)
+	SetCertificate("cert-ns", "s", &renewed)
+	assert.False(t, CertificateInfo.DeleteLabelValues("cert-ns", "s", "CN=example.com", "CN=issuer", "ff"), "the old certificate is no longer reported")
+	assert.True(t, CertificateInfo.DeleteLabelValues("cert-ns", "s", "CN=example.com", "CN=issuer", "100"))
+}
+
+func TestSetTargetBackoff(t *testing.T) {
+	next := time.Unix(5000, 0)
+	SetTargetBackoff("backoff-ns", "s", "acm.1", 3, next)
+	assert.Equal(t, 3.0, testutil.ToFloat64(FailedAttempts.WithLabelValues("backoff-ns", "s", "acm.1")))
+	assert.Equal(t, 5000.0, testutil.ToFloat64(NextRetry.WithLabelValues("backoff-ns", "s", "acm.1")))
+
+	SetTargetSynced("backoff-ns", "s", "acm.1", time.Unix(6000, 0))
+	assert.Equal(t, 6000.0, testutil.ToFloat64(LastSuccess.WithLabelValues("backoff-ns", "s", "acm.1")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(FailedAttempts.WithLabelValues("backoff-ns", "s", "acm.1")))
+	assert.False(t, NextRetry.DeleteLabelValues("backoff-ns", "s", "acm.1"), "a synced target has no next retry")
+}
+
+func TestObserveSyncAndDelete(t *testing.T) {
+	success := testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultSuccess))
+	failure := testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultFailure))
+	unchanged := testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultUnchanged))
+	ObserveSync("observe", ResultSuccess)
+	ObserveSync("observe", ResultFailure)
+	ObserveSync("observe", ResultFailure)
+	ObserveSync("observe", ResultUnchanged)
+	assert.Equal(t, success+1, testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultSuccess)))
+	assert.Equal(t, failure+2, testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultFailure)))
+	assert.Equal(t, unchanged+1, testutil.ToFloat64(SyncAttempts.WithLabelValues("observe", ResultUnchanged)))
+
+	skipped := testutil.ToFloat64(DeleteAttempts.WithLabelValues("observe", ResultSkipped))
+	ObserveDelete("observe", ResultSkipped)
+	assert.Equal(t, skipped+1, testutil.ToFloat64(DeleteAttempts.WithLabelValues("observe", ResultSkipped)))
+
+	auth := testutil.ToFloat64(SyncErrors.WithLabelValues("observe", "auth"))
+	ObserveSyncError("observe", "auth")
+	assert.Equal(t, auth+1, testutil.ToFloat64(SyncErrors.WithLabelValues("observe", "auth")))
+
+	SetCircuitState("observe", "", "secret ns/cf", 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(CircuitState.WithLabelValues("observe", "", "secret ns/cf")))
+
+	ObserveSyncDuration("observe", 3*time.Second)
+	assert.Equal(t, 1, testutil.CollectAndCount(SyncDuration))
+}
+
+func TestDeleteSecret(t *testing.T) {
+	SetSuccess("delete-ns", "s", "acm", "acm")
+	SetCertificate("delete-ns", "s", &x509.Certificate{SerialNumber: big.NewInt(1)})
+	SetTargetBackoff("delete-ns", "s", "acm", 2, time.Unix(5000, 0))
+	SetSuccess("delete-ns", "other", "acm", "acm")
+
+	DeleteSecret("delete-ns", "s")
+	for _, v := range perSecret {
+		assert.Zero(t, v.DeletePartialMatch(prometheus.Labels{"namespace": "delete-ns", "secret": "s"}))
+	}
+	assert.True(t, SyncStatus.DeleteLabelValues("delete-ns", "other", "acm", "acm", "success"), "other secrets are kept")
+}
+
+func TestSetDrift(t *testing.T) {
+	SetDrift("drift-ns", "s", "acm", true, time.Unix(7000, 0))
+	assert.Equal(t, 1.0, testutil.ToFloat64(Drift.WithLabelValues("drift-ns", "s", "acm")))
+	assert.Equal(t, 7000.0, testutil.ToFloat64(RemoteNotAfter.WithLabelValues("drift-ns", "s", "acm")))
+
+	SetDrift("drift-ns", "s", "acm", true, time.Time{})
+	assert.False(t, RemoteNotAfter.DeleteLabelValues("drift-ns", "s", "acm"), "a missing certificate has no expiry")
+
+	SetTargetSynced("drift-ns", "s", "acm", time.Unix(8000, 0))
+	assert.False(t, Drift.DeleteLabelValues("drift-ns", "s", "acm"), "a sync clears the drift")
+}
@@ -0,0 +1,214 @@
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+)
+
+// StoreConfig returns the store type of the target and its config in the
+// key format of the store annotations, e.g. "role-arn" for ACMTarget.RoleARN.
+// It is an error to set no store or more than one.
+func (t *SyncTarget) StoreConfig() (string, map[string]string, error) {
+	var (
+		store  string
+		config map[string]string
+		n      int
+	)
+	set := func(s string, c map[string]string) {
+		store, config = s, c
+		n++
+	}
+	if t.ACM != nil {
+		set(string(cmtypes.ACMStoreType), t.ACM.config())
+	}
+	if t.Cloudflare != nil {
+		set(string(cmtypes.CloudflareStoreType), t.Cloudflare.config())
+	}
+	if t.DigitalOcean != nil {
+		set(string(cmtypes.DigitalOceanStoreType), t.DigitalOcean.config())
+	}
+	if t.Filepath != nil {
+		set(string(cmtypes.FilepathStoreType), t.Filepath.config())
+	}
+	if t.GCP != nil {
+		set(string(cmtypes.GCPStoreType), t.GCP.config())
+	}
+	if t.Heroku != nil {
+		set(string(cmtypes.HerokuStoreType), t.Heroku.config())
+	}
+	if t.HetznerCloud != nil {
+		set(string(cmtypes.HetznerCloudStoreType), t.HetznerCloud.config())
+	}
+	if t.Imperva != nil {
+		set(string(cmtypes.ImpervaStoreType), t.Imperva.config())
+	}
+	if t.ThreatX != nil {
+		set(string(cmtypes.ThreatxStoreType), t.ThreatX.config())
+	}
+	if t.Vault != nil {
+		set(string(cmtypes.VaultStoreType), t.Vault.config())
+	}
+	switch n {
+	case 0:
+		return "", nil, errors.New("no store set")
+	case 1:
+		if t.Credential != "" {
+			config["credential"] = t.Credential
+		}
+		if t.ClusterCredential != "" {
+			config["cluster-credential"] = t.ClusterCredential
+		}
+		return store, config, nil
+	default:
+		return "", nil, fmt.Errorf("%d stores set, want exactly one", n)
+	}
+}
+
+// TargetName returns the target's name, defaulting to its store type.
+func (t *SyncTarget) TargetName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	store, _, _ := t.StoreConfig()
+	return store
+}
+
+// Validate reports the first structural problem with the spec: a missing
+// secret name, no targets, a target without exactly one store or with two
+// credential profiles, or two targets sharing a name.
+func (s *SecretSyncSpec) Validate() error {
+	if s.SecretName == "" {
+		return errors.New("spec.secretName is required")
+	}
+	if len(s.Targets) == 0 {
+		return errors.New("spec.targets must not be empty")
+	}
+	seen := make(map[string]int, len(s.Targets))
+	for i := range s.Targets {
+		t := &s.Targets[i]
+		if _, _, err := t.StoreConfig(); err != nil {
+			return fmt.Errorf("spec.targets[%d]: %w", i, err)
+		}
+		if t.Credential != "" && t.ClusterCredential != "" {
+			return fmt.Errorf("spec.targets[%d]: credential and clusterCredential are mutually exclusive", i)
+		}
+		name := t.TargetName()
+		if j, ok := seen[name]; ok {
+			return fmt.Errorf("spec.targets[%d]: name %q is already used by spec.targets[%d]; set a unique name", i, name, j)
+		}
+		seen[name] = i
+	}
+	return nil
+}
+
+// configMap builds an annotation-style config from key/value pairs, dropping
+// empty values so that unset fields fall back to store defaults exactly as a
+// missing annotation would.
+func configMap(kv ...string) map[string]string {
+	m := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			m[kv[i]] = kv[i+1]
+		}
+	}
+	return m
+}
+
+func boolString(b bool) string {
+	if !b {
+		return ""
+	}
+	return strconv.FormatBool(b)
+}
+
+func (t *ACMTarget) config() map[string]string {
+	return configMap(
+		"region", t.Region,
+		"role-arn", t.RoleARN,
+		"certificate-arn", t.CertificateARN,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *CloudflareTarget) config() map[string]string {
+	return configMap(
+		"zone-id", t.ZoneID,
+		"cert-id", t.CertID,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *DigitalOceanTarget) config() map[string]string {
+	return configMap(
+		"cert-name", t.CertName,
+		"cert-id", t.CertID,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *FilepathTarget) config() map[string]string {
+	return configMap(
+		"dir", t.Dir,
+		"cert", t.Cert,
+		"key", t.Key,
+		"ca", t.CA,
+	)
+}
+
+func (t *GCPTarget) config() map[string]string {
+	return configMap(
+		"project", t.Project,
+		"location", t.Location,
+		"certificate-name", t.CertificateName,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *HerokuTarget) config() map[string]string {
+	return configMap(
+		"app", t.App,
+		"cert-name", t.CertName,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *HetznerCloudTarget) config() map[string]string {
+	return configMap(
+		"cert-name", t.CertName,
+		"cert-id", t.CertID,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *ImpervaTarget) config() map[string]string {
+	return configMap(
+		"site-id", t.SiteID,
+		"auth-type", t.AuthType,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *ThreatXTarget) config() map[string]string {
+	return configMap(
+		"hostname", t.Hostname,
+		"secret-name", t.CredentialsSecret,
+	)
+}
+
+func (t *VaultTarget) config() map[string]string {
+	return configMap(
+		"addr", t.Addr,
+		"path", t.Path,
+		"namespace", t.Namespace,
+		"role", t.Role,
+		"auth-method", t.AuthMethod,
+		"base64-decode", boolString(t.Base64Decode),
+		"pkcs12", boolString(t.PKCS12),
+		"pkcs12-password-secret", t.PKCS12PasswordSecret,
+		"pkcs12-password-secret-key", t.PKCS12PasswordSecretKey,
+		"pkcs12-password-secret-namespace", t.PKCS12PasswordSecretNamespace,
+	)
+}
@@ -0,0 +1,82 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTarget_StoreConfig(t *testing.T) {
+	target := SyncTarget{ACM: &ACMTarget{
+		Region:            "us-east-1",
+		RoleARN:           "arn:aws:iam::123456789012:role/sync",
+		CredentialsSecret: "aws",
+	}}
+	store, config, err := target.StoreConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "acm", store)
+	assert.Equal(t, map[string]string{
+		"region":      "us-east-1",
+		"role-arn":    "arn:aws:iam::123456789012:role/sync",
+		"secret-name": "aws",
+	}, config, "unset fields are omitted")
+}
+
+func TestSyncTarget_StoreConfig_VaultBooleans(t *testing.T) {
+	target := SyncTarget{Vault: &VaultTarget{Addr: "https://vault", Path: "kv/tls", PKCS12: true}}
+	_, config, err := target.StoreConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "true", config["pkcs12"])
+	assert.NotContains(t, config, "base64-decode")
+}
+
+func TestSyncTarget_StoreConfig_RequiresExactlyOneStore(t *testing.T) {
+	_, _, err := (&SyncTarget{}).StoreConfig()
+	assert.Error(t, err)
+
+	_, _, err = (&SyncTarget{ACM: &ACMTarget{}, Vault: &VaultTarget{}}).StoreConfig()
+	assert.Error(t, err)
+}
+
+func TestSyncTarget_StoreConfig_Credential(t *testing.T) {
+	_, config, err := (&SyncTarget{Credential: "aws", ACM: &ACMTarget{}}).StoreConfig()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"credential": "aws"}, config)
+
+	_, config, err = (&SyncTarget{ClusterCredential: "aws", ACM: &ACMTarget{}}).StoreConfig()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster-credential": "aws"}, config)
+}
+
+func TestSyncTarget_TargetName(t *testing.T) {
+	assert.Equal(t, "heroku", (&SyncTarget{Heroku: &HerokuTarget{App: "a"}}).TargetName())
+	assert.Equal(t, "prod", (&SyncTarget{Name: "prod", Heroku: &HerokuTarget{App: "a"}}).TargetName())
+}
+
+func TestSecretSyncSpec_Validate(t *testing.T) {
+	acm := func(name string) SyncTarget { return SyncTarget{Name: name, ACM: &ACMTarget{Region: "us-east-1"}} }
+	tests := []struct {
+		name    string
+		spec    SecretSyncSpec
+		wantErr bool
+	}{
+		{"valid", SecretSyncSpec{SecretName: "s", Targets: []SyncTarget{acm("")}}, false},
+		{"distinct names", SecretSyncSpec{SecretName: "s", Targets: []SyncTarget{acm("a"), acm("b")}}, false},
+		{"missing secret", SecretSyncSpec{Targets: []SyncTarget{acm("")}}, true},
+		{"no targets", SecretSyncSpec{SecretName: "s"}, true},
+		{"no store", SecretSyncSpec{SecretName: "s", Targets: []SyncTarget{{Name: "x"}}}, true},
+		{"duplicate default name", SecretSyncSpec{SecretName: "s", Targets: []SyncTarget{acm(""), acm("")}}, true},
+		{"two credential profiles", SecretSyncSpec{SecretName: "s", Targets: []SyncTarget{{Credential: "a", ClusterCredential: "b", ACM: &ACMTarget{}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// Package v1alpha1 contains the v1alpha1 custom resources of the operator.
+//
+// The types are read and written as unstructured objects through the dynamic
+// client, so no generated clientset is required.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Group is the API group of the operator's custom resources. Unlike
+// annotation keys it does not follow OPERATOR_NAME: a CRD's group is part of
+// its schema and cannot be renamed per installation.
+const Group = "cert-manager-sync.lestak.sh"
+
+// Version is the API version of the types in this package.
+const Version = "v1alpha1"
+
+// Kinds of the operator's custom resources.
+const (
+	SecretSyncKind             = "SecretSync"
+	StoreCredentialKind        = "StoreCredential"
+	ClusterStoreCredentialKind = "ClusterStoreCredential"
+)
+
+var (
+	// SchemeGroupVersion is the group version of the types in this package.
+	SchemeGroupVersion = schema.GroupVersion{Group: Group, Version: Version}
+	// SecretSyncResource is the resource served for SecretSync objects.
+	SecretSyncResource = SchemeGroupVersion.WithResource("secretsyncs")
+	// StoreCredentialResource is the resource served for StoreCredential
+	// objects.
+	StoreCredentialResource = SchemeGroupVersion.WithResource("storecredentials")
+	// ClusterStoreCredentialResource is the resource served for
+	// ClusterStoreCredential objects.
+	ClusterStoreCredentialResource = SchemeGroupVersion.WithResource("clusterstorecredentials")
+)
+
+// SecretSync syncs a kubernetes.io/tls Secret in its own namespace to one or
+// more remote stores. It is an alternative to configuring the secret through
+// store annotations; both may be used on the same secret.
+type SecretSync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SecretSyncSpec   `json:"spec"`
+	Status SecretSyncStatus `json:"status,omitempty"`
+}
+
+// SecretSyncSpec is the desired sync configuration.
+type SecretSyncSpec struct {
+	// SecretName is the name of the TLS secret to sync, in the SecretSync's
+	// namespace.
+	SecretName string `json:"secretName"`
+	// Targets are the remote stores the secret is synced to.
+	Targets []SyncTarget `json:"targets"`
+}
+
+// SyncTarget is a single remote destination. Exactly one store must be set.
+type SyncTarget struct {
+	// Name identifies the target within the SecretSync and keys its status.
+	// Defaults to the store type, so it must be set when a SecretSync has
+	// more than one target for the same store.
+	Name string `json:"name,omitempty"`
+	// Credential is the "<name>" or "<namespace>/<name>" of a StoreCredential
+	// supplying the store's credentials and config defaults.
+	Credential string `json:"credential,omitempty"`
+	// ClusterCredential is the name of a ClusterStoreCredential supplying the
+	// store's credentials and config defaults.
+	ClusterCredential string `json:"clusterCredential,omitempty"`
+
+	ACM          *ACMTarget          `json:"acm,omitempty"`
+	Cloudflare   *CloudflareTarget   `json:"cloudflare,omitempty"`
+	DigitalOcean *DigitalOceanTarget `json:"digitalocean,omitempty"`
+	Filepath     *FilepathTarget     `json:"filepath,omitempty"`
+	GCP          *GCPTarget          `json:"gcp,omitempty"`
+	Heroku       *HerokuTarget       `json:"heroku,omitempty"`
+	HetznerCloud *HetznerCloudTarget `json:"hetznercloud,omitempty"`
+	Imperva      *ImpervaTarget      `json:"imperva,omitempty"`
+	ThreatX      *ThreatXTarget      `json:"threatx,omitempty"`
+	Vault        *VaultTarget        `json:"vault,omitempty"`
+}
+
+// ACMTarget syncs to AWS Certificate Manager.
+type ACMTarget struct {
+	Region         string `json:"region,omitempty"`
+	RoleARN        string `json:"roleArn,omitempty"`
+	CertificateARN string `json:"certificateArn,omitempty"`
+	// CredentialsSecret is an optional "<namespace>/<name>" or "<name>"
+	// secret holding AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// CloudflareTarget syncs to a Cloudflare zone.
+type CloudflareTarget struct {
+	ZoneID            string `json:"zoneId"`
+	CertID            string `json:"certId,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// DigitalOceanTarget syncs to DigitalOcean certificates.
+type DigitalOceanTarget struct {
+	CertName          string `json:"certName"`
+	CertID            string `json:"certId,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// FilepathTarget writes the certificate to the operator's local filesystem.
+type FilepathTarget struct {
+	Dir  string `json:"dir"`
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	CA   string `json:"ca,omitempty"`
+}
+
+// GCPTarget syncs to Google Cloud Certificate Manager.
+type GCPTarget struct {
+	Project           string `json:"project"`
+	Location          string `json:"location"`
+	CertificateName   string `json:"certificateName,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// HerokuTarget syncs to a Heroku app.
+type HerokuTarget struct {
+	App               string `json:"app"`
+	CertName          string `json:"certName,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// HetznerCloudTarget syncs to Hetzner Cloud certificates.
+type HetznerCloudTarget struct {
+	CertName          string `json:"certName"`
+	CertID            string `json:"certId,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// ImpervaTarget syncs to an Imperva site.
+type ImpervaTarget struct {
+	SiteID            string `json:"siteId"`
+	AuthType          string `json:"authType,omitempty"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// ThreatXTarget syncs to a ThreatX site.
+type ThreatXTarget struct {
+	Hostname          string `json:"hostname"`
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// VaultTarget syncs to a HashiCorp Vault KV v2 path.
+type VaultTarget struct {
+	Addr         string `json:"addr"`
+	Path         string `json:"path"`
+	Namespace    string `json:"namespace,omitempty"`
+	Role         string `json:"role"`
+	AuthMethod   string `json:"authMethod"`
+	Base64Decode bool   `json:"base64Decode,omitempty"`
+	PKCS12       bool   `json:"pkcs12,omitempty"`
+	// PKCS12PasswordSecret is an optional secret holding the PKCS#12
+	// password under PKCS12PasswordSecretKey (default "password").
+	PKCS12PasswordSecret          string `json:"pkcs12PasswordSecret,omitempty"`
+	PKCS12PasswordSecretKey       string `json:"pkcs12PasswordSecretKey,omitempty"`
+	PKCS12PasswordSecretNamespace string `json:"pkcs12PasswordSecretNamespace,omitempty"`
+}
+
+// SecretSyncStatus is the observed sync state.
+type SecretSyncStatus struct {
+	// ObservedGeneration is the spec generation the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions holds the Ready condition.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Targets is the state of each spec target, in spec order.
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus is the observed state of a single target.
+type TargetStatus struct {
+	Name  string `json:"name"`
+	Store string `json:"store"`
+	// Synced is true when the store holds the current certificate.
+	Synced         bool         `json:"synced"`
+	LastSyncTime   *metav1.Time `json:"lastSyncTime,omitempty"`
+	FailedAttempts int          `json:"failedAttempts,omitempty"`
+	NextRetry      *metav1.Time `json:"nextRetry,omitempty"`
+	LastError      string       `json:"lastError,omitempty"`
+	// Outputs are identifiers the store reported for the remote certificate
+	// (for example a certificate ID). They are fed back into later syncs so
+	// the store updates the certificate it created instead of creating
+	// another, the way annotation targets have them written back as
+	// annotations.
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// ConditionReady is the condition type reporting whether every target holds
+// the current certificate.
+const ConditionReady = "Ready"
+
+// Reasons for the Ready condition.
+const (
+	ReasonSynced      = "Synced"
+	ReasonSyncFailed  = "SyncFailed"
+	ReasonSyncPending = "SyncPending"
+	ReasonInvalidSpec = "InvalidSpec"
+)
+
+// StoreCredential is a reusable credential profile for one store type. Sync
+// targets in its namespace, and in any namespace it allows, reference it by
+// name instead of repeating the credentials secret and its key layout.
+type StoreCredential struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StoreCredentialSpec `json:"spec"`
+}
+
+// ClusterStoreCredential is the cluster-scoped StoreCredential. Unless it
+// sets AllowedNamespaces, no target may use it while cross-namespace
+// credentials are restricted (the default).
+type ClusterStoreCredential struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StoreCredentialSpec `json:"spec"`
+}
+
+// StoreCredentialSpec describes where a store's credentials live and which
+// targets may use them.
+type StoreCredentialSpec struct {
+	// Store is the store type the credential is for, e.g. "acm".
+	Store string `json:"store"`
+	// SecretRef is the secret holding the credentials. A StoreCredential may
+	// only reference secrets in its own namespace. It may be omitted for
+	// stores that need no secret, e.g. to share Vault or ACM defaults.
+	SecretRef *SecretReference `json:"secretRef,omitempty"`
+	// Keys maps the store's credential keys (e.g. "api_token") to the keys
+	// they are stored under in the secret, for keys that differ.
+	Keys map[string]string `json:"keys,omitempty"`
+	// Defaults are store config values, in annotation key format (e.g.
+	// "region" or "addr"), used by targets that do not set them.
+	Defaults map[string]string `json:"defaults,omitempty"`
+	// AllowedNamespaces lists the namespaces whose targets may use the
+	// credential; "*" allows all. A StoreCredential is always usable from
+	// its own namespace. A ClusterStoreCredential without the list is usable
+	// from no namespace, or from every namespace when
+	// RESTRICT_CROSS_NAMESPACE_CREDENTIALS is "false".
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// SecretReference names a secret. Namespace defaults to the namespace of the
+// referencing StoreCredential and is required for a ClusterStoreCredential.
+type SecretReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
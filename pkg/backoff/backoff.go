@@ -0,0 +1,87 @@
+// Package backoff computes the delay before retrying a failed sync or delete,
+// so every retry path of the operator follows the same configurable policy.
+package backoff
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// Keys of the settings of a Policy, as used in annotations and store configs.
+const (
+	KeyBase       = "backoff-base"
+	KeyMultiplier = "backoff-multiplier"
+	KeyMax        = "backoff-max"
+	KeyJitter     = "backoff-jitter"
+)
+
+// Keys lists the keys of every setting of a Policy.
+var Keys = []string{KeyBase, KeyMultiplier, KeyMax, KeyJitter}
+
+// Policy is an exponential backoff: the delay after the first failure is
+// Base, and each further failure multiplies it by Multiplier, up to Max.
+// Jitter randomizes each delay by up to that fraction in either direction, so
+// that targets that failed together do not retry in lockstep.
+type Policy struct {
+	Base       time.Duration
+	Multiplier float64
+	Max        time.Duration
+	Jitter     float64
+}
+
+// Default is the policy used when none is configured: 1m, 2m, 4m, ... up to
+// 32h, without jitter.
+var Default = Policy{
+	Base:       time.Minute,
+	Multiplier: 2,
+	Max:        32 * time.Hour,
+}
+
+// Delay returns the delay before the next attempt, after the given number of
+// previously failed attempts. Max caps the delay before jitter is applied.
+func (p Policy) Delay(retries int) time.Duration {
+	d := float64(p.Base) * math.Pow(p.Multiplier, float64(max(retries, 0)))
+	if d > float64(p.Max) || math.IsInf(d, 0) || math.IsNaN(d) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		d += (rand.Float64()*2 - 1) * p.Jitter * d
+	}
+	return time.Duration(d)
+}
+
+// Set sets the setting named by key, one of Keys, from value. The policy is
+// unchanged when the key is unknown or the value invalid: durations must be
+// positive, the multiplier at least 1, and the jitter between 0 and 1.
+func (p *Policy) Set(key, value string) error {
+	switch key {
+	case KeyBase, KeyMax:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s must be a positive duration, got %q", key, value)
+		}
+		if key == KeyBase {
+			p.Base = d
+		} else {
+			p.Max = d
+		}
+	case KeyMultiplier:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 1 || math.IsInf(f, 0) {
+			return fmt.Errorf("%s must be a number of at least 1, got %q", key, value)
+		}
+		p.Multiplier = f
+	case KeyJitter:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("%s must be a number between 0 and 1, got %q", key, value)
+		}
+		p.Jitter = f
+	default:
+		return fmt.Errorf("unknown backoff setting %q", key)
+	}
+	return nil
+}
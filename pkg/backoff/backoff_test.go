@@ -0,0 +1,57 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefault_Delay(t *testing.T) {
+	for retries, want := range map[int]time.Duration{
+		-1:  time.Minute,
+		0:   time.Minute,
+		1:   2 * time.Minute,
+		5:   32 * time.Minute,
+		11:  32 * time.Hour,
+		100: 32 * time.Hour,
+		1e6: 32 * time.Hour,
+	} {
+		assert.Equal(t, want, Default.Delay(retries), retries)
+	}
+}
+
+func TestPolicy_Jitter(t *testing.T) {
+	p := Policy{Base: 10 * time.Second, Multiplier: 3, Max: time.Minute, Jitter: 0.5}
+	seen := map[time.Duration]bool{}
+	for range 50 {
+		d := p.Delay(1)
+		assert.GreaterOrEqual(t, d, 15*time.Second)
+		assert.LessOrEqual(t, d, 45*time.Second)
+		seen[d] = true
+	}
+	assert.Greater(t, len(seen), 1, "delays are randomized")
+	d := p.Delay(10)
+	assert.GreaterOrEqual(t, d, 30*time.Second)
+	assert.LessOrEqual(t, d, 90*time.Second, "jitter applies to the capped delay")
+}
+
+func TestPolicy_Set(t *testing.T) {
+	p := Default
+	assert.NoError(t, p.Set(KeyBase, "30s"))
+	assert.NoError(t, p.Set(KeyMultiplier, "1.5"))
+	assert.NoError(t, p.Set(KeyMax, "1h"))
+	assert.NoError(t, p.Set(KeyJitter, "0.2"))
+	assert.Equal(t, Policy{Base: 30 * time.Second, Multiplier: 1.5, Max: time.Hour, Jitter: 0.2}, p)
+
+	for key, value := range map[string]string{
+		KeyBase:       "0s",
+		KeyMax:        "soon",
+		KeyMultiplier: "0.5",
+		KeyJitter:     "2",
+		"backoff-min": "1s",
+	} {
+		assert.Error(t, p.Set(key, value), key)
+	}
+	assert.Equal(t, Policy{Base: 30 * time.Second, Multiplier: 1.5, Max: time.Hour, Jitter: 0.2}, p, "invalid values are ignored")
+}
@@ -0,0 +1,200 @@
+// Package certificates integrates with cert-manager Certificates: a secret
+// issued for a Certificate is only synced once the Certificate is Ready, and
+// never while it holds cert-manager's temporary certificate.
+package certificates
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Resource is the resource served for cert-manager Certificates.
+var Resource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+// NameAnnotation is set by cert-manager on the secrets it issues, naming
+// their Certificate.
+const NameAnnotation = "cert-manager.io/certificate-name"
+
+// temporaryIssuer is the common name of the throwaway CA cert-manager signs
+// the temporary certificates of cert-manager.io/issue-temporary-certificate
+// with.
+const temporaryIssuer = "cert-manager.local"
+
+// secretIndex indexes Certificates by the "<namespace>/<secretName>" key of
+// the secret they issue.
+const secretIndex = "secret"
+
+// indexer is the Certificate informer cache. It is nil until Register is
+// called; Gate then only checks for temporary certificates.
+var indexer cache.Indexer
+
+// Enabled reports whether the integration is turned on with
+// WATCH_CERTIFICATES=true.
+func Enabled() bool {
+	return os.Getenv("WATCH_CERTIFICATES") == "true"
+}
+
+// CRDInstalled reports whether the API server serves cert-manager
+// Certificates.
+func CRDInstalled(dc discovery.DiscoveryInterface) (bool, error) {
+	rl, err := dc.ServerResourcesForGroupVersion(Resource.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rl.APIResources {
+		if r.Name == Resource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Register adds the secret index to a Certificate informer and makes its
+// cache the source for ForSecret. It must be called before the informer
+// starts.
+func Register(inf cache.SharedIndexInformer) error {
+	if err := inf.AddIndexers(cache.Indexers{secretIndex: indexBySecret}); err != nil {
+		return fmt.Errorf("add certificate indexer: %w", err)
+	}
+	indexer = inf.GetIndexer()
+	return nil
+}
+
+func indexBySecret(obj interface{}) ([]string, error) {
+	key := SecretKey(obj)
+	if key == "" {
+		return nil, nil
+	}
+	return []string{key}, nil
+}
+
+// SecretKey returns the "<namespace>/<name>" key of the secret a Certificate
+// informer object issues, or "" when it names none.
+func SecretKey(obj interface{}) string {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	name, _, _ := unstructured.NestedString(u.Object, "spec", "secretName")
+	if name == "" {
+		return ""
+	}
+	return u.GetNamespace() + "/" + name
+}
+
+// ForSecret returns the Certificate the secret is issued for: the one its
+// certificate-name annotation names, or else the first, by name, whose
+// secretName is the secret. It returns nil when there is none, or when no
+// Certificate informer is registered.
+func ForSecret(s *corev1.Secret) *unstructured.Unstructured {
+	if indexer == nil || s == nil {
+		return nil
+	}
+	l := log.WithFields(log.Fields{
+		"action":    "certificates.ForSecret",
+		"namespace": s.Namespace,
+		"name":      s.Name,
+	})
+	key := s.Namespace + "/" + s.Name
+	if name := s.Annotations[NameAnnotation]; name != "" {
+		obj, ok, err := indexer.GetByKey(s.Namespace + "/" + name)
+		if err != nil {
+			l.WithError(err).Error("certificate lookup failed")
+		} else if ok && SecretKey(obj) == key {
+			return obj.(*unstructured.Unstructured)
+		}
+	}
+	objs, err := indexer.ByIndex(secretIndex, key)
+	if err != nil {
+		l.WithError(err).Error("index lookup failed")
+		return nil
+	}
+	var out []*unstructured.Unstructured
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			out = append(out, u)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out[0]
+}
+
+// Gate returns the Certificate the secret is issued for, if any, and why the
+// secret must not be synced yet: it holds cert-manager's temporary
+// certificate, or its Certificate is not Ready. The reason is empty when the
+// secret may be synced, which every secret may when the integration is not
+// Enabled.
+func Gate(s *corev1.Secret) (*unstructured.Unstructured, string) {
+	if !Enabled() {
+		return nil, ""
+	}
+	crt := ForSecret(s)
+	if Temporary(s) {
+		return crt, "the secret holds a temporary certificate issued by cert-manager"
+	}
+	if crt == nil {
+		return nil, ""
+	}
+	return crt, notReady(crt)
+}
+
+// Temporary reports whether the secret, issued by cert-manager, holds the
+// temporary certificate cert-manager writes while the real one is issued.
+func Temporary(s *corev1.Secret) bool {
+	if s == nil || s.Annotations[NameAnnotation] == "" {
+		return false
+	}
+	block, _ := pem.Decode(s.Data[corev1.TLSCertKey])
+	if block == nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return leaf.Issuer.CommonName == temporaryIssuer
+}
+
+// notReady returns why the Certificate is not Ready, or "" when its Ready
+// condition is True for its current generation.
+func notReady(crt *unstructured.Unstructured) string {
+	conds, _, _ := unstructured.NestedSlice(crt.Object, "status", "conditions")
+	for _, c := range conds {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		if cond["status"] != "True" {
+			msg, _ := cond["message"].(string)
+			if msg == "" {
+				msg, _ = cond["reason"].(string)
+			}
+			return fmt.Sprintf("Certificate %s is not Ready: %s", crt.GetName(), msg)
+		}
+		if gen, ok, _ := unstructured.NestedInt64(cond, "observedGeneration"); ok && gen < crt.GetGeneration() {
+			return fmt.Sprintf("Certificate %s changed and is not Ready yet", crt.GetName())
+		}
+		return ""
+	}
+	return fmt.Sprintf("Certificate %s is not Ready yet", crt.GetName())
+}
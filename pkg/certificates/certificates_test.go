@@ -0,0 +1,161 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// withIndexer points ForSecret at an in-memory cache holding the given
+// Certificates.
+func withIndexer(t *testing.T, list ...*unstructured.Unstructured) {
+	t.Helper()
+	idx := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{secretIndex: indexBySecret})
+	for _, crt := range list {
+		require.NoError(t, idx.Add(crt))
+	}
+	prev := indexer
+	indexer = idx
+	t.Cleanup(func() { indexer = prev })
+}
+
+// newCertificate returns a Certificate issuing secret, with a Ready condition
+// of the given status unless it is empty.
+func newCertificate(name, secret, ready string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"namespace":  "ns",
+			"generation": int64(1),
+		},
+		"spec": map[string]interface{}{"secretName": secret},
+	}}
+	if ready != "" {
+		u.Object["status"] = map[string]interface{}{
+			"conditions": []interface{}{map[string]interface{}{
+				"type":               "Ready",
+				"status":             ready,
+				"reason":             "Issuing",
+				"message":            "Issuing certificate as Secret does not exist",
+				"observedGeneration": int64(1),
+			}},
+		}
+	}
+	return u
+}
+
+// issuedSecret returns a secret cert-manager issued for the Certificate
+// named crt, holding a certificate issued by issuer.
+func issuedSecret(t *testing.T, name, crt, issuer string) *corev1.Secret {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: issuer},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "ns",
+			Annotations: map[string]string{NameAnnotation: crt},
+		},
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		},
+	}
+}
+
+func TestSecretKey(t *testing.T) {
+	u := newCertificate("web", "example.com", "")
+	assert.Equal(t, "ns/example.com", SecretKey(u))
+	assert.Equal(t, "ns/example.com", SecretKey(cache.DeletedFinalStateUnknown{Key: "ns/web", Obj: u}))
+	assert.Empty(t, SecretKey(newCertificate("web", "", "")))
+	assert.Empty(t, SecretKey(&corev1.Secret{}))
+}
+
+func TestForSecret(t *testing.T) {
+	withIndexer(t,
+		newCertificate("web", "example.com", "True"),
+		newCertificate("b", "shared.com", "True"),
+		newCertificate("a", "shared.com", "True"),
+	)
+	assert.Equal(t, "web", ForSecret(issuedSecret(t, "example.com", "web", "ca")).GetName())
+	assert.Equal(t, "a", ForSecret(issuedSecret(t, "shared.com", "other", "ca")).GetName(),
+		"a stale annotation falls back to the secretName index")
+	assert.Equal(t, "b", ForSecret(issuedSecret(t, "shared.com", "b", "ca")).GetName())
+	assert.Nil(t, ForSecret(issuedSecret(t, "unreferenced.com", "web", "ca")),
+		"the annotated Certificate must issue the secret")
+
+	prev := indexer
+	indexer = nil
+	defer func() { indexer = prev }()
+	assert.Nil(t, ForSecret(issuedSecret(t, "example.com", "web", "ca")))
+}
+
+func TestGate(t *testing.T) {
+	t.Setenv("WATCH_CERTIFICATES", "true")
+	stale := newCertificate("stale", "stale.com", "True")
+	stale.SetGeneration(2)
+	withIndexer(t,
+		newCertificate("ready", "ready.com", "True"),
+		newCertificate("issuing", "issuing.com", "False"),
+		newCertificate("new", "new.com", ""),
+		stale,
+	)
+
+	crt, reason := Gate(issuedSecret(t, "ready.com", "ready", "ca"))
+	require.NotNil(t, crt)
+	assert.Equal(t, "ready", crt.GetName())
+	assert.Empty(t, reason)
+
+	crt, reason = Gate(issuedSecret(t, "ready.com", "ready", temporaryIssuer))
+	require.NotNil(t, crt)
+	assert.Contains(t, reason, "temporary certificate")
+
+	_, reason = Gate(issuedSecret(t, "issuing.com", "issuing", "ca"))
+	assert.Equal(t, "Certificate issuing is not Ready: Issuing certificate as Secret does not exist", reason)
+	_, reason = Gate(issuedSecret(t, "new.com", "new", "ca"))
+	assert.Equal(t, "Certificate new is not Ready yet", reason)
+	_, reason = Gate(issuedSecret(t, "stale.com", "stale", "ca"))
+	assert.Equal(t, "Certificate stale changed and is not Ready yet", reason)
+
+	crt, reason = Gate(issuedSecret(t, "unmanaged.com", "", "ca"))
+	assert.Nil(t, crt)
+	assert.Empty(t, reason, "secrets without a Certificate are not gated")
+
+	t.Setenv("WATCH_CERTIFICATES", "")
+	crt, reason = Gate(issuedSecret(t, "issuing.com", "issuing", temporaryIssuer))
+	assert.Nil(t, crt)
+	assert.Empty(t, reason)
+}
+
+func TestTemporary(t *testing.T) {
+	assert.True(t, Temporary(issuedSecret(t, "s", "web", temporaryIssuer)))
+	assert.False(t, Temporary(issuedSecret(t, "s", "web", "ca")))
+	unmanaged := issuedSecret(t, "s", "web", temporaryIssuer)
+	unmanaged.Annotations = nil
+	assert.False(t, Temporary(unmanaged), "only secrets issued by cert-manager hold its temporary certificate")
+	invalid := issuedSecret(t, "s", "web", temporaryIssuer)
+	invalid.Data["tls.crt"] = []byte("cert")
+	assert.False(t, Temporary(invalid))
+}
@@ -0,0 +1,58 @@
+package certmanagersync
+
+import (
+	"sync"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/certificates"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// certificateGateFn is indirected so tests can gate secrets without a
+// Certificate informer.
+var certificateGateFn = certificates.Gate
+
+// reportedWaits maps a secret's "<namespace>/<name>" to the reason its sync
+// last waited for its Certificate, so SyncWaiting is emitted once per reason
+// rather than on every reconcile.
+var reportedWaits sync.Map
+
+// waitForCertificate returns the Certificate s is issued for, if any, and
+// whether s must not be synced yet because the Certificate is not Ready or s
+// holds a temporary certificate. A SyncWaiting event is emitted on s and the
+// Certificate when the reason changes.
+func waitForCertificate(s *corev1.Secret) (*unstructured.Unstructured, bool) {
+	key := s.Namespace + "/" + s.Name
+	crt, reason := certificateGateFn(s)
+	if reason == "" {
+		reportedWaits.Delete(key)
+		return crt, false
+	}
+	log.WithFields(log.Fields{
+		"action":    "HandleSecret",
+		"namespace": s.Namespace,
+		"name":      s.Name,
+		"reason":    reason,
+	}).Debug("waiting for certificate")
+	if prev, ok := reportedWaits.Swap(key, reason); !ok || prev != reason {
+		recordEvent(s, crt, corev1.EventTypeNormal, "SyncWaiting", "Sync waiting: "+reason)
+	}
+	return crt, true
+}
+
+// ForgetSecret drops what is remembered of the secret namespace/name once it
+// no longer exists or is no longer watched.
+func ForgetSecret(namespace, name string) {
+	reportedWaits.Delete(namespace + "/" + name)
+}
+
+// recordEvent emits an event on s and, when s is issued for a Certificate,
+// mirrors it onto crt so the sync status shows alongside the Certificate.
+func recordEvent(s *corev1.Secret, crt *unstructured.Unstructured, eventtype, reason, msg string) {
+	state.EventRecorder.Event(s, eventtype, reason, msg)
+	if crt != nil {
+		state.EventRecorder.Event(crt, eventtype, reason, "Secret "+s.Name+": "+msg)
+	}
+}
@@ -0,0 +1,92 @@
+package certmanagersync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// withCertificateGate makes every secret issued for a Certificate named web,
+// which waits for reason until it is changed through the returned pointer.
+func withCertificateGate(t *testing.T, reason string) *string {
+	t.Helper()
+	crt := &unstructured.Unstructured{}
+	crt.SetAPIVersion("cert-manager.io/v1")
+	crt.SetKind("Certificate")
+	crt.SetNamespace("ns")
+	crt.SetName("web")
+	prev := certificateGateFn
+	certificateGateFn = func(*corev1.Secret) (*unstructured.Unstructured, string) { return crt, reason }
+	reportedWaits.Clear()
+	t.Cleanup(func() {
+		certificateGateFn = prev
+		reportedWaits.Clear()
+	})
+	return &reason
+}
+
+func TestHandleSecret_WaitsForCertificate(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm, v := &fakeStore{}, &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": v})
+	reason := withCertificateGate(t, "Certificate web is not Ready yet")
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Zero(t, acm.syncCnt)
+	assert.Zero(t, v.syncCnt)
+	assert.Empty(t, getSecret(t, cs).Annotations[state.SyncStateAnnotation()], "the secret is not changed")
+	assert.Equal(t, []string{
+		"Normal SyncWaiting Sync waiting: Certificate web is not Ready yet",
+		"Normal SyncWaiting Secret s1: Sync waiting: Certificate web is not Ready yet",
+	}, recordedEvents(), "the wait is reported once, on the secret and its Certificate")
+
+	*reason = ""
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, 1, v.syncCnt)
+	assert.Equal(t, []string{
+		"Normal Synced Secret synced to 2 stores",
+		"Normal Synced Secret s1: Secret synced to 2 stores",
+	}, recordedEvents())
+}
+
+func TestForgetSecret_ReportsWaitAgain(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	withCertificateGate(t, "Certificate web is not Ready yet")
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Len(t, recordedEvents(), 2)
+	ForgetSecret("ns", "s1")
+	_, ok := reportedWaits.Load("ns/s1")
+	assert.False(t, ok)
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Len(t, recordedEvents(), 2, "a recreated secret has its wait reported again")
+}
+
+func TestPlan_BlockedByCertificate(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	withCertificateGate(t, "the secret holds a temporary certificate issued by cert-manager")
+
+	p, err := Plan(context.Background(), s)
+	require.NoError(t, err)
+	for _, tp := range p.Targets {
+		assert.Equal(t, PlanBlockedByCertificate, tp.Action)
+		assert.Equal(t, "the secret holds a temporary certificate issued by cert-manager", tp.Reason)
+	}
+	assert.Len(t, p.Targets, 2)
+	assert.Empty(t, recordedEvents())
+}
@@ -2,14 +2,24 @@ package certmanagersync
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/robertlestak/cert-manager-sync/internal/metrics"
 	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/intermediates"
+	"github.com/robertlestak/cert-manager-sync/pkg/secretsync"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeguard"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/robertlestak/cert-manager-sync/stores/acm"
 	"github.com/robertlestak/cert-manager-sync/stores/cloudflare"
@@ -23,13 +33,16 @@ import (
 	"github.com/robertlestak/cert-manager-sync/stores/vault"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 )
 
+const defaultSyncConcurrency = 4
+
+// RemoteStore is a destination a certificate is synced to. Implementations
+// must honour ctx cancellation in every remote call, as the caller bounds each
+// store call with state.SyncTimeout and cancels in-flight calls on shutdown.
 type RemoteStore interface {
-	Sync(cert *tlssecret.Certificate) (map[string]string, error)
-	FromConfig(config tlssecret.GenericSecretSyncConfig) error
+	Sync(ctx context.Context, cert *tlssecret.Certificate) (map[string]string, error)
+	FromConfig(ctx context.Context, config tlssecret.GenericSecretSyncConfig) error
 }
 
 // newStoreFn is the function used to instantiate a RemoteStore by type. It's
@@ -165,85 +178,211 @@ func readyToRetry(s *corev1.Secret) bool {
 	return false
 }
 
+// RetryAfter returns how long until HandleSecret will next act on a secret
+// that is sitting in sync backoff. It returns zero when the secret is ready
+// now, or when no next-retry is scheduled because every failing target has
+// exhausted max-sync-attempts and will not be retried until someone
+// intervenes.
+func RetryAfter(s *corev1.Secret) time.Duration {
+	nextR := nextRetryTime(s)
+	if nextR.IsZero() {
+		return 0
+	}
+	if d := time.Until(nextR); d > 0 {
+		return d
+	}
+	return 0
+}
+
 func calculateNextRetryTime(secret *corev1.Secret) time.Time {
 	// Get the number of failed sync attempts from the annotations
 	retries := consumedRetries(secret)
 
-	// Calculate the delay using binary exponential backoff
-	var delay time.Duration
-	if retries < 31 {
-		delay = time.Duration(1<<uint(retries)) * time.Minute
-	} else {
-		delay = 32 * time.Hour
-	}
+	// Calculate the next retry time using the secret's backoff policy
+	return time.Now().Add(state.SecretBackoff(secret).Delay(retries))
+}
 
-	// Cap the delay at 32 hours
-	if delay > 32*time.Hour {
-		delay = 32 * time.Hour
-	}
+type forceKey struct{}
+
+// WithForce returns a context under which HandleSecret syncs every target of
+// a secret, including those already in sync, in backoff, failed permanently
+// or out of retries.
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
 
-	// Calculate the next retry time
-	nextRetryTime := time.Now().Add(delay)
-	return nextRetryTime
+// Forced reports whether ctx was returned by WithForce.
+func Forced(ctx context.Context) bool {
+	f, _ := ctx.Value(forceKey{}).(bool)
+	return f
 }
 
-func HandleSecret(s *corev1.Secret) error {
+// HandleSecret syncs a secret to each of its configured targets. Every target
+// (a store at an index) carries its own hash, attempt counter and backoff in
+// the sync-state annotation, so only targets that have never synced, whose
+// config or certificate changed, or whose backoff has elapsed are pushed. A
+// single failing target does not cause healthy ones to be re-uploaded.
+//
+// The secret-wide failed-sync-attempts and next-retry annotations are kept as
+// a summary of the failing targets (the highest attempt count and the earliest
+// retry), so that removing next-retry or lowering failed-sync-attempts still
+// forces an immediate retry.
+//
+// Each store call is bounded by state.SyncTimeout; a call that times out or
+// is cancelled through ctx is recorded as a failed attempt for that target.
+//
+// Failures are classified with syncerrors: transient ones are retried within
+// minutes, and permanent ones (rejected credentials, invalid input, a missing
+// remote resource) are not retried until the certificate or the target's
+// config changes.
+//
+// Store calls share a rate limit and a circuit breaker per store and
+// credentials (see storeguard). A target whose call the guard defers is not
+// counted as a failed attempt; the secret is retried once the guard allows,
+// and a SyncDeferred event is emitted instead of SyncFailed.
+//
+// A target with no record of a sync, or re-pushed only because the cache is
+// disabled, is not written when its store implements InspectableRemoteStore
+// and already holds the leaf; it is recorded as synced, unchanged.
+//
+// The outcome of every target, and a Ready condition summarizing them, is
+// recorded in the sync-status annotation for users and tooling.
+//
+// Targets declared by SecretSync resources referencing the secret are synced
+// alongside its annotation targets, and the outcome is written to their status.
+//
+// Under a context returned by WithForce, every target is due.
+//
+// With WATCH_CERTIFICATES enabled, a secret issued for a cert-manager
+// Certificate is not synced until the Certificate is Ready, nor while it holds
+// cert-manager's temporary certificate; a SyncWaiting event says why. The
+// summary events of a sync are mirrored onto the Certificate.
+func HandleSecret(ctx context.Context, s *corev1.Secret) error {
 	l := log.WithFields(log.Fields{
 		"action":    "HandleSecret",
 		"namespace": s.Namespace,
 		"name":      s.Name,
 	})
 	l.Debugf("HandleSecret %s/%s", s.Namespace, s.Name)
-	// ensure we haven't exceeded the allotted retries
-	if !readyToRetry(s) {
+	recordCertificateMetrics(s)
+	force := Forced(ctx)
+	st, hasState := state.GetSyncState(s)
+	// Secrets last synced before per-target state was recorded only carry
+	// the secret-wide backoff; honour it until the first per-target sync.
+	if !hasState && !readyToRetry(s) && !force {
 		l.Debug("not ready to retry")
 		return nil
 	}
+	// A SecretSync can change without the secret changing, so only the
+	// per-target hashes can tell whether a SecretSync-backed secret needs work.
+	secretSyncs := secretSyncsFn(s)
 	// check if the secret has changed since last sync
-	if !state.CacheChanged(s) {
+	if len(secretSyncs) == 0 && !state.CacheChanged(s) && !force {
 		l.Debug("cache not changed")
 		return nil
 	}
-	cert := tlssecret.ParseSecret(s)
+	crt, wait := waitForCertificate(s)
+	if wait {
+		return nil
+	}
+	cert := parseSecret(s, secretSyncs)
 	if cert == nil {
 		l.Errorf("error parsing secret")
 		return fmt.Errorf("error parsing secret %s/%s", s.Namespace, s.Name)
 	}
-	var errs []error
+	targets := loadTargetStates(s, cert, st, hasState)
+	pruneTargetMetrics(s, st, targets)
+	_, scheduled := summarizeTargets(s, targets)
+	var due []*tlssecret.GenericSecretSyncConfig
+	readBack := make(map[string]bool)
 	for _, sync := range cert.Syncs {
+		hash := state.HashSecretTarget(s, sync.Config)
+		if resetIfChanged(targets[sync.Key()], hash) {
+			l.WithFields(log.Fields{
+				"store": sync.Store,
+				"index": sync.Index,
+			}).Info("secret or target config changed since the failed attempts; retry budget reset")
+		}
+		if ok, reason := targetDue(s, targets[sync.Key()], hash, scheduled); !ok && !force {
+			if !targets[sync.Key()].Failed() {
+				// no longer waiting for a deferred sync
+				targets[sync.Key()].NextRetry = time.Time{}
+			}
+			l.WithFields(log.Fields{
+				"store":  sync.Store,
+				"index":  sync.Index,
+				"reason": reason,
+			}).Debug("skipping target")
+			continue
+		}
+		due = append(due, sync)
+		readBack[sync.Key()] = readBackFirst(targets[sync.Key()], hash)
+	}
+	var results []syncResult
+	completeChain(ctx, s, cert, due)
+	if err := validateCertificate(s, cert, due); err != nil {
+		// Counted as a permanent failure of every due target, which is
+		// retried once the secret is fixed.
+		results = make([]syncResult, len(due))
+		for i, sync := range due {
+			results[i].err = fmt.Errorf("store %s: %w", sync.Store, syncerrors.New(syncerrors.InvalidInput, err))
+		}
+	} else {
+		results = syncTargets(ctx, s, cert, due, readBack)
+	}
+	var errs []error
+	var pushed []*tlssecret.GenericSecretSyncConfig
+	unchanged := 0
+	deferred := 0
+	var deferUntil time.Time
+	for i, res := range results {
+		sync := due[i]
+		ts := targets[sync.Key()]
 		ll := l.WithFields(log.Fields{
 			"store": sync.Store,
+			"index": sync.Index,
 		})
-		ll.Debugf("syncing to store %s", sync.Store)
-		rs, err := NewStore(cmtypes.StoreType(sync.Store))
-		if err != nil {
-			ll.WithError(err).Errorf("failed to initialize store %s: %v", sync.Store, err)
-			metrics.SetFailure(s.Namespace, s.Name, sync.Store)
-			state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to initialize store %s: %v", sync.Store, err))
-			errs = append(errs, fmt.Errorf("store %s initialization failed: %w", sync.Store, err))
+		metrics.ObserveSync(sync.Store, res.result())
+		if until, ok := res.deferred(); ok {
+			// Not an attempt: the target keeps its state, and waits
+			// for the guard rather than its own backoff.
+			ll.WithError(res.err).Info("target sync deferred")
+			ts.NextRetry = until
+			if deferUntil.IsZero() || until.Before(deferUntil) {
+				deferUntil = until
+			}
+			deferred++
 			continue
 		}
-		if err := rs.FromConfig(*sync); err != nil {
-			ll.WithError(err).Errorf("failed to configure store %s: %v", sync.Store, err)
-			metrics.SetFailure(s.Namespace, s.Name, sync.Store)
-			state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to configure store %s: %v", sync.Store, err))
-			errs = append(errs, fmt.Errorf("store %s configuration failed: %w", sync.Store, err))
+		if err := res.err; err != nil {
+			cat := syncerrors.Classify(err)
+			ll.WithError(err).WithField("category", cat).Error("target sync failed")
+			metrics.SetFailure(s.Namespace, s.Name, sync.Store, sync.Key())
+			metrics.ObserveSyncError(sync.Store, string(cat))
+			ts.Hash = ""
+			ts.FailedHash = state.HashSecretTarget(s, sync.Config)
+			ts.FailedAttempts++
+			ts.NextRetry = time.Now().Add(retryDelay(state.TargetBackoff(s, sync.Config), cat, ts.FailedAttempts-1))
+			ts.LastError = err.Error()
+			ts.ErrorCategory = string(cat)
+			errs = append(errs, err)
 			continue
 		}
-		updates, err := rs.Sync(cert)
-		if err != nil {
-			ll.WithError(err).Errorf("failed to sync certificate to store %s: %v", sync.Store, err)
-			metrics.SetFailure(s.Namespace, s.Name, sync.Store)
-			state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to sync to store %s: %v", sync.Store, err))
-			errs = append(errs, fmt.Errorf("store %s sync failed: %w", sync.Store, err))
-			continue
+		metrics.SetSuccess(s.Namespace, s.Name, sync.Store, sync.Key())
+		metrics.SetTargetSynced(s.Namespace, s.Name, sync.Key(), time.Now())
+		if res.unchanged {
+			ll.Debug("store already holds the certificate")
+			unchanged++
+		}
+		if len(sync.Updates) > 0 {
+			ll.WithField("updates", sync.Updates).Debug("synced with updates")
 		}
-		metrics.SetSuccess(s.Namespace, s.Name, sync.Store)
-		if len(updates) > 0 {
-			l.WithField("updates", updates).Debug("synced with updates")
+		*ts = state.TargetState{
+			Hash: state.HashSecretTarget(s, sync.EffectiveConfig()),
 		}
-		sync.Updates = updates
+		pushed = append(pushed, sync)
 	}
+	recordBackoffMetrics(s, cert, targets)
 	patchAnnotations := make(map[string]string)
 	if s.Annotations != nil {
 		for k, v := range s.Annotations {
@@ -255,40 +394,50 @@ func HandleSecret(s *corev1.Secret) error {
 	for k, v := range au {
 		patchAnnotations[k] = v
 	}
-	if len(errs) > 0 {
-		// increment the failed-sync-attempts annotation
-		// increment the failed-sync-attempts annotation
-		iv := consumedRetries(s) + 1
-		patchAnnotations[state.OperatorName+"/failed-sync-attempts"] = strconv.Itoa(iv)
-		// set the next-retry annotation to the current time plus the delay
-		// the delay is a binary exponential backoff, starting at 1 minute, then 2, 4, 8.. up to 32 hours
-		nextRetry := calculateNextRetryTime(s)
-		// add the next-retry annotation to the secret
-		// this will be evaluated by the readyToRetry function
-		// when the next sync attempt is made
-		patchAnnotations[state.OperatorName+"/next-retry"] = nextRetry.Format(time.RFC3339)
+	sv, err := targets.Marshal()
+	if err != nil {
+		l.WithError(err).Errorf("json.Marshal error")
+		return err
+	}
+	patchAnnotations[state.SyncStateAnnotation()] = sv
+	status, err := buildStatus(state.GetStatus(s), cert, targets, due, results, time.Now()).Marshal()
+	if err != nil {
+		l.WithError(err).Errorf("json.Marshal error")
+		return err
+	}
+	patchAnnotations[state.SyncStatusAnnotation()] = status
+	attempts, nextRetry := summarizeTargets(s, targets)
+	if attempts > 0 {
+		patchAnnotations[state.OperatorName+"/failed-sync-attempts"] = strconv.Itoa(attempts)
 	} else {
 		delete(patchAnnotations, state.OperatorName+"/failed-sync-attempts")
-		// remove the next-retry annotation
+	}
+	if nextRetry.IsZero() {
+		// every target is in sync, or every failing target has exhausted
+		// max-sync-attempts
 		delete(patchAnnotations, state.OperatorName+"/next-retry")
-		// the sync was a success, add the secret to the cache
-		patchAnnotations[state.OperatorName+"/hash"] = state.HashSecret(s)
+	} else {
+		patchAnnotations[state.OperatorName+"/next-retry"] = nextRetry.Format(time.RFC3339)
 	}
-	l.WithField("patchAnnotations", patchAnnotations).Debug("patchAnnotations")
-	// patch the secret with the updated annotations
-	patchData := map[string]interface{}{
-		"metadata": map[string]interface{}{
-			"annotations": patchAnnotations,
-		},
+	if attempts == 0 && deferred == 0 {
+		// every target is in sync; hash the secret as it will look once
+		// patched so the annotation updates above are not mistaken for a change
+		hs := s.DeepCopy()
+		hs.Annotations = patchAnnotations
+		patchAnnotations[state.OperatorName+"/hash"] = state.HashSecret(hs)
 	}
-	pd, err := json.Marshal(patchData)
-	if err != nil {
-		l.WithError(err).Errorf("json.Marshal error")
-		return err
+	rctx, cancel := recordContext(ctx)
+	defer cancel()
+	// Store outputs of SecretSync targets live only in their status, so it
+	// is written first: if it were lost after the secret recorded the targets
+	// as synced, the next push would not know the remote certificate's ID.
+	var statusErr error
+	if len(secretSyncs) > 0 {
+		if statusErr = recordStatusFn(rctx, secretSyncs, pushed, targets); statusErr != nil {
+			l.WithError(statusErr).Error("failed to record secretsync status")
+		}
 	}
-	l.WithField("patchData", string(pd)).Debug("patchData")
-	_, err = state.KubeClient.CoreV1().Secrets(s.Namespace).Patch(context.Background(), s.Name, types.MergePatchType, pd, metav1.PatchOptions{})
-	if err != nil {
+	if err := patchSecretAnnotations(rctx, s, patchAnnotations); err != nil {
 		l.WithError(err).Errorf("failed to patch secret annotations: %v", err)
 		return err
 	}
@@ -297,28 +446,297 @@ func HandleSecret(s *corev1.Secret) error {
 		for _, e := range errs {
 			l.WithError(e).Error("sync error details")
 		}
-		l.WithField("error_count", len(errs)).Errorf("failed to sync secret to %d store%s", len(errs), func() string {
-			if len(errs) == 1 {
-				return ""
-			}
-			return "s"
-		}())
-		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Secret sync failed to %d store%s", len(errs), func() string {
-			if len(errs) == 1 {
-				return ""
-			}
-			return "s"
-		}()))
+		l.WithField("error_count", len(errs)).Errorf("failed to sync secret to %d store%s", len(errs), plural(len(errs)))
+		recordEvent(s, crt, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Secret sync failed to %d store%s", len(errs), plural(len(errs))))
 		return fmt.Errorf("errors syncing secret %s/%s: %v", s.Namespace, s.Name, errs)
 	}
-	scf := func() string {
-		if len(cert.Syncs) == 1 {
-			return ""
+	if statusErr != nil {
+		return statusErr
+	}
+	if deferred > 0 {
+		msg := fmt.Sprintf("Sync to %d store%s deferred until %s: store unavailable or rate limited", deferred, plural(deferred), deferUntil.Format(time.RFC3339))
+		l.Info(msg)
+		recordEvent(s, crt, corev1.EventTypeNormal, "SyncDeferred", msg)
+	}
+	synced := len(pushed)
+	if synced == 0 {
+		l.Debug("no targets due for sync")
+		return nil
+	}
+	eventMsg := fmt.Sprintf("Secret synced to %d store%s", synced, plural(synced))
+	if unchanged > 0 {
+		eventMsg += fmt.Sprintf("; %d already up to date", unchanged)
+	}
+	l.Info(eventMsg)
+	recordEvent(s, crt, corev1.EventTypeNormal, "Synced", eventMsg)
+	return nil
+}
+
+// Function-typed indirection so SecretSync lookups and status writes can be
+// stubbed in tests without an informer or API server.
+var (
+	secretSyncsFn  = secretsync.ForSecret
+	recordStatusFn = secretsync.RecordStatus
+)
+
+// parseSecret parses the secret's annotation targets and appends the targets
+// declared by secretSyncs. It returns nil when the annotations are invalid.
+func parseSecret(s *corev1.Secret, secretSyncs []*v1alpha1.SecretSync) *tlssecret.Certificate {
+	cert := tlssecret.ParseSecret(s)
+	if cert == nil {
+		return nil
+	}
+	cert.Syncs = append(cert.Syncs, secretsync.Targets(secretSyncs)...)
+	return cert
+}
+
+// trustedRoots are the roots a chain may end under without being completed.
+// nil uses the system roots; it is a var so tests can trust their own.
+var trustedRoots *x509.CertPool
+
+// completeChain adds the intermediates missing from the chain of cert to its
+// ca.crt when chain completion is enabled for s and a target is due, emitting
+// an event naming them. The secret itself is not changed. A chain ending at a
+// root, or under one of the system roots, is left alone without reading any
+// source. Failing to complete the chain is not fatal: the certificate is
+// synced with the chain it has.
+func completeChain(ctx context.Context, s *corev1.Secret, cert *tlssecret.Certificate, due []*tlssecret.GenericSecretSyncConfig) {
+	if len(due) == 0 || !state.CompleteChain(s) {
+		return
+	}
+	// An invalid certificate is reported by validateCertificate.
+	if ch, err := cert.Chain(); err != nil || intermediates.Trusted(ch, trustedRoots) {
+		return
+	}
+	l := log.WithFields(log.Fields{
+		"action":    "completeChain",
+		"namespace": s.Namespace,
+		"name":      s.Name,
+	})
+	c, err := intermediates.FromEnv(ctx)
+	if err != nil {
+		l.WithError(err).Warn("cannot complete certificate chain")
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "ChainIncomplete", fmt.Sprintf("Certificate chain not completed: %v", err))
+		return
+	}
+	c.Roots = trustedRoots
+	added, err := c.Complete(ctx, cert)
+	if len(added) > 0 {
+		if err := cert.CompleteCA(added...); err != nil {
+			l.WithError(err).Warn("cannot complete certificate chain")
+			return
+		}
+		names := make([]string, len(added))
+		for i, a := range added {
+			names[i] = fmt.Sprintf("%q", a.Subject)
 		}
-		return "s"
-	}()
-	l.Infof("Secret synced to %d store%s", len(cert.Syncs), scf)
-	eventMsg := fmt.Sprintf("Secret synced to %d store%s", len(cert.Syncs), scf)
-	state.EventRecorder.Event(s, corev1.EventTypeNormal, "Synced", eventMsg)
+		l.WithField("added", names).Info("completed certificate chain")
+		state.EventRecorder.Event(s, corev1.EventTypeNormal, "ChainCompleted", fmt.Sprintf("Completed certificate chain with %d intermediate%s: %s", len(added), plural(len(added)), strings.Join(names, ", ")))
+	}
+	if err != nil {
+		l.WithError(err).Warn("cannot complete certificate chain")
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "ChainIncomplete", fmt.Sprintf("Certificate chain not completed: %v", err))
+	}
+}
+
+// validateCertificate checks the certificate material before it is pushed to
+// any of the due targets, emitting a SyncFailed event when it is invalid.
+// Nothing is checked when no target is due.
+func validateCertificate(s *corev1.Secret, cert *tlssecret.Certificate, due []*tlssecret.GenericSecretSyncConfig) error {
+	if len(due) == 0 {
+		return nil
+	}
+	if err := cert.Validate(); err != nil {
+		log.WithFields(log.Fields{
+			"action":    "validateCertificate",
+			"namespace": s.Namespace,
+			"name":      s.Name,
+		}).WithError(err).Error("refusing to sync invalid certificate")
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Certificate not synced to any store: %v", err))
+		return err
+	}
 	return nil
 }
+
+// syncResult is the outcome of syncing a single target.
+type syncResult struct {
+	err error
+	// unchanged is set when the store already held the certificate and was
+	// not written.
+	unchanged bool
+}
+
+// deferred reports whether the store guard deferred the sync, and until when.
+func (r syncResult) deferred() (time.Time, bool) {
+	return deferredUntil(r.err)
+}
+
+// result returns the metrics result of r.
+func (r syncResult) result() string {
+	if _, ok := r.deferred(); ok {
+		return metrics.ResultDeferred
+	}
+	switch {
+	case r.err != nil:
+		return metrics.ResultFailure
+	case r.unchanged:
+		return metrics.ResultUnchanged
+	}
+	return metrics.ResultSuccess
+}
+
+// syncTargets runs syncTarget for each target concurrently, with at most
+// syncConcurrency() calls in flight, reading back the remote certificate first
+// for the targets whose key is set in readBack. The returned results are
+// indexed like targets. Each goroutine only writes its own target's Updates
+// and its own result slot; cert and readBack are shared read-only.
+func syncTargets(ctx context.Context, s *corev1.Secret, cert *tlssecret.Certificate, targets []*tlssecret.GenericSecretSyncConfig, readBack map[string]bool) []syncResult {
+	results := make([]syncResult, len(targets))
+	timeout := state.SyncTimeout(s)
+	sem := make(chan struct{}, syncConcurrency())
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			log.WithFields(log.Fields{
+				"action":    "syncTargets",
+				"namespace": s.Namespace,
+				"name":      s.Name,
+				"store":     target.Store,
+				"index":     target.Index,
+			}).Debugf("syncing to store %s", target.Store)
+			unchanged, err := syncTarget(ctx, timeout, s, cert, target, readBack[target.Key()])
+			results[i] = syncResult{err: err, unchanged: unchanged}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// syncConcurrency returns the maximum number of stores a single secret is
+// synced to in parallel, read from SYNC_CONCURRENCY. Defaults to 4 when unset
+// or invalid; 1 syncs stores one at a time.
+func syncConcurrency() int {
+	n, err := strconv.Atoi(os.Getenv("SYNC_CONCURRENCY"))
+	if err != nil || n < 1 {
+		return defaultSyncConcurrency
+	}
+	return n
+}
+
+// permanentReasons are the event reasons of store errors of permanent
+// categories.
+var permanentReasons = map[syncerrors.Category]string{
+	syncerrors.Auth:         "AuthenticationFailed",
+	syncerrors.InvalidInput: "InvalidInput",
+	syncerrors.NotFound:     "RemoteNotFound",
+}
+
+// syncTarget pushes the certificate to a single target, emitting a SyncFailed
+// event on failure, or one of permanentReasons for a permanent store error.
+// The target's credential profile, if any, is applied, its access to
+// credentials checked and its config validated against the store's schema
+// before the store is configured. The store call is abandoned after timeout,
+// which is a transient error. Any updates the store reports are recorded on
+// sync.Updates.
+//
+// The store calls go through the store guard. When it does not admit them,
+// syncTarget returns its *storeguard.DeferredError without an event: the
+// target is retried once the guard allows, without counting a failed attempt.
+//
+// With readBack, the write is skipped when the store already holds the leaf,
+// which syncTarget reports as unchanged.
+func syncTarget(ctx context.Context, timeout time.Duration, s *corev1.Secret, cert *tlssecret.Certificate, sync *tlssecret.GenericSecretSyncConfig, readBack bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	rs, err := newStoreFn(sync.Store)
+	if err != nil {
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to initialize store %s: %v", sync.Store, err))
+		return false, fmt.Errorf("store %s initialization failed: %w", sync.Store, err)
+	}
+	cfg, err := credentials.Prepare(ctx, *sync, s.Namespace)
+	if err != nil {
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to resolve credentials for store %s: %v", sync.Store, err))
+		return false, fmt.Errorf("store %s credentials: %w", sync.Store, err)
+	}
+	if err := validateConfig(rs, cfg); err != nil {
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Invalid configuration for store %s: %v", sync.Store, err))
+		return false, fmt.Errorf("store %s configuration invalid: %w", sync.Store, syncerrors.New(syncerrors.InvalidInput, err))
+	}
+	if err := rs.FromConfig(ctx, cfg); err != nil {
+		state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to configure store %s: %v", sync.Store, err))
+		return false, fmt.Errorf("store %s configuration failed: %w", sync.Store, err)
+	}
+	var unchanged bool
+	var updates map[string]string
+	err = guarded(ctx, guardKey(cfg, s.Namespace), func() error {
+		if readBack && remoteHolds(ctx, rs, cert, sync) {
+			unchanged = true
+			return nil
+		}
+		start := time.Now()
+		var err error
+		updates, err = rs.Sync(ctx, cert)
+		metrics.ObserveSyncDuration(sync.Store, time.Since(start))
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = syncerrors.New(syncerrors.Transient, err)
+		}
+		return err
+	})
+	if errors.Is(err, storeguard.ErrDeferred) {
+		return false, err
+	}
+	if err != nil {
+		if reason, ok := permanentReasons[syncerrors.Classify(err)]; ok {
+			state.EventRecorder.Event(s, corev1.EventTypeWarning, reason, fmt.Sprintf("Failed to sync to store %s, not retried until the certificate or config changes: %v", sync.Store, err))
+		} else {
+			state.EventRecorder.Event(s, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("Failed to sync to store %s: %v", sync.Store, err))
+		}
+		return false, fmt.Errorf("store %s sync failed: %w", sync.Store, err)
+	}
+	if unchanged {
+		return true, nil
+	}
+	sync.Updates = updates
+	return false, nil
+}
+
+// remoteHolds reports whether the configured store rs already holds the chain
+// of cert, as proven by the fingerprints of its leaf and intermediates. Stores
+// that cannot be inspected or do not expose their chain, and any error reading
+// the remote certificate, lead to a normal write.
+func remoteHolds(ctx context.Context, rs RemoteStore, cert *tlssecret.Certificate, sync *tlssecret.GenericSecretSyncConfig) bool {
+	inspector, ok := rs.(InspectableRemoteStore)
+	if !ok {
+		return false
+	}
+	l := log.WithFields(log.Fields{
+		"action":    "remoteHolds",
+		"namespace": cert.Namespace,
+		"name":      cert.SecretName,
+		"store":     sync.Store,
+		"index":     sync.Index,
+	})
+	ch, err := cert.Chain()
+	if err != nil {
+		return false
+	}
+	remote, err := inspector.Inspect(ctx)
+	if errors.Is(err, tlssecret.ErrRemoteNotFound) {
+		l.Debug("no remote certificate")
+		return false
+	}
+	if err != nil {
+		l.WithError(err).Debug("cannot read back remote certificate")
+		return false
+	}
+	return remote.Holds(ch)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -217,3 +217,44 @@ func TestCalculateNextRetryTime(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   *v1.Secret
+		min, max time.Duration
+	}{
+		{
+			name:   "No annotations needs no requeue",
+			secret: createSecretWithAnnotations("secret1", nil),
+		},
+		{
+			name: "Next retry time in the past needs no requeue",
+			secret: createSecretWithAnnotations("secret2", map[string]string{
+				state.OperatorName + "/next-retry": time.Now().Add(-1 * time.Hour).Format(time.RFC3339),
+			}),
+		},
+		{
+			name: "Next retry time in the future requeues at that time",
+			secret: createSecretWithAnnotations("secret3", map[string]string{
+				state.OperatorName + "/next-retry": time.Now().Add(10 * time.Minute).Format(time.RFC3339),
+			}),
+			min: 9 * time.Minute,
+			max: 10 * time.Minute,
+		},
+		{
+			name: "Reached max retries needs no requeue",
+			secret: createSecretWithAnnotations("secret4", map[string]string{
+				state.OperatorName + "/max-sync-attempts":    "5",
+				state.OperatorName + "/failed-sync-attempts": "5",
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RetryAfter(tt.secret)
+			assert.True(t, got >= tt.min && got <= tt.max, "delay=%s expected in [%s,%s]", got, tt.min, tt.max)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package certmanagersync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSecret_DeniedCrossNamespaceCredentials(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", "true")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/acm-secret-name": "shared/aws-creds",
+	})
+	creds := makeSecret("aws-creds", "shared", nil, nil)
+	withFakeClientset(t, s, creds)
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 0, acm.syncCnt, "the store is never called with denied credentials")
+	assert.Equal(t, 1, vault.syncCnt)
+
+	assert.Contains(t, recordedEvents(), "Warning SyncFailed Failed to resolve credentials for store acm: "+
+		"credentials denied: secret shared/aws-creds may not be used from namespace ns; list it in the "+
+		state.AllowedNamespacesAnnotation()+" annotation")
+}
+
+func TestHandleSecret_AllowedCrossNamespaceCredentials(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", "true")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/acm-secret-name": "shared/aws-creds",
+	})
+	creds := makeSecret("aws-creds", "shared", map[string]string{
+		state.AllowedNamespacesAnnotation(): "other,ns",
+	}, nil)
+	withFakeClientset(t, s, creds)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, "shared/aws-creds", acm.gotConfig.Config["secret-name"])
+}
@@ -3,11 +3,14 @@ package certmanagersync
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
@@ -166,17 +169,36 @@ func readyToRetryDelete(s *corev1.Secret) bool {
 	return !time.Now().Before(t)
 }
 
+// DeleteRetryAfter returns how long until HandleSecretDelete will next act on
+// a secret that is sitting in delete backoff, or zero if it is ready now.
+func DeleteRetryAfter(s *corev1.Secret) time.Duration {
+	t := nextDeleteTime(s)
+	if t.IsZero() {
+		return 0
+	}
+	if d := time.Until(t); d > 0 {
+		return d
+	}
+	return 0
+}
+
 // HandleSecretDelete reconciles the deletion of a secret carrying the operator finalizer.
-// It walks the per-store sync configs encoded on the secret, calls Delete on stores that
-// implement DeletableRemoteStore, and removes the finalizer when all capable stores have
-// reported success (or when the configured failure policy says to give up).
+// It walks the per-store sync configs encoded on the secret or declared by SecretSyncs
+// referencing it, calls Delete on stores that implement DeletableRemoteStore, and
+// removes the finalizer when all capable stores have reported success (or when the
+// configured failure policy says to give up).
 //
 // The secret passed in is expected to already carry a DeletionTimestamp and the operator
 // finalizer; callers should gate via state.SecretDeletePending.
 //
 // Returns nil when the finalizer has been removed (success or give-up), and a non-nil
 // error when the caller should retry later. The caller is not expected to mutate s.
-func HandleSecretDelete(s *corev1.Secret) error {
+// Each store's FromConfig and Delete are bounded by state.SyncTimeout.
+//
+// A delete the store guard defers does not count as a failed attempt: when it is
+// the only thing left, next-delete is set to when the guard allows and nil is
+// returned.
+func HandleSecretDelete(ctx context.Context, s *corev1.Secret) error {
 	l := log.WithFields(log.Fields{
 		"action":    "HandleSecretDelete",
 		"namespace": s.Namespace,
@@ -192,8 +214,6 @@ func HandleSecretDelete(s *corev1.Secret) error {
 		return nil
 	}
 
-	ctx := context.Background()
-
 	// If the secret was never opted in to deletion, just drop the finalizer.
 	// This handles a user removing the annotation between "ensure finalizer" and the
 	// actual deletion event — we should not leave them stuck.
@@ -205,7 +225,7 @@ func HandleSecretDelete(s *corev1.Secret) error {
 		return nil
 	}
 
-	cert := tlssecret.ParseSecret(s)
+	cert := parseSecret(s, secretSyncsFn(s))
 	if cert == nil {
 		// Without parseable annotations we have nothing to act on. Don't keep the
 		// secret wedged forever; drop the finalizer and warn.
@@ -221,11 +241,14 @@ func HandleSecretDelete(s *corev1.Secret) error {
 
 	var errs []error
 	skippedStores := 0
+	var deferUntil time.Time
+	timeout := state.SyncTimeout(s)
 	for _, sync := range cert.Syncs {
 		ll := l.WithFields(log.Fields{"store": sync.Store, "index": sync.Index})
 		rs, err := newStoreFn(sync.Store)
 		if err != nil {
 			ll.WithError(err).Errorf("failed to initialize store for delete")
+			metrics.ObserveDelete(sync.Store, metrics.ResultFailure)
 			errs = append(errs, fmt.Errorf("init store %s: %w", sync.Store, err))
 			continue
 		}
@@ -238,36 +261,56 @@ func HandleSecretDelete(s *corev1.Secret) error {
 		// prefix into SecretNamespace, so this single shim covers all stores
 		// without per-store edits.
 		cfg := withSecretNamespaceDefault(*sync, s.Namespace)
-		if err := rs.FromConfig(cfg); err != nil {
-			ll.WithError(err).Errorf("failed to configure store for delete")
-			errs = append(errs, fmt.Errorf("configure store %s: %w", sync.Store, err))
-			continue
-		}
-		deleter, ok := rs.(DeletableRemoteStore)
-		if !ok {
+		err = deleteFromStore(ctx, timeout, rs, cfg, s.Namespace)
+		if errors.Is(err, errDeleteUnsupported) {
 			ll.Debug("store does not implement DeletableRemoteStore; skipping remote cleanup")
+			metrics.ObserveDelete(sync.Store, metrics.ResultSkipped)
 			if state.EventRecorder != nil {
 				state.EventRecorder.Eventf(s, corev1.EventTypeNormal, "DeleteSkipped", "Store %s does not support delete; remote state unchanged", sync.Store)
 			}
 			skippedStores++
 			continue
 		}
-		if err := deleter.Delete(ctx); err != nil {
+		if until, ok := deferredUntil(err); ok {
+			ll.WithError(err).Info("remote delete deferred")
+			metrics.ObserveDelete(sync.Store, metrics.ResultDeferred)
+			if deferUntil.IsZero() || until.Before(deferUntil) {
+				deferUntil = until
+			}
+			continue
+		}
+		if err != nil {
 			ll.WithError(err).Errorf("remote delete failed")
-			errs = append(errs, fmt.Errorf("delete from store %s: %w", sync.Store, err))
+			metrics.ObserveDelete(sync.Store, metrics.ResultFailure)
+			errs = append(errs, err)
 			continue
 		}
+		metrics.ObserveDelete(sync.Store, metrics.ResultSuccess)
 		ll.Info("remote certificate deleted")
 		if state.EventRecorder != nil {
 			state.EventRecorder.Eventf(s, corev1.EventTypeNormal, "Deleted", "Deleted remote certificate from store %s", sync.Store)
 		}
 	}
 
+	// Remote deletes have run; record their outcome even if ctx is cancelled.
+	rctx, cancel := recordContext(ctx)
+	defer cancel()
+
+	if len(errs) == 0 && !deferUntil.IsZero() {
+		if err := patchDeleteRetry(rctx, s, deleteAttempts(s), deferUntil); err != nil {
+			return err
+		}
+		if state.EventRecorder != nil {
+			state.EventRecorder.Eventf(s, corev1.EventTypeNormal, "DeleteDeferred",
+				"Remote delete deferred until %s: store unavailable or rate limited", deferUntil.Format(time.RFC3339))
+		}
+		return nil
+	}
 	if len(errs) == 0 {
 		if state.EventRecorder != nil {
 			state.EventRecorder.Eventf(s, corev1.EventTypeNormal, "DeleteCompleted", "Remote cleanup complete (%d stores synced, %d skipped); removing finalizer", len(cert.Syncs)-skippedStores, skippedStores)
 		}
-		if _, err := RemoveFinalizer(ctx, s); err != nil {
+		if _, err := RemoveFinalizer(rctx, s); err != nil {
 			return err
 		}
 		return nil
@@ -291,14 +334,14 @@ func HandleSecretDelete(s *corev1.Secret) error {
 				"Failed to delete remote certificate after %d attempts; finalizer force-removed because DELETE_BLOCKING=false. Remote certificate may need manual cleanup. Errors: %v",
 				attempts, errs)
 		}
-		if _, err := RemoveFinalizer(ctx, s); err != nil {
+		if _, err := RemoveFinalizer(rctx, s); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	nextRetry := calculateNextDeleteRetry(attempts)
-	if err := patchDeleteRetry(ctx, s, attempts, nextRetry); err != nil {
+	nextRetry := calculateNextDeleteRetry(s, attempts)
+	if err := patchDeleteRetry(rctx, s, attempts, nextRetry); err != nil {
 		// If we can't persist the attempt counter / next-retry, the caller will
 		// keep retrying without backoff — bounded only by the informer resync
 		// period. Surface this via a Warning event so it's visible in
@@ -317,6 +360,34 @@ func HandleSecretDelete(s *corev1.Secret) error {
 	return fmt.Errorf("delete reconcile errors for %s/%s (attempt %d): %v", s.Namespace, s.Name, attempts, errs)
 }
 
+// errDeleteUnsupported is returned by deleteFromStore for stores that do not
+// implement DeletableRemoteStore.
+var errDeleteUnsupported = errors.New("store does not support delete")
+
+// deleteFromStore configures rs, applying the target's credential profile as
+// seen from namespace, and deletes its remote certificate, bounding all calls
+// by timeout. The delete goes through the store guard, and its
+// *storeguard.DeferredError is returned when the guard does not admit it.
+func deleteFromStore(ctx context.Context, timeout time.Duration, rs RemoteStore, cfg tlssecret.GenericSecretSyncConfig, namespace string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cfg, err := credentials.Prepare(ctx, cfg, namespace)
+	if err != nil {
+		return fmt.Errorf("store %s credentials: %w", cfg.Store, err)
+	}
+	if err := rs.FromConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("configure store %s: %w", cfg.Store, err)
+	}
+	deleter, ok := rs.(DeletableRemoteStore)
+	if !ok {
+		return errDeleteUnsupported
+	}
+	if err := guarded(ctx, guardKey(cfg, namespace), func() error { return deleter.Delete(ctx) }); err != nil {
+		return fmt.Errorf("delete from store %s: %w", cfg.Store, err)
+	}
+	return nil
+}
+
 // withSecretNamespaceDefault returns a deep-enough copy of the sync config with
 // `secret-name` rewritten to `<namespace>/<name>` when it lacks a namespace
 // prefix. The K8s secret being reconciled is the source of truth for the
@@ -347,20 +418,8 @@ func withSecretNamespaceDefault(in tlssecret.GenericSecretSyncConfig, namespace
 }
 
 // calculateNextDeleteRetry returns the timestamp at which the next delete attempt
-// should run. Uses the same binary exponential backoff as sync retries, capped at
-// 32 hours.
-func calculateNextDeleteRetry(attempts int) time.Time {
-	var delay time.Duration
-	switch {
-	case attempts < 1:
-		delay = time.Minute
-	case attempts >= 31:
-		delay = 32 * time.Hour
-	default:
-		delay = time.Duration(1<<uint(attempts-1)) * time.Minute
-	}
-	if delay > 32*time.Hour {
-		delay = 32 * time.Hour
-	}
-	return time.Now().Add(delay)
+// should run, after the given number of failed attempts. Uses the secret's
+// backoff policy, like sync retries.
+func calculateNextDeleteRetry(s *corev1.Secret, attempts int) time.Time {
+	return time.Now().Add(state.SecretBackoff(s).Delay(attempts - 1))
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
@@ -35,10 +36,15 @@ func withFakeClientset(t *testing.T, objs ...runtime.Object) *fake.Clientset {
 		return cs.CoreV1().Secrets(namespace)
 	}
 	t.Cleanup(func() { secretsClient = prev })
+	// Credentials secrets are read through the shared client.
+	prevClient := state.KubeClient
+	state.KubeClient = cs
+	t.Cleanup(func() { state.KubeClient = prevClient })
 	// Use a fresh fake recorder per test so events don't leak between tests.
 	prevRec := state.EventRecorder
 	state.EventRecorder = record.NewFakeRecorder(50)
 	t.Cleanup(func() { state.EventRecorder = prevRec })
+	resetStoreGuard(t)
 	return cs
 }
 
@@ -161,13 +167,27 @@ func registerStubStore(t *testing.T, stubs map[string]RemoteStore) {
 	t.Cleanup(func() { newStoreFn = prev })
 }
 
+// resetStoreGuard starts the test with every circuit closed, so failures of
+// one test do not defer the calls of the next.
+func resetStoreGuard(t *testing.T) {
+	t.Helper()
+	guardMu.Lock()
+	guard = nil
+	guardMu.Unlock()
+	t.Cleanup(func() {
+		guardMu.Lock()
+		guard = nil
+		guardMu.Unlock()
+	})
+}
+
 func TestHandleSecretDelete_Idempotent_NoFinalizer(t *testing.T) {
 	clearDeleteEnv(t)
 	s := makeSecret("s1", "ns", map[string]string{
 		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
 	}, nil)
 	withFakeClientset(t, s)
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 }
 
 func TestHandleSecretDelete_RemovesFinalizer_WhenPolicyRetain(t *testing.T) {
@@ -176,7 +196,7 @@ func TestHandleSecretDelete_RemovesFinalizer_WhenPolicyRetain(t *testing.T) {
 		map[string]string{state.DeletePolicyAnnotation(): state.DeletePolicyRetain},
 		[]string{state.FinalizerName()})
 	cs := withFakeClientset(t, s)
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	got, err := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
 	require.NoError(t, err)
 	assert.NotContains(t, got.Finalizers, state.FinalizerName())
@@ -198,7 +218,7 @@ func TestHandleSecretDelete_AllStoresSucceed_RemovesFinalizer(t *testing.T) {
 		"vault": vaultStub,
 	})
 
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	assert.Equal(t, 1, acmStub.deleteCnt)
 	assert.Equal(t, 1, vaultStub.deleteCnt)
 
@@ -218,7 +238,7 @@ func TestHandleSecretDelete_StoreWithoutDeleteSupport_IsSkipped(t *testing.T) {
 	stub := &nonDeletableFakeStore{}
 	registerStubStore(t, map[string]RemoteStore{"imperva": stub})
 
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	// Confirms FromConfig ran (proves the delete path reached the store) but no Delete was called.
 	assert.Equal(t, "123", stub.gotConfig.Config["siteid"])
 
@@ -238,7 +258,7 @@ func TestHandleSecretDelete_FailureRetries_KeepsFinalizer(t *testing.T) {
 	stub := &fakeStore{deleteErr: errors.New("api down")}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
-	err := HandleSecretDelete(s)
+	err := HandleSecretDelete(context.Background(), s)
 	require.Error(t, err)
 
 	got, err2 := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
@@ -265,7 +285,7 @@ func TestHandleSecretDelete_BackoffSkipsUntilDue(t *testing.T) {
 	stub := &fakeStore{deleteErr: errors.New("should not be called")}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	assert.Equal(t, 0, stub.deleteCnt, "delete must not run before backoff elapses")
 }
 
@@ -284,7 +304,7 @@ func TestHandleSecretDelete_GivesUpAfterMaxAttempts(t *testing.T) {
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
 	// Returns nil because we gave up rather than asking the caller to retry.
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	got, err := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
 	require.NoError(t, err)
 	assert.NotContains(t, got.Finalizers, state.FinalizerName(), "finalizer force-removed when DELETE_BLOCKING=false")
@@ -307,7 +327,7 @@ func TestHandleSecretDelete_DefaultBlocking_NeverGivesUp(t *testing.T) {
 	stub := &fakeStore{deleteErr: errors.New("permafail")}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
-	err := HandleSecretDelete(s)
+	err := HandleSecretDelete(context.Background(), s)
 	require.Error(t, err)
 	got, gerr := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
 	require.NoError(t, gerr)
@@ -328,7 +348,7 @@ func TestHandleSecretDelete_BlockingNeverGivesUp(t *testing.T) {
 	stub := &fakeStore{deleteErr: errors.New("permafail")}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
-	err := HandleSecretDelete(s)
+	err := HandleSecretDelete(context.Background(), s)
 	require.Error(t, err)
 	got, gerr := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
 	require.NoError(t, gerr)
@@ -349,7 +369,7 @@ func TestHandleSecretDelete_ZeroMaxAttemptsRetriesForever(t *testing.T) {
 	stub := &fakeStore{deleteErr: errors.New("permafail")}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
 
-	err := HandleSecretDelete(s)
+	err := HandleSecretDelete(context.Background(), s)
 	require.Error(t, err)
 	got, gerr := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
 	require.NoError(t, gerr)
@@ -369,7 +389,7 @@ func TestHandleSecretDelete_PartialFailure_KeepsFinalizer(t *testing.T) {
 	bad := &fakeStore{deleteErr: errors.New("boom")}
 	registerStubStore(t, map[string]RemoteStore{"acm": bad, "vault": good})
 
-	err := HandleSecretDelete(s)
+	err := HandleSecretDelete(context.Background(), s)
 	require.Error(t, err)
 	assert.Equal(t, 1, good.deleteCnt)
 	assert.Equal(t, 1, bad.deleteCnt)
@@ -391,9 +411,31 @@ func TestCalculateNextDeleteRetry(t *testing.T) {
 		{attempts: 100, min: 31 * time.Hour, max: 33 * time.Hour},
 	}
 	for _, c := range cases {
-		got := calculateNextDeleteRetry(c.attempts).Sub(time.Now())
+		got := calculateNextDeleteRetry(makeSecret("s", "ns", nil, nil), c.attempts).Sub(time.Now())
 		assert.True(t, got >= c.min && got <= c.max, "attempts=%d delay=%s expected in [%s,%s]", c.attempts, got, c.min, c.max)
 	}
+
+	s := makeSecret("s", "ns", map[string]string{
+		state.BackoffAnnotation(backoff.KeyBase): "5m",
+		state.BackoffAnnotation(backoff.KeyMax):  "12m",
+	}, nil)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), calculateNextDeleteRetry(s, 2), 5*time.Second, "the secret's policy applies to deletes")
+	assert.WithinDuration(t, time.Now().Add(12*time.Minute), calculateNextDeleteRetry(s, 5), 5*time.Second)
+}
+
+func TestDeleteRetryAfter(t *testing.T) {
+	assert.Zero(t, DeleteRetryAfter(makeSecret("s", "ns", nil, nil)))
+
+	past := makeSecret("s", "ns", map[string]string{
+		state.NextDeleteAnnotation(): time.Now().Add(-time.Minute).Format(time.RFC3339),
+	}, nil)
+	assert.Zero(t, DeleteRetryAfter(past))
+
+	future := makeSecret("s", "ns", map[string]string{
+		state.NextDeleteAnnotation(): time.Now().Add(5 * time.Minute).Format(time.RFC3339),
+	}, nil)
+	got := DeleteRetryAfter(future)
+	assert.True(t, got > 4*time.Minute && got <= 5*time.Minute, "delay=%s", got)
 }
 
 func TestWithSecretNamespaceDefault(t *testing.T) {
@@ -453,7 +495,7 @@ func TestHandleSecretDelete_PassesNamespacedCredentialsSecret(t *testing.T) {
 	withFakeClientset(t, s)
 	stub := &fakeStore{}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	assert.Equal(t, "team-a/aws-creds", stub.gotConfig.Config["secret-name"],
 		"HandleSecretDelete must default secret-name to <secretNamespace>/<name> so per-store FromConfig sets SecretNamespace")
 }
@@ -468,7 +510,7 @@ func TestHandleSecretDelete_PreservesExplicitNamespacedSecretName(t *testing.T)
 	withFakeClientset(t, s)
 	stub := &fakeStore{}
 	registerStubStore(t, map[string]RemoteStore{"acm": stub})
-	require.NoError(t, HandleSecretDelete(s))
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
 	assert.Equal(t, "shared/aws-creds", stub.gotConfig.Config["secret-name"],
 		"explicit cross-namespace secret-name must be preserved")
 }
@@ -0,0 +1,185 @@
+package certmanagersync
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeguard"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InspectableRemoteStore is implemented by stores that can describe the
+// certificate they currently serve, so a target recorded as synced can be
+// checked against the secret, and a write of a certificate the store already
+// holds can be skipped. Stores that do not implement it are never checked for
+// drift and always written.
+//
+// Implementations must:
+//   - Operate on the identifiers populated by FromConfig, like Delete.
+//   - Return an error wrapping tlssecret.ErrRemoteNotFound when the remote
+//     certificate no longer exists.
+type InspectableRemoteStore interface {
+	Inspect(ctx context.Context) (*tlssecret.RemoteCertificate, error)
+}
+
+// errInspectUnsupported is returned by inspectTarget for stores that do not
+// implement InspectableRemoteStore.
+var errInspectUnsupported = errors.New("store does not support inspect")
+
+// DetectDrift compares the certificate served by each target of s recorded as
+// synced with the secret's leaf, for the targets whose store implements
+// InspectableRemoteStore. A target whose remote certificate differs or is
+// gone raises a DriftDetected event. When state.DriftResync is on for s, the
+// recorded hash of the drifted targets is cleared so the next reconcile
+// pushes them again, and DetectDrift reports true once that is persisted.
+//
+// Targets that failed, or whose certificate or config changed since their
+// last sync, are left to HandleSecret. Errors inspecting a target are
+// returned joined, after every target was checked.
+func DetectDrift(ctx context.Context, s *corev1.Secret) (bool, error) {
+	l := log.WithFields(log.Fields{
+		"action":    "DetectDrift",
+		"namespace": s.Namespace,
+		"name":      s.Name,
+	})
+	st, hasState := state.GetSyncState(s)
+	if !hasState {
+		return false, nil
+	}
+	cert := parseSecret(s, secretSyncsFn(s))
+	if cert == nil {
+		return false, fmt.Errorf("error parsing secret %s/%s", s.Namespace, s.Name)
+	}
+	leaf, err := cert.Leaf()
+	if err != nil {
+		// An invalid certificate is reported by HandleSecret.
+		l.WithError(err).Debug("cannot parse certificate")
+		return false, nil
+	}
+	timeout := state.SyncTimeout(s)
+	resync := state.DriftResync(s)
+	var errs []error
+	drifted := 0
+	for _, sync := range cert.Syncs {
+		ts := st[sync.Key()]
+		if ts == nil || ts.Failed() || ts.Hash != state.HashSecretTarget(s, sync.Config) {
+			continue
+		}
+		ll := l.WithFields(log.Fields{
+			"store": sync.Store,
+			"index": sync.Index,
+		})
+		remote, err := inspectTarget(ctx, timeout, s.Namespace, sync)
+		if errors.Is(err, errInspectUnsupported) {
+			continue
+		}
+		if errors.Is(err, storeguard.ErrDeferred) {
+			// checked again at the next interval
+			ll.WithError(err).Debug("drift check deferred")
+			metrics.ObserveDriftCheck(sync.Store, metrics.ResultDeferred)
+			continue
+		}
+		if err != nil && !errors.Is(err, tlssecret.ErrRemoteNotFound) {
+			ll.WithError(err).Warn("drift check failed")
+			metrics.ObserveDriftCheck(sync.Store, metrics.ResultFailure)
+			errs = append(errs, err)
+			continue
+		}
+		if err == nil && remote.Matches(leaf) {
+			ll.Debug("remote certificate in sync")
+			metrics.ObserveDriftCheck(sync.Store, metrics.ResultInSync)
+			metrics.SetDrift(s.Namespace, s.Name, sync.Key(), false, remote.NotAfter)
+			continue
+		}
+		drifted++
+		if err != nil {
+			ll.WithError(err).Warn("remote certificate missing")
+			metrics.ObserveDriftCheck(sync.Store, metrics.ResultMissing)
+			metrics.SetDrift(s.Namespace, s.Name, sync.Key(), true, time.Time{})
+			state.EventRecorder.Event(s, corev1.EventTypeWarning, "DriftDetected", fmt.Sprintf("Remote certificate in store %s not found", sync.Store))
+		} else {
+			ll.WithField("remoteNotAfter", remote.NotAfter).Warn("remote certificate does not match the secret")
+			metrics.ObserveDriftCheck(sync.Store, metrics.ResultDrifted)
+			metrics.SetDrift(s.Namespace, s.Name, sync.Key(), true, remote.NotAfter)
+			state.EventRecorder.Event(s, corev1.EventTypeWarning, "DriftDetected", fmt.Sprintf("Remote certificate in store %s does not match the secret: %s", sync.Store, driftDetail(remote, leaf)))
+		}
+		if resync {
+			ts.Hash = ""
+		}
+	}
+	if drifted == 0 || !resync {
+		return false, errors.Join(errs...)
+	}
+	if err := scheduleResync(ctx, s, st); err != nil {
+		l.WithError(err).Error("failed to schedule resync")
+		return false, errors.Join(append(errs, err)...)
+	}
+	l.WithField("targets", drifted).Info("drifted targets scheduled for resync")
+	return true, errors.Join(errs...)
+}
+
+// inspectTarget configures the target's store, applying its credential
+// profile as seen from namespace, and inspects its remote certificate,
+// bounding all calls by timeout.
+func inspectTarget(ctx context.Context, timeout time.Duration, namespace string, sync *tlssecret.GenericSecretSyncConfig) (*tlssecret.RemoteCertificate, error) {
+	rs, err := newStoreFn(sync.Store)
+	if err != nil {
+		return nil, fmt.Errorf("store %s initialization failed: %w", sync.Store, err)
+	}
+	inspector, ok := rs.(InspectableRemoteStore)
+	if !ok {
+		return nil, errInspectUnsupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cfg, err := credentials.Prepare(ctx, *sync, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("store %s credentials: %w", sync.Store, err)
+	}
+	if err := rs.FromConfig(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("store %s configuration failed: %w", sync.Store, err)
+	}
+	var remote *tlssecret.RemoteCertificate
+	err = guarded(ctx, guardKey(cfg, namespace), func() error {
+		var err error
+		remote, err = inspector.Inspect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store %s inspect: %w", sync.Store, err)
+	}
+	return remote, nil
+}
+
+// driftDetail describes how the remote certificate differs from leaf.
+func driftDetail(remote *tlssecret.RemoteCertificate, leaf *x509.Certificate) string {
+	if !remote.NotAfter.Equal(leaf.NotAfter) {
+		return fmt.Sprintf("remote expires %s, secret expires %s", remote.NotAfter.UTC().Format(time.RFC3339), leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("remote fingerprint %s, secret fingerprint %s", remote.Fingerprint, tlssecret.Fingerprint(leaf))
+}
+
+// scheduleResync persists st, in which the drifted targets have no hash, and
+// drops the secret-wide hash so the secret is not skipped as unchanged. The
+// resulting update queues the secret for a reconcile.
+func scheduleResync(ctx context.Context, s *corev1.Secret, st state.SyncState) error {
+	sv, err := st.Marshal()
+	if err != nil {
+		return err
+	}
+	want := maps.Clone(s.Annotations)
+	want[state.SyncStateAnnotation()] = sv
+	delete(want, state.OperatorName+"/hash")
+	rctx, cancel := recordContext(ctx)
+	defer cancel()
+	return patchSecretAnnotations(rctx, s, want)
+}
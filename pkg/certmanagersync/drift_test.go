@@ -0,0 +1,140 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// inspectStore is a fakeStore that also implements InspectableRemoteStore.
+// Without a remote or an error set it has no remote certificate.
+type inspectStore struct {
+	fakeStore
+	remote     *tlssecret.RemoteCertificate
+	inspectErr error
+	inspectCnt int
+}
+
+func (f *inspectStore) Inspect(_ context.Context) (*tlssecret.RemoteCertificate, error) {
+	f.inspectCnt++
+	if f.remote == nil && f.inspectErr == nil {
+		return nil, tlssecret.ErrRemoteNotFound
+	}
+	return f.remote, f.inspectErr
+}
+
+// syncedSecret syncs the secret built by syncSecret to acm and vault and
+// returns it as recorded, with the events of the sync drained.
+func syncedSecret(t *testing.T, acm *inspectStore) (*corev1.Secret, *fake.Clientset) {
+	t.Helper()
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+	recordedEvents()
+	acm.inspectCnt = 0
+	return getSecret(t, cs), cs
+}
+
+func remoteOf(t *testing.T, s *corev1.Secret) *tlssecret.RemoteCertificate {
+	t.Helper()
+	r, err := tlssecret.InspectPEM(s.Data["tls.crt"], s.Data["ca.crt"])
+	require.NoError(t, err)
+	return r
+}
+
+func TestDetectDrift_InSync(t *testing.T) {
+	t.Setenv("DRIFT_RESYNC", "true")
+	acm := &inspectStore{}
+	s, _ := syncedSecret(t, acm)
+	acm.remote = remoteOf(t, s)
+
+	resync, err := DetectDrift(context.Background(), s)
+	require.NoError(t, err)
+	assert.False(t, resync)
+	assert.Equal(t, 1, acm.inspectCnt)
+	assert.Empty(t, recordedEvents())
+}
+
+func TestDetectDrift_ResyncsDriftedTarget(t *testing.T) {
+	t.Setenv("DRIFT_RESYNC", "true")
+	acm := &inspectStore{}
+	s, cs := syncedSecret(t, acm)
+	acm.remote = remoteOf(t, s)
+	acm.remote.Fingerprint = "replaced"
+
+	resync, err := DetectDrift(context.Background(), s)
+	require.NoError(t, err)
+	assert.True(t, resync)
+	events := recordedEvents()
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning DriftDetected Remote certificate in store acm does not match the secret: remote fingerprint replaced")
+
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.Empty(t, st["acm"].Hash)
+	assert.NotEmpty(t, st["vault"].Hash)
+	assert.NotContains(t, got.Annotations, state.OperatorName+"/hash")
+
+	vault := &fakeStore{}
+	acm.syncCnt = 0
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), got))
+	assert.Equal(t, 1, acm.syncCnt, "the drifted target is pushed again")
+	assert.Zero(t, vault.syncCnt)
+}
+
+func TestDetectDrift_MissingWithoutResync(t *testing.T) {
+	t.Setenv("DRIFT_RESYNC", "")
+	acm := &inspectStore{}
+	s, cs := syncedSecret(t, acm)
+	acm.inspectErr = fmt.Errorf("gone: %w", tlssecret.ErrRemoteNotFound)
+
+	resync, err := DetectDrift(context.Background(), s)
+	require.NoError(t, err)
+	assert.False(t, resync)
+	assert.Equal(t, []string{"Warning DriftDetected Remote certificate in store acm not found"}, recordedEvents())
+	assert.Equal(t, s.Annotations, getSecret(t, cs).Annotations, "the secret is not changed")
+}
+
+func TestDetectDrift_SkipsTargetsNotInSync(t *testing.T) {
+	acm := &inspectStore{}
+	s, _ := syncedSecret(t, acm)
+	synced := s.Annotations[state.SyncStateAnnotation()]
+	st := syncStateOf(t, s)
+	st["acm"].FailedAttempts = 1
+	st["acm"].NextRetry = time.Now().Add(time.Hour)
+	sv, err := st.Marshal()
+	require.NoError(t, err)
+	s.Annotations[state.SyncStateAnnotation()] = sv
+
+	_, err = DetectDrift(context.Background(), s)
+	require.NoError(t, err)
+	assert.Zero(t, acm.inspectCnt, "a failed target is left to HandleSecret")
+
+	s.Annotations[state.SyncStateAnnotation()] = synced
+	s.Data = tlsData("renewed")
+	_, err = DetectDrift(context.Background(), s)
+	require.NoError(t, err)
+	assert.Zero(t, acm.inspectCnt, "a changed certificate is left to HandleSecret")
+}
+
+func TestDetectDrift_InspectError(t *testing.T) {
+	acm := &inspectStore{inspectErr: errors.New("throttled")}
+	s, _ := syncedSecret(t, acm)
+
+	resync, err := DetectDrift(context.Background(), s)
+	assert.ErrorContains(t, err, "store acm inspect: throttled")
+	assert.False(t, resync)
+	assert.Empty(t, recordedEvents())
+}
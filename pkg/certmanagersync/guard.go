@@ -0,0 +1,82 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeguard"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+)
+
+var (
+	guardMu sync.Mutex
+	guard   *storeguard.Guard
+)
+
+// storeGuard returns the guard shared by every remote store call, configured
+// from the environment on first use.
+func storeGuard() *storeguard.Guard {
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	if guard == nil {
+		guard = storeguard.New(storeguard.ConfigFromEnv())
+		guard.OnStateChange = func(k storeguard.Key, s storeguard.State) {
+			metrics.SetCircuitState(k.Store, k.Endpoint, k.Credential, int(s))
+		}
+	}
+	return guard
+}
+
+// guardKey returns the key of the calls a target in namespace makes: its
+// store type, the endpoint its config selects and the credentials it uses.
+// target has its credential profile applied, which may set the endpoint.
+func guardKey(target tlssecret.GenericSecretSyncConfig, namespace string) storeguard.Key {
+	k := storeguard.Key{Store: target.Store}
+	if schema, err := StoreSchema(cmtypes.StoreType(target.Store)); err == nil {
+		k.Endpoint = schema.Endpoint(target.Config)
+	}
+	if ref := credentials.TargetRef(target, namespace); ref.IsSet() {
+		k.Credential = ref.String()
+	}
+	return k
+}
+
+// guarded runs call, the remote calls of a configured store, once the store
+// guard admits them. A call that is not admitted is not run, and a
+// *storeguard.DeferredError is returned.
+func guarded(ctx context.Context, key storeguard.Key, call func() error) error {
+	g := storeGuard()
+	if err := g.Acquire(ctx, key); err != nil {
+		return err
+	}
+	err := call()
+	g.Done(key, unavailable(err))
+	return err
+}
+
+// unavailable reports whether err suggests the store itself is failing, and
+// counts towards opening its circuit. Errors of a permanent category, and a
+// missing remote certificate, prove the store answered; a cancelled call says
+// nothing about the store.
+func unavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, tlssecret.ErrRemoteNotFound) {
+		return false
+	}
+	return !syncerrors.Classify(err).Permanent()
+}
+
+// deferredUntil returns when the call deferred with err may be retried, or
+// false when err is not a deferral.
+func deferredUntil(err error) (time.Time, bool) {
+	var de *storeguard.DeferredError
+	if !errors.As(err, &de) {
+		return time.Time{}, false
+	}
+	return de.Until, true
+}
@@ -0,0 +1,155 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeguard"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSecret_OpenCircuitDefersSync(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "1")
+	t.Setenv("CIRCUIT_BREAKER_OPEN_DURATION", "10m")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{syncErr: syncerrors.New(syncerrors.Transient, errors.New("service unavailable"))}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, storeguard.Open, storeGuard().State(storeguard.Key{Store: "acm", Endpoint: "us-east-1"}))
+	recordedEvents()
+
+	// a user forcing the retry still does not reach the store
+	got := getSecret(t, cs)
+	delete(got.Annotations, state.OperatorName+"/next-retry")
+	acm.syncErr = nil
+	require.NoError(t, HandleSecret(context.Background(), got))
+	assert.Equal(t, 1, acm.syncCnt)
+
+	got = getSecret(t, cs)
+	ts := syncStateOf(t, got)["acm"]
+	assert.Equal(t, 1, ts.FailedAttempts, "a deferred sync is not a failed attempt")
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), ts.NextRetry, 5*time.Second)
+	assert.Equal(t, ts.NextRetry.Format(time.RFC3339), got.Annotations[state.OperatorName+"/next-retry"])
+	assert.Empty(t, got.Annotations[state.OperatorName+"/hash"])
+	events := recordedEvents()
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0], "Normal SyncDeferred Sync to 1 store deferred until")
+}
+
+func TestHandleSecret_DeferredHealthyTargetIsRetried(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "1")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	storeGuard().Done(storeguard.Key{Store: "acm", Endpoint: "us-east-1"}, true)
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 0, acm.syncCnt)
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	require.NotNil(t, st["acm"])
+	assert.False(t, st["acm"].Failed())
+	assert.Empty(t, st["acm"].Hash, "the target is still due")
+	assert.NotEmpty(t, st["vault"].Hash)
+	assert.NotEmpty(t, got.Annotations[state.OperatorName+"/next-retry"])
+	assert.Empty(t, got.Annotations[state.OperatorName+"/failed-sync-attempts"])
+	assert.Empty(t, got.Annotations[state.OperatorName+"/hash"], "the secret is not marked as synced")
+	status := state.GetStatus(got)
+	assert.True(t, status.Targets["acm"].LastAttemptTime.IsZero(), "the store was not called")
+	assert.False(t, status.Targets["vault"].LastAttemptTime.IsZero())
+}
+
+func TestHandleSecret_DeferralKeepsOtherTargetsBackoff(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	// vault's deferral has passed, as the operator scheduled it; acm is
+	// still in its own backoff.
+	deferred := time.Now().Add(-time.Minute)
+	st := state.SyncState{
+		"acm":   {FailedAttempts: 3, NextRetry: time.Now().Add(time.Hour), LastError: "throttled"},
+		"vault": {NextRetry: deferred},
+	}
+	sv, err := st.Marshal()
+	require.NoError(t, err)
+	s := syncSecret(map[string]string{
+		state.SyncStateAnnotation():                  sv,
+		state.OperatorName + "/failed-sync-attempts": "3",
+		state.OperatorName + "/next-retry":           deferred.Format(time.RFC3339),
+	})
+	cs := withFakeClientset(t, s)
+	acm, vault := &fakeStore{}, &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, vault.syncCnt)
+	assert.Zero(t, acm.syncCnt, "another store's deferral does not cut acm's backoff short")
+	got := syncStateOf(t, getSecret(t, cs))
+	assert.True(t, got["vault"].NextRetry.IsZero())
+	assert.WithinDuration(t, time.Now().Add(time.Hour), got["acm"].NextRetry, time.Minute)
+
+	// moving next-retry earlier still forces the retry
+	forced := getSecret(t, cs)
+	forced.Annotations[state.OperatorName+"/next-retry"] = time.Now().Add(-time.Second).Format(time.RFC3339)
+	require.NoError(t, HandleSecret(context.Background(), forced))
+	assert.Equal(t, 1, acm.syncCnt)
+}
+
+func TestHandleSecretDelete_OpenCircuitDefersDelete(t *testing.T) {
+	clearDeleteEnv(t)
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "1")
+	s := makeSecret("s1", "ns", map[string]string{
+		state.DeletePolicyAnnotation():     state.DeletePolicyDelete,
+		state.OperatorName + "/acm-region": "us-east-1",
+	}, []string{state.FinalizerName()})
+	cs := withFakeClientset(t, s)
+	stub := &fakeStore{deleteErr: errors.New("api down")}
+	registerStubStore(t, map[string]RemoteStore{"acm": stub})
+
+	require.Error(t, HandleSecretDelete(context.Background(), s))
+	got := getSecret(t, cs)
+	delete(got.Annotations, state.NextDeleteAnnotation())
+	require.NoError(t, HandleSecretDelete(context.Background(), got))
+	assert.Equal(t, 1, stub.deleteCnt)
+
+	got = getSecret(t, cs)
+	assert.Contains(t, got.Finalizers, state.FinalizerName())
+	assert.Equal(t, "1", got.Annotations[state.DeleteAttemptsAnnotation()], "a deferred delete is not a failed attempt")
+	next, err := time.Parse(time.RFC3339, got.Annotations[state.NextDeleteAnnotation()])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), next, 5*time.Second)
+}
+
+func TestGuardKey(t *testing.T) {
+	assert.Equal(t, storeguard.Key{Store: "acm", Endpoint: "us-east-1"},
+		guardKey(tlssecret.GenericSecretSyncConfig{Store: "acm", Config: map[string]string{"region": "us-east-1"}}, "ns"))
+	assert.Equal(t, storeguard.Key{Store: "acm", Endpoint: "eu-west-1"},
+		guardKey(tlssecret.GenericSecretSyncConfig{Store: "acm", Config: map[string]string{"region": "eu-west-1"}}, "ns"),
+		"ambient credentials in another region do not share a circuit")
+	assert.Equal(t, storeguard.Key{Store: "cloudflare", Credential: "secret ns/cf"},
+		guardKey(tlssecret.GenericSecretSyncConfig{Store: "cloudflare", Config: map[string]string{"secret-name": "cf"}}, "ns"))
+}
+
+func TestUnavailable(t *testing.T) {
+	for err, want := range map[error]bool{
+		nil:                         false,
+		errors.New("boom"):          true,
+		context.DeadlineExceeded:    true,
+		context.Canceled:            false,
+		tlssecret.ErrRemoteNotFound: false,
+		syncerrors.New(syncerrors.Quota, errors.New("429")):                                true,
+		fmt.Errorf("store acm: %w", syncerrors.New(syncerrors.Auth, errors.New("denied"))): false,
+	} {
+		assert.Equal(t, want, unavailable(err), "%v", err)
+	}
+}
@@ -2,10 +2,66 @@ package certmanagersync
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"time"
 
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"k8s.io/client-go/tools/record"
 )
 
+// recordedEvents drains and returns the events recorded by the fake
+// state.EventRecorder.
+func recordedEvents() []string {
+	rec := state.EventRecorder.(*record.FakeRecorder)
+	var events []string
+	for len(rec.Events) > 0 {
+		events = append(events, <-rec.Events)
+	}
+	return events
+}
+
+// testKey is the private key of every certificate tlsData returns.
+var testKey = sync.OnceValue(func() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// tlsData returns secret data holding a valid self-signed certificate for cn,
+// so the secret passes the certificate integrity checks. Certificates for
+// different names share a key, as a renewal would.
+func tlsData(cn string) map[string][]byte {
+	key := testKey()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return map[string][]byte{
+		"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"tls.key": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
 // fakeStore is a test double satisfying both RemoteStore and DeletableRemoteStore.
 type fakeStore struct {
 	syncErr   error
@@ -13,14 +69,16 @@ type fakeStore struct {
 	syncCnt   int
 	deleteCnt int
 	gotConfig tlssecret.GenericSecretSyncConfig
+	gotCert   *tlssecret.Certificate
 }
 
-func (f *fakeStore) Sync(_ *tlssecret.Certificate) (map[string]string, error) {
+func (f *fakeStore) Sync(_ context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	f.syncCnt++
+	f.gotCert = c
 	return nil, f.syncErr
 }
 
-func (f *fakeStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (f *fakeStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	f.gotConfig = c
 	return nil
 }
@@ -39,12 +97,12 @@ type nonDeletableFakeStore struct {
 	gotConfig tlssecret.GenericSecretSyncConfig
 }
 
-func (f *nonDeletableFakeStore) Sync(_ *tlssecret.Certificate) (map[string]string, error) {
+func (f *nonDeletableFakeStore) Sync(_ context.Context, _ *tlssecret.Certificate) (map[string]string, error) {
 	f.syncCnt++
 	return nil, f.syncErr
 }
 
-func (f *nonDeletableFakeStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (f *nonDeletableFakeStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	f.gotConfig = c
 	return nil
 }
@@ -0,0 +1,209 @@
+package certmanagersync
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuedTLSData returns secret data holding only a leaf, signed by an
+// intermediate, and the PEM encoded intermediate and root.
+func issuedTLSData(t *testing.T) (map[string][]byte, []byte, []byte) {
+	t.Helper()
+	create := func(cn string, isCA bool, pub *ecdsa.PublicKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+		}
+		if isCA {
+			tmpl.KeyUsage = x509.KeyUsageCertSign
+		}
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return cert
+	}
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	interKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	root := create("root", true, &rootKey.PublicKey, nil, rootKey)
+	inter := create("intermediate", true, &interKey.PublicKey, root, rootKey)
+	leaf := create("leaf", false, &testKey().PublicKey, inter, interKey)
+
+	data := tlsData("leaf")
+	data["tls.crt"] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	return data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: inter.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+}
+
+func TestHandleSecret_CompletesChain(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "")
+	data, inter, _ := issuedTLSData(t)
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, os.WriteFile(bundle, inter, 0o600))
+	t.Setenv("INTERMEDIATES_BUNDLE", bundle)
+
+	s := syncSecret(map[string]string{state.CompleteChainAnnotation(): "true"})
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, inter, acm.gotCert.Ca)
+	assert.Empty(t, s.Data["ca.crt"], "the secret is not changed")
+	assert.Contains(t, recordedEvents(), `Normal ChainCompleted Completed certificate chain with 1 intermediate: "CN=intermediate"`)
+}
+
+func TestHandleSecret_CompletesChainBeforeRoot(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "true")
+	data, inter, root := issuedTLSData(t)
+	data["ca.crt"] = root
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, os.WriteFile(bundle, inter, 0o600))
+	t.Setenv("INTERMEDIATES_BUNDLE", bundle)
+
+	s := syncSecret(nil)
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Equal(t, 1, acm.syncCnt, "the completed chain is valid")
+	assert.Equal(t, append(append([]byte(nil), inter...), root...), acm.gotCert.Ca, "the intermediate goes before the root")
+}
+
+func TestHandleSecret_TrustedChainIsNotCompleted(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "true")
+	t.Setenv("INTERMEDIATES_BUNDLE", filepath.Join(t.TempDir(), "missing.pem"))
+	data, inter, root := issuedTLSData(t)
+	data["ca.crt"] = inter
+	block, _ := pem.Decode(root)
+	rootCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	prev := trustedRoots
+	trustedRoots = x509.NewCertPool()
+	trustedRoots.AddCert(rootCert)
+	t.Cleanup(func() { trustedRoots = prev })
+
+	s := syncSecret(nil)
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, inter, acm.gotCert.Ca)
+	for _, e := range recordedEvents() {
+		assert.NotContains(t, e, "ChainIncomplete", "no source is read for a chain ending under a trusted root")
+	}
+}
+
+func TestHandleSecret_ChainCompletionDisabled(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "true")
+	data, inter, _ := issuedTLSData(t)
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, os.WriteFile(bundle, inter, 0o600))
+	t.Setenv("INTERMEDIATES_BUNDLE", bundle)
+
+	s := syncSecret(map[string]string{state.CompleteChainAnnotation(): "false"})
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Equal(t, 1, acm.syncCnt)
+	assert.Empty(t, acm.gotCert.Ca)
+}
+
+func TestHandleSecret_EnablingCompletionRepushes(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "")
+	data, inter, _ := issuedTLSData(t)
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, os.WriteFile(bundle, inter, 0o600))
+	t.Setenv("INTERMEDIATES_BUNDLE", bundle)
+
+	s := syncSecret(nil)
+	s.Data = data
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+
+	t.Setenv("COMPLETE_CHAIN", "true")
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), getSecret(t, cs)))
+	require.Equal(t, 1, acm.syncCnt, "a target in sync is pushed again once its chain is completed")
+	assert.Equal(t, inter, acm.gotCert.Ca)
+}
+
+func TestHandleSecret_ChainCompletionFailureStillSyncs(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "true")
+	t.Setenv("INTERMEDIATES_BUNDLE", filepath.Join(t.TempDir(), "missing.pem"))
+	data, _, _ := issuedTLSData(t)
+
+	s := syncSecret(nil)
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	events := recordedEvents()
+	require.NotEmpty(t, events)
+	assert.Contains(t, events[0], "Warning ChainIncomplete Certificate chain not completed: failed to read intermediates bundle")
+}
+
+func TestHandleSecret_SyncsMisorderedChain(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("COMPLETE_CHAIN", "")
+	data, inter, root := issuedTLSData(t)
+	data["ca.crt"] = append(append(append([]byte(nil), root...), inter...), inter...)
+
+	s := syncSecret(nil)
+	s.Data = data
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.Equal(t, 1, acm.syncCnt, "a chain out of order or with repeats is put in order, not rejected")
+	ch, err := acm.gotCert.Chain()
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte(nil), inter...), root...), ch.IssuersPEM(tlssecret.IncludeRoot))
+}
@@ -0,0 +1,56 @@
+package certmanagersync
+
+import (
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordCertificateMetrics records the expiry and identity of the secret's
+// leaf certificate. It runs on every reconcile, not only when a target is
+// due, so the gauges are set for every watched secret after a restart.
+func recordCertificateMetrics(s *corev1.Secret) {
+	leaf, err := (&tlssecret.Certificate{Certificate: s.Data["tls.crt"]}).Leaf()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"action":    "recordCertificateMetrics",
+			"namespace": s.Namespace,
+			"name":      s.Name,
+		}).WithError(err).Debug("cannot parse certificate")
+		return
+	}
+	metrics.SetCertificate(s.Namespace, s.Name, leaf)
+}
+
+// pruneTargetMetrics drops the series of the targets recorded in prev that
+// are no longer configured on the secret.
+func pruneTargetMetrics(s *corev1.Secret, prev, targets state.SyncState) {
+	for key := range prev {
+		if _, ok := targets[key]; !ok {
+			metrics.DeleteTarget(s.Namespace, s.Name, key)
+		}
+	}
+}
+
+// recordBackoffMetrics records the failed attempts and next retry of each of
+// the secret's targets. Targets that have exhausted max-sync-attempts or
+// failed permanently have no next retry.
+func recordBackoffMetrics(s *corev1.Secret, cert *tlssecret.Certificate, targets state.SyncState) {
+	maxR := maxRetries(s)
+	for _, sync := range cert.Syncs {
+		ts := targets[sync.Key()]
+		if !ts.Failed() {
+			metrics.SetTargetBackoff(s.Namespace, s.Name, sync.Key(), 0, time.Time{})
+			continue
+		}
+		next := ts.NextRetry
+		if maxR != -1 && ts.FailedAttempts >= maxR || failedPermanently(ts) {
+			next = time.Time{}
+		}
+		metrics.SetTargetBackoff(s.Namespace, s.Name, sync.Key(), ts.FailedAttempts, next)
+	}
+}
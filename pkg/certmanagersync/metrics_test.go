@@ -0,0 +1,57 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSecret_RecordsMetrics(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{syncErr: errors.New("throttled")}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	failures := testutil.ToFloat64(metrics.SyncAttempts.WithLabelValues("acm", metrics.ResultFailure))
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	leaf, err := (&tlssecret.Certificate{Certificate: s.Data["tls.crt"]}).Leaf()
+	require.NoError(t, err)
+	assert.Equal(t, float64(leaf.NotAfter.Unix()), testutil.ToFloat64(metrics.CertificateNotAfter.WithLabelValues("ns", "s1")))
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.SyncAttempts.WithLabelValues("acm", metrics.ResultFailure)))
+
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.FailedAttempts.WithLabelValues("ns", "s1", "acm")))
+	assert.Equal(t, float64(st["acm"].NextRetry.Unix()), testutil.ToFloat64(metrics.NextRetry.WithLabelValues("ns", "s1", "acm")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.FailedAttempts.WithLabelValues("ns", "s1", "vault")))
+	assert.NotZero(t, testutil.ToFloat64(metrics.LastSuccess.WithLabelValues("ns", "s1", "vault")))
+	assert.False(t, metrics.NextRetry.DeleteLabelValues("ns", "s1", "vault"), "a synced target has no next retry")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SyncStatus.WithLabelValues("ns", "s1", "acm", "acm", "fail")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SyncStatus.WithLabelValues("ns", "s1", "vault", "vault", "success")))
+}
+
+func TestHandleSecret_RemovedTargetDropsMetrics(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+	require.NotZero(t, testutil.ToFloat64(metrics.LastSuccess.WithLabelValues("ns", "s1", "vault")))
+
+	got := getSecret(t, cs)
+	delete(got.Annotations, state.OperatorName+"/vault-path")
+	require.NoError(t, HandleSecret(context.Background(), got))
+	for _, v := range []*prometheus.GaugeVec{metrics.SyncStatus, metrics.LastSuccess, metrics.FailedAttempts} {
+		assert.Zero(t, v.DeletePartialMatch(prometheus.Labels{"namespace": "ns", "secret": "s1", "target": "vault"}))
+	}
+	assert.True(t, metrics.SyncStatus.DeleteLabelValues("ns", "s1", "acm", "acm", "success"), "remaining targets are kept")
+}
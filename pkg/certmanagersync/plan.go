@@ -0,0 +1,152 @@
+package certmanagersync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Planned actions of a target.
+const (
+	// PlanCreate is a sync to a target with no remote certificate recorded.
+	PlanCreate = "create"
+	// PlanUpdate is a sync to a target that already has a remote certificate.
+	PlanUpdate = "update"
+	// PlanDelete is the remote delete of a target of a deleted secret.
+	PlanDelete = "delete"
+	// PlanSkipUnchanged is a target that would not be touched.
+	PlanSkipUnchanged = "skip-unchanged"
+	// PlanBlockedByBackoff is a failed target waiting for its next retry, or
+	// one that exhausted max-sync-attempts or failed permanently.
+	PlanBlockedByBackoff = "blocked-by-backoff"
+	// PlanBlockedByCertificate is a target waiting for the secret's
+	// cert-manager Certificate to be Ready.
+	PlanBlockedByCertificate = "blocked-by-certificate"
+)
+
+// TargetPlan is what a reconcile would do to a single target.
+type TargetPlan struct {
+	Target string `json:"target"`
+	Store  string `json:"store"`
+	Action string `json:"action"`
+	// Reason explains a skipped or blocked target.
+	Reason   string `json:"reason,omitempty"`
+	RemoteID string `json:"remoteId,omitempty"`
+	// Error is the credential or configuration problem that would fail the
+	// action.
+	Error string `json:"error,omitempty"`
+}
+
+// SecretPlan is what a reconcile would do to each target of a secret.
+type SecretPlan struct {
+	Namespace string       `json:"namespace"`
+	Name      string       `json:"name"`
+	Targets   []TargetPlan `json:"targets"`
+}
+
+// Plan returns what HandleSecret, or HandleSecretDelete for a secret pending
+// deletion, would do to each target of s, without calling any store's Sync or
+// Delete and without changing the secret. Targets that would be synced have
+// their credential profile resolved and their config checked against the
+// store's schema, and a problem is reported in the target's Error, as is an
+// invalid certificate.
+func Plan(ctx context.Context, s *corev1.Secret) (*SecretPlan, error) {
+	p := &SecretPlan{Namespace: s.Namespace, Name: s.Name}
+	secretSyncs := secretSyncsFn(s)
+	cert := parseSecret(s, secretSyncs)
+	if cert == nil {
+		return nil, fmt.Errorf("error parsing secret %s/%s", s.Namespace, s.Name)
+	}
+	if state.SecretDeletePending(s) {
+		p.Targets = planDelete(ctx, s, cert)
+		return p, nil
+	}
+	st, hasState := state.GetSyncState(s)
+	targets := loadTargetStates(s, cert, st, hasState)
+	_, scheduled := summarizeTargets(s, targets)
+	legacyBackoff := !hasState && !readyToRetry(s)
+	unchanged := len(secretSyncs) == 0 && !state.CacheChanged(s)
+	certErr := cert.Validate()
+	_, waiting := certificateGateFn(s)
+	for _, sync := range cert.Syncs {
+		tp := TargetPlan{Target: sync.Key(), Store: sync.Store, RemoteID: remoteID(sync)}
+		hash := state.HashSecretTarget(s, sync.Config)
+		resetIfChanged(targets[sync.Key()], hash)
+		switch ok, reason := targetDue(s, targets[sync.Key()], hash, scheduled); {
+		case legacyBackoff:
+			tp.Action, tp.Reason = PlanBlockedByBackoff, "backoff"
+		case unchanged:
+			tp.Action, tp.Reason = PlanSkipUnchanged, "secret unchanged"
+		case !ok && reason == "unchanged":
+			tp.Action = PlanSkipUnchanged
+		case !ok:
+			tp.Action, tp.Reason = PlanBlockedByBackoff, reason
+		case waiting != "":
+			tp.Action, tp.Reason = PlanBlockedByCertificate, waiting
+		default:
+			tp.Action = PlanUpdate
+			if tp.RemoteID == "" {
+				tp.Action = PlanCreate
+			}
+			if certErr != nil {
+				tp.Error = certErr.Error()
+			} else if err := checkTarget(ctx, s.Namespace, sync); err != nil {
+				tp.Error = err.Error()
+			}
+		}
+		p.Targets = append(p.Targets, tp)
+	}
+	return p, nil
+}
+
+// checkTarget resolves the credential profile of a target and validates its
+// config against the store's schema, as syncTarget does before any store
+// call.
+func checkTarget(ctx context.Context, namespace string, sync *tlssecret.GenericSecretSyncConfig) error {
+	rs, err := newStoreFn(sync.Store)
+	if err != nil {
+		return fmt.Errorf("store %s initialization failed: %w", sync.Store, err)
+	}
+	cfg, err := credentials.Prepare(ctx, *sync, namespace)
+	if err != nil {
+		return fmt.Errorf("store %s credentials: %w", sync.Store, err)
+	}
+	if err := validateConfig(rs, cfg); err != nil {
+		return fmt.Errorf("store %s configuration invalid: %w", sync.Store, err)
+	}
+	return nil
+}
+
+// planDelete returns the plan of HandleSecretDelete for a secret pending
+// deletion.
+func planDelete(ctx context.Context, s *corev1.Secret, cert *tlssecret.Certificate) []TargetPlan {
+	var out []TargetPlan
+	for _, sync := range cert.Syncs {
+		tp := TargetPlan{Target: sync.Key(), Store: sync.Store, RemoteID: remoteID(sync), Action: PlanDelete}
+		switch {
+		case !readyToRetryDelete(s):
+			tp.Action, tp.Reason = PlanBlockedByBackoff, "delete backoff"
+		case state.EffectiveDeletePolicy(s) != state.DeletePolicyDelete:
+			tp.Action, tp.Reason = PlanSkipUnchanged, "delete policy is retain"
+		default:
+			rs, err := newStoreFn(sync.Store)
+			if err != nil {
+				tp.Error = fmt.Sprintf("init store %s: %v", sync.Store, err)
+				break
+			}
+			if _, ok := rs.(DeletableRemoteStore); !ok {
+				tp.Action, tp.Reason = PlanSkipUnchanged, errDeleteUnsupported.Error()
+				break
+			}
+			if _, err := credentials.Prepare(ctx, withSecretNamespaceDefault(*sync, s.Namespace), s.Namespace); err != nil {
+				tp.Error = fmt.Sprintf("store %s credentials: %v", sync.Store, err)
+			}
+		}
+		out = append(out, tp)
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/stores/vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlan_CreateAndUpdate(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/acm-certificate-arn": "arn:aws:acm:us-east-1:1:certificate/1",
+	})
+	cs := withFakeClientset(t, s)
+	acm := &arnStore{}
+	v := &schemaStore{schema: &vault.Schema}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": v})
+
+	p, err := Plan(context.Background(), s)
+	require.NoError(t, err)
+	assert.Equal(t, "ns", p.Namespace)
+	assert.Equal(t, "s1", p.Name)
+	assert.Equal(t, []TargetPlan{
+		{Target: "acm", Store: "acm", Action: PlanUpdate, RemoteID: "arn:aws:acm:us-east-1:1:certificate/1"},
+		{Target: "vault", Store: "vault", Action: PlanCreate, Error: "store vault configuration invalid: invalid vault configuration: addr: required"},
+	}, p.Targets)
+	assert.Zero(t, acm.syncCnt)
+	assert.Zero(t, v.syncCnt)
+	assert.Equal(t, s.Annotations, getSecret(t, cs).Annotations, "the secret is not changed")
+	assert.Empty(t, recordedEvents())
+}
+
+func TestPlan_SkipsUnchangedAndBlocksBackoff(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("throttled")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+	recordedEvents()
+
+	acm, v := &fakeStore{}, &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": v})
+	got := getSecret(t, cs)
+	p, err := Plan(context.Background(), got)
+	require.NoError(t, err)
+	assert.Equal(t, []TargetPlan{
+		{Target: "acm", Store: "acm", Action: PlanBlockedByBackoff, Reason: "backoff"},
+		{Target: "vault", Store: "vault", Action: PlanSkipUnchanged},
+	}, p.Targets)
+
+	// Removing next-retry makes the failed target due again.
+	delete(got.Annotations, state.OperatorName+"/next-retry")
+	p, err = Plan(context.Background(), got)
+	require.NoError(t, err)
+	assert.Equal(t, PlanCreate, p.Targets[0].Action)
+	assert.Equal(t, PlanSkipUnchanged, p.Targets[1].Action)
+	assert.Zero(t, acm.syncCnt)
+	assert.Zero(t, v.syncCnt)
+}
+
+func TestPlan_InvalidCertificate(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	s.Data["tls.key"] = tlsData("other")["tls.crt"]
+	withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+
+	p, err := Plan(context.Background(), s)
+	require.NoError(t, err)
+	for _, tp := range p.Targets {
+		assert.Equal(t, PlanCreate, tp.Action)
+		assert.Contains(t, tp.Error, "invalid certificate: tls.key")
+	}
+}
+
+func TestPlan_Delete(t *testing.T) {
+	clearDeleteEnv(t)
+	s := syncSecret(map[string]string{state.DeletePolicyAnnotation(): state.DeletePolicyDelete})
+	s.Finalizers = []string{state.FinalizerName()}
+	now := metav1.Now()
+	s.DeletionTimestamp = &now
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &nonDeletableFakeStore{}})
+
+	p, err := Plan(context.Background(), s)
+	require.NoError(t, err)
+	assert.Equal(t, []TargetPlan{
+		{Target: "acm", Store: "acm", Action: PlanDelete},
+		{Target: "vault", Store: "vault", Action: PlanSkipUnchanged, Reason: "store does not support delete"},
+	}, p.Targets)
+	assert.Zero(t, acm.deleteCnt)
+
+	s.Annotations[state.NextDeleteAnnotation()] = time.Now().Add(time.Hour).Format(time.RFC3339)
+	p, err = Plan(context.Background(), s)
+	require.NoError(t, err)
+	for _, tp := range p.Targets {
+		assert.Equal(t, PlanBlockedByBackoff, tp.Action)
+	}
+
+	s.Annotations[state.DeletePolicyAnnotation()] = state.DeletePolicyRetain
+	delete(s.Annotations, state.NextDeleteAnnotation())
+	p, err = Plan(context.Background(), s)
+	require.NoError(t, err)
+	for _, tp := range p.Targets {
+		assert.Equal(t, PlanSkipUnchanged, tp.Action)
+		assert.Equal(t, "delete policy is retain", tp.Reason)
+	}
+}
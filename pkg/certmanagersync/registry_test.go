@@ -50,3 +50,42 @@ func TestStoresImplementDelete(t *testing.T) {
 		})
 	}
 }
+
+// TestStoresDeclareSchema requires every built-in store to declare the
+// configuration keys it reads.
+func TestStoresDeclareSchema(t *testing.T) {
+	for _, st := range cmtypes.EnabledStores {
+		t.Run(string(st), func(t *testing.T) {
+			rs, err := NewStore(st)
+			if err != nil {
+				t.Fatalf("NewStore(%s): %v", st, err)
+			}
+			if _, ok := rs.(SchemaRemoteStore); !ok {
+				t.Fatalf("store %s does not implement SchemaRemoteStore", st)
+			}
+		})
+	}
+}
+
+// TestStoresImplementInspect locks in which built-in stores can be checked
+// for drift and spared writes of a certificate they already hold.
+func TestStoresImplementInspect(t *testing.T) {
+	inspectable := map[cmtypes.StoreType]bool{
+		cmtypes.ACMStoreType:          true,
+		cmtypes.CloudflareStoreType:   true,
+		cmtypes.DigitalOceanStoreType: true,
+		cmtypes.FilepathStoreType:     true,
+		cmtypes.HetznerCloudStoreType: true,
+	}
+	for _, st := range cmtypes.EnabledStores {
+		t.Run(string(st), func(t *testing.T) {
+			rs, err := NewStore(st)
+			if err != nil {
+				t.Fatalf("NewStore(%s): %v", st, err)
+			}
+			if _, ok := rs.(InspectableRemoteStore); ok != inspectable[st] {
+				t.Fatalf("store %s: implements InspectableRemoteStore = %v, want %v", st, ok, inspectable[st])
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package certmanagersync
+
+import (
+	"errors"
+	"fmt"
+
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	log "github.com/sirupsen/logrus"
+)
+
+// SchemaRemoteStore is implemented by stores that declare the configuration
+// keys they read. A target of such a store is validated against the schema
+// before the store is configured, so a missing or malformed key fails the
+// target without any provider call.
+type SchemaRemoteStore interface {
+	ConfigSchema() *storeconfig.Schema
+}
+
+// errNoSchema is returned by StoreSchema for a store that does not implement
+// SchemaRemoteStore.
+var errNoSchema = errors.New("store does not declare a config schema")
+
+// StoreSchema returns the configuration schema of a store type.
+func StoreSchema(storeType cmtypes.StoreType) (*storeconfig.Schema, error) {
+	rs, err := NewStore(storeType)
+	if err != nil {
+		return nil, err
+	}
+	sr, ok := rs.(SchemaRemoteStore)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", storeType, errNoSchema)
+	}
+	return sr.ConfigSchema(), nil
+}
+
+func init() {
+	credentials.StoreSecretKeys = storeSecretKeys
+}
+
+// storeSecretKeys returns the secret keys declared by the schema of a store
+// type, or nil when it has none.
+func storeSecretKeys(store string) []credentials.SecretKey {
+	schema, err := StoreSchema(cmtypes.StoreType(store))
+	if err != nil {
+		return nil
+	}
+	return schema.SecretKeys()
+}
+
+// VerifySchemas checks that every enabled store declares a well-formed
+// schema. It is called at startup.
+func VerifySchemas() error {
+	var errs []error
+	for _, st := range cmtypes.EnabledStores {
+		schema, err := StoreSchema(st)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := schema.Verify(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfig checks a target's config, with its credential profile
+// applied, against the store's schema. Keys the store does not read are
+// logged rather than rejected, as they were always ignored. Stores without a
+// schema are not checked.
+func validateConfig(rs RemoteStore, c tlssecret.GenericSecretSyncConfig) error {
+	sr, ok := rs.(SchemaRemoteStore)
+	if !ok {
+		return nil
+	}
+	schema := sr.ConfigSchema()
+	if unknown := schema.Unknown(c.Config); len(unknown) > 0 {
+		log.WithFields(log.Fields{
+			"action": "validateConfig",
+			"store":  c.Store,
+			"index":  c.Index,
+			"keys":   unknown,
+		}).Warn("ignoring unknown config keys")
+	}
+	return schema.Validate(c.Config)
+}
@@ -0,0 +1,72 @@
+package certmanagersync
+
+import (
+	"context"
+	"testing"
+
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/stores/vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schemaStore is a fakeStore declaring a config schema.
+type schemaStore struct {
+	fakeStore
+	schema *storeconfig.Schema
+}
+
+func (s *schemaStore) ConfigSchema() *storeconfig.Schema {
+	return s.schema
+}
+
+func TestVerifySchemas(t *testing.T) {
+	require.NoError(t, VerifySchemas())
+	for _, st := range cmtypes.EnabledStores {
+		schema, err := StoreSchema(st)
+		require.NoError(t, err, st)
+		assert.NotEmpty(t, schema.Keys, st)
+	}
+	_, err := StoreSchema("nope")
+	assert.ErrorIs(t, err, cmtypes.ErrInvalidStoreType)
+}
+
+func TestStoreSecretKeys(t *testing.T) {
+	assert.Equal(t, []credentials.SecretKey{{Name: "secret-name", Namespace: "secret-namespace"}}, storeSecretKeys("cloudflare"))
+	assert.Equal(t, []credentials.SecretKey{{Name: "secret-name"}}, storeSecretKeys("heroku"),
+		"heroku reads the namespace only from a <namespace>/<name> secret-name")
+	assert.Equal(t, []credentials.SecretKey{{Name: "pkcs12-password-secret", Namespace: "pkcs12-password-secret-namespace"}}, storeSecretKeys("vault"))
+	assert.Nil(t, storeSecretKeys("nope"))
+}
+
+func TestHandleSecret_InvalidConfigSkipsStore(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	v := &schemaStore{schema: &vault.Schema}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": v})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, 0, v.syncCnt, "the store is never configured or called with an invalid config")
+
+	assert.Contains(t, recordedEvents(), "Warning SyncFailed Invalid configuration for store vault: invalid vault configuration: addr: required")
+}
+
+func TestHandleSecret_ValidConfigSyncs(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/vault-addr":    "https://vault",
+		state.OperatorName + "/vault-unknown": "ignored",
+	})
+	withFakeClientset(t, s)
+	v := &schemaStore{schema: &vault.Schema}
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": v})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, v.syncCnt, "unknown keys are not fatal")
+}
@@ -0,0 +1,116 @@
+package certmanagersync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// outputStore is a RemoteStore that reports fixed updates on sync.
+type outputStore struct {
+	fakeStore
+	updates map[string]string
+}
+
+func (o *outputStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
+	_, err := o.fakeStore.Sync(ctx, c)
+	return o.updates, err
+}
+
+// statusCall captures the arguments of a recordStatusFn call.
+type statusCall struct {
+	list    []*v1alpha1.SecretSync
+	pushed  []*tlssecret.GenericSecretSyncConfig
+	targets state.SyncState
+}
+
+// withSecretSyncs stubs the SecretSync lookup to return list for every
+// secret, and records status writes instead of sending them.
+func withSecretSyncs(t *testing.T, list ...*v1alpha1.SecretSync) *[]statusCall {
+	t.Helper()
+	var calls []statusCall
+	prevFor, prevRecord := secretSyncsFn, recordStatusFn
+	secretSyncsFn = func(*corev1.Secret) []*v1alpha1.SecretSync { return list }
+	recordStatusFn = func(_ context.Context, list []*v1alpha1.SecretSync, pushed []*tlssecret.GenericSecretSyncConfig, targets state.SyncState) error {
+		calls = append(calls, statusCall{list: list, pushed: pushed, targets: targets})
+		return nil
+	}
+	t.Cleanup(func() { secretSyncsFn, recordStatusFn = prevFor, prevRecord })
+	return &calls
+}
+
+func acmSecretSync() *v1alpha1.SecretSync {
+	return &v1alpha1.SecretSync{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+		Spec: v1alpha1.SecretSyncSpec{
+			SecretName: "s1",
+			Targets:    []v1alpha1.SyncTarget{{ACM: &v1alpha1.ACMTarget{Region: "eu-west-1"}}},
+		},
+	}
+}
+
+func TestHandleSecret_SecretSyncTargetsSyncWithoutAnnotations(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := makeSecret("s1", "ns", nil, nil)
+	s.Data = tlsData("cert")
+	cs := withFakeClientset(t, s)
+	calls := withSecretSyncs(t, acmSecretSync())
+	acm := &outputStore{updates: map[string]string{"certificate-arn": "arn:1"}}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, "eu-west-1", acm.gotConfig.Config["region"])
+
+	require.Len(t, *calls, 1)
+	call := (*calls)[0]
+	require.Len(t, call.pushed, 1)
+	assert.Equal(t, "arn:1", call.pushed[0].Updates["certificate-arn"])
+	assert.False(t, call.targets["secretsync/web/acm"].Failed())
+
+	got := getSecret(t, cs)
+	assert.NotEmpty(t, syncStateOf(t, got)["secretsync/web/acm"].Hash)
+	assert.NotContains(t, got.Annotations, state.OperatorName+"/acm-certificate-arn", "outputs are recorded in status, not annotations")
+}
+
+func TestHandleSecret_SecretSyncChangeIsSyncedWhenSecretIsUnchanged(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+	synced := getSecret(t, cs)
+	require.False(t, state.CacheChanged(synced))
+
+	withSecretSyncs(t, acmSecretSync())
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), synced))
+	assert.Equal(t, 1, acm.syncCnt, "only the new SecretSync target is pushed")
+	assert.Equal(t, "eu-west-1", acm.gotConfig.Config["region"])
+	assert.Equal(t, 0, vault.syncCnt)
+}
+
+func TestHandleSecretDelete_IncludesSecretSyncTargets(t *testing.T) {
+	clearDeleteEnv(t)
+	s := makeSecret("s1", "ns", map[string]string{
+		state.DeletePolicyAnnotation(): state.DeletePolicyDelete,
+	}, []string{state.FinalizerName()})
+	now := metav1.Now()
+	s.DeletionTimestamp = &now
+	withFakeClientset(t, s)
+	withSecretSyncs(t, acmSecretSync())
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm})
+
+	require.NoError(t, HandleSecretDelete(context.Background(), s))
+	assert.Equal(t, 1, acm.deleteCnt)
+}
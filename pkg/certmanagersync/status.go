@@ -0,0 +1,108 @@
+package certmanagersync
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// buildStatus returns the sync-status of a secret after a sync round at now.
+// prev is the status recorded before; the due targets are updated from their
+// results, targets no longer configured are dropped, and every target's
+// Synced flag and last error, as well as the Ready condition, follow the sync
+// state in targets.
+func buildStatus(prev state.Status, cert *tlssecret.Certificate, targets state.SyncState, due []*tlssecret.GenericSecretSyncConfig, results []syncResult, now time.Time) state.Status {
+	now = now.UTC().Truncate(time.Second)
+	status := state.Status{
+		Conditions: slices.Clone(prev.Conditions),
+		Targets:    make(map[string]*state.TargetStatus, len(cert.Syncs)),
+	}
+	var serial string
+	var notAfter time.Time
+	if leaf, err := cert.Leaf(); err == nil {
+		serial, notAfter = leaf.SerialNumber.Text(16), leaf.NotAfter.UTC()
+	}
+	attempted := make(map[string]syncResult, len(due))
+	for i, sync := range due {
+		attempted[sync.Key()] = results[i]
+	}
+	var failed []string
+	pending := 0
+	for _, sync := range cert.Syncs {
+		key := sync.Key()
+		ts := &state.TargetStatus{}
+		if p := prev.Targets[key]; p != nil {
+			*ts = *p
+		}
+		ts.Store = sync.Store
+		// a deferred target's store was not called
+		res, ok := attempted[key]
+		if _, deferred := res.deferred(); ok && !deferred {
+			ts.LastAttemptTime = now
+			if res.err == nil {
+				ts.LastSuccessTime = now
+				ts.Serial, ts.NotAfter = serial, notAfter
+			}
+		}
+		if id := remoteID(sync); id != "" {
+			ts.RemoteID = id
+		}
+		st := targets[key]
+		ts.Synced = st != nil && !st.Failed() && st.Hash != ""
+		ts.LastError, ts.ErrorCategory = "", ""
+		switch {
+		case st.Failed():
+			ts.LastError, ts.ErrorCategory = st.LastError, st.ErrorCategory
+			failed = append(failed, fmt.Sprintf("%s: %s", key, st.LastError))
+		case !ts.Synced:
+			pending++
+		}
+		status.Targets[key] = ts
+	}
+	n := len(status.Targets)
+	cond := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             v1alpha1.ReasonSynced,
+		Message:            fmt.Sprintf("%d target%s synced", n, plural(n)),
+	}
+	if len(failed) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = v1alpha1.ReasonSyncFailed
+		cond.Message = fmt.Sprintf("%d of %d targets failed: %s", len(failed), n, strings.Join(failed, "; "))
+	} else if pending > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = v1alpha1.ReasonSyncPending
+		cond.Message = fmt.Sprintf("%d of %d targets not yet synced", pending, n)
+	}
+	apimeta.SetStatusCondition(&status.Conditions, cond)
+	return status
+}
+
+// remoteID returns the identifier of the remote certificate of a target, the
+// value of the output key declared by its store's schema, if any.
+func remoteID(sync *tlssecret.GenericSecretSyncConfig) string {
+	rs, err := newStoreFn(sync.Store)
+	if err != nil {
+		return ""
+	}
+	sr, ok := rs.(SchemaRemoteStore)
+	if !ok {
+		return ""
+	}
+	config := sync.EffectiveConfig()
+	for _, k := range sr.ConfigSchema().Keys {
+		if k.Output && config[k.Name] != "" {
+			return config[k.Name]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/robertlestak/cert-manager-sync/stores/acm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// arnStore is an outputStore declaring the acm schema, so the ARN it reports
+// is recognized as the remote ID.
+type arnStore struct {
+	outputStore
+}
+
+func (*arnStore) ConfigSchema() *storeconfig.Schema {
+	return &acm.Schema
+}
+
+func TestHandleSecret_RecordsStatus(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	leaf, err := (&tlssecret.Certificate{Certificate: s.Data["tls.crt"]}).Leaf()
+	require.NoError(t, err)
+	arn := &arnStore{outputStore{fakeStore: fakeStore{syncErr: errors.New("throttled")}}}
+	registerStubStore(t, map[string]RemoteStore{"acm": arn, "vault": &fakeStore{}})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	got := getSecret(t, cs)
+	status := state.GetStatus(got)
+	ready := apimeta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, v1alpha1.ReasonSyncFailed, ready.Reason)
+	assert.Equal(t, "1 of 2 targets failed: acm: store acm sync failed: throttled", ready.Message)
+
+	failed := status.Targets["acm"]
+	require.NotNil(t, failed)
+	assert.Equal(t, "acm", failed.Store)
+	assert.False(t, failed.Synced)
+	assert.False(t, failed.LastAttemptTime.IsZero())
+	assert.True(t, failed.LastSuccessTime.IsZero())
+	assert.Equal(t, "store acm sync failed: throttled", failed.LastError)
+
+	synced := status.Targets["vault"]
+	require.NotNil(t, synced)
+	assert.True(t, synced.Synced)
+	assert.Equal(t, synced.LastAttemptTime, synced.LastSuccessTime)
+	assert.Equal(t, leaf.SerialNumber.Text(16), synced.Serial)
+	assert.Equal(t, leaf.NotAfter.Unix(), synced.NotAfter.Unix())
+	assert.Empty(t, synced.LastError)
+
+	// Retry the failed target; the recorded ARN becomes its remote ID.
+	delete(got.Annotations, state.OperatorName+"/next-retry")
+	_, err = cs.CoreV1().Secrets("ns").Update(context.Background(), got, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	arn.syncErr = nil
+	arn.updates = map[string]string{"certificate-arn": "arn:aws:acm:us-east-1:1:certificate/1"}
+	require.NoError(t, HandleSecret(context.Background(), got))
+	status = state.GetStatus(getSecret(t, cs))
+	ready = apimeta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, "2 targets synced", ready.Message)
+	assert.True(t, status.Targets["acm"].Synced)
+	assert.Equal(t, "arn:aws:acm:us-east-1:1:certificate/1", status.Targets["acm"].RemoteID)
+	assert.Empty(t, status.Targets["acm"].LastError)
+	assert.Equal(t, synced.LastSuccessTime, status.Targets["vault"].LastSuccessTime, "a target not due keeps its status")
+}
+
+func TestHandleSecret_StatusDoesNotCountAsChange(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	got := getSecret(t, cs)
+	require.NotEmpty(t, got.Annotations[state.SyncStatusAnnotation()])
+	assert.False(t, state.CacheChanged(got))
+}
@@ -0,0 +1,204 @@
+package certmanagersync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// loadTargetStates returns a state entry for every target currently configured
+// on the secret, dropping entries for targets that have since been removed.
+//
+// Entries are copies, so they can be updated freely without touching st.
+// When the secret has no recorded sync state yet, each target inherits the
+// secret-wide failed-sync-attempts count so backoff carries over. When a user
+// lowers or removes failed-sync-attempts, targets are capped to that value so
+// that the documented "reset the counter" escape hatch keeps working.
+func loadTargetStates(s *corev1.Secret, cert *tlssecret.Certificate, st state.SyncState, hasState bool) state.SyncState {
+	consumed := consumedRetries(s)
+	out := make(state.SyncState, len(cert.Syncs))
+	for _, sync := range cert.Syncs {
+		ts := &state.TargetState{}
+		if prev, ok := st[sync.Key()]; ok && prev != nil {
+			*ts = *prev
+		}
+		if !hasState {
+			ts.FailedAttempts = consumed
+		} else if ts.FailedAttempts > consumed {
+			ts.FailedAttempts = consumed
+		}
+		out[sync.Key()] = ts
+	}
+	return out
+}
+
+// targetDue reports whether a target should be pushed, and if not, why.
+//
+// A healthy target is due when its recorded hash no longer matches. A failed
+// target is due once its own backoff has elapsed, unless it has exhausted
+// max-sync-attempts or its last error is permanent. The secret-wide
+// next-retry annotation acts as an override: when a user removes it or moves
+// it earlier than scheduled, the retry the operator wrote to it (see
+// summarizeTargets), failed targets are retried as soon as it allows.
+func targetDue(s *corev1.Secret, ts *state.TargetState, hash string, scheduled time.Time) (bool, string) {
+	if !ts.Failed() {
+		if ts.Hash == hash && !state.CacheDisabled() {
+			return false, "unchanged"
+		}
+		return true, ""
+	}
+	if maxR := maxRetries(s); maxR != -1 && ts.FailedAttempts >= maxR {
+		return false, "max retries reached"
+	}
+	if failedPermanently(ts) {
+		return false, "permanent error"
+	}
+	now := time.Now()
+	if !now.Before(ts.NextRetry) || retryForced(s, scheduled, now) {
+		return true, ""
+	}
+	return false, "backoff"
+}
+
+// retryForced reports whether a user forced the failed targets of s to be
+// retried, by removing the next-retry annotation or by moving it earlier
+// than scheduled, and the time it names has come. The annotation holds whole
+// seconds, so scheduled is compared at that precision.
+func retryForced(s *corev1.Secret, scheduled, now time.Time) bool {
+	override := nextRetryTime(s)
+	if override.IsZero() {
+		return true
+	}
+	return override.Before(scheduled.Truncate(time.Second)) && !now.Before(override)
+}
+
+// failedPermanently reports whether the last failure of a target is of a
+// permanent category, such as rejected credentials or an invalid certificate.
+// Such a target is not retried until resetIfChanged, or a user resetting
+// failed-sync-attempts, clears its failures.
+func failedPermanently(ts *state.TargetState) bool {
+	return ts.Failed() && syncerrors.Category(ts.ErrorCategory).Permanent()
+}
+
+// retryDelay returns when a target that failed with an error of the given
+// category is retried, after the given number of previously failed attempts.
+// Transient errors are retried soon, following transientBackoff; others
+// follow the target's policy p.
+func retryDelay(p backoff.Policy, c syncerrors.Category, retries int) time.Duration {
+	if c == syncerrors.Transient {
+		return transientBackoff.Delay(retries)
+	}
+	return p.Delay(retries)
+}
+
+// transientBackoff is the policy of transient errors: 15s, 30s, 1m, ... up to
+// 5m, with jitter so targets that failed together do not retry together.
+var transientBackoff = backoff.Policy{
+	Base:       15 * time.Second,
+	Multiplier: 2,
+	Max:        5 * time.Minute,
+	Jitter:     0.2,
+}
+
+// resetIfChanged clears the failures of a target when its hash no longer
+// matches the one they were counted against, because the certificate or the
+// target's config changed since, so the change gets a fresh retry budget. It
+// reports whether the target was reset. Failures recorded without a hash are
+// kept.
+func resetIfChanged(ts *state.TargetState, hash string) bool {
+	if !ts.Failed() || ts.FailedHash == "" || ts.FailedHash == hash {
+		return false
+	}
+	*ts = state.TargetState{}
+	return true
+}
+
+// readBackFirst reports whether the store of a due target is asked for the
+// certificate it holds before it is written: only when the target has no
+// record of a sync, or is due although unchanged because the cache is
+// disabled. A target that failed, or whose certificate or config changed, is
+// always written so a config change is never lost.
+func readBackFirst(ts *state.TargetState, hash string) bool {
+	return !ts.Failed() && (ts.Hash == "" || ts.Hash == hash)
+}
+
+// summarizeTargets returns the values for the secret-wide
+// failed-sync-attempts and next-retry annotations: the highest attempt count
+// of any failed target, and the earliest retry among failed targets that have
+// not exhausted max-sync-attempts nor failed permanently, and healthy targets
+// whose sync the store guard deferred. A zero attempt count means no target
+// failed.
+func summarizeTargets(s *corev1.Secret, targets state.SyncState) (int, time.Time) {
+	maxR := maxRetries(s)
+	attempts := 0
+	var nextRetry time.Time
+	for _, ts := range targets {
+		if !ts.Failed() {
+			if !ts.NextRetry.IsZero() && (nextRetry.IsZero() || ts.NextRetry.Before(nextRetry)) {
+				nextRetry = ts.NextRetry
+			}
+			continue
+		}
+		attempts = max(attempts, ts.FailedAttempts)
+		if maxR != -1 && ts.FailedAttempts >= maxR || failedPermanently(ts) {
+			continue
+		}
+		if nextRetry.IsZero() || ts.NextRetry.Before(nextRetry) {
+			nextRetry = ts.NextRetry
+		}
+	}
+	return attempts, nextRetry
+}
+
+// recordTimeout bounds the patch that records the outcome of store calls.
+const recordTimeout = 30 * time.Second
+
+// recordContext returns the context used to persist the outcome of store
+// calls that have already run. It ignores ctx's cancellation, so a shutdown
+// or sync timeout cannot drop the record of a completed remote write (such as
+// a newly issued certificate ID), but is bounded by recordTimeout.
+func recordContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+}
+
+// patchSecretAnnotations merge-patches the secret so its annotations match
+// want. Only changed keys are sent; keys absent from want are removed. No
+// request is made when nothing changed.
+func patchSecretAnnotations(ctx context.Context, s *corev1.Secret, want map[string]string) error {
+	changes := make(map[string]interface{})
+	for k, v := range want {
+		if cur, ok := s.Annotations[k]; !ok || cur != v {
+			changes[k] = v
+		}
+	}
+	for k := range s.Annotations {
+		if _, ok := want[k]; !ok {
+			// a JSON merge patch removes a key only when it is explicitly null
+			changes[k] = nil
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	pd, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": changes,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal annotation patch: %w", err)
+	}
+	if _, err := secretsClient(s.Namespace).Patch(ctx, s.Name, types.MergePatchType, pd, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("patch annotations on %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return nil
+}
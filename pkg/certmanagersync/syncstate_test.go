@@ -0,0 +1,627 @@
+package certmanagersync
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robertlestak/cert-manager-sync/internal/metrics"
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// syncSecret builds a watched secret syncing to acm and vault.
+func syncSecret(extra map[string]string) *corev1.Secret {
+	annot := map[string]string{
+		state.OperatorName + "/sync-enabled": "true",
+		state.OperatorName + "/acm-region":   "us-east-1",
+		state.OperatorName + "/vault-path":   "kv/data/test",
+	}
+	for k, v := range extra {
+		annot[k] = v
+	}
+	s := makeSecret("s1", "ns", annot, nil)
+	s.Data = tlsData("cert")
+	return s
+}
+
+func getSecret(t *testing.T, cs *fake.Clientset) *corev1.Secret {
+	t.Helper()
+	got, err := cs.CoreV1().Secrets("ns").Get(context.Background(), "s1", metav1.GetOptions{})
+	require.NoError(t, err)
+	return got
+}
+
+func syncStateOf(t *testing.T, s *corev1.Secret) state.SyncState {
+	t.Helper()
+	st, ok := state.GetSyncState(s)
+	require.True(t, ok, "sync-state annotation must be present")
+	return st
+}
+
+func TestHandleSecret_PartialFailure_RecordsPerTargetState(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{syncErr: errors.New("throttled")}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, 1, vault.syncCnt)
+
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.False(t, st["vault"].Failed())
+	assert.NotEmpty(t, st["vault"].Hash)
+	assert.Equal(t, 1, st["acm"].FailedAttempts)
+	assert.Contains(t, st["acm"].LastError, "throttled")
+	assert.True(t, st["acm"].NextRetry.After(time.Now()))
+	assert.Equal(t, "1", got.Annotations[state.OperatorName+"/failed-sync-attempts"])
+	assert.NotEmpty(t, got.Annotations[state.OperatorName+"/next-retry"])
+	assert.Empty(t, got.Annotations[state.OperatorName+"/hash"], "secret is not fully synced")
+}
+
+func TestHandleSecret_BackoffSkipsFailedTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("throttled")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), getSecret(t, cs)))
+	assert.Equal(t, 0, acm.syncCnt, "failed target is still in backoff")
+	assert.Equal(t, 0, vault.syncCnt, "healthy target is not re-pushed")
+}
+
+func TestHandleSecret_RetryOnlyPushesFailedTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("throttled")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+
+	// Removing next-retry is the documented way to force an immediate retry.
+	retry := getSecret(t, cs)
+	delete(retry.Annotations, state.OperatorName+"/next-retry")
+	_, err := cs.CoreV1().Secrets("ns").Update(context.Background(), retry, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), retry))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, 0, vault.syncCnt, "healthy target is not re-pushed")
+
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.False(t, st["acm"].Failed())
+	assert.NotEmpty(t, st["acm"].Hash)
+	assert.NotContains(t, got.Annotations, state.OperatorName+"/failed-sync-attempts")
+	assert.NotContains(t, got.Annotations, state.OperatorName+"/next-retry")
+	assert.NotEmpty(t, got.Annotations[state.OperatorName+"/hash"])
+	assert.False(t, state.CacheChanged(got), "fully synced secret hashes clean")
+}
+
+func TestHandleSecret_ConfigChangeOnlyPushesChangedTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+
+	changed := getSecret(t, cs)
+	changed.Annotations[state.OperatorName+"/vault-path"] = "kv/data/other"
+	_, err := cs.CoreV1().Secrets("ns").Update(context.Background(), changed, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), changed))
+	assert.Equal(t, 0, acm.syncCnt)
+	assert.Equal(t, 1, vault.syncCnt)
+}
+
+func TestHandleSecret_CertificateChangePushesEveryTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+
+	renewed := getSecret(t, cs)
+	renewed.Data["tls.crt"] = tlsData("renewed")["tls.crt"]
+
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), renewed))
+	assert.Equal(t, 1, acm.syncCnt)
+	assert.Equal(t, 1, vault.syncCnt)
+}
+
+func TestHandleSecret_ExhaustedTargetDoesNotBlockOthers(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/max-sync-attempts": "1",
+	})
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("denied")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+	exhausted := getSecret(t, cs)
+	assert.NotContains(t, exhausted.Annotations, state.OperatorName+"/next-retry", "nothing left to retry")
+	assert.Zero(t, RetryAfter(exhausted))
+
+	exhausted.Data["tls.crt"] = tlsData("renewed")["tls.crt"]
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), exhausted))
+	assert.Equal(t, 1, acm.syncCnt, "renewal resets the exhausted target")
+	assert.Equal(t, 1, vault.syncCnt, "renewal still reaches healthy target")
+}
+
+func TestHandleSecret_UnchangedExhaustedTargetIsNotRetried(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/max-sync-attempts": "1",
+	})
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("denied")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Equal(t, state.HashTarget(s.Data, map[string]string{"region": "us-east-1"}), st["acm"].FailedHash)
+
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), getSecret(t, cs)))
+	assert.Zero(t, acm.syncCnt, "exhausted target waits for a change")
+}
+
+func TestHandleSecret_ForceSyncsEveryTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/max-sync-attempts": "1",
+	})
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("denied")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+
+	acm, vault := &fakeStore{}, &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+	require.NoError(t, HandleSecret(context.Background(), getSecret(t, cs)))
+	assert.Zero(t, acm.syncCnt+vault.syncCnt)
+	require.NoError(t, HandleSecret(WithForce(context.Background()), getSecret(t, cs)))
+	assert.Equal(t, 1, acm.syncCnt, "an exhausted target is retried")
+	assert.Equal(t, 1, vault.syncCnt, "a target in sync is pushed again")
+	assert.False(t, state.CacheDisabled())
+}
+
+func TestHandleSecret_ConfigChangeResetsRetryBudget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("denied")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+	// Removing next-retry retries the failed target at once.
+	retry := getSecret(t, cs)
+	delete(retry.Annotations, state.OperatorName+"/next-retry")
+	retry, err := cs.CoreV1().Secrets("ns").Update(context.Background(), retry, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Error(t, HandleSecret(context.Background(), retry))
+	got := getSecret(t, cs)
+	require.Equal(t, 2, syncStateOf(t, got)["acm"].FailedAttempts)
+
+	got.Annotations[state.OperatorName+"/acm-region"] = "eu-west-1"
+	_, err = cs.CoreV1().Secrets("ns").Update(context.Background(), got, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	acm := &fakeStore{syncErr: errors.New("still denied")}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	require.Error(t, HandleSecret(context.Background(), got))
+	assert.Equal(t, 1, acm.syncCnt, "the fixed config is synced despite the backoff")
+
+	got = getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.Equal(t, 1, st["acm"].FailedAttempts, "attempts count from the change")
+	assert.Equal(t, "1", got.Annotations[state.OperatorName+"/failed-sync-attempts"])
+	assert.WithinDuration(t, time.Now().Add(time.Minute), st["acm"].NextRetry, 5*time.Second)
+}
+
+func TestHandleSecret_PermanentErrorIsNotRetried(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{syncErr: syncerrors.New(syncerrors.Auth, errors.New("access denied"))}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	authErrs := testutil.ToFloat64(metrics.SyncErrors.WithLabelValues("acm", "auth"))
+
+	require.ErrorContains(t, HandleSecret(context.Background(), s), "access denied")
+	assert.Contains(t, recordedEvents(), "Warning AuthenticationFailed Failed to sync to store acm, not retried until the certificate or config changes: access denied")
+	assert.Equal(t, authErrs+1, testutil.ToFloat64(metrics.SyncErrors.WithLabelValues("acm", "auth")))
+
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.Equal(t, "auth", st["acm"].ErrorCategory)
+	assert.Equal(t, "auth", state.GetStatus(got).Targets["acm"].ErrorCategory)
+	assert.Equal(t, "1", got.Annotations[state.OperatorName+"/failed-sync-attempts"])
+	assert.NotContains(t, got.Annotations, state.OperatorName+"/next-retry", "a permanent failure has no next retry")
+
+	require.NoError(t, HandleSecret(context.Background(), got))
+	assert.Equal(t, 1, acm.syncCnt, "the same input is not retried")
+
+	got.Annotations[state.OperatorName+"/acm-region"] = "eu-west-1"
+	got, err := cs.CoreV1().Secrets("ns").Update(context.Background(), got, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Error(t, HandleSecret(context.Background(), got))
+	assert.Equal(t, 2, acm.syncCnt, "a config change is retried")
+}
+
+func TestHandleSecret_TransientErrorRetriesSoon(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: syncerrors.New(syncerrors.Transient, errors.New("service unavailable"))},
+		"vault": &fakeStore{},
+	})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	assert.Contains(t, recordedEvents(), "Warning SyncFailed Failed to sync to store acm: service unavailable")
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Equal(t, "transient", st["acm"].ErrorCategory)
+	assert.WithinDuration(t, time.Now().Add(transientBackoff.Base), st["acm"].NextRetry, 5*time.Second)
+}
+
+func TestRetryDelay(t *testing.T) {
+	for n := range 8 {
+		want := min(transientBackoff.Base<<uint(n), transientBackoff.Max)
+		d := retryDelay(backoff.Default, syncerrors.Transient, n)
+		assert.GreaterOrEqual(t, d, want*8/10, n)
+		assert.LessOrEqual(t, d, want*12/10, n)
+		assert.Equal(t, backoff.Default.Delay(n), retryDelay(backoff.Default, syncerrors.Unknown, n))
+		assert.Equal(t, backoff.Default.Delay(n), retryDelay(backoff.Default, syncerrors.Quota, n))
+	}
+}
+
+func TestHandleSecret_TargetBackoffPolicy(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.BackoffAnnotation(backoff.KeyBase):   "10m",
+		state.OperatorName + "/vault-backoff-base": "3h",
+	})
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("throttled")},
+		"vault": &fakeStore{syncErr: errors.New("sealed")},
+	})
+
+	require.Error(t, HandleSecret(context.Background(), s))
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), st["acm"].NextRetry, 5*time.Second, "the secret's policy")
+	assert.WithinDuration(t, time.Now().Add(3*time.Hour), st["vault"].NextRetry, 5*time.Second, "the target's policy")
+	assert.Equal(t, st["acm"].NextRetry.Format(time.RFC3339), got.Annotations[state.OperatorName+"/next-retry"])
+}
+
+func TestHandleSecret_LegacyBackoffCarriesOver(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(map[string]string{
+		state.OperatorName + "/failed-sync-attempts": "2",
+		state.OperatorName + "/next-retry":           time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	withFakeClientset(t, s)
+	acm := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 0, acm.syncCnt, "legacy secret-wide backoff is honoured")
+
+	s.Annotations[state.OperatorName+"/next-retry"] = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{
+		"acm":   &fakeStore{syncErr: errors.New("still broken")},
+		"vault": &fakeStore{},
+	})
+	require.Error(t, HandleSecret(context.Background(), s))
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Equal(t, 3, st["acm"].FailedAttempts, "attempt count continues from the legacy annotation")
+	assert.False(t, st["vault"].Failed())
+}
+
+func TestHandleSecret_RemovedTargetIsPruned(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	registerStubStore(t, map[string]RemoteStore{"acm": &fakeStore{}, "vault": &fakeStore{}})
+	require.NoError(t, HandleSecret(context.Background(), s))
+
+	trimmed := getSecret(t, cs)
+	delete(trimmed.Annotations, state.OperatorName+"/vault-path")
+	_, err := cs.CoreV1().Secrets("ns").Update(context.Background(), trimmed, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, HandleSecret(context.Background(), trimmed))
+
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Contains(t, st, "acm")
+	assert.NotContains(t, st, "vault")
+}
+
+func TestPatchSecretAnnotations_NoChangeSkipsPatch(t *testing.T) {
+	s := makeSecret("s1", "ns", map[string]string{"a": "1"}, nil)
+	cs := withFakeClientset(t, s)
+	require.NoError(t, patchSecretAnnotations(context.Background(), s, map[string]string{"a": "1"}))
+	for _, a := range cs.Actions() {
+		assert.NotEqual(t, "patch", a.GetVerb())
+	}
+}
+
+func TestPatchSecretAnnotations_RemovesKeys(t *testing.T) {
+	s := makeSecret("s1", "ns", map[string]string{"a": "1", "b": "2"}, nil)
+	cs := withFakeClientset(t, s)
+	require.NoError(t, patchSecretAnnotations(context.Background(), s, map[string]string{"a": "1", "c": "3"}))
+	got := getSecret(t, cs)
+	assert.Equal(t, map[string]string{"a": "1", "c": "3"}, got.Annotations)
+}
+
+// blockingStore records how many Sync calls are in flight at once.
+type blockingStore struct {
+	fakeStore
+	inFlight *atomic.Int32
+	peak     *atomic.Int32
+	release  <-chan struct{}
+	updates  map[string]string
+}
+
+func (b *blockingStore) Sync(_ context.Context, _ *tlssecret.Certificate) (map[string]string, error) {
+	n := b.inFlight.Add(1)
+	for {
+		p := b.peak.Load()
+		if n <= p || b.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	<-b.release
+	b.inFlight.Add(-1)
+	return b.updates, nil
+}
+
+func TestSyncConcurrency(t *testing.T) {
+	cases := map[string]int{"": defaultSyncConcurrency, "1": 1, "10": 10, "0": defaultSyncConcurrency, "x": defaultSyncConcurrency}
+	for in, want := range cases {
+		t.Setenv("SYNC_CONCURRENCY", in)
+		assert.Equal(t, want, syncConcurrency(), "SYNC_CONCURRENCY=%q", in)
+	}
+}
+
+func TestHandleSecret_FansOutWithinConcurrencyLimit(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	t.Setenv("SYNC_CONCURRENCY", "2")
+	annot := map[string]string{state.OperatorName + "/sync-enabled": "true"}
+	for i := 0; i < 4; i++ {
+		annot[state.OperatorName+"/acm-region."+strconv.Itoa(i)] = "us-east-" + strconv.Itoa(i)
+	}
+	s := makeSecret("s1", "ns", annot, nil)
+	s.Data = tlsData("cert")
+	cs := withFakeClientset(t, s)
+
+	var inFlight, peak atomic.Int32
+	release := make(chan struct{})
+	prev := newStoreFn
+	var mu sync.Mutex
+	created := 0
+	newStoreFn = func(string) (RemoteStore, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created++
+		return &blockingStore{
+			inFlight: &inFlight,
+			peak:     &peak,
+			release:  release,
+			updates:  map[string]string{"certificate-arn": "arn-" + strconv.Itoa(created)},
+		}, nil
+	}
+	t.Cleanup(func() { newStoreFn = prev })
+
+	done := make(chan error)
+	go func() { done <- HandleSecret(context.Background(), s) }()
+	require.Eventually(t, func() bool { return inFlight.Load() == 2 }, time.Second, time.Millisecond)
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, int32(2), peak.Load(), "never more than SYNC_CONCURRENCY stores in flight")
+
+	got := getSecret(t, cs)
+	for i := 0; i < 4; i++ {
+		assert.NotEmpty(t, got.Annotations[state.OperatorName+"/acm-certificate-arn."+strconv.Itoa(i)], "updates from every store are written back")
+	}
+	assert.Len(t, syncStateOf(t, got), 4)
+}
+
+// ctxStore blocks in Sync until its context is done.
+type ctxStore struct {
+	fakeStore
+}
+
+func (c *ctxStore) Sync(ctx context.Context, _ *tlssecret.Certificate) (map[string]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHandleSecret_SyncTimeoutFailsTarget(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := makeSecret("s1", "ns", map[string]string{
+		state.OperatorName + "/sync-enabled": "true",
+		state.OperatorName + "/acm-region":   "us-east-1",
+		state.SyncTimeoutAnnotation():        "20ms",
+	}, nil)
+	s.Data = tlsData("cert")
+	cs := withFakeClientset(t, s)
+	prev := newStoreFn
+	newStoreFn = func(string) (RemoteStore, error) { return &ctxStore{}, nil }
+	t.Cleanup(func() { newStoreFn = prev })
+
+	err := HandleSecret(context.Background(), s)
+	require.Error(t, err)
+	ts := syncStateOf(t, getSecret(t, cs))["acm"]
+	require.NotNil(t, ts)
+	assert.Equal(t, 1, ts.FailedAttempts)
+	assert.Contains(t, ts.LastError, context.DeadlineExceeded.Error())
+}
+
+func TestHandleSecret_InvalidCertificateIsNotPushed(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	s.Data["tls.key"] = tlsData("other")["tls.crt"]
+	cs := withFakeClientset(t, s)
+	acm := &fakeStore{}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.ErrorContains(t, HandleSecret(context.Background(), s), "invalid certificate: tls.key")
+	assert.Equal(t, 0, acm.syncCnt)
+	assert.Equal(t, 0, vault.syncCnt)
+
+	var invalid []string
+	for _, e := range recordedEvents() {
+		if strings.HasPrefix(e, "Warning SyncFailed Certificate not synced to any store: invalid certificate: tls.key:") {
+			invalid = append(invalid, e)
+		}
+	}
+	assert.Len(t, invalid, 1, "one event for the secret, not one per store")
+
+	st := syncStateOf(t, getSecret(t, cs))
+	assert.Equal(t, 1, st["acm"].FailedAttempts)
+	assert.Equal(t, 1, st["vault"].FailedAttempts)
+	assert.Contains(t, st["vault"].LastError, "invalid certificate")
+}
+
+func TestHandleSecret_SkipsWriteWhenRemoteHoldsCertificate(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	s := syncSecret(nil)
+	cs := withFakeClientset(t, s)
+	acm := &inspectStore{remote: remoteOf(t, s)}
+	vault := &fakeStore{}
+	registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": vault})
+
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.inspectCnt)
+	assert.Zero(t, acm.syncCnt, "the store already holds the certificate")
+	assert.Equal(t, 1, vault.syncCnt)
+	assert.Contains(t, recordedEvents(), "Normal Synced Secret synced to 2 stores; 1 already up to date")
+
+	got := getSecret(t, cs)
+	st := syncStateOf(t, got)
+	assert.NotEmpty(t, st["acm"].Hash, "an unchanged target is recorded as synced")
+	assert.NotEmpty(t, got.Annotations[state.OperatorName+"/hash"])
+}
+
+func TestHandleSecret_ReadBackFallsBackToWrite(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	cases := []struct {
+		name string
+		acm  *inspectStore
+	}{
+		{name: "missing", acm: &inspectStore{}},
+		{name: "different", acm: &inspectStore{remote: &tlssecret.RemoteCertificate{Fingerprint: "other"}}},
+		{name: "expiry only", acm: &inspectStore{remote: &tlssecret.RemoteCertificate{NotAfter: time.Now()}}},
+		{name: "error", acm: &inspectStore{inspectErr: errors.New("throttled")}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := syncSecret(nil)
+			withFakeClientset(t, s)
+			registerStubStore(t, map[string]RemoteStore{"acm": tc.acm, "vault": &fakeStore{}})
+
+			require.NoError(t, HandleSecret(context.Background(), s))
+			assert.Equal(t, 1, tc.acm.inspectCnt)
+			assert.Equal(t, 1, tc.acm.syncCnt)
+		})
+	}
+}
+
+func TestHandleSecret_ReadBackComparesChain(t *testing.T) {
+	t.Setenv("CACHE_DISABLE", "")
+	data, inter, root := issuedTLSData(t)
+	leafOnly, err := tlssecret.InspectPEM(data["tls.crt"], nil)
+	require.NoError(t, err)
+	withChain, err := tlssecret.InspectPEM(data["tls.crt"], append(append([]byte(nil), inter...), root...))
+	require.NoError(t, err)
+	cases := []struct {
+		name   string
+		remote *tlssecret.RemoteCertificate
+	}{
+		{name: "stale chain", remote: withChain},
+		{name: "leaf only", remote: &tlssecret.RemoteCertificate{Fingerprint: leafOnly.Fingerprint}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := syncSecret(nil)
+			s.Data = data
+			acm := &inspectStore{remote: tc.remote}
+			withFakeClientset(t, s)
+			registerStubStore(t, map[string]RemoteStore{"acm": acm, "vault": &fakeStore{}})
+
+			require.NoError(t, HandleSecret(context.Background(), s))
+			assert.Equal(t, 1, acm.inspectCnt)
+			assert.Equal(t, 1, acm.syncCnt, "the store holds the leaf but not its chain")
+		})
+	}
+}
+
+func TestHandleSecret_ReadBackOnlyForUnchangedTargets(t *testing.T) {
+	acm := &inspectStore{}
+	s, cs := syncedSecret(t, acm)
+
+	// With the cache disabled an unchanged target is due again, and only
+	// written when the store no longer holds the certificate.
+	t.Setenv("CACHE_DISABLE", "true")
+	acm.remote = remoteOf(t, s)
+	acm.syncCnt = 0
+	require.NoError(t, HandleSecret(context.Background(), s))
+	assert.Equal(t, 1, acm.inspectCnt)
+	assert.Zero(t, acm.syncCnt)
+
+	// A renewed certificate is written without asking the store first.
+	t.Setenv("CACHE_DISABLE", "")
+	renewed := getSecret(t, cs)
+	renewed.Data["tls.crt"] = tlsData("renewed")["tls.crt"]
+	acm.inspectCnt = 0
+	require.NoError(t, HandleSecret(context.Background(), renewed))
+	assert.Zero(t, acm.inspectCnt)
+	assert.Equal(t, 1, acm.syncCnt)
+}
@@ -0,0 +1,321 @@
+// Package credentials resolves the credentials a store uses for a sync
+// target, whether named directly as a secret or through a StoreCredential or
+// ClusterStoreCredential profile.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Config keys referencing a credential profile.
+const (
+	CredentialKey        = "credential"
+	ClusterCredentialKey = "cluster-credential"
+)
+
+// ErrNoSecret is returned by Resolve for a profile without a secretRef.
+// Stores that can fall back to ambient credentials, such as ACM, check for it.
+var ErrNoSecret = errors.New("credential profile has no secretRef")
+
+// ErrDenied is returned by Prepare when a target's namespace may not use the
+// credentials it references.
+var ErrDenied = errors.New("credentials denied")
+
+// Ref is a store target's reference to its credentials. At most one of the
+// secret, Credential and ClusterCredential is expected to be set.
+type Ref struct {
+	// Store is the store type resolving the credentials, checked against the
+	// profile's store.
+	Store string
+	// SecretName and SecretNamespace name a credentials secret directly.
+	SecretName      string
+	SecretNamespace string
+	// Credential is the "<namespace>/<name>" of a StoreCredential.
+	Credential string
+	// ClusterCredential is the name of a ClusterStoreCredential.
+	ClusterCredential string
+}
+
+// IsSet reports whether the reference names any credentials.
+func (r Ref) IsSet() bool {
+	return r.SecretName != "" || r.Credential != "" || r.ClusterCredential != ""
+}
+
+// String describes the credentials the reference names, for messages.
+func (r Ref) String() string {
+	switch {
+	case r.Credential != "":
+		return v1alpha1.StoreCredentialKind + " " + r.Credential
+	case r.ClusterCredential != "":
+		return v1alpha1.ClusterStoreCredentialKind + " " + r.ClusterCredential
+	default:
+		return "secret " + r.SecretNamespace + "/" + r.SecretName
+	}
+}
+
+// TargetRef returns the reference of a target in namespace to its
+// credentials: its credential profile, or else the secret its secret-name
+// names. It is unset for a target using the store's ambient credentials.
+func TargetRef(c tlssecret.GenericSecretSyncConfig, namespace string) Ref {
+	r := Ref{Store: c.Store, ClusterCredential: c.Config[ClusterCredentialKey]}
+	if cred := c.Config[CredentialKey]; cred != "" {
+		if !strings.Contains(cred, "/") {
+			cred = namespace + "/" + cred
+		}
+		r.Credential = cred
+	}
+	if r.IsSet() || c.Config["secret-name"] == "" {
+		return r
+	}
+	k := SecretKey{Name: "secret-name"}
+	keys := secretKeys(c.Store)
+	if i := slices.IndexFunc(keys, func(sk SecretKey) bool { return sk.Name == k.Name }); i >= 0 {
+		k = keys[i]
+	}
+	ref := secretRef(c, k, namespace)
+	r.SecretName, r.SecretNamespace = ref.Name, ref.Namespace
+	return r
+}
+
+// Credentials are the resolved credential values of a target.
+type Credentials struct {
+	// SecretNamespace and SecretName identify the secret the values were
+	// read from.
+	SecretNamespace string
+	SecretName      string
+
+	data map[string][]byte
+	keys map[string]string
+}
+
+// Get returns the value of a store credential key, such as "api_token",
+// reading it from the secret key the profile maps it to. It returns "" when
+// the key is absent.
+func (c *Credentials) Get(key string) string {
+	if k, ok := c.keys[key]; ok && k != "" {
+		key = k
+	}
+	return string(c.data[key])
+}
+
+// Has reports whether a store credential key is present.
+func (c *Credentials) Has(key string) bool {
+	return c.Get(key) != ""
+}
+
+// Require returns an error naming the first key that is absent.
+func (c *Credentials) Require(keys ...string) error {
+	for _, k := range keys {
+		if !c.Has(k) {
+			return fmt.Errorf("%s not found in secret %s/%s", k, c.SecretNamespace, c.SecretName)
+		}
+	}
+	return nil
+}
+
+// getSecret reads a credentials secret. Defined as a var so tests may swap
+// it.
+var getSecret = func(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	return state.KubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// getObject reads a credential profile through the dynamic client. Defined as
+// a var so tests may swap it.
+var getObject = func(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		return state.DynamicClient.Resource(gvr).Get(ctx, name, metav1.GetOptions{})
+	}
+	return state.DynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// profile is a loaded credential profile.
+type profile struct {
+	kind      string
+	namespace string
+	name      string
+	spec      v1alpha1.StoreCredentialSpec
+}
+
+func (p *profile) String() string {
+	if p.namespace == "" {
+		return p.kind + " " + p.name
+	}
+	return p.kind + " " + p.namespace + "/" + p.name
+}
+
+// loadProfile returns the profile the reference names, or nil when it names
+// none. The profile must be for the reference's store.
+func loadProfile(ctx context.Context, r Ref) (*profile, error) {
+	var (
+		p   = &profile{}
+		gvr schema.GroupVersionResource
+	)
+	switch {
+	case r.Credential != "":
+		ns, name, ok := strings.Cut(r.Credential, "/")
+		if !ok || ns == "" || name == "" {
+			return nil, fmt.Errorf("credential %q must be qualified as <namespace>/<name>", r.Credential)
+		}
+		p.kind, p.namespace, p.name, gvr = v1alpha1.StoreCredentialKind, ns, name, v1alpha1.StoreCredentialResource
+	case r.ClusterCredential != "":
+		p.kind, p.name, gvr = v1alpha1.ClusterStoreCredentialKind, r.ClusterCredential, v1alpha1.ClusterStoreCredentialResource
+	default:
+		return nil, nil
+	}
+	u, err := getObject(ctx, gvr, p.namespace, p.name)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", p, err)
+	}
+	spec, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", p, err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &p.spec); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", p, err)
+	}
+	if r.Store != "" && canonicalStore(p.spec.Store) != canonicalStore(r.Store) {
+		return nil, fmt.Errorf("%s is for store %q, not %q", p, p.spec.Store, r.Store)
+	}
+	return p, nil
+}
+
+// canonicalStore maps the deprecated incapsula store name to imperva, which
+// shares its implementation and credentials.
+func canonicalStore(store string) string {
+	if store == string(cmtypes.IncapsulaStoreType) {
+		return string(cmtypes.ImpervaStoreType)
+	}
+	return store
+}
+
+// allows reports whether targets in namespace may use the profile. A
+// ClusterStoreCredential without allowedNamespaces is usable from every
+// namespace only when cross-namespace credentials are not restricted.
+func (p *profile) allows(namespace string) bool {
+	if p.namespace != "" && p.namespace == namespace {
+		return true
+	}
+	if p.namespace == "" && len(p.spec.AllowedNamespaces) == 0 {
+		return !state.RestrictCrossNamespaceCredentials()
+	}
+	return slices.Contains(p.spec.AllowedNamespaces, "*") || slices.Contains(p.spec.AllowedNamespaces, namespace)
+}
+
+// secretRef returns the namespace and name of the profile's secret.
+func (p *profile) secretRef() (string, string, error) {
+	ref := p.spec.SecretRef
+	if ref == nil || ref.Name == "" {
+		return "", "", fmt.Errorf("%s: %w", p, ErrNoSecret)
+	}
+	ns := ref.Namespace
+	if p.namespace != "" {
+		// A namespaced profile must not become a way to read secrets its
+		// author could not read.
+		if ns != "" && ns != p.namespace {
+			return "", "", fmt.Errorf("%s may only reference secrets in namespace %s", p, p.namespace)
+		}
+		ns = p.namespace
+	}
+	if ns == "" {
+		return "", "", fmt.Errorf("%s: secretRef.namespace is required", p)
+	}
+	return ns, ref.Name, nil
+}
+
+// Resolve reads the credentials the reference names. Access to profiles is
+// checked by Prepare, which runs before the store is configured.
+func Resolve(ctx context.Context, r Ref) (*Credentials, error) {
+	p, err := loadProfile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	c := &Credentials{SecretNamespace: r.SecretNamespace, SecretName: r.SecretName}
+	if p != nil {
+		if c.SecretNamespace, c.SecretName, err = p.secretRef(); err != nil {
+			return nil, err
+		}
+		c.keys = p.spec.Keys
+	}
+	if c.SecretName == "" {
+		return nil, errors.New("no credentials configured")
+	}
+	sc, err := getSecret(ctx, c.SecretNamespace, c.SecretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret %s/%s: %w", c.SecretNamespace, c.SecretName, err)
+	}
+	c.data = sc.Data
+	return c, nil
+}
+
+// Prepare returns the target config with its credential profile applied, for
+// a target syncing a secret in namespace, and checks the namespace may use the
+// credentials it references. Denials wrap ErrDenied. c itself is never
+// modified.
+func Prepare(ctx context.Context, c tlssecret.GenericSecretSyncConfig, namespace string) (tlssecret.GenericSecretSyncConfig, error) {
+	out, err := applyProfile(ctx, c, namespace)
+	if err != nil {
+		return c, err
+	}
+	if err := authorize(ctx, out, namespace); err != nil {
+		return c, err
+	}
+	return out, nil
+}
+
+// applyProfile returns the config with its credential profile applied. It
+// fails when the target names more than one of secret-name, a StoreCredential
+// and a ClusterStoreCredential, or a profile that is for another store or does
+// not allow the namespace. The StoreCredential name is qualified with
+// namespace, and the profile's defaults fill in keys the config leaves unset.
+// Configs without a profile are returned unchanged.
+func applyProfile(ctx context.Context, c tlssecret.GenericSecretSyncConfig, namespace string) (tlssecret.GenericSecretSyncConfig, error) {
+	cred, clusterCred := c.Config[CredentialKey], c.Config[ClusterCredentialKey]
+	if cred == "" && clusterCred == "" {
+		return c, nil
+	}
+	if cred != "" && clusterCred != "" {
+		return c, fmt.Errorf("%s and %s are mutually exclusive", CredentialKey, ClusterCredentialKey)
+	}
+	if c.Config["secret-name"] != "" {
+		return c, fmt.Errorf("secret-name cannot be combined with a credential profile")
+	}
+	out := c
+	out.Config = make(map[string]string, len(c.Config))
+	for k, v := range c.Config {
+		out.Config[k] = v
+	}
+	if cred != "" && !strings.Contains(cred, "/") {
+		out.Config[CredentialKey] = namespace + "/" + cred
+	}
+	p, err := loadProfile(ctx, Ref{
+		Store:             c.Store,
+		Credential:        out.Config[CredentialKey],
+		ClusterCredential: clusterCred,
+	})
+	if err != nil {
+		return c, err
+	}
+	if !p.allows(namespace) {
+		return c, fmt.Errorf("%w: %s may not be used from namespace %s", ErrDenied, p, namespace)
+	}
+	for k, v := range p.spec.Defaults {
+		if out.Config[k] == "" {
+			out.Config[k] = v
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,218 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// withObjects stubs the secret and profile lookups with in-memory objects,
+// keyed by "<namespace>/<name>" ("/<name>" for cluster-scoped profiles).
+func withObjects(t *testing.T, secrets map[string]*corev1.Secret, profiles map[string]interface{}) {
+	t.Helper()
+	prevSecret, prevObject := getSecret, getObject
+	getSecret = func(_ context.Context, namespace, name string) (*corev1.Secret, error) {
+		if s, ok := secrets[namespace+"/"+name]; ok {
+			return s, nil
+		}
+		return nil, errors.New("not found")
+	}
+	getObject = func(_ context.Context, _ schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+		p, ok := profiles[namespace+"/"+name]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+		require.NoError(t, err)
+		return &unstructured.Unstructured{Object: obj}, nil
+	}
+	t.Cleanup(func() { getSecret, getObject = prevSecret, prevObject })
+}
+
+func secret(data map[string]string) *corev1.Secret {
+	s := &corev1.Secret{Data: map[string][]byte{}}
+	for k, v := range data {
+		s.Data[k] = []byte(v)
+	}
+	return s
+}
+
+func storeCredential(namespace, name string, spec v1alpha1.StoreCredentialSpec) *v1alpha1.StoreCredential {
+	return &v1alpha1.StoreCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       spec,
+	}
+}
+
+func clusterStoreCredential(name string, spec v1alpha1.StoreCredentialSpec) *v1alpha1.ClusterStoreCredential {
+	return &v1alpha1.ClusterStoreCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	}
+}
+
+func TestResolve_Secret(t *testing.T) {
+	withObjects(t, map[string]*corev1.Secret{
+		"ns/cf": secret(map[string]string{"api_token": "t"}),
+	}, nil)
+	c, err := Resolve(context.Background(), Ref{Store: "cloudflare", SecretName: "cf", SecretNamespace: "ns"})
+	require.NoError(t, err)
+	assert.Equal(t, "t", c.Get("api_token"))
+	assert.NoError(t, c.Require("api_token"))
+	assert.ErrorContains(t, c.Require("api_token", "zone"), "zone not found in secret ns/cf")
+
+	_, err = Resolve(context.Background(), Ref{Store: "cloudflare", SecretName: "missing", SecretNamespace: "ns"})
+	assert.ErrorContains(t, err, "ns/missing")
+}
+
+func TestResolve_ProfileKeyMapping(t *testing.T) {
+	withObjects(t, map[string]*corev1.Secret{
+		"shared/aws": secret(map[string]string{"access-key": "id", "secret-key": "sk"}),
+	}, map[string]interface{}{
+		"/aws": clusterStoreCredential("aws", v1alpha1.StoreCredentialSpec{
+			Store:     "acm",
+			SecretRef: &v1alpha1.SecretReference{Name: "aws", Namespace: "shared"},
+			Keys:      map[string]string{"AWS_ACCESS_KEY_ID": "access-key", "AWS_SECRET_ACCESS_KEY": "secret-key"},
+		}),
+	})
+	c, err := Resolve(context.Background(), Ref{Store: "acm", ClusterCredential: "aws"})
+	require.NoError(t, err)
+	assert.Equal(t, "shared", c.SecretNamespace)
+	assert.Equal(t, "id", c.Get("AWS_ACCESS_KEY_ID"))
+	assert.Equal(t, "sk", c.Get("AWS_SECRET_ACCESS_KEY"))
+}
+
+func TestResolve_ProfileErrors(t *testing.T) {
+	withObjects(t, nil, map[string]interface{}{
+		"team-a/cf": storeCredential("team-a", "cf", v1alpha1.StoreCredentialSpec{
+			Store:     "cloudflare",
+			SecretRef: &v1alpha1.SecretReference{Name: "cf", Namespace: "kube-system"},
+		}),
+		"team-a/defaults": storeCredential("team-a", "defaults", v1alpha1.StoreCredentialSpec{Store: "acm"}),
+		"/cf": clusterStoreCredential("cf", v1alpha1.StoreCredentialSpec{
+			Store:     "cloudflare",
+			SecretRef: &v1alpha1.SecretReference{Name: "cf"},
+		}),
+	})
+	ctx := context.Background()
+
+	_, err := Resolve(ctx, Ref{Store: "heroku", Credential: "team-a/cf"})
+	assert.ErrorContains(t, err, `is for store "cloudflare"`)
+
+	_, err = Resolve(ctx, Ref{Store: "cloudflare", Credential: "team-a/cf"})
+	assert.ErrorContains(t, err, "may only reference secrets in namespace team-a")
+
+	_, err = Resolve(ctx, Ref{Store: "cloudflare", ClusterCredential: "cf"})
+	assert.ErrorContains(t, err, "secretRef.namespace is required")
+
+	_, err = Resolve(ctx, Ref{Store: "acm", Credential: "team-a/defaults"})
+	assert.ErrorIs(t, err, ErrNoSecret)
+
+	_, err = Resolve(ctx, Ref{Store: "cloudflare", Credential: "cf"})
+	assert.ErrorContains(t, err, "must be qualified")
+}
+
+func TestResolve_IncapsulaUsesImpervaProfile(t *testing.T) {
+	withObjects(t, map[string]*corev1.Secret{
+		"ns/imperva": secret(map[string]string{"api_id": "1", "api_key": "k"}),
+	}, map[string]interface{}{
+		"ns/imperva": storeCredential("ns", "imperva", v1alpha1.StoreCredentialSpec{
+			Store:     "incapsula",
+			SecretRef: &v1alpha1.SecretReference{Name: "imperva"},
+		}),
+	})
+	c, err := Resolve(context.Background(), Ref{Store: "imperva", Credential: "ns/imperva"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", c.Get("api_id"))
+}
+
+func TestRef_String(t *testing.T) {
+	assert.Equal(t, "secret ns/cf", Ref{SecretName: "cf", SecretNamespace: "ns"}.String())
+	assert.Equal(t, "StoreCredential ns/cf", Ref{Credential: "ns/cf"}.String())
+	assert.Equal(t, "ClusterStoreCredential cf", Ref{ClusterCredential: "cf"}.String())
+}
+
+func TestTargetRef(t *testing.T) {
+	target := func(config map[string]string) tlssecret.GenericSecretSyncConfig {
+		return tlssecret.GenericSecretSyncConfig{Store: "cloudflare", Config: config}
+	}
+	assert.Equal(t, Ref{Store: "cloudflare", SecretName: "cf", SecretNamespace: "shared"},
+		TargetRef(target(map[string]string{"secret-name": "shared/cf", "pkcs12-password-secret": "p12"}), "ns"))
+	assert.Equal(t, Ref{Store: "cloudflare", Credential: "ns/cf"},
+		TargetRef(target(map[string]string{CredentialKey: "cf"}), "ns"))
+	assert.Equal(t, Ref{Store: "cloudflare", Credential: "team/cf"},
+		TargetRef(target(map[string]string{CredentialKey: "team/cf"}), "ns"))
+	assert.Equal(t, Ref{Store: "cloudflare", ClusterCredential: "cf"},
+		TargetRef(target(map[string]string{ClusterCredentialKey: "cf"}), "ns"))
+	assert.False(t, TargetRef(target(map[string]string{"zone-id": "z"}), "ns").IsSet())
+
+	withSecretKeys(t, SecretKey{Name: "secret-name", Namespace: "secret-namespace"})
+	assert.Equal(t, Ref{Store: "cloudflare", SecretName: "cf", SecretNamespace: "shared"},
+		TargetRef(target(map[string]string{"secret-name": "cf", "secret-namespace": "shared"}), "ns"))
+}
+
+func TestPrepare(t *testing.T) {
+	withObjects(t, nil, map[string]interface{}{
+		"team-a/vault": storeCredential("team-a", "vault", v1alpha1.StoreCredentialSpec{
+			Store:             "vault",
+			Defaults:          map[string]string{"addr": "https://vault", "role": "sync"},
+			AllowedNamespaces: []string{"team-b"},
+		}),
+		"/aws": clusterStoreCredential("aws", v1alpha1.StoreCredentialSpec{
+			Store:             "acm",
+			Defaults:          map[string]string{"region": "eu-west-1"},
+			AllowedNamespaces: []string{"prod"},
+		}),
+		"/open": clusterStoreCredential("open", v1alpha1.StoreCredentialSpec{Store: "acm"}),
+	})
+	ctx := context.Background()
+	vault := func(config map[string]string) tlssecret.GenericSecretSyncConfig {
+		return tlssecret.GenericSecretSyncConfig{Store: "vault", Config: config}
+	}
+
+	in := vault(map[string]string{"credential": "vault", "role": "custom"})
+	got, err := Prepare(ctx, in, "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"credential": "team-a/vault",
+		"addr":       "https://vault",
+		"role":       "custom",
+	}, got.Config, "defaults fill unset keys only")
+	assert.Equal(t, "vault", in.Config["credential"], "the input config is not modified")
+
+	_, err = Prepare(ctx, vault(map[string]string{"credential": "team-a/vault"}), "team-b")
+	assert.NoError(t, err, "an allowed namespace may use the profile")
+	_, err = Prepare(ctx, vault(map[string]string{"credential": "team-a/vault"}), "team-c")
+	assert.ErrorContains(t, err, "may not be used from namespace team-c")
+
+	_, err = Prepare(ctx, tlssecret.GenericSecretSyncConfig{Store: "acm", Config: map[string]string{"cluster-credential": "aws"}}, "dev")
+	assert.ErrorContains(t, err, "may not be used from namespace dev")
+
+	_, err = Prepare(ctx, vault(map[string]string{"credential": "vault", "secret-name": "s"}), "team-a")
+	assert.ErrorContains(t, err, "cannot be combined")
+
+	_, err = Prepare(ctx, vault(map[string]string{"credential": "vault", "cluster-credential": "aws"}), "team-a")
+	assert.ErrorContains(t, err, "mutually exclusive")
+
+	plain := vault(map[string]string{"addr": "https://vault"})
+	got, err = Prepare(ctx, plain, "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	open := tlssecret.GenericSecretSyncConfig{Store: "acm", Config: map[string]string{"cluster-credential": "open"}}
+	_, err = Prepare(ctx, open, "dev")
+	assert.ErrorIs(t, err, ErrDenied, "a ClusterStoreCredential without allowedNamespaces is denied by default")
+	t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", "false")
+	_, err = Prepare(ctx, open, "dev")
+	assert.NoError(t, err, "and allowed everywhere when restriction is off")
+}
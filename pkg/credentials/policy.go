@@ -0,0 +1,91 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SecretKey is a config key naming a credentials secret.
+type SecretKey struct {
+	// Name is the key naming the secret.
+	Name string
+	// Namespace is the key that may set the secret's namespace, or "" when
+	// the store only reads it from a "<namespace>/<name>" value.
+	Namespace string
+}
+
+// StoreSecretKeys returns the secret keys a store type reads, so a target is
+// checked against the secrets its store actually loads. It is set by the
+// package registering the stores, as this one cannot import them. When it is
+// unset or returns nil, secret-name alone is assumed.
+var StoreSecretKeys func(store string) []SecretKey
+
+// defaultSecretKeys are the secret keys of a store StoreSecretKeys does not
+// know.
+var defaultSecretKeys = []SecretKey{{Name: "secret-name"}}
+
+// secretKeys returns the secret keys of store.
+func secretKeys(store string) []SecretKey {
+	if StoreSecretKeys != nil {
+		if keys := StoreSecretKeys(store); keys != nil {
+			return keys
+		}
+	}
+	return defaultSecretKeys
+}
+
+// secretRefs returns the secrets the config names directly, qualified the way
+// its store qualifies them: a "<namespace>/<name>" value wins over the
+// store's namespace key, which wins over the namespace of the synced secret.
+func secretRefs(c tlssecret.GenericSecretSyncConfig, namespace string) []types.NamespacedName {
+	var refs []types.NamespacedName
+	for _, k := range secretKeys(c.Store) {
+		if c.Config[k.Name] != "" {
+			refs = append(refs, secretRef(c, k, namespace))
+		}
+	}
+	return refs
+}
+
+// secretRef returns the secret key k of the config names.
+func secretRef(c tlssecret.GenericSecretSyncConfig, k SecretKey, namespace string) types.NamespacedName {
+	name := c.Config[k.Name]
+	ref := types.NamespacedName{Namespace: namespace, Name: name}
+	if ns := c.Config[k.Namespace]; k.Namespace != "" && ns != "" {
+		ref.Namespace = ns
+	}
+	if ns, n, ok := strings.Cut(name, "/"); ok {
+		ref.Namespace, ref.Name = ns, n
+	}
+	return ref
+}
+
+// authorize checks that a target in namespace may use every credentials
+// secret its config names in another namespace, per state.CredentialsAllowed.
+// Secrets reached through a credential profile are governed by the profile's
+// allowedNamespaces instead.
+func authorize(ctx context.Context, c tlssecret.GenericSecretSyncConfig, namespace string) error {
+	for _, ref := range secretRefs(c, namespace) {
+		if ref.Namespace == namespace {
+			continue
+		}
+		sc, err := getSecret(ctx, ref.Namespace, ref.Name)
+		if apierrors.IsNotFound(err) {
+			// Nothing to protect; the store reports the missing secret.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get credentials secret %s: %w", ref, err)
+		}
+		if !state.CredentialsAllowed(sc, namespace) {
+			return fmt.Errorf("%w: secret %s may not be used from namespace %s; list it in the %s annotation", ErrDenied, ref, namespace, state.AllowedNamespacesAnnotation())
+		}
+	}
+	return nil
+}
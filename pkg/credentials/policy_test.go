@@ -0,0 +1,79 @@
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// withSecretKeys makes every store read the given secret keys.
+func withSecretKeys(t *testing.T, keys ...SecretKey) {
+	t.Helper()
+	prev := StoreSecretKeys
+	StoreSecretKeys = func(string) []SecretKey { return keys }
+	t.Cleanup(func() { StoreSecretKeys = prev })
+}
+
+func TestSecretRefs(t *testing.T) {
+	config := tlssecret.GenericSecretSyncConfig{Config: map[string]string{
+		"secret-name":                      "aws",
+		"secret-namespace":                 "shared",
+		"pkcs12-password-secret":           "other/pkcs12",
+		"pkcs12-password-secret-namespace": "ignored",
+	}}
+	withSecretKeys(t,
+		SecretKey{Name: "secret-name", Namespace: "secret-namespace"},
+		SecretKey{Name: "pkcs12-password-secret", Namespace: "pkcs12-password-secret-namespace"},
+	)
+	assert.Equal(t, []types.NamespacedName{
+		{Namespace: "shared", Name: "aws"},
+		{Namespace: "other", Name: "pkcs12"},
+	}, secretRefs(config, "ns"))
+
+	got := secretRefs(tlssecret.GenericSecretSyncConfig{Config: map[string]string{"secret-name": "aws"}}, "ns")
+	assert.Equal(t, []types.NamespacedName{{Namespace: "ns", Name: "aws"}}, got)
+
+	withSecretKeys(t, SecretKey{Name: "secret-name"})
+	assert.Equal(t, []types.NamespacedName{{Namespace: "ns", Name: "aws"}}, secretRefs(config, "ns"),
+		"a namespace key the store does not read is ignored")
+
+	StoreSecretKeys = nil
+	assert.Equal(t, []types.NamespacedName{{Namespace: "ns", Name: "aws"}}, secretRefs(config, "ns"),
+		"unknown stores read secret-name alone")
+}
+
+func TestPrepare_CrossNamespacePolicy(t *testing.T) {
+	shared := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:        "aws",
+		Namespace:   "shared",
+		Annotations: map[string]string{state.AllowedNamespacesAnnotation(): "team-a"},
+	}}
+	open := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "open", Namespace: "shared"}}
+	withObjects(t, map[string]*corev1.Secret{"shared/aws": shared, "shared/open": open}, nil)
+	ctx := context.Background()
+	acm := func(secretName string) tlssecret.GenericSecretSyncConfig {
+		return tlssecret.GenericSecretSyncConfig{Store: "acm", Config: map[string]string{"secret-name": secretName}}
+	}
+
+	_, err := Prepare(ctx, acm("shared/aws"), "team-a")
+	assert.NoError(t, err, "listed namespaces may use the secret")
+	_, err = Prepare(ctx, acm("shared/aws"), "team-b")
+	require.ErrorIs(t, err, ErrDenied)
+	assert.ErrorContains(t, err, "secret shared/aws may not be used from namespace team-b")
+	_, err = Prepare(ctx, acm("aws"), "team-b")
+	assert.NoError(t, err, "same-namespace references are not checked")
+
+	t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", "")
+	_, err = Prepare(ctx, acm("shared/open"), "team-b")
+	assert.ErrorIs(t, err, ErrDenied, "unannotated secrets are restricted by default")
+	t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", "false")
+	_, err = Prepare(ctx, acm("shared/open"), "team-b")
+	assert.NoError(t, err)
+}
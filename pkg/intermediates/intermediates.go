@@ -0,0 +1,236 @@
+// Package intermediates completes certificate chains that stop short of the
+// root, for issuers that write only the leaf to a secret. Missing issuers are
+// looked up in a bundle of known intermediates and, optionally, downloaded
+// from the CA Issuers URL (AIA) of the certificates themselves.
+package intermediates
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxIntermediates bounds the number of certificates added to a chain.
+	maxIntermediates = 5
+	// maxAIASize bounds the size of a certificate downloaded from an AIA URL.
+	maxAIASize = 1 << 20
+	// aiaTimeout bounds a single AIA download.
+	aiaTimeout = 10 * time.Second
+)
+
+// Completer adds missing intermediates to certificate chains.
+type Completer struct {
+	// Bundle holds the known intermediates.
+	Bundle []*x509.Certificate
+	// FetchAIA enables downloading issuers missing from Bundle from the CA
+	// Issuers URLs of the certificates.
+	FetchAIA bool
+	// Client is used for AIA downloads. nil uses a client with a timeout.
+	Client *http.Client
+	// Roots are the trusted roots a chain may end under without its root.
+	// nil uses the system roots.
+	Roots *x509.CertPool
+}
+
+// Trusted reports whether the chain needs no completion: it ends at a
+// self-signed certificate, or its last certificate is issued by one of roots,
+// nil meaning the system roots. Most chains leave out their root, so ending
+// under a trusted root is as complete as they get.
+func Trusted(ch *tlssecret.Chain, roots *x509.CertPool) bool {
+	return ch.ReachesRoot() || issuedByRoot(ch.Last(), roots)
+}
+
+// issuedByRoot reports whether cert chains directly to one of roots, nil
+// meaning the system roots.
+func issuedByRoot(cert *x509.Certificate, roots *x509.CertPool) bool {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// FromEnv returns a Completer configured from the environment:
+// INTERMEDIATES_BUNDLE is the path of a PEM bundle of known intermediates,
+// INTERMEDIATES_CONFIGMAP a "<namespace>/<name>" ConfigMap whose values are
+// PEM bundles, and INTERMEDIATES_FETCH_AIA=true enables AIA downloads. The
+// bundle and ConfigMap are read on every call, so updates apply to the next
+// sync.
+func FromEnv(ctx context.Context) (*Completer, error) {
+	c := &Completer{FetchAIA: os.Getenv("INTERMEDIATES_FETCH_AIA") == "true"}
+	if path := os.Getenv("INTERMEDIATES_BUNDLE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read intermediates bundle: %w", err)
+		}
+		certs, err := ParseBundle(data)
+		if err != nil {
+			return nil, fmt.Errorf("intermediates bundle %s: %w", path, err)
+		}
+		c.Bundle = append(c.Bundle, certs...)
+	}
+	if ref := os.Getenv("INTERMEDIATES_CONFIGMAP"); ref != "" {
+		ns, name, ok := strings.Cut(ref, "/")
+		if !ok || ns == "" || name == "" {
+			return nil, fmt.Errorf("INTERMEDIATES_CONFIGMAP %q must be <namespace>/<name>", ref)
+		}
+		cm, err := state.KubeClient.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get intermediates ConfigMap %s: %w", ref, err)
+		}
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			certs, err := ParseBundle([]byte(cm.Data[k]))
+			if err != nil {
+				return nil, fmt.Errorf("intermediates ConfigMap %s key %s: %w", ref, k, err)
+			}
+			c.Bundle = append(c.Bundle, certs...)
+		}
+	}
+	return c, nil
+}
+
+// ParseBundle parses the PEM certificates in data. Other PEM blocks are
+// skipped.
+func ParseBundle(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// Complete returns the intermediates missing from the chain of cert, ordered
+// from the issuer of its last certificate up. Nothing is returned for a chain
+// that is Trusted. Roots are never added: completion stops at the first
+// certificate issued by a trusted root, at the first self-signed issuer, or
+// when no issuer is known. The intermediates found before an AIA download
+// fails are returned with the error.
+func (c *Completer) Complete(ctx context.Context, cert *tlssecret.Certificate) ([]*x509.Certificate, error) {
+	ch, err := cert.Chain()
+	if err != nil {
+		return nil, err
+	}
+	if Trusted(ch, c.Roots) {
+		return nil, nil
+	}
+	var added []*x509.Certificate
+	for cur := ch.Last(); len(added) < maxIntermediates; {
+		issuer, err := c.issuer(ctx, cur)
+		if err != nil {
+			return added, err
+		}
+		if issuer == nil || tlssecret.SelfSigned(issuer) {
+			break
+		}
+		added = append(added, issuer)
+		cur = issuer
+		if issuedByRoot(cur, c.Roots) {
+			break
+		}
+	}
+	return added, nil
+}
+
+// issuer returns the certificate that issued cert, from the bundle or an AIA
+// download, or nil when there is none.
+func (c *Completer) issuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, candidate := range c.Bundle {
+		if issued(cert, candidate) {
+			return candidate, nil
+		}
+	}
+	if !c.FetchAIA {
+		return nil, nil
+	}
+	for _, u := range cert.IssuingCertificateURL {
+		candidate, err := c.fetch(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch issuer of %q from %s: %w", cert.Subject, u, err)
+		}
+		if issued(cert, candidate) {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetch downloads the certificate at an AIA URL, which is DER encoded by
+// convention but sometimes PEM.
+func (c *Completer) fetch(ctx context.Context, rawURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, aiaTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: aiaTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxAIASize))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		certs, err := ParseBundle(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificate in response")
+		}
+		return certs[0], nil
+	}
+	return x509.ParseCertificate(data)
+}
+
+// issued reports whether candidate issued cert.
+func issued(cert, candidate *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, candidate.RawSubject) && cert.CheckSignatureFrom(candidate) == nil
+}
@@ -0,0 +1,335 @@
+// Package secretsync resolves SecretSync resources into the sync targets of
+// the secrets they reference and records the outcome of each sync on their
+// status subresource.
+package secretsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretIndex indexes SecretSyncs by the "<namespace>/<secretName>" key of
+// the secret they reference.
+const secretIndex = "secret"
+
+// indexer is the SecretSync informer cache. It is nil until Register is
+// called, which is the case when the CRD is not installed; every lookup then
+// reports no SecretSyncs and secrets are configured through annotations only.
+var indexer cache.Indexer
+
+// statusPatcher is the subset of the dynamic resource API used to write the
+// status subresource.
+type statusPatcher interface {
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error)
+}
+
+// statusClient returns the SecretSync API used to patch status. Defined as a
+// var so tests may swap it.
+var statusClient = func(namespace string) statusPatcher {
+	return state.DynamicClient.Resource(v1alpha1.SecretSyncResource).Namespace(namespace)
+}
+
+// CRDInstalled reports whether the API server serves the SecretSync resource.
+func CRDInstalled(dc discovery.DiscoveryInterface) (bool, error) {
+	rl, err := dc.ServerResourcesForGroupVersion(v1alpha1.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rl.APIResources {
+		if r.Name == v1alpha1.SecretSyncResource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Register adds the secret index to a SecretSync informer and makes its cache
+// the source for ForSecret. It must be called before the informer starts.
+func Register(inf cache.SharedIndexInformer) error {
+	if err := inf.AddIndexers(cache.Indexers{secretIndex: indexBySecret}); err != nil {
+		return fmt.Errorf("add secretsync indexer: %w", err)
+	}
+	indexer = inf.GetIndexer()
+	return nil
+}
+
+// Load lists the SecretSyncs of the cluster once and makes them the source
+// for ForSecret, for commands that run no informer. It returns a func that
+// restores the source it replaced.
+func Load(ctx context.Context) (func(), error) {
+	list, err := state.DynamicClient.Resource(v1alpha1.SecretSyncResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return func() {}, fmt.Errorf("list secretsyncs: %w", err)
+	}
+	idx := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{secretIndex: indexBySecret})
+	for i := range list.Items {
+		if err := idx.Add(&list.Items[i]); err != nil {
+			return func() {}, fmt.Errorf("index secretsync %s: %w", list.Items[i].GetName(), err)
+		}
+	}
+	prev := indexer
+	indexer = idx
+	return func() { indexer = prev }, nil
+}
+
+func indexBySecret(obj interface{}) ([]string, error) {
+	key := SecretKey(obj)
+	if key == "" {
+		return nil, nil
+	}
+	return []string{key}, nil
+}
+
+// SecretKey returns the "<namespace>/<name>" key of the secret referenced by
+// a SecretSync informer object, or "" when it references none.
+func SecretKey(obj interface{}) string {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	name, _, _ := unstructured.NestedString(u.Object, "spec", "secretName")
+	if name == "" {
+		return ""
+	}
+	return u.GetNamespace() + "/" + name
+}
+
+// ForSecret returns the SecretSyncs referencing the secret, sorted by name.
+// Objects that cannot be decoded are logged and skipped.
+func ForSecret(s *corev1.Secret) []*v1alpha1.SecretSync {
+	if indexer == nil || s == nil {
+		return nil
+	}
+	l := log.WithFields(log.Fields{
+		"action":    "secretsync.ForSecret",
+		"namespace": s.Namespace,
+		"name":      s.Name,
+	})
+	objs, err := indexer.ByIndex(secretIndex, s.Namespace+"/"+s.Name)
+	if err != nil {
+		l.WithError(err).Error("index lookup failed")
+		return nil
+	}
+	var out []*v1alpha1.SecretSync
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		ss := &v1alpha1.SecretSync{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, ss); err != nil {
+			l.WithError(err).WithField("secretsync", u.GetName()).Error("decode secretsync")
+			continue
+		}
+		out = append(out, ss)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Watched reports whether the secret is synced through a SecretSync: it is a
+// TLS secret in a watched namespace and at least one SecretSync references it.
+// Unlike annotation-configured secrets it needs no sync-enabled annotation.
+func Watched(s *corev1.Secret) bool {
+	return state.NamespaceWatched(s.Namespace) && state.HasTLSData(s) && len(ForSecret(s)) > 0
+}
+
+// Targets returns the sync targets declared by the SecretSyncs. Each target's
+// config is its spec merged with the outputs recorded in its status, the
+// outputs taking precedence the way annotation targets have store updates
+// written back over their annotations. SecretSyncs with an invalid spec
+// contribute no targets; RecordStatus reports them.
+func Targets(list []*v1alpha1.SecretSync) []*tlssecret.GenericSecretSyncConfig {
+	var out []*tlssecret.GenericSecretSyncConfig
+	for _, ss := range list {
+		if err := ss.Spec.Validate(); err != nil {
+			log.WithFields(log.Fields{
+				"action":     "secretsync.Targets",
+				"namespace":  ss.Namespace,
+				"secretsync": ss.Name,
+			}).WithError(err).Warn("invalid secretsync spec")
+			continue
+		}
+		outputs := make(map[string]map[string]string, len(ss.Status.Targets))
+		for _, ts := range ss.Status.Targets {
+			outputs[ts.Name] = ts.Outputs
+		}
+		for i := range ss.Spec.Targets {
+			t := &ss.Spec.Targets[i]
+			store, config, _ := t.StoreConfig()
+			name := t.TargetName()
+			for k, v := range outputs[name] {
+				config[k] = v
+			}
+			out = append(out, &tlssecret.GenericSecretSyncConfig{
+				Store:      store,
+				Index:      -1,
+				Config:     config,
+				SecretSync: ss.Name,
+				Name:       name,
+			})
+		}
+	}
+	return out
+}
+
+// RecordStatus writes the outcome of a sync round to the status of each
+// SecretSync. pushed are the targets synced successfully in this round, with
+// the Updates their stores reported; targets is the secret's sync state after
+// the round. Status is only patched when it changed, so an unchanged round
+// does not trigger another informer event.
+func RecordStatus(ctx context.Context, list []*v1alpha1.SecretSync, pushed []*tlssecret.GenericSecretSyncConfig, targets state.SyncState) error {
+	byKey := make(map[string]*tlssecret.GenericSecretSyncConfig, len(pushed))
+	for _, sync := range pushed {
+		byKey[sync.Key()] = sync
+	}
+	var errs []error
+	for _, ss := range list {
+		status := buildStatus(ss, byKey, targets, time.Now())
+		if equality.Semantic.DeepEqual(status, &ss.Status) {
+			continue
+		}
+		if err := patchStatus(ctx, ss, status); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("record secretsync status: %v", errs)
+	}
+	return nil
+}
+
+// buildStatus returns the status of ss after a sync round.
+func buildStatus(ss *v1alpha1.SecretSync, pushed map[string]*tlssecret.GenericSecretSyncConfig, targets state.SyncState, now time.Time) *v1alpha1.SecretSyncStatus {
+	status := &v1alpha1.SecretSyncStatus{
+		ObservedGeneration: ss.Generation,
+		Conditions:         slices.Clone(ss.Status.Conditions),
+		Targets:            ss.Status.Targets,
+	}
+	if err := ss.Spec.Validate(); err != nil {
+		apimeta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ss.Generation,
+			Reason:             v1alpha1.ReasonInvalidSpec,
+			Message:            err.Error(),
+		})
+		return status
+	}
+	prev := make(map[string]v1alpha1.TargetStatus, len(ss.Status.Targets))
+	for _, ts := range ss.Status.Targets {
+		prev[ts.Name] = ts
+	}
+	status.Targets = make([]v1alpha1.TargetStatus, 0, len(ss.Spec.Targets))
+	var failed []string
+	for i := range ss.Spec.Targets {
+		t := &ss.Spec.Targets[i]
+		store, _, _ := t.StoreConfig()
+		name := t.TargetName()
+		key := (&tlssecret.GenericSecretSyncConfig{SecretSync: ss.Name, Name: name}).Key()
+		ts := prev[name]
+		ts.Name, ts.Store = name, store
+		if sync := pushed[key]; sync != nil {
+			synced := statusTime(now)
+			ts.LastSyncTime = &synced
+			if len(sync.Updates) > 0 {
+				outputs := make(map[string]string, len(ts.Outputs)+len(sync.Updates))
+				for k, v := range ts.Outputs {
+					outputs[k] = v
+				}
+				for k, v := range sync.Updates {
+					outputs[k] = v
+				}
+				ts.Outputs = outputs
+			}
+		}
+		st := targets[key]
+		ts.Synced = st != nil && !st.Failed() && st.Hash != ""
+		ts.FailedAttempts, ts.NextRetry, ts.LastError = 0, nil, ""
+		if st.Failed() {
+			next := statusTime(st.NextRetry)
+			ts.FailedAttempts, ts.NextRetry, ts.LastError = st.FailedAttempts, &next, st.LastError
+			failed = append(failed, fmt.Sprintf("%s: %s", name, st.LastError))
+		}
+		status.Targets = append(status.Targets, ts)
+	}
+	cond := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: ss.Generation,
+		Reason:             v1alpha1.ReasonSynced,
+		Message:            fmt.Sprintf("%d target%s synced", len(status.Targets), plural(len(status.Targets))),
+	}
+	if len(failed) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = v1alpha1.ReasonSyncFailed
+		cond.Message = fmt.Sprintf("%d of %d targets failed: %s", len(failed), len(status.Targets), strings.Join(failed, "; "))
+	} else if n := pending(status.Targets); n > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = v1alpha1.ReasonSyncPending
+		cond.Message = fmt.Sprintf("%d of %d targets not yet synced", n, len(status.Targets))
+	}
+	apimeta.SetStatusCondition(&status.Conditions, cond)
+	return status
+}
+
+// statusTime truncates t to the second precision status is serialized with,
+// so a status read back from the API compares equal to a freshly built one.
+func statusTime(t time.Time) metav1.Time {
+	return metav1.NewTime(t.Truncate(time.Second))
+}
+
+func pending(targets []v1alpha1.TargetStatus) int {
+	n := 0
+	for _, ts := range targets {
+		if !ts.Synced {
+			n++
+		}
+	}
+	return n
+}
+
+// patchStatus merge-patches the status subresource of ss.
+func patchStatus(ctx context.Context, ss *v1alpha1.SecretSync, status *v1alpha1.SecretSyncStatus) error {
+	pd, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return fmt.Errorf("marshal status patch: %w", err)
+	}
+	if _, err := statusClient(ss.Namespace).Patch(ctx, ss.Name, types.MergePatchType, pd, metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("patch status of secretsync %s/%s: %w", ss.Namespace, ss.Name, err)
+	}
+	return nil
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,242 @@
+package secretsync
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recordingPatcher records status patches instead of sending them.
+type recordingPatcher struct {
+	patches []map[string]interface{}
+}
+
+func (r *recordingPatcher) Patch(_ context.Context, name string, _ types.PatchType, data []byte, _ metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	p["name"] = name
+	p["subresources"] = subresources
+	r.patches = append(r.patches, p)
+	return &unstructured.Unstructured{}, nil
+}
+
+func withRecordingPatcher(t *testing.T) *recordingPatcher {
+	t.Helper()
+	r := &recordingPatcher{}
+	prev := statusClient
+	statusClient = func(string) statusPatcher { return r }
+	t.Cleanup(func() { statusClient = prev })
+	return r
+}
+
+// withIndexer points ForSecret at an in-memory cache holding the given
+// SecretSyncs.
+func withIndexer(t *testing.T, list ...*v1alpha1.SecretSync) {
+	t.Helper()
+	idx := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{secretIndex: indexBySecret})
+	for _, ss := range list {
+		require.NoError(t, idx.Add(toUnstructured(t, ss)))
+	}
+	prev := indexer
+	indexer = idx
+	t.Cleanup(func() { indexer = prev })
+}
+
+func toUnstructured(t *testing.T, ss *v1alpha1.SecretSync) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ss)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func newSecretSync(name, secret string, targets ...v1alpha1.SyncTarget) *v1alpha1.SecretSync {
+	return &v1alpha1.SecretSync{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Generation: 1},
+		Spec:       v1alpha1.SecretSyncSpec{SecretName: secret, Targets: targets},
+	}
+}
+
+func acmTarget() v1alpha1.SyncTarget {
+	return v1alpha1.SyncTarget{ACM: &v1alpha1.ACMTarget{Region: "us-east-1"}}
+}
+
+func tlsSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Data: map[string][]byte{
+			"tls.crt": []byte("cert"),
+			"tls.key": []byte("key"),
+		},
+	}
+}
+
+func TestSecretKey(t *testing.T) {
+	u := toUnstructured(t, newSecretSync("web", "example.com", acmTarget()))
+	assert.Equal(t, "ns/example.com", SecretKey(u))
+	assert.Equal(t, "ns/example.com", SecretKey(cache.DeletedFinalStateUnknown{Key: "ns/web", Obj: u}))
+	assert.Empty(t, SecretKey(toUnstructured(t, newSecretSync("web", ""))))
+	assert.Empty(t, SecretKey(tlsSecret("s")))
+}
+
+func TestForSecret(t *testing.T) {
+	withIndexer(t,
+		newSecretSync("b", "example.com", acmTarget()),
+		newSecretSync("a", "example.com", acmTarget()),
+		newSecretSync("other", "other.com", acmTarget()),
+	)
+	got := ForSecret(tlsSecret("example.com"))
+	require.Len(t, got, 2)
+	assert.Equal(t, "a", got[0].Name)
+	assert.Equal(t, "b", got[1].Name)
+	assert.Empty(t, ForSecret(tlsSecret("unreferenced.com")))
+}
+
+func TestLoad(t *testing.T) {
+	prev := indexer
+	t.Cleanup(func() { indexer = prev })
+	dc := state.DynamicClient
+	t.Cleanup(func() { state.DynamicClient = dc })
+	u := toUnstructured(t, newSecretSync("web", "example.com", acmTarget()))
+	u.SetAPIVersion(v1alpha1.SchemeGroupVersion.String())
+	u.SetKind(v1alpha1.SecretSyncKind)
+	state.DynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.SecretSyncResource: v1alpha1.SecretSyncKind + "List"}, u)
+
+	restore, err := Load(context.Background())
+	require.NoError(t, err)
+	got := ForSecret(tlsSecret("example.com"))
+	require.Len(t, got, 1)
+	assert.Equal(t, "web", got[0].Name)
+
+	restore()
+	assert.True(t, indexer == prev, "the previous source is restored")
+}
+
+func TestForSecret_NotRegistered(t *testing.T) {
+	prev := indexer
+	indexer = nil
+	t.Cleanup(func() { indexer = prev })
+	assert.Nil(t, ForSecret(tlsSecret("example.com")))
+}
+
+func TestWatched(t *testing.T) {
+	t.Setenv("ENABLED_NAMESPACES", "")
+	t.Setenv("DISABLED_NAMESPACES", "")
+	t.Setenv("SECRETS_NAMESPACE", "")
+	withIndexer(t, newSecretSync("web", "example.com", acmTarget()))
+
+	assert.True(t, Watched(tlsSecret("example.com")), "no sync-enabled annotation is needed")
+	assert.False(t, Watched(tlsSecret("unreferenced.com")))
+	noData := tlsSecret("example.com")
+	noData.Data = nil
+	assert.False(t, Watched(noData))
+	t.Setenv("DISABLED_NAMESPACES", "ns")
+	assert.False(t, Watched(tlsSecret("example.com")))
+}
+
+func TestTargets_MergesStatusOutputs(t *testing.T) {
+	ss := newSecretSync("web", "example.com", acmTarget(), v1alpha1.SyncTarget{
+		Name:     "files",
+		Filepath: &v1alpha1.FilepathTarget{Dir: "/certs"},
+	})
+	ss.Status.Targets = []v1alpha1.TargetStatus{
+		{Name: "acm", Store: "acm", Outputs: map[string]string{"certificate-arn": "arn:1"}},
+	}
+	invalid := newSecretSync("invalid", "example.com")
+
+	got := Targets([]*v1alpha1.SecretSync{ss, invalid})
+	require.Len(t, got, 2)
+	assert.Equal(t, "acm", got[0].Store)
+	assert.Equal(t, "secretsync/web/acm", got[0].Key())
+	assert.Equal(t, map[string]string{"region": "us-east-1", "certificate-arn": "arn:1"}, got[0].Config)
+	assert.Equal(t, "filepath", got[1].Store)
+	assert.Equal(t, "secretsync/web/files", got[1].Key())
+}
+
+func TestBuildStatus(t *testing.T) {
+	ss := newSecretSync("web", "example.com", acmTarget(), v1alpha1.SyncTarget{
+		Name:     "files",
+		Filepath: &v1alpha1.FilepathTarget{Dir: "/certs"},
+	})
+	syncs := Targets([]*v1alpha1.SecretSync{ss})
+	syncs[0].Updates = map[string]string{"certificate-arn": "arn:1"}
+	now := time.Now()
+	next := now.Add(time.Minute)
+	targets := state.SyncState{
+		"secretsync/web/acm":   {Hash: "h"},
+		"secretsync/web/files": {FailedAttempts: 2, NextRetry: next, LastError: "disk full"},
+	}
+
+	status := buildStatus(ss, map[string]*tlssecret.GenericSecretSyncConfig{syncs[0].Key(): syncs[0]}, targets, now)
+	assert.Equal(t, int64(1), status.ObservedGeneration)
+	require.Len(t, status.Targets, 2)
+
+	acm := status.Targets[0]
+	assert.True(t, acm.Synced)
+	require.NotNil(t, acm.LastSyncTime)
+	assert.Equal(t, map[string]string{"certificate-arn": "arn:1"}, acm.Outputs)
+
+	files := status.Targets[1]
+	assert.False(t, files.Synced)
+	assert.Nil(t, files.LastSyncTime)
+	assert.Equal(t, 2, files.FailedAttempts)
+	assert.Equal(t, "disk full", files.LastError)
+	require.NotNil(t, files.NextRetry)
+	assert.True(t, files.NextRetry.Time.Equal(next.Truncate(time.Second)))
+
+	ready := apimeta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, v1alpha1.ReasonSyncFailed, ready.Reason)
+	assert.Contains(t, ready.Message, "files: disk full")
+}
+
+func TestBuildStatus_InvalidSpec(t *testing.T) {
+	ss := newSecretSync("web", "example.com")
+	status := buildStatus(ss, nil, nil, time.Now())
+	ready := apimeta.FindStatusCondition(status.Conditions, v1alpha1.ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, v1alpha1.ReasonInvalidSpec, ready.Reason)
+}
+
+func TestRecordStatus_PatchesOnlyOnChange(t *testing.T) {
+	r := withRecordingPatcher(t)
+	ss := newSecretSync("web", "example.com", acmTarget())
+	syncs := Targets([]*v1alpha1.SecretSync{ss})
+	targets := state.SyncState{"secretsync/web/acm": {Hash: "h"}}
+
+	require.NoError(t, RecordStatus(context.Background(), []*v1alpha1.SecretSync{ss}, syncs, targets))
+	require.Len(t, r.patches, 1)
+	assert.Equal(t, "web", r.patches[0]["name"])
+	assert.Equal(t, []string{"status"}, r.patches[0]["subresources"])
+
+	// Feed the recorded status back as the cached object, as the informer
+	// would, and run a round in which nothing was pushed.
+	var applied struct {
+		Status v1alpha1.SecretSyncStatus `json:"status"`
+	}
+	pd, err := json.Marshal(map[string]interface{}{"status": r.patches[0]["status"]})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(pd, &applied))
+	ss.Status = applied.Status
+	require.NoError(t, RecordStatus(context.Background(), []*v1alpha1.SecretSync{ss}, nil, targets))
+	assert.Len(t, r.patches, 1, "unchanged status is not patched again")
+}
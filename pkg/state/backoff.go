@@ -0,0 +1,75 @@
+package state
+
+import (
+	"os"
+	"strings"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BackoffEnv returns the environment variable holding the global value of a
+// backoff setting, such as BACKOFF_BASE for backoff.KeyBase.
+func BackoffEnv(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// BackoffAnnotation returns the annotation key of a per-secret backoff
+// setting.
+func BackoffAnnotation(key string) string {
+	return OperatorName + "/" + key
+}
+
+// globalBackoff returns backoff.Default with the settings of the BACKOFF_*
+// environment variables applied. Invalid values are ignored.
+func globalBackoff() backoff.Policy {
+	p := backoff.Default
+	for _, k := range backoff.Keys {
+		if v := os.Getenv(BackoffEnv(k)); v != "" {
+			if err := p.Set(k, v); err != nil {
+				log.WithError(err).Warnf("ignoring invalid %s", BackoffEnv(k))
+			}
+		}
+	}
+	return p
+}
+
+// SecretBackoff returns the retry policy of a secret's failed syncs and
+// deletes: the global policy, overridden by the secret's backoff-*
+// annotations. Invalid annotation values fall back to the global setting.
+func SecretBackoff(s *corev1.Secret) backoff.Policy {
+	p := globalBackoff()
+	if s == nil {
+		return p
+	}
+	for _, k := range backoff.Keys {
+		if v, ok := s.Annotations[BackoffAnnotation(k)]; ok {
+			if err := p.Set(k, v); err != nil {
+				log.WithFields(log.Fields{
+					"namespace": s.Namespace,
+					"name":      s.Name,
+				}).WithError(err).Warn("ignoring invalid backoff annotation")
+			}
+		}
+	}
+	return p
+}
+
+// TargetBackoff returns the retry policy of a single target of a secret: the
+// secret's policy, overridden by the backoff-* keys of the target's config,
+// such as the acm-backoff-max annotation.
+func TargetBackoff(s *corev1.Secret, config map[string]string) backoff.Policy {
+	p := SecretBackoff(s)
+	for _, k := range backoff.Keys {
+		if v, ok := config[k]; ok {
+			if err := p.Set(k, v); err != nil {
+				log.WithFields(log.Fields{
+					"namespace": s.Namespace,
+					"name":      s.Name,
+				}).WithError(err).Warn("ignoring invalid target backoff setting")
+			}
+		}
+	}
+	return p
+}
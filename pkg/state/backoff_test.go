@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretBackoff(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		annot map[string]string
+		want  backoff.Policy
+	}{
+		{
+			name: "default with no env or annotation",
+			want: backoff.Default,
+		},
+		{
+			name: "global env",
+			env:  map[string]string{"BACKOFF_BASE": "30s", "BACKOFF_JITTER": "0.1"},
+			want: backoff.Policy{Base: 30 * time.Second, Multiplier: 2, Max: 32 * time.Hour, Jitter: 0.1},
+		},
+		{
+			name:  "annotations override env per setting",
+			env:   map[string]string{"BACKOFF_BASE": "30s", "BACKOFF_MAX": "1h"},
+			annot: map[string]string{BackoffAnnotation(backoff.KeyMax): "10m", BackoffAnnotation(backoff.KeyMultiplier): "3"},
+			want:  backoff.Policy{Base: 30 * time.Second, Multiplier: 3, Max: 10 * time.Minute},
+		},
+		{
+			name:  "invalid values fall back",
+			env:   map[string]string{"BACKOFF_MULTIPLIER": "0", "BACKOFF_MAX": "1h"},
+			annot: map[string]string{BackoffAnnotation(backoff.KeyMax): "never"},
+			want:  backoff.Policy{Base: time.Minute, Multiplier: 2, Max: time.Hour},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range backoff.Keys {
+				t.Setenv(BackoffEnv(k), tt.env[BackoffEnv(k)])
+			}
+			s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annot}}
+			assert.Equal(t, tt.want, SecretBackoff(s))
+		})
+	}
+}
+
+func TestTargetBackoff(t *testing.T) {
+	for _, k := range backoff.Keys {
+		t.Setenv(BackoffEnv(k), "")
+	}
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		BackoffAnnotation(backoff.KeyBase):   "10s",
+		BackoffAnnotation(backoff.KeyJitter): "0.2",
+	}}}
+	assert.Equal(t, backoff.Policy{Base: 5 * time.Second, Multiplier: 2, Max: time.Minute, Jitter: 0.2},
+		TargetBackoff(s, map[string]string{backoff.KeyBase: "5s", backoff.KeyMax: "1m", "region": "us-east-1"}))
+	assert.Equal(t, SecretBackoff(s), TargetBackoff(s, nil))
+}
@@ -11,6 +11,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -23,6 +24,7 @@ import (
 var (
 	OperatorName  = "cert-manager-sync.lestak.sh"
 	KubeClient    kubernetes.Interface
+	DynamicClient dynamic.Interface
 	EventRecorder record.EventRecorder
 )
 
@@ -94,10 +96,16 @@ func HashSecret(s *corev1.Secret) string {
 		case OperatorName + "/failed-sync-attempts",
 			OperatorName + "/next-retry",
 			OperatorName + "/delete-attempts",
-			OperatorName + "/next-delete":
+			OperatorName + "/next-delete",
+			SyncStateAnnotation(),
+			SyncStatusAnnotation():
 			delete(annotationsMap, k)
 		}
 	}
+	// COMPLETE_CHAIN changes what is pushed without changing the secret
+	if CompleteChain(s) {
+		annotationsMap["chain/complete"] = true
+	}
 	jd, err = json.Marshal(annotationsMap)
 	if err != nil {
 		l.WithError(err).Errorf("json.Marshal error")
@@ -134,6 +142,19 @@ func cmsHash(s *corev1.Secret) string {
 	return cmsHash
 }
 
+// CacheDisabled reports whether CACHE_DISABLE is set, forcing every
+// reconcile to push to every target regardless of recorded hashes.
+func CacheDisabled() bool {
+	return os.Getenv("CACHE_DISABLE") == "true"
+}
+
+// DryRun reports whether DRY_RUN is set, in which case the operator only
+// reports what it would do to each target, without calling any store or
+// changing any secret.
+func DryRun() bool {
+	return os.Getenv("DRY_RUN") == "true"
+}
+
 func CacheChanged(s *corev1.Secret) bool {
 	l := log.WithFields(
 		log.Fields{
@@ -142,7 +163,7 @@ func CacheChanged(s *corev1.Secret) bool {
 		},
 	)
 	l.Debug("checking cacheChanged")
-	if os.Getenv("CACHE_DISABLE") == "true" {
+	if CacheDisabled() {
 		l.Debug("cache disabled")
 		return true
 	}
@@ -195,6 +216,11 @@ func CreateKubeClient() error {
 		l.Debugf("kubernetes.NewForConfig error=%v", err)
 		return err
 	}
+	DynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		l.Debugf("dynamic.NewForConfig error=%v", err)
+		return err
+	}
 	// Create broadcaster
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: KubeClient.CoreV1().Events("")})
@@ -252,18 +278,25 @@ func SecretWatched(s *corev1.Secret) bool {
 		l.Trace("enabled not true")
 		return false
 	}
-	if namespaceDisabled(s.Namespace) {
-		l.Debug("namespace disabled")
-		return false
-	}
-	if !namespaceEnabled(s.Namespace) {
-		l.Debug("namespace not enabled")
+	if !NamespaceWatched(s.Namespace) {
+		l.Debug("namespace not watched")
 		return false
 	}
-	if len(s.Data["tls.crt"]) == 0 || len(s.Data["tls.key"]) == 0 {
+	if !HasTLSData(s) {
 		l.Debug("skipping secret without tls.crt or tls.key")
 		return false
 	}
 	l.Debug("returning true")
 	return true
 }
+
+// NamespaceWatched reports whether secrets in namespace n may be synced under
+// DISABLED_NAMESPACES, ENABLED_NAMESPACES and SECRETS_NAMESPACE.
+func NamespaceWatched(n string) bool {
+	return !namespaceDisabled(n) && namespaceEnabled(n)
+}
+
+// HasTLSData reports whether the secret holds both a tls.crt and a tls.key.
+func HasTLSData(s *corev1.Secret) bool {
+	return len(s.Data["tls.crt"]) > 0 && len(s.Data["tls.key"]) > 0
+}
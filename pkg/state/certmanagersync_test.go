@@ -92,6 +92,8 @@ func TestHashSecretIgnoresInternalBookkeepingAnnotations(t *testing.T) {
 		"/next-retry",
 		"/delete-attempts",
 		"/next-delete",
+		"/sync-state",
+		syncStatusAnnotationKey,
 	} {
 		t.Run(key, func(t *testing.T) {
 			s := base.DeepCopy()
@@ -0,0 +1,37 @@
+package state
+
+import (
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const completeChainAnnotationKey = "/complete-chain"
+
+// CompleteChainAnnotation returns the annotation key a secret uses to turn
+// chain completion on ("true") or off ("false") for itself.
+func CompleteChainAnnotation() string {
+	return OperatorName + completeChainAnnotationKey
+}
+
+// CompleteChain reports whether missing intermediates should be added to the
+// chain of s before it is synced. The per-secret annotation wins; otherwise
+// COMPLETE_CHAIN=true enables completion for every secret.
+func CompleteChain(s *corev1.Secret) bool {
+	if s != nil {
+		switch s.Annotations[CompleteChainAnnotation()] {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return os.Getenv("COMPLETE_CHAIN") == "true"
+}
+
+// HashSecretTarget returns the HashTarget of a target of s. Turning chain
+// completion on or off changes the certificates the stores receive, so it
+// changes the hash of a secret whose data and config did not.
+func HashSecretTarget(s *corev1.Secret, config map[string]string) string {
+	return hashTarget(s.Data, config, CompleteChain(s))
+}
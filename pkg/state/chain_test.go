@@ -0,0 +1,49 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompleteChain(t *testing.T) {
+	secret := func(v string) *corev1.Secret {
+		s := &corev1.Secret{}
+		if v != "" {
+			s.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{CompleteChainAnnotation(): v}}
+		}
+		return s
+	}
+	tests := []struct {
+		name       string
+		global     string
+		annotation string
+		want       bool
+	}{
+		{"default", "", "", false},
+		{"global", "true", "", true},
+		{"annotation enables", "", "true", true},
+		{"annotation disables", "true", "false", false},
+		{"unknown annotation uses global", "true", "yes", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COMPLETE_CHAIN", tt.global)
+			assert.Equal(t, tt.want, CompleteChain(secret(tt.annotation)))
+		})
+	}
+}
+
+func TestHashSecretTarget(t *testing.T) {
+	t.Setenv("COMPLETE_CHAIN", "")
+	s := &corev1.Secret{Data: map[string][]byte{"tls.crt": []byte("cert")}}
+	cfg := map[string]string{"region": "us-east-1"}
+	assert.Equal(t, HashTarget(s.Data, cfg), HashSecretTarget(s, cfg), "hashes are unchanged without completion")
+	secretHash := HashSecret(s)
+
+	t.Setenv("COMPLETE_CHAIN", "true")
+	assert.NotEqual(t, HashTarget(s.Data, cfg), HashSecretTarget(s, cfg), "turning completion on changes the target hash")
+	assert.NotEqual(t, secretHash, HashSecret(s), "and the secret hash")
+}
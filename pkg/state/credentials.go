@@ -0,0 +1,47 @@
+package state
+
+import (
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const allowedNamespacesAnnotationKey = "/allowed-namespaces"
+
+// AllowedNamespacesAnnotation returns the annotation key a credentials secret
+// uses to list, as csv, the namespaces whose secrets may reference it. "*"
+// allows every namespace.
+func AllowedNamespacesAnnotation() string {
+	return OperatorName + allowedNamespacesAnnotationKey
+}
+
+// RestrictCrossNamespaceCredentials reports whether secrets may only use
+// credentials secrets in other namespaces that list them in the
+// allowed-namespaces annotation. It is the default; set
+// RESTRICT_CROSS_NAMESPACE_CREDENTIALS=false to let unannotated credentials
+// secrets be used from any namespace.
+func RestrictCrossNamespaceCredentials() bool {
+	return os.Getenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS") != "false"
+}
+
+// CredentialsAllowed reports whether a secret in namespace may use the
+// credentials secret cred. Credentials in the same namespace are always
+// allowed. Otherwise the allowed-namespaces annotation decides when present,
+// and RestrictCrossNamespaceCredentials when it is not.
+func CredentialsAllowed(cred *corev1.Secret, namespace string) bool {
+	if cred.Namespace == namespace {
+		return true
+	}
+	v, ok := cred.Annotations[AllowedNamespacesAnnotation()]
+	if !ok {
+		return !RestrictCrossNamespaceCredentials()
+	}
+	for _, n := range strings.Split(v, ",") {
+		n = strings.TrimSpace(n)
+		if n == "*" || n == namespace {
+			return true
+		}
+	}
+	return false
+}
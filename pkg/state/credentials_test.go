@@ -0,0 +1,41 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCredentialsAllowed(t *testing.T) {
+	cred := func(annotations map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        "aws",
+			Namespace:   "shared",
+			Annotations: annotations,
+		}}
+	}
+	tests := []struct {
+		name      string
+		restrict  string
+		cred      *corev1.Secret
+		namespace string
+		want      bool
+	}{
+		{"same namespace", "true", cred(nil), "shared", true},
+		{"unannotated, unrestricted", "false", cred(nil), "team-a", true},
+		{"unannotated, restricted", "true", cred(nil), "team-a", false},
+		{"unannotated, restricted by default", "", cred(nil), "team-a", false},
+		{"listed", "true", cred(map[string]string{AllowedNamespacesAnnotation(): "team-b, team-a"}), "team-a", true},
+		{"not listed", "false", cred(map[string]string{AllowedNamespacesAnnotation(): "team-b"}), "team-a", false},
+		{"empty list", "false", cred(map[string]string{AllowedNamespacesAnnotation(): ""}), "team-a", false},
+		{"wildcard", "true", cred(map[string]string{AllowedNamespacesAnnotation(): "*"}), "team-a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RESTRICT_CROSS_NAMESPACE_CREDENTIALS", tt.restrict)
+			assert.Equal(t, tt.want, CredentialsAllowed(tt.cred, tt.namespace))
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package state
+
+import (
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const driftResyncAnnotationKey = "/drift-resync"
+
+// DriftResyncAnnotation returns the annotation key a secret uses to turn the
+// re-sync of drifted targets on ("true") or off ("false") for itself.
+func DriftResyncAnnotation() string {
+	return OperatorName + driftResyncAnnotationKey
+}
+
+// DriftResync reports whether a target whose remote certificate no longer
+// matches s should be synced again. The per-secret annotation wins; otherwise
+// DRIFT_RESYNC=true enables it for every secret.
+func DriftResync(s *corev1.Secret) bool {
+	if s != nil {
+		switch s.Annotations[DriftResyncAnnotation()] {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return os.Getenv("DRIFT_RESYNC") == "true"
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDriftResync(t *testing.T) {
+	secret := func(v string) *corev1.Secret {
+		s := &corev1.Secret{}
+		if v != "" {
+			s.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{DriftResyncAnnotation(): v}}
+		}
+		return s
+	}
+	tests := []struct {
+		name       string
+		global     string
+		annotation string
+		want       bool
+	}{
+		{"default", "", "", false},
+		{"global", "true", "", true},
+		{"annotation enables", "", "true", true},
+		{"annotation disables", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DRIFT_RESYNC", tt.global)
+			assert.Equal(t, tt.want, DriftResync(secret(tt.annotation)))
+		})
+	}
+}
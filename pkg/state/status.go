@@ -0,0 +1,72 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const syncStatusAnnotationKey = "/sync-status"
+
+// SyncStatusAnnotation returns the annotation key holding the sync status of
+// a secret.
+func SyncStatusAnnotation() string {
+	return OperatorName + syncStatusAnnotationKey
+}
+
+// Status is the machine-readable outcome of the syncs of a secret, kept for
+// users and tooling. Unlike SyncState it is never read back to decide what to
+// sync.
+type Status struct {
+	// Conditions holds the Ready condition.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Targets maps a target key to its status.
+	Targets map[string]*TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus is the observed state of a single target.
+type TargetStatus struct {
+	Store string `json:"store"`
+	// Synced is true when the store holds the current certificate.
+	Synced          bool      `json:"synced"`
+	LastAttemptTime time.Time `json:"lastAttemptTime,omitzero"`
+	LastSuccessTime time.Time `json:"lastSuccessTime,omitzero"`
+	// RemoteID identifies the remote certificate, such as its ARN or ID.
+	RemoteID string `json:"remoteId,omitempty"`
+	// Serial and NotAfter describe the certificate last synced.
+	Serial    string    `json:"serial,omitempty"`
+	NotAfter  time.Time `json:"notAfter,omitzero"`
+	LastError string    `json:"lastError,omitempty"`
+	// ErrorCategory is the kind of the last error, such as auth or transient.
+	ErrorCategory string `json:"errorCategory,omitempty"`
+}
+
+// GetStatus parses the sync-status annotation of a secret. An absent or
+// unparseable annotation yields an empty status.
+func GetStatus(s *corev1.Secret) Status {
+	var st Status
+	if s == nil || s.Annotations[SyncStatusAnnotation()] == "" {
+		return st
+	}
+	if err := json.Unmarshal([]byte(s.Annotations[SyncStatusAnnotation()]), &st); err != nil {
+		log.WithFields(log.Fields{
+			"action":    "GetStatus",
+			"namespace": s.Namespace,
+			"name":      s.Name,
+		}).WithError(err).Warn("ignoring unparseable sync-status annotation")
+		return Status{}
+	}
+	return st
+}
+
+// Marshal returns the annotation value for the status.
+func (st Status) Marshal() (string, error) {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
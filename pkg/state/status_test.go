@@ -0,0 +1,44 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetStatus_Unparseable(t *testing.T) {
+	assert.Empty(t, GetStatus(&corev1.Secret{}))
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{SyncStatusAnnotation(): "{not json"},
+	}}
+	assert.Empty(t, GetStatus(s))
+}
+
+func TestStatus_RoundTrip(t *testing.T) {
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := Status{
+		Conditions: []metav1.Condition{{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Synced", LastTransitionTime: metav1.NewTime(at)}},
+		Targets: map[string]*TargetStatus{
+			"acm":          {Store: "acm", Synced: true, LastAttemptTime: at, LastSuccessTime: at, RemoteID: "arn", Serial: "1f", NotAfter: at},
+			"cloudflare.2": {Store: "cloudflare", LastAttemptTime: at, LastError: "denied"},
+		},
+	}
+	v, err := in.Marshal()
+	require.NoError(t, err)
+	assert.NotContains(t, v, `"lastSuccessTime":"0001`, "zero times are omitted")
+
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{SyncStatusAnnotation(): v},
+	}}
+	out := GetStatus(s)
+	require.Len(t, out.Conditions, 1)
+	assert.Equal(t, "Synced", out.Conditions[0].Reason)
+	assert.Equal(t, "arn", out.Targets["acm"].RemoteID)
+	assert.True(t, at.Equal(out.Targets["acm"].NotAfter))
+	assert.False(t, out.Targets["cloudflare.2"].Synced)
+	assert.Equal(t, "denied", out.Targets["cloudflare.2"].LastError)
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const syncStateAnnotationKey = "/sync-state"
+
+// SyncStateAnnotation returns the annotation key holding the per-target sync
+// state of a secret.
+func SyncStateAnnotation() string {
+	return OperatorName + syncStateAnnotationKey
+}
+
+// TargetState is the sync bookkeeping for a single remote target, i.e. one
+// store at one index. Hash is the target hash as of the last successful sync;
+// a target whose current hash matches and which has no outstanding failures
+// does not need to be pushed again. FailedHash is the target hash the failed
+// attempts were counted against, and ErrorCategory the syncerrors category of
+// the last failure.
+type TargetState struct {
+	Hash           string    `json:"hash,omitempty"`
+	FailedAttempts int       `json:"failedAttempts,omitempty"`
+	NextRetry      time.Time `json:"nextRetry,omitzero"`
+	LastError      string    `json:"lastError,omitempty"`
+	FailedHash     string    `json:"failedHash,omitempty"`
+	ErrorCategory  string    `json:"errorCategory,omitempty"`
+}
+
+// Failed reports whether the target's last sync attempt failed.
+func (t *TargetState) Failed() bool {
+	return t != nil && t.FailedAttempts > 0
+}
+
+// SyncState maps a target key (see tlssecret.GenericSecretSyncConfig.Key) to
+// its state.
+type SyncState map[string]*TargetState
+
+// GetSyncState parses the sync-state annotation of a secret. The second
+// return value is false when the annotation is absent or cannot be parsed, in
+// which case the caller should treat every target as never synced.
+func GetSyncState(s *corev1.Secret) (SyncState, bool) {
+	st := SyncState{}
+	if s == nil || s.Annotations == nil {
+		return st, false
+	}
+	v, ok := s.Annotations[SyncStateAnnotation()]
+	if !ok || v == "" {
+		return st, false
+	}
+	if err := json.Unmarshal([]byte(v), &st); err != nil {
+		log.WithFields(log.Fields{
+			"action":    "GetSyncState",
+			"namespace": s.Namespace,
+			"name":      s.Name,
+		}).WithError(err).Warn("ignoring unparseable sync-state annotation")
+		return SyncState{}, false
+	}
+	return st, true
+}
+
+// Marshal returns the annotation value for the sync state.
+func (st SyncState) Marshal() (string, error) {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// HashTarget returns a deterministic hash of the secret data and a single
+// target's effective config. It changes when either the certificate material
+// or that target's configuration changes, and is unaffected by the config of
+// any other target.
+func HashTarget(data map[string][]byte, config map[string]string) string {
+	return hashTarget(data, config, false)
+}
+
+// hashTarget is HashTarget, marked when the chain is completed before the
+// target is synced. Unmarked hashes are unchanged from HashTarget.
+func hashTarget(data map[string][]byte, config map[string]string, completeChain bool) string {
+	m := make(map[string]any, len(data)+len(config)+1)
+	for k, v := range data {
+		m["data/"+k] = v
+	}
+	for k, v := range config {
+		m["config/"+k] = v
+	}
+	if completeChain {
+		m["chain/complete"] = true
+	}
+	h, err := hashMapValues(m)
+	if err != nil {
+		log.WithError(err).Error("hashMapValues error")
+		return ""
+	}
+	return h
+}
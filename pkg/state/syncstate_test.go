@@ -0,0 +1,59 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSyncState_Absent(t *testing.T) {
+	st, ok := GetSyncState(&corev1.Secret{})
+	assert.False(t, ok)
+	assert.Empty(t, st)
+}
+
+func TestGetSyncState_Unparseable(t *testing.T) {
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{SyncStateAnnotation(): "{not json"},
+	}}
+	st, ok := GetSyncState(s)
+	assert.False(t, ok)
+	assert.Empty(t, st)
+}
+
+func TestSyncState_RoundTrip(t *testing.T) {
+	next := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := SyncState{
+		"acm":     {Hash: "abc"},
+		"vault.1": {FailedAttempts: 2, NextRetry: next, LastError: "denied"},
+	}
+	v, err := in.Marshal()
+	require.NoError(t, err)
+	assert.NotContains(t, v, `"nextRetry":"0001`, "zero times are omitted")
+
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{SyncStateAnnotation(): v},
+	}}
+	out, ok := GetSyncState(s)
+	require.True(t, ok)
+	assert.Equal(t, "abc", out["acm"].Hash)
+	assert.False(t, out["acm"].Failed())
+	assert.True(t, out["vault.1"].Failed())
+	assert.True(t, next.Equal(out["vault.1"].NextRetry))
+	assert.Equal(t, "denied", out["vault.1"].LastError)
+}
+
+func TestHashTarget(t *testing.T) {
+	data := map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")}
+	cfg := map[string]string{"region": "us-east-1"}
+	h := HashTarget(data, cfg)
+	assert.NotEmpty(t, h)
+	assert.Equal(t, h, HashTarget(data, map[string]string{"region": "us-east-1"}), "hash is deterministic")
+	assert.NotEqual(t, h, HashTarget(data, map[string]string{"region": "us-west-2"}), "config change changes hash")
+	assert.NotEqual(t, h, HashTarget(map[string][]byte{"tls.crt": []byte("new"), "tls.key": []byte("key")}, cfg), "data change changes hash")
+	assert.NotEqual(t, HashTarget(map[string][]byte{"x": []byte("y")}, nil), HashTarget(nil, map[string]string{"x": "y"}), "data and config keys do not collide")
+}
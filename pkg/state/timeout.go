@@ -0,0 +1,68 @@
+package state
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultSyncTimeout bounds a single store call when neither SYNC_TIMEOUT nor
+// the sync-timeout annotation is set.
+const DefaultSyncTimeout = 5 * time.Minute
+
+const syncTimeoutAnnotationKey = "/sync-timeout"
+
+// SyncTimeoutAnnotation returns the annotation key used for the per-secret
+// store call timeout.
+func SyncTimeoutAnnotation() string {
+	return OperatorName + syncTimeoutAnnotationKey
+}
+
+// parseTimeout parses a positive Go duration, returning false when v is empty
+// or invalid.
+func parseTimeout(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// globalSyncTimeout reads SYNC_TIMEOUT, falling back to DefaultSyncTimeout
+// when it is unset or invalid.
+func globalSyncTimeout() time.Duration {
+	v := os.Getenv("SYNC_TIMEOUT")
+	d, ok := parseTimeout(v)
+	if !ok {
+		if v != "" {
+			log.WithField("value", v).Warn("ignoring invalid SYNC_TIMEOUT")
+		}
+		return DefaultSyncTimeout
+	}
+	return d
+}
+
+// SyncTimeout returns how long a single store call (FromConfig plus Sync or
+// Delete) may run for the secret before its context is cancelled. The
+// per-secret annotation wins; invalid annotation values fall back to the
+// global SYNC_TIMEOUT.
+func SyncTimeout(s *corev1.Secret) time.Duration {
+	if s != nil && s.Annotations != nil {
+		if v, ok := s.Annotations[SyncTimeoutAnnotation()]; ok {
+			if d, ok := parseTimeout(v); ok {
+				return d
+			}
+			log.WithFields(log.Fields{
+				"namespace": s.Namespace,
+				"name":      s.Name,
+				"value":     v,
+			}).Warn("ignoring invalid sync-timeout annotation")
+		}
+	}
+	return globalSyncTimeout()
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		annot    map[string]string
+		want     time.Duration
+	}{
+		{
+			name: "default with no env or annotation",
+			want: DefaultSyncTimeout,
+		},
+		{
+			name:     "global env",
+			envValue: "90s",
+			want:     90 * time.Second,
+		},
+		{
+			name:     "invalid env falls back to default",
+			envValue: "soon",
+			want:     DefaultSyncTimeout,
+		},
+		{
+			name:     "non-positive env falls back to default",
+			envValue: "0s",
+			want:     DefaultSyncTimeout,
+		},
+		{
+			name:     "annotation overrides env",
+			envValue: "90s",
+			annot:    map[string]string{SyncTimeoutAnnotation(): "10m"},
+			want:     10 * time.Minute,
+		},
+		{
+			name:     "invalid annotation falls back to env",
+			envValue: "90s",
+			annot:    map[string]string{SyncTimeoutAnnotation(): "-1m"},
+			want:     90 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SYNC_TIMEOUT", tt.envValue)
+			s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annot}}
+			assert.Equal(t, tt.want, SyncTimeout(s))
+		})
+	}
+}
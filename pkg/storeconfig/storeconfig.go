@@ -0,0 +1,276 @@
+// Package storeconfig declares the configuration keys each store reads from a
+// sync target, so a target can be checked before any provider is called and
+// tooling such as the validating webhook can describe a store's settings.
+package storeconfig
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+)
+
+// Type is the type of a key's value.
+type Type string
+
+const (
+	// String accepts any value.
+	String Type = "string"
+	// Bool accepts "true" or "false", in any case.
+	Bool Type = "bool"
+	// Int accepts a base-10 integer.
+	Int Type = "int"
+)
+
+// Key describes a single configuration key of a store.
+type Key struct {
+	// Name is the key as it appears after "<store>-" in an annotation.
+	Name string
+	// Type is the type of the value. The zero value is String.
+	Type Type
+	// Required keys must be set for the store to sync.
+	Required bool
+	// Default is the value the store uses when the key is unset, if any.
+	Default string
+	// SecretRef marks a key naming a Kubernetes secret that holds
+	// credentials. A required SecretRef is satisfied by a credential profile.
+	// Its namespace may be set by a key of the same name with "-name"
+	// replaced by, or else followed by, "-namespace", when the store declares
+	// one.
+	SecretRef bool
+	// Output marks a key the store writes back after a sync, such as the ID
+	// of the remote certificate.
+	Output bool
+	// Endpoint marks a key selecting where the store's calls go, such as a
+	// region or a server address.
+	Endpoint bool
+	// Prefix marks Name as the prefix of a family of keys, such as "label-".
+	Prefix bool
+	// Description is a short, human-readable explanation of the key.
+	Description string
+}
+
+// typ returns the key's type, defaulting to String.
+func (k Key) typ() Type {
+	if k.Type == "" {
+		return String
+	}
+	return k.Type
+}
+
+// check returns an error when value is not valid for the key's type.
+func (k Key) check(value string) error {
+	switch k.typ() {
+	case Bool:
+		if !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+	case Int:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+	}
+	return nil
+}
+
+// Common are the keys accepted by every store.
+var Common = []Key{
+	{Name: "enabled", Type: Bool, Description: `Set to "false" to disable the target.`},
+	{Name: credentials.CredentialKey, Description: "StoreCredential providing the target's credentials and defaults."},
+	{Name: credentials.ClusterCredentialKey, Description: "ClusterStoreCredential providing the target's credentials and defaults."},
+	{Name: backoff.KeyBase, Description: "Delay before the first retry of a failed sync, such as 30s."},
+	{Name: backoff.KeyMultiplier, Description: "Factor each further retry delay is multiplied by."},
+	{Name: backoff.KeyMax, Description: "Longest delay between retries, such as 1h."},
+	{Name: backoff.KeyJitter, Description: "Fraction each retry delay is randomized by, between 0 and 1."},
+}
+
+// Schema is the set of keys a store reads.
+type Schema struct {
+	// Store is the store type the schema describes.
+	Store string
+	// Keys are the store's own keys, in addition to Common.
+	Keys []Key
+}
+
+// Lookup returns the key matching name, including Common keys and the
+// families of Prefix keys.
+func (s *Schema) Lookup(name string) (Key, bool) {
+	for _, k := range slices.Concat(Common, s.Keys) {
+		if !k.Prefix && k.Name == name {
+			return k, true
+		}
+		// A bare prefix is not a key of its family.
+		if k.Prefix && strings.HasPrefix(name, k.Name) && len(name) > len(k.Name) {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Names returns the names of every key the store accepts.
+func (s *Schema) Names() []string {
+	var names []string
+	for _, k := range slices.Concat(Common, s.Keys) {
+		names = append(names, k.Name)
+	}
+	return names
+}
+
+// Unknown returns the keys of config the store does not read, sorted.
+func (s *Schema) Unknown(config map[string]string) []string {
+	var unknown []string
+	for name := range config {
+		if _, ok := s.Lookup(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// SecretKeys returns the store's SecretRef keys, each with the key that sets
+// its namespace if the store declares one.
+func (s *Schema) SecretKeys() []credentials.SecretKey {
+	var keys []credentials.SecretKey
+	for _, k := range s.Keys {
+		if !k.SecretRef {
+			continue
+		}
+		sk := credentials.SecretKey{Name: k.Name}
+		if ns := strings.TrimSuffix(k.Name, "-name") + "-namespace"; slices.ContainsFunc(s.Keys, func(k Key) bool { return k.Name == ns }) {
+			sk.Namespace = ns
+		}
+		keys = append(keys, sk)
+	}
+	return keys
+}
+
+// Endpoint returns the values of the endpoint keys of config, defaulted and
+// joined with commas, or "" when the store has none.
+func (s *Schema) Endpoint(config map[string]string) string {
+	var values []string
+	for _, k := range s.Keys {
+		if !k.Endpoint {
+			continue
+		}
+		v := config[k.Name]
+		if v == "" {
+			v = k.Default
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, ",")
+}
+
+// Problem is a single problem with a target's configuration.
+type Problem struct {
+	// Key is the config key the problem is about.
+	Key string
+	// Message describes the problem.
+	Message string
+	// Missing is set when a required key is unset.
+	Missing bool
+}
+
+func (p Problem) String() string {
+	return p.Key + ": " + p.Message
+}
+
+// Error is returned by Validate for an invalid target configuration.
+type Error struct {
+	Store    string
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return fmt.Sprintf("invalid %s configuration: %s", e.Store, strings.Join(msgs, "; "))
+}
+
+// Problems returns the set values that are invalid for their key's type and
+// the required keys config lacks, in the schema's key order. Unknown keys are
+// reported by Unknown instead.
+func (s *Schema) Problems(config map[string]string) []Problem {
+	var problems []Problem
+	profile := config[credentials.CredentialKey] != "" || config[credentials.ClusterCredentialKey] != ""
+	for _, k := range slices.Concat(Common, s.Keys) {
+		if k.Prefix {
+			for _, name := range sortedKeys(config) {
+				if strings.HasPrefix(name, k.Name) {
+					if err := k.check(config[name]); err != nil {
+						problems = append(problems, Problem{Key: name, Message: err.Error()})
+					}
+				}
+			}
+			continue
+		}
+		v, ok := config[k.Name]
+		switch {
+		case ok && v != "":
+			if err := k.check(v); err != nil {
+				problems = append(problems, Problem{Key: k.Name, Message: err.Error()})
+			}
+		case k.Required && k.Default == "" && !(k.SecretRef && profile):
+			problems = append(problems, Problem{Key: k.Name, Message: "required", Missing: true})
+		}
+	}
+	return problems
+}
+
+// Validate returns an *Error listing the Problems with config, or nil.
+func (s *Schema) Validate(config map[string]string) error {
+	if problems := s.Problems(config); len(problems) > 0 {
+		return &Error{Store: s.Store, Problems: problems}
+	}
+	return nil
+}
+
+// Verify checks the schema itself is well formed: keys are named, unique and
+// typed, and defaults are valid for their type. It is run at startup so a
+// broken schema fails fast rather than on the first sync.
+func (s *Schema) Verify() error {
+	if s.Store == "" {
+		return errors.New("schema has no store")
+	}
+	seen := map[string]bool{}
+	for _, k := range slices.Concat(Common, s.Keys) {
+		if k.Name == "" {
+			return fmt.Errorf("%s schema: key has no name", s.Store)
+		}
+		if seen[k.Name] {
+			return fmt.Errorf("%s schema: duplicate key %q", s.Store, k.Name)
+		}
+		seen[k.Name] = true
+		switch k.typ() {
+		case String, Bool, Int:
+		default:
+			return fmt.Errorf("%s schema: key %q has unknown type %q", s.Store, k.Name, k.Type)
+		}
+		if k.Default != "" {
+			if err := k.check(k.Default); err != nil {
+				return fmt.Errorf("%s schema: key %q default %w", s.Store, k.Name, err)
+			}
+		}
+		if k.Required && k.Output {
+			return fmt.Errorf("%s schema: output key %q cannot be required", s.Store, k.Name)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
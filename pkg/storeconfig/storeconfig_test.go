@@ -0,0 +1,134 @@
+package storeconfig
+
+import (
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSchema = Schema{
+	Store: "test",
+	Keys: []Key{
+		{Name: "zone", Required: true},
+		{Name: "secret-name", Required: true, SecretRef: true},
+		{Name: "file", Required: true, Default: "tls.crt"},
+		{Name: "id", Type: Int, Output: true},
+		{Name: "label-", Prefix: true},
+	},
+}
+
+func TestSchema_Lookup(t *testing.T) {
+	k, ok := testSchema.Lookup("zone")
+	require.True(t, ok)
+	assert.True(t, k.Required)
+
+	_, ok = testSchema.Lookup("enabled")
+	assert.True(t, ok, "common keys are accepted by every store")
+
+	k, ok = testSchema.Lookup("label-team")
+	require.True(t, ok)
+	assert.Equal(t, "label-", k.Name)
+
+	_, ok = testSchema.Lookup("label-")
+	assert.False(t, ok, "a prefix alone is not a key")
+	_, ok = testSchema.Lookup("zones")
+	assert.False(t, ok)
+}
+
+func TestSchema_SecretKeys(t *testing.T) {
+	assert.Equal(t, []credentials.SecretKey{{Name: "secret-name"}}, testSchema.SecretKeys())
+	s := Schema{Keys: []Key{
+		{Name: "secret-name", SecretRef: true},
+		{Name: "secret-namespace"},
+		{Name: "password-secret", SecretRef: true},
+		{Name: "password-secret-namespace"},
+	}}
+	assert.Equal(t, []credentials.SecretKey{
+		{Name: "secret-name", Namespace: "secret-namespace"},
+		{Name: "password-secret", Namespace: "password-secret-namespace"},
+	}, s.SecretKeys())
+}
+
+func TestSchema_Endpoint(t *testing.T) {
+	assert.Empty(t, testSchema.Endpoint(map[string]string{"zone": "z"}))
+	s := Schema{Keys: []Key{
+		{Name: "region", Endpoint: true},
+		{Name: "addr", Endpoint: true, Default: "https://api"},
+		{Name: "path"},
+	}}
+	assert.Equal(t, "eu-west-1,https://api", s.Endpoint(map[string]string{"region": "eu-west-1", "path": "p"}))
+	assert.Equal(t, ",https://other", s.Endpoint(map[string]string{"addr": "https://other"}))
+}
+
+func TestSchema_Unknown(t *testing.T) {
+	assert.Equal(t, []string{"nope", "zones"}, testSchema.Unknown(map[string]string{
+		"zone": "z", "zones": "z", "nope": "x", "label-a": "b", "credential": "c",
+	}))
+	assert.Empty(t, testSchema.Unknown(map[string]string{"zone": "z"}))
+}
+
+func TestSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   []Problem
+	}{
+		{
+			name:   "valid",
+			config: map[string]string{"zone": "z", "secret-name": "s", "id": "42", "enabled": "True"},
+		},
+		{
+			name:   "missing required keys",
+			config: map[string]string{},
+			want: []Problem{
+				{Key: "zone", Message: "required", Missing: true},
+				{Key: "secret-name", Message: "required", Missing: true},
+			},
+		},
+		{
+			name:   "a credential profile satisfies secret refs",
+			config: map[string]string{"zone": "z", "cluster-credential": "cf"},
+		},
+		{
+			name:   "invalid values",
+			config: map[string]string{"zone": "z", "secret-name": "s", "id": "abc", "enabled": "yes"},
+			want: []Problem{
+				{Key: "enabled", Message: `must be true or false, got "yes"`},
+				{Key: "id", Message: `must be an integer, got "abc"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, testSchema.Problems(tt.config))
+			err := testSchema.Validate(tt.config)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *Error
+			require.ErrorAs(t, err, &verr)
+			assert.Equal(t, tt.want, verr.Problems)
+		})
+	}
+	assert.EqualError(t, testSchema.Validate(map[string]string{"secret-name": "s"}), "invalid test configuration: zone: required")
+}
+
+func TestSchema_Verify(t *testing.T) {
+	require.NoError(t, testSchema.Verify())
+
+	bad := []Schema{
+		{},
+		{Store: "x", Keys: []Key{{Name: ""}}},
+		{Store: "x", Keys: []Key{{Name: "a"}, {Name: "a"}}},
+		{Store: "x", Keys: []Key{{Name: "enabled"}}},
+		{Store: "x", Keys: []Key{{Name: "a", Type: "float"}}},
+		{Store: "x", Keys: []Key{{Name: "a", Type: Bool, Default: "yes"}}},
+		{Store: "x", Keys: []Key{{Name: "a", Required: true, Output: true}}},
+	}
+	for _, s := range bad {
+		assert.Error(t, s.Verify(), "%+v", s)
+	}
+}
@@ -0,0 +1,334 @@
+// Package storeguard protects remote stores from the operator: calls to a
+// store, per credential, share a token-bucket rate limit and a circuit
+// breaker, so a store that is down or throttling is not hammered by every
+// secret that targets it.
+package storeguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// ErrDeferred is matched by the errors of calls the guard did not admit.
+var ErrDeferred = errors.New("store call deferred")
+
+// Reasons a call is deferred.
+const (
+	ReasonCircuitOpen = "circuit_open"
+	ReasonRateLimited = "rate_limited"
+)
+
+// Key identifies the calls that share a rate limit and a circuit: those to a
+// store type at the same endpoint with the same credentials. Endpoint is the
+// region or address the calls go to, empty for stores with a single one.
+// Credential is empty for targets using the store's ambient credentials.
+type Key struct {
+	Store      string
+	Endpoint   string
+	Credential string
+}
+
+// DeferredError is returned by Acquire for a call that was not admitted. The
+// call should be retried at Until; it did not reach the store and is not a
+// failure of the target.
+type DeferredError struct {
+	Key    Key
+	Reason string
+	Until  time.Time
+	// Err is the error of the context that ended while the call waited for
+	// the rate limit, if any.
+	Err error
+}
+
+func (e *DeferredError) Error() string {
+	msg := fmt.Sprintf("store %s %s, deferred until %s", e.Key.Store, reasonText[e.Reason], e.Until.UTC().Format(time.RFC3339))
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is makes DeferredError match ErrDeferred.
+func (e *DeferredError) Is(target error) bool {
+	return target == ErrDeferred
+}
+
+// Unwrap returns the error of the context that ended the wait, if any.
+func (e *DeferredError) Unwrap() error {
+	return e.Err
+}
+
+var reasonText = map[string]string{
+	ReasonCircuitOpen: "is unavailable",
+	ReasonRateLimited: "is rate limited",
+}
+
+// State is the state of a circuit.
+type State int
+
+const (
+	// Closed circuits admit every call.
+	Closed State = iota
+	// HalfOpen circuits admit a single probe call, whose outcome closes or
+	// reopens the circuit.
+	HalfOpen
+	// Open circuits admit no call until Config.OpenDuration has passed.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "closed"
+}
+
+// probeWait is how long calls are deferred while the probe of a half-open
+// circuit is in flight.
+const probeWait = 15 * time.Second
+
+// Config configures a Guard.
+type Config struct {
+	// Rate is the number of calls per second admitted for each key; zero
+	// admits calls without limit.
+	Rate float64
+	// Burst is the number of calls admitted at once before Rate applies.
+	Burst int
+	// FailureThreshold is the number of consecutive failed calls that opens
+	// a circuit; zero disables the circuit breaker.
+	FailureThreshold int
+	// OpenDuration is how long an open circuit rejects calls before it lets
+	// a probe through.
+	OpenDuration time.Duration
+}
+
+// DefaultConfig does not rate limit calls, and opens a circuit for a minute
+// after 5 consecutive failures.
+var DefaultConfig = Config{
+	Burst:            5,
+	FailureThreshold: 5,
+	OpenDuration:     time.Minute,
+}
+
+// ConfigFromEnv returns DefaultConfig with the settings of the environment
+// applied: STORE_RATE_LIMIT (calls per second), STORE_RATE_BURST,
+// CIRCUIT_BREAKER_THRESHOLD and CIRCUIT_BREAKER_OPEN_DURATION. Invalid values
+// are ignored.
+func ConfigFromEnv() Config {
+	c := DefaultConfig
+	if v := os.Getenv("STORE_RATE_LIMIT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			c.Rate = f
+		} else {
+			log.WithField("value", v).Warn("ignoring invalid STORE_RATE_LIMIT")
+		}
+	}
+	if v := os.Getenv("STORE_RATE_BURST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			c.Burst = n
+		} else {
+			log.WithField("value", v).Warn("ignoring invalid STORE_RATE_BURST")
+		}
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			c.FailureThreshold = n
+		} else {
+			log.WithField("value", v).Warn("ignoring invalid CIRCUIT_BREAKER_THRESHOLD")
+		}
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_OPEN_DURATION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			c.OpenDuration = d
+		} else {
+			log.WithField("value", v).Warn("ignoring invalid CIRCUIT_BREAKER_OPEN_DURATION")
+		}
+	}
+	return c
+}
+
+// Guard admits calls to stores. It is safe for concurrent use.
+type Guard struct {
+	cfg Config
+	// OnStateChange, when set, is called with the new state of a circuit
+	// whenever it changes. It is called with the guard's lock held and must
+	// not call the guard.
+	OnStateChange func(Key, State)
+
+	mu      sync.Mutex
+	entries map[Key]*entry
+	now     func() time.Time
+}
+
+// entry is the limiter and circuit of a key.
+type entry struct {
+	limiter  *rate.Limiter
+	state    State
+	failures int
+	// until is when an open circuit lets a probe through.
+	until time.Time
+	// probing is set while the probe of a half-open circuit is in flight.
+	probing bool
+}
+
+// New returns a Guard with every circuit closed.
+func New(cfg Config) *Guard {
+	return &Guard{cfg: cfg, entries: make(map[Key]*entry), now: time.Now}
+}
+
+// Acquire waits until a call for key may be made, and must be followed by
+// Done once the call completes. It returns a *DeferredError, without waiting,
+// when the key's circuit is open, or when the rate limit would delay the call
+// past ctx's deadline; and a *DeferredError wrapping ctx's error when ctx ends
+// while waiting.
+func (g *Guard) Acquire(ctx context.Context, key Key) error {
+	g.mu.Lock()
+	e := g.entry(key)
+	if err := g.admit(key, e); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.mu.Unlock()
+	if e.limiter == nil {
+		return nil
+	}
+	now := g.now()
+	r := e.limiter.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && delay > time.Until(deadline) {
+		r.CancelAt(now)
+		g.abort(key)
+		return &DeferredError{Key: key, Reason: ReasonRateLimited, Until: now.Add(delay)}
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		g.abort(key)
+		return &DeferredError{Key: key, Reason: ReasonRateLimited, Until: now.Add(delay), Err: ctx.Err()}
+	}
+}
+
+// Done records the outcome of a call admitted by Acquire. failed reports
+// whether the call failed in a way that suggests the store is unavailable,
+// such as a timeout or a server error; a call rejected for its input or
+// credentials proves the store is up and should be reported as not failed.
+func (g *Guard) Done(key Key, failed bool) {
+	if g.cfg.FailureThreshold <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := g.entry(key)
+	if !failed {
+		e.failures = 0
+		e.probing = false
+		g.setState(key, e, Closed)
+		return
+	}
+	e.failures++
+	switch {
+	case e.state == HalfOpen:
+		g.open(key, e)
+	case e.state == Closed && e.failures >= g.cfg.FailureThreshold:
+		g.open(key, e)
+	}
+}
+
+// State returns the state of key's circuit.
+func (g *Guard) State(key Key) State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.entries[key]; ok {
+		return e.state
+	}
+	return Closed
+}
+
+// entry returns the entry of key, creating it. g.mu must be held.
+func (g *Guard) entry(key Key) *entry {
+	e, ok := g.entries[key]
+	if !ok {
+		e = &entry{}
+		if g.cfg.Rate > 0 {
+			e.limiter = rate.NewLimiter(rate.Limit(g.cfg.Rate), max(g.cfg.Burst, 1))
+		}
+		g.entries[key] = e
+	}
+	return e
+}
+
+// admit checks key's circuit, letting the first call after an open circuit's
+// OpenDuration through as the probe. g.mu must be held.
+func (g *Guard) admit(key Key, e *entry) error {
+	if g.cfg.FailureThreshold <= 0 {
+		return nil
+	}
+	now := g.now()
+	switch e.state {
+	case Open:
+		if now.Before(e.until) {
+			return &DeferredError{Key: key, Reason: ReasonCircuitOpen, Until: e.until}
+		}
+		g.setState(key, e, HalfOpen)
+		e.probing = true
+	case HalfOpen:
+		if e.probing {
+			return &DeferredError{Key: key, Reason: ReasonCircuitOpen, Until: now.Add(probeWait)}
+		}
+		e.probing = true
+	}
+	return nil
+}
+
+// abort releases the probe of key's half-open circuit when the call admitted
+// as the probe was not made.
+func (g *Guard) abort(key Key) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e := g.entry(key); e.state == HalfOpen {
+		e.probing = false
+	}
+}
+
+// open opens key's circuit for OpenDuration. g.mu must be held.
+func (g *Guard) open(key Key, e *entry) {
+	e.until = g.now().Add(g.cfg.OpenDuration)
+	e.probing = false
+	g.setState(key, e, Open)
+	log.WithFields(log.Fields{
+		"store":      key.Store,
+		"endpoint":   key.Endpoint,
+		"credential": key.Credential,
+		"failures":   e.failures,
+		"until":      e.until,
+	}).Warn("store circuit opened")
+}
+
+// setState moves key's circuit to s. g.mu must be held.
+func (g *Guard) setState(key Key, e *entry, s State) {
+	if e.state == s {
+		return
+	}
+	e.state = s
+	if g.OnStateChange != nil {
+		g.OnStateChange(key, s)
+	}
+}
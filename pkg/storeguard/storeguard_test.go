@@ -0,0 +1,141 @@
+package storeguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGuard returns a guard whose clock is advanced by the returned func.
+func newTestGuard(cfg Config) (*Guard, func(time.Duration)) {
+	g := New(cfg)
+	now := time.Unix(1000, 0)
+	g.now = func() time.Time { return now }
+	return g, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestGuard_CircuitBreaker(t *testing.T) {
+	g, advance := newTestGuard(Config{FailureThreshold: 3, OpenDuration: time.Minute})
+	var states []State
+	g.OnStateChange = func(_ Key, s State) { states = append(states, s) }
+	key := Key{Store: "cloudflare", Credential: "secret ns/cf"}
+	ctx := context.Background()
+
+	for range 3 {
+		require.NoError(t, g.Acquire(ctx, key))
+		g.Done(key, true)
+	}
+	assert.Equal(t, Open, g.State(key))
+	err := g.Acquire(ctx, key)
+	require.ErrorIs(t, err, ErrDeferred)
+	var de *DeferredError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, ReasonCircuitOpen, de.Reason)
+	assert.Equal(t, time.Unix(1060, 0), de.Until)
+	assert.Equal(t, Closed, g.State(Key{Store: "cloudflare"}), "other credentials are not affected")
+	require.NoError(t, g.Acquire(ctx, Key{Store: "cloudflare"}))
+
+	advance(time.Minute)
+	require.NoError(t, g.Acquire(ctx, key), "a probe is let through")
+	assert.Equal(t, HalfOpen, g.State(key))
+	assert.ErrorIs(t, g.Acquire(ctx, key), ErrDeferred, "only one probe at a time")
+	g.Done(key, true)
+	assert.Equal(t, Open, g.State(key), "a failed probe reopens the circuit")
+
+	advance(time.Minute)
+	require.NoError(t, g.Acquire(ctx, key))
+	g.Done(key, false)
+	assert.Equal(t, Closed, g.State(key))
+	assert.Equal(t, []State{Open, HalfOpen, Open, HalfOpen, Closed}, states)
+}
+
+func TestGuard_SuccessResetsFailures(t *testing.T) {
+	g, _ := newTestGuard(Config{FailureThreshold: 2, OpenDuration: time.Minute})
+	key := Key{Store: "imperva"}
+	for _, failed := range []bool{true, false, true, false, true} {
+		require.NoError(t, g.Acquire(context.Background(), key))
+		g.Done(key, failed)
+	}
+	assert.Equal(t, Closed, g.State(key))
+}
+
+func TestGuard_Disabled(t *testing.T) {
+	g, _ := newTestGuard(Config{})
+	key := Key{Store: "acm"}
+	for range 20 {
+		require.NoError(t, g.Acquire(context.Background(), key))
+		g.Done(key, true)
+	}
+	assert.Equal(t, Closed, g.State(key))
+}
+
+func TestGuard_RateLimit(t *testing.T) {
+	g := New(Config{Rate: 1, Burst: 2})
+	key := Key{Store: "cloudflare"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, g.Acquire(ctx, key))
+	require.NoError(t, g.Acquire(ctx, key))
+	err := g.Acquire(ctx, key)
+	require.ErrorIs(t, err, ErrDeferred, "the wait would outlast the deadline")
+	var de *DeferredError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, ReasonRateLimited, de.Reason)
+	assert.WithinDuration(t, time.Now().Add(time.Second), de.Until, 100*time.Millisecond)
+
+	fast := New(Config{Rate: 50, Burst: 1})
+	require.NoError(t, fast.Acquire(ctx, key))
+	start := time.Now()
+	require.NoError(t, fast.Acquire(ctx, key), "short waits are absorbed")
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestGuard_RateLimitCancelled(t *testing.T) {
+	g := New(Config{Rate: 1, Burst: 1})
+	key := Key{Store: "cloudflare"}
+	require.NoError(t, g.Acquire(context.Background(), key))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := g.Acquire(ctx, key)
+	require.ErrorIs(t, err, ErrDeferred, "a cancelled wait is deferred")
+	assert.ErrorIs(t, err, context.Canceled)
+	var de *DeferredError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, ReasonRateLimited, de.Reason)
+}
+
+func TestGuard_RateLimitReleasesProbe(t *testing.T) {
+	g, advance := newTestGuard(Config{Rate: 0.001, Burst: 1, FailureThreshold: 1, OpenDuration: time.Minute})
+	key := Key{Store: "cloudflare"}
+	require.NoError(t, g.Acquire(context.Background(), key))
+	g.Done(key, true)
+	advance(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.ErrorIs(t, g.Acquire(ctx, key), ErrDeferred)
+	assert.False(t, g.entries[key].probing, "the deferred probe does not block the next one")
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("STORE_RATE_LIMIT", "")
+	t.Setenv("STORE_RATE_BURST", "")
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "")
+	t.Setenv("CIRCUIT_BREAKER_OPEN_DURATION", "")
+	assert.Equal(t, DefaultConfig, ConfigFromEnv())
+
+	t.Setenv("STORE_RATE_LIMIT", "2.5")
+	t.Setenv("STORE_RATE_BURST", "10")
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "0")
+	t.Setenv("CIRCUIT_BREAKER_OPEN_DURATION", "5m")
+	assert.Equal(t, Config{Rate: 2.5, Burst: 10, OpenDuration: 5 * time.Minute}, ConfigFromEnv())
+
+	t.Setenv("STORE_RATE_LIMIT", "-1")
+	t.Setenv("STORE_RATE_BURST", "0")
+	t.Setenv("CIRCUIT_BREAKER_THRESHOLD", "many")
+	t.Setenv("CIRCUIT_BREAKER_OPEN_DURATION", "0s")
+	assert.Equal(t, DefaultConfig, ConfigFromEnv(), "invalid values are ignored")
+}
@@ -0,0 +1,110 @@
+// Package syncerrors classifies the errors stores return, so the operator can
+// tell a failure worth retrying soon from one that needs a human.
+package syncerrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Category is the kind of a store error.
+type Category string
+
+const (
+	// Transient errors, such as a 503 or a timeout, are expected to clear on
+	// their own and are retried soon.
+	Transient Category = "transient"
+	// Auth errors mean the store's credentials were rejected or lack a
+	// permission.
+	Auth Category = "auth"
+	// InvalidInput errors mean the store rejected the certificate or the
+	// target's config, such as an unsupported key type.
+	InvalidInput Category = "invalid_input"
+	// Quota errors mean a rate limit or a resource limit of the account was
+	// reached.
+	Quota Category = "quota"
+	// NotFound errors mean a resource the target refers to, such as a zone or
+	// a recorded certificate, does not exist.
+	NotFound Category = "not_found"
+	// Unknown is the category of errors no store classified.
+	Unknown Category = "unknown"
+)
+
+// Categories lists every category.
+var Categories = []Category{Transient, Auth, InvalidInput, Quota, NotFound, Unknown}
+
+// Permanent reports whether errors of the category are not retried until the
+// certificate or the target's config changes: retrying with the same input
+// would fail the same way.
+func (c Category) Permanent() bool {
+	switch c {
+	case Auth, InvalidInput, NotFound:
+		return true
+	}
+	return false
+}
+
+// Error is an error classified into a Category. Its message is that of Err,
+// so classifying an error does not change what users see.
+type Error struct {
+	Category Category
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New classifies err as c. A nil err yields nil, and an err already
+// classified keeps its category.
+func New(c Category, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Category: c, Err: err}
+}
+
+// Classify returns the category of err, or Unknown when it was not
+// classified.
+func Classify(err error) Category {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return Unknown
+}
+
+// HTTPCategory returns the category of an HTTP response status, or Unknown
+// for statuses that do not tell.
+func HTTPCategory(status int) Category {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return Auth
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusTooManyRequests:
+		return Quota
+	case status == http.StatusRequestTimeout, status >= 500:
+		return Transient
+	case status == http.StatusBadRequest, status == http.StatusConflict, status == http.StatusRequestEntityTooLarge, status == http.StatusUnprocessableEntity:
+		return InvalidInput
+	}
+	return Unknown
+}
+
+// FromHTTPStatus classifies err by the HTTP status of the response it came
+// with. Errors of statuses that do not tell are returned as they are.
+func FromHTTPStatus(status int, err error) error {
+	if c := HTTPCategory(status); c != Unknown {
+		return New(c, err)
+	}
+	return err
+}
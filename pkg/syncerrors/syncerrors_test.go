@@ -0,0 +1,47 @@
+package syncerrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAndClassify(t *testing.T) {
+	base := errors.New("denied")
+	err := fmt.Errorf("import: %w", New(Auth, base))
+	assert.Equal(t, Auth, Classify(err))
+	assert.ErrorIs(t, err, base)
+	assert.EqualError(t, err, "import: denied")
+
+	assert.Equal(t, Auth, Classify(New(Transient, err)), "the first classification wins")
+	assert.Nil(t, New(Quota, nil))
+	assert.Equal(t, Unknown, Classify(base))
+	assert.Equal(t, Unknown, Classify(nil))
+}
+
+func TestPermanent(t *testing.T) {
+	for _, c := range Categories {
+		assert.Equal(t, c == Auth || c == InvalidInput || c == NotFound, c.Permanent(), c)
+	}
+}
+
+func TestFromHTTPStatus(t *testing.T) {
+	base := errors.New("failed")
+	for status, want := range map[int]Category{
+		400: InvalidInput,
+		401: Auth,
+		403: Auth,
+		404: NotFound,
+		408: Transient,
+		422: InvalidInput,
+		429: Quota,
+		500: Transient,
+		503: Transient,
+		302: Unknown,
+	} {
+		assert.Equal(t, want, Classify(FromHTTPStatus(status, base)), status)
+	}
+	assert.Same(t, base, FromHTTPStatus(302, base))
+}
@@ -0,0 +1,205 @@
+package tlssecret
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"slices"
+)
+
+// RootOption selects whether the self-signed root is part of a chain sent to a
+// store. Most providers accept either; some reject a chain holding the root.
+type RootOption bool
+
+const (
+	// IncludeRoot keeps the root at the end of the chain, if there is one.
+	IncludeRoot RootOption = true
+	// ExcludeRoot leaves the root out of the chain.
+	ExcludeRoot RootOption = false
+)
+
+// Chain is the certificate chain of a Certificate, from the leaf to the root.
+type Chain struct {
+	// Leaf is the first certificate of tls.crt.
+	Leaf *x509.Certificate
+	// Intermediates are the issuers between the leaf and the root, each one
+	// issued by the next.
+	Intermediates []*x509.Certificate
+	// Root is the self-signed certificate ending the chain, or nil when the
+	// chain does not reach one or the leaf is itself self-signed.
+	Root *x509.Certificate
+}
+
+// Chain parses tls.crt and ca.crt into a Chain. The leaf is the first
+// certificate of tls.crt; the rest of the chain is built by following issuers
+// through the remaining certificates of both keys, whatever their order.
+// Repeated certificates, and certificates that did not issue the chain, are
+// dropped.
+func (c *Certificate) Chain() (*Chain, error) {
+	ch, _, err := c.chain()
+	return ch, err
+}
+
+// chain builds the Chain of c, also returning the certificates of tls.crt and
+// ca.crt it left out for not issuing the chain.
+func (c *Certificate) chain() (*Chain, []*x509.Certificate, error) {
+	certs, err := parseCertificates("tls.crt", c.Certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(c.Ca) > 0 {
+		ca, err := parseCertificates("ca.crt", c.Ca)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, ca...)
+	}
+	ch := &Chain{Leaf: certs[0]}
+	var pool []*x509.Certificate
+	for _, cert := range certs[1:] {
+		if !cert.Equal(ch.Leaf) && !slices.ContainsFunc(pool, cert.Equal) {
+			pool = append(pool, cert)
+		}
+	}
+	cur := ch.Leaf
+	for !SelfSigned(cur) {
+		i := issuerOf(cur, pool)
+		if i < 0 {
+			break
+		}
+		cur = pool[i]
+		pool = slices.Delete(pool, i, i+1)
+		if SelfSigned(cur) {
+			ch.Root = cur
+			break
+		}
+		ch.Intermediates = append(ch.Intermediates, cur)
+	}
+	return ch, pool, nil
+}
+
+// Leaf returns the parsed leaf certificate.
+func (c *Certificate) Leaf() (*x509.Certificate, error) {
+	ch, err := c.Chain()
+	if err != nil {
+		return nil, err
+	}
+	return ch.Leaf, nil
+}
+
+// Intermediates returns the parsed intermediates, ordered from the leaf's
+// issuer towards the root.
+func (c *Certificate) Intermediates() ([]*x509.Certificate, error) {
+	ch, err := c.Chain()
+	if err != nil {
+		return nil, err
+	}
+	return ch.Intermediates, nil
+}
+
+// Root returns the parsed root certificate, or nil when the chain does not
+// reach one.
+func (c *Certificate) Root() (*x509.Certificate, error) {
+	ch, err := c.Chain()
+	if err != nil {
+		return nil, err
+	}
+	return ch.Root, nil
+}
+
+// Certificates returns the chain from the leaf, optionally ending with the
+// root.
+func (ch *Chain) Certificates(root RootOption) []*x509.Certificate {
+	return append([]*x509.Certificate{ch.Leaf}, ch.Issuers(root)...)
+}
+
+// Issuers returns the chain without the leaf, as providers that take the leaf
+// and its chain in separate fields expect.
+func (ch *Chain) Issuers(root RootOption) []*x509.Certificate {
+	issuers := append([]*x509.Certificate(nil), ch.Intermediates...)
+	if root == IncludeRoot && ch.Root != nil {
+		issuers = append(issuers, ch.Root)
+	}
+	return issuers
+}
+
+// Last returns the last certificate of the chain: the root if there is one,
+// otherwise the last intermediate or the leaf.
+func (ch *Chain) Last() *x509.Certificate {
+	certs := ch.Certificates(IncludeRoot)
+	return certs[len(certs)-1]
+}
+
+// ReachesRoot reports whether the chain ends at a self-signed certificate, so
+// no issuer is missing from it.
+func (ch *Chain) ReachesRoot() bool {
+	return SelfSigned(ch.Last())
+}
+
+// CompleteCA adds the issuers certs to ca.crt and rewrites it as the rest of
+// the chain in leaf-to-root order, so tls.crt followed by ca.crt stays in
+// order even when ca.crt already held the root. Certificates of tls.crt are
+// not repeated, and certificates of ca.crt that did not issue the chain are
+// kept at its end. It builds a new slice, so Certificates shared with other
+// goroutines are not written to.
+func (c *Certificate) CompleteCA(certs ...*x509.Certificate) error {
+	crt, err := parseCertificates("tls.crt", c.Certificate)
+	if err != nil {
+		return err
+	}
+	completed := &Certificate{Certificate: c.Certificate, Ca: append(EncodePEM(certs...), c.Ca...)}
+	ch, unused, err := completed.chain()
+	if err != nil {
+		return err
+	}
+	var ca []*x509.Certificate
+	for _, cert := range ch.Issuers(IncludeRoot) {
+		if !slices.ContainsFunc(crt, cert.Equal) {
+			ca = append(ca, cert)
+		}
+	}
+	c.Ca = EncodePEM(append(ca, unused...)...)
+	return nil
+}
+
+// LeafPEM returns the PEM encoded leaf.
+func (ch *Chain) LeafPEM() []byte {
+	return EncodePEM(ch.Leaf)
+}
+
+// PEM returns the PEM encoded chain from the leaf.
+func (ch *Chain) PEM(root RootOption) []byte {
+	return EncodePEM(ch.Certificates(root)...)
+}
+
+// IssuersPEM returns the PEM encoded chain without the leaf. It is empty when
+// the leaf has no issuers to send.
+func (ch *Chain) IssuersPEM(root RootOption) []byte {
+	return EncodePEM(ch.Issuers(root)...)
+}
+
+// EncodePEM returns certs as concatenated PEM CERTIFICATE blocks.
+func EncodePEM(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// SelfSigned reports whether cert is signed by its own key.
+func SelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// issuerOf returns the index of the certificate in pool that signed cert, or
+// -1 when there is none.
+func issuerOf(cert *x509.Certificate, pool []*x509.Certificate) int {
+	for i, p := range pool {
+		if bytes.Equal(cert.RawIssuer, p.RawSubject) && cert.CheckSignatureFrom(p) == nil {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,199 @@
+package tlssecret
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subjects(certs []*x509.Certificate) []string {
+	names := []string{}
+	for _, c := range certs {
+		names = append(names, c.Subject.CommonName)
+	}
+	return names
+}
+
+func TestCertificate_Chain(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+	stray := issue(t, "stray", true, nil)
+	self := issue(t, "self", false, nil)
+
+	tests := []struct {
+		name     string
+		cert     Certificate
+		wantInts []string
+		wantRoot string
+	}{
+		{
+			name:     "leaf and intermediate with root CA",
+			cert:     Certificate{Certificate: concat(leaf.pem, inter.pem), Ca: root.pem},
+			wantInts: []string{"intermediate"},
+			wantRoot: "root",
+		},
+		{
+			name:     "intermediate repeated in CA",
+			cert:     Certificate{Certificate: concat(leaf.pem, inter.pem), Ca: concat(inter.pem, root.pem)},
+			wantInts: []string{"intermediate"},
+			wantRoot: "root",
+		},
+		{
+			name:     "out of order",
+			cert:     Certificate{Certificate: concat(leaf.pem, root.pem, inter.pem)},
+			wantInts: []string{"intermediate"},
+			wantRoot: "root",
+		},
+		{
+			name:     "unrelated certificate dropped",
+			cert:     Certificate{Certificate: leaf.pem, Ca: concat(stray.pem, inter.pem, root.pem)},
+			wantInts: []string{"intermediate"},
+			wantRoot: "root",
+		},
+		{
+			name:     "no root",
+			cert:     Certificate{Certificate: concat(leaf.pem, inter.pem)},
+			wantInts: []string{"intermediate"},
+		},
+		{
+			name:     "leaf only",
+			cert:     Certificate{Certificate: leaf.pem},
+			wantInts: []string{},
+		},
+		{
+			name:     "self-signed leaf",
+			cert:     Certificate{Certificate: self.pem, Ca: self.pem},
+			wantInts: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := tt.cert.Chain()
+			require.NoError(t, err)
+			certs, err := parseCertificates("tls.crt", tt.cert.Certificate)
+			require.NoError(t, err)
+			assert.True(t, ch.Leaf.Equal(certs[0]))
+			assert.Equal(t, tt.wantInts, subjects(ch.Intermediates))
+			if tt.wantRoot == "" {
+				assert.Nil(t, ch.Root)
+			} else {
+				require.NotNil(t, ch.Root)
+				assert.Equal(t, tt.wantRoot, ch.Root.Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestCertificate_ChainInvalid(t *testing.T) {
+	_, err := (&Certificate{Certificate: []byte("leaf")}).Chain()
+	assert.ErrorIs(t, err, ErrInvalidCertificate)
+
+	leaf := issue(t, "leaf", false, nil)
+	_, err = (&Certificate{Certificate: leaf.pem, Ca: []byte("ca")}).Chain()
+	assert.ErrorContains(t, err, "ca.crt: data is not PEM encoded")
+}
+
+func TestChain_PEM(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+
+	c := &Certificate{Certificate: concat(leaf.pem, inter.pem, root.pem), Ca: concat(root.pem, inter.pem)}
+	ch, err := c.Chain()
+	require.NoError(t, err)
+
+	assert.Equal(t, leaf.pem, ch.LeafPEM())
+	assert.Equal(t, concat(leaf.pem, inter.pem, root.pem), ch.PEM(IncludeRoot))
+	assert.Equal(t, concat(leaf.pem, inter.pem), ch.PEM(ExcludeRoot))
+	assert.Equal(t, concat(inter.pem, root.pem), ch.IssuersPEM(IncludeRoot))
+	assert.Equal(t, inter.pem, ch.IssuersPEM(ExcludeRoot))
+
+	self := &Certificate{Certificate: root.pem}
+	ch, err = self.Chain()
+	require.NoError(t, err)
+	assert.Empty(t, ch.IssuersPEM(IncludeRoot))
+	assert.Equal(t, root.pem, ch.PEM(IncludeRoot))
+}
+
+func TestCertificate_LeafIntermediatesRoot(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+	c := &Certificate{Certificate: concat(leaf.pem, inter.pem), Ca: root.pem}
+
+	got, err := c.Leaf()
+	require.NoError(t, err)
+	assert.Equal(t, "leaf", got.Subject.CommonName)
+
+	ints, err := c.Intermediates()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"intermediate"}, subjects(ints))
+
+	r, err := c.Root()
+	require.NoError(t, err)
+	assert.Equal(t, "root", r.Subject.CommonName)
+}
+
+func TestChain_ReachesRoot(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+
+	tests := []struct {
+		name     string
+		cert     Certificate
+		wantLast string
+		want     bool
+	}{
+		{"complete", Certificate{Certificate: leaf.pem, Ca: concat(inter.pem, root.pem)}, "root", true},
+		{"missing root", Certificate{Certificate: concat(leaf.pem, inter.pem)}, "intermediate", false},
+		{"leaf only", Certificate{Certificate: leaf.pem}, "leaf", false},
+		{"self-signed", Certificate{Certificate: root.pem}, "root", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := tt.cert.Chain()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLast, ch.Last().Subject.CommonName)
+			assert.Equal(t, tt.want, ch.ReachesRoot())
+		})
+	}
+}
+
+func TestCertificate_CompleteCA(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter1 := issue(t, "intermediate 1", true, root)
+	inter2 := issue(t, "intermediate 2", true, inter1)
+	leaf := issue(t, "leaf", false, inter2)
+	other := issue(t, "other", true, nil)
+
+	tests := []struct {
+		name  string
+		cert  Certificate
+		added []*x509.Certificate
+		want  []byte
+	}{
+		{"empty CA", Certificate{Certificate: leaf.pem}, []*x509.Certificate{inter2.cert, inter1.cert}, concat(inter2.pem, inter1.pem)},
+		{"root-only CA", Certificate{Certificate: leaf.pem, Ca: root.pem}, []*x509.Certificate{inter2.cert, inter1.cert}, concat(inter2.pem, inter1.pem, root.pem)},
+		{"intermediate in tls.crt", Certificate{Certificate: concat(leaf.pem, inter2.pem), Ca: root.pem}, []*x509.Certificate{inter1.cert}, concat(inter1.pem, root.pem)},
+		{"unrelated CA", Certificate{Certificate: leaf.pem, Ca: other.pem}, []*x509.Certificate{inter2.cert}, concat(inter2.pem, other.pem)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.cert.CompleteCA(tt.added...))
+			assert.Equal(t, tt.want, tt.cert.Ca)
+		})
+	}
+
+	ca := make([]byte, 0, 4096)
+	ca = append(ca, root.pem...)
+	c := &Certificate{Certificate: leaf.pem, Ca: ca}
+	require.NoError(t, c.CompleteCA(inter2.cert, inter1.cert))
+	assert.Zero(t, ca[:cap(ca)][len(ca)], "the original slice is not written to")
+	assert.NoError(t, (&Certificate{Certificate: leaf.pem, Key: keyPEM(t, leaf.key), Ca: c.Ca}).Validate())
+
+	assert.ErrorIs(t, (&Certificate{Certificate: []byte("cert")}).CompleteCA(inter1.cert), ErrInvalidCertificate)
+}
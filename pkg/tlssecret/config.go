@@ -16,6 +16,39 @@ type GenericSecretSyncConfig struct {
 	Index   int
 	Config  map[string]string
 	Updates map[string]string
+	// SecretSync is the name of the SecretSync resource that declared the
+	// target, and Name the target's name within it. Both are empty for
+	// targets declared through annotations.
+	SecretSync string
+	Name       string
+}
+
+// Key returns a stable identifier for the sync target within its secret:
+// the store name, suffixed with ".<index>" for indexed configs. Targets
+// declared by a SecretSync are keyed "secretsync/<secretsync>/<name>" so they
+// never collide with annotation targets.
+func (c *GenericSecretSyncConfig) Key() string {
+	if c.SecretSync != "" {
+		return "secretsync/" + c.SecretSync + "/" + c.Name
+	}
+	if c.Index == -1 {
+		return c.Store
+	}
+	return c.Store + "." + strconv.Itoa(c.Index)
+}
+
+// EffectiveConfig returns the target config with any updates written back by
+// the last sync merged in, matching what the config will parse to once those
+// updates have been persisted as annotations.
+func (c *GenericSecretSyncConfig) EffectiveConfig() map[string]string {
+	out := make(map[string]string, len(c.Config)+len(c.Updates))
+	for k, v := range c.Config {
+		out[k] = v
+	}
+	for k, v := range c.Updates {
+		out[k] = v
+	}
+	return out
 }
 
 func IsStoreAnnotation(k string) bool {
@@ -220,6 +253,11 @@ func AnnotationUpdates(c *Certificate) map[string]string {
 			ll.Debug("no updates")
 			continue
 		}
+		if s.SecretSync != "" {
+			// recorded in the SecretSync status instead
+			ll.Debug("secretsync target")
+			continue
+		}
 		if s.Index == -1 {
 			for k, v := range s.Updates {
 				updates[state.OperatorName+"/"+s.Store+"-"+k] = v
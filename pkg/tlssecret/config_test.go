@@ -444,6 +444,23 @@ func TestAnnotationUpdates(t *testing.T) {
 				state.OperatorName + "/gcp-key2.1": "value2",
 			},
 		},
+		{
+			name: "Test secretsync updates are not written to annotations",
+			c: &Certificate{
+				Syncs: []*GenericSecretSyncConfig{
+					{
+						Store:      "acm",
+						Index:      -1,
+						SecretSync: "web",
+						Name:       "acm",
+						Updates: map[string]string{
+							"certificate-arn": "arn",
+						},
+					},
+				},
+			},
+			want: map[string]string{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -455,3 +472,34 @@ func TestAnnotationUpdates(t *testing.T) {
 		})
 	}
 }
+
+func TestGenericSecretSyncConfigKey(t *testing.T) {
+	tests := []struct {
+		c    GenericSecretSyncConfig
+		want string
+	}{
+		{GenericSecretSyncConfig{Store: "acm", Index: -1}, "acm"},
+		{GenericSecretSyncConfig{Store: "acm", Index: 0}, "acm.0"},
+		{GenericSecretSyncConfig{Store: "vault", Index: 2}, "vault.2"},
+		{GenericSecretSyncConfig{Store: "acm", Index: -1, SecretSync: "web", Name: "prod"}, "secretsync/web/prod"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Key(); got != tt.want {
+			t.Errorf("Key() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestGenericSecretSyncConfigEffectiveConfig(t *testing.T) {
+	c := &GenericSecretSyncConfig{
+		Config:  map[string]string{"region": "us-east-1", "certificate-arn": "old"},
+		Updates: map[string]string{"certificate-arn": "new"},
+	}
+	want := map[string]string{"region": "us-east-1", "certificate-arn": "new"}
+	if got := c.EffectiveConfig(); !mapsEqual(got, want) {
+		t.Errorf("EffectiveConfig() = %v, want %v", got, want)
+	}
+	if c.Config["certificate-arn"] != "old" {
+		t.Errorf("EffectiveConfig() must not mutate Config")
+	}
+}
@@ -0,0 +1,94 @@
+package tlssecret
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrRemoteNotFound is returned by stores inspecting a remote certificate
+// that no longer exists.
+var ErrRemoteNotFound = errors.New("remote certificate not found")
+
+// RemoteCertificate describes the certificate a store currently serves.
+type RemoteCertificate struct {
+	// Fingerprint is the Fingerprint of the remote leaf, empty when the store
+	// does not expose the certificate itself.
+	Fingerprint string
+	// SHA1Fingerprint is the hex encoded SHA-1 of the remote leaf, for stores
+	// that only report that digest.
+	SHA1Fingerprint string
+	// NotAfter is the expiry of the remote leaf.
+	NotAfter time.Time
+	// Intermediates are the Fingerprints of the intermediates the store serves
+	// with the leaf, ordered from the leaf's issuer. It is nil when the store
+	// does not expose its chain.
+	Intermediates []string
+}
+
+// Fingerprint returns the hex encoded SHA-256 of the DER encoding of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// InspectPEM describes the PEM encoded chain a store holds, for stores that
+// can read back what they wrote. certificate starts with the leaf; ca holds
+// the rest of the chain when the store keeps it apart, and may be empty.
+func InspectPEM(certificate, ca []byte) (*RemoteCertificate, error) {
+	ch, err := (&Certificate{Certificate: certificate, Ca: ca}).Chain()
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteCertificate{
+		Fingerprint:   Fingerprint(ch.Leaf),
+		NotAfter:      ch.Leaf.NotAfter,
+		Intermediates: fingerprints(ch.Intermediates),
+	}, nil
+}
+
+func fingerprints(certs []*x509.Certificate) []string {
+	fps := make([]string, 0, len(certs))
+	for _, cert := range certs {
+		fps = append(fps, Fingerprint(cert))
+	}
+	return fps
+}
+
+// Matches reports whether the remote certificate is leaf. Without a
+// fingerprint only the expiry can be compared, which still tells a renewed or
+// replaced certificate apart in practice.
+func (r *RemoteCertificate) Matches(leaf *x509.Certificate) bool {
+	if r.Fingerprint != "" || r.SHA1Fingerprint != "" {
+		return r.Identical(leaf)
+	}
+	return r.NotAfter.Unix() == leaf.NotAfter.Unix()
+}
+
+// Identical reports whether a fingerprint proves the remote certificate is
+// leaf. Unlike Matches it never relies on the expiry alone, so a store is only
+// spared a write when it certainly holds the certificate.
+func (r *RemoteCertificate) Identical(leaf *x509.Certificate) bool {
+	switch {
+	case r.Fingerprint != "":
+		return r.Fingerprint == Fingerprint(leaf)
+	case r.SHA1Fingerprint != "":
+		sum := sha1.Sum(leaf.Raw)
+		return strings.EqualFold(strings.ReplaceAll(r.SHA1Fingerprint, ":", ""), hex.EncodeToString(sum[:]))
+	}
+	return false
+}
+
+// Holds reports whether the store is proven to serve ch: the same leaf and
+// the same intermediates in the same order. The root is not compared, as
+// stores differ in whether they keep it. A store that does not expose its
+// chain never holds one.
+func (r *RemoteCertificate) Holds(ch *Chain) bool {
+	return r.Intermediates != nil && r.Identical(ch.Leaf) &&
+		slices.Equal(r.Intermediates, fingerprints(ch.Intermediates))
+}
@@ -0,0 +1,96 @@
+package tlssecret
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectPEM(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	leaf := issue(t, "leaf", false, root)
+
+	r, err := InspectPEM(concat(leaf.pem, root.pem), nil)
+	require.NoError(t, err)
+	assert.Equal(t, Fingerprint(leaf.cert), r.Fingerprint)
+	assert.Equal(t, []string{}, r.Intermediates)
+	assert.True(t, r.NotAfter.Equal(leaf.cert.NotAfter))
+	assert.True(t, r.Matches(leaf.cert))
+	assert.False(t, r.Matches(root.cert))
+
+	_, err = InspectPEM([]byte("not a certificate"), nil)
+	assert.ErrorIs(t, err, ErrInvalidCertificate)
+}
+
+func TestRemoteCertificate_MatchesWithoutFingerprint(t *testing.T) {
+	leaf := issue(t, "leaf", false, nil)
+	r := &RemoteCertificate{NotAfter: leaf.cert.NotAfter.Add(400 * time.Millisecond)}
+	assert.True(t, r.Matches(leaf.cert), "expiry is compared to the second")
+
+	r.NotAfter = leaf.cert.NotAfter.Add(time.Hour)
+	assert.False(t, r.Matches(leaf.cert))
+}
+
+func TestRemoteCertificate_Identical(t *testing.T) {
+	leaf := issue(t, "leaf", false, nil)
+	other := issue(t, "other", false, nil)
+	sum := sha1.Sum(leaf.cert.Raw)
+
+	cases := []struct {
+		name   string
+		remote RemoteCertificate
+		want   bool
+	}{
+		{name: "sha-256", remote: RemoteCertificate{Fingerprint: Fingerprint(leaf.cert)}, want: true},
+		{name: "other sha-256", remote: RemoteCertificate{Fingerprint: Fingerprint(other.cert)}},
+		{name: "sha-1", remote: RemoteCertificate{SHA1Fingerprint: hex.EncodeToString(sum[:])}, want: true},
+		{name: "sha-1 upper case", remote: RemoteCertificate{SHA1Fingerprint: strings.ToUpper(hex.EncodeToString(sum[:]))}, want: true},
+		{name: "other sha-1", remote: RemoteCertificate{SHA1Fingerprint: "00"}},
+		{name: "expiry only", remote: RemoteCertificate{NotAfter: leaf.cert.NotAfter}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.remote.Identical(leaf.cert))
+			if tc.remote.Fingerprint != "" || tc.remote.SHA1Fingerprint != "" {
+				assert.Equal(t, tc.want, tc.remote.Matches(leaf.cert))
+			}
+		})
+	}
+}
+
+func TestRemoteCertificate_Holds(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	other := issue(t, "other intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+	ch, err := (&Certificate{Certificate: concat(leaf.pem, inter.pem), Ca: root.pem}).Chain()
+	require.NoError(t, err)
+
+	cases := []struct {
+		name        string
+		certificate []byte
+		ca          []byte
+		want        bool
+	}{
+		{name: "same chain", certificate: concat(leaf.pem, inter.pem), ca: root.pem, want: true},
+		{name: "chain apart", certificate: leaf.pem, ca: concat(root.pem, inter.pem), want: true},
+		{name: "without root", certificate: concat(leaf.pem, inter.pem), want: true},
+		{name: "leaf only", certificate: leaf.pem},
+		{name: "other intermediate", certificate: concat(leaf.pem, other.pem), ca: root.pem},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := InspectPEM(tc.certificate, tc.ca)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, r.Holds(ch))
+		})
+	}
+
+	fp := &RemoteCertificate{Fingerprint: Fingerprint(leaf.cert)}
+	assert.False(t, fp.Holds(ch), "a store that does not expose its chain")
+}
@@ -29,10 +29,18 @@ func ParseSecret(s *corev1.Secret) *Certificate {
 	return c
 }
 
-// FullChain returns the full certificate chain, including the CA certificate if present
+// FullChain returns the full certificate chain, including the CA certificate if present.
+// The bytes of tls.crt and ca.crt are concatenated as they are; stores should
+// prefer Chain, which drops repeated certificates and orders the chain.
+// The chain is built in a new slice: appending to c.Certificate directly could
+// write into its spare capacity, which races when several stores sync the same
+// Certificate concurrently.
 func (c *Certificate) FullChain() []byte {
 	if len(c.Ca) > 0 {
-		return append(append(c.Certificate, '\n'), c.Ca...)
+		chain := make([]byte, 0, len(c.Certificate)+1+len(c.Ca))
+		chain = append(chain, c.Certificate...)
+		chain = append(chain, '\n')
+		return append(chain, c.Ca...)
 	}
 	return c.Certificate
 }
@@ -249,3 +249,23 @@ func TestFullChain(t *testing.T) {
 		})
 	}
 }
+
+// TestFullChainDoesNotAliasCertificate ensures FullChain never writes into the
+// spare capacity of Certificate, which would race when stores sync the same
+// Certificate concurrently.
+func TestFullChainDoesNotAliasCertificate(t *testing.T) {
+	certBuf := make([]byte, 4, 64)
+	copy(certBuf, "leaf")
+	c := &Certificate{Certificate: certBuf, Ca: []byte("root")}
+
+	first := c.FullChain()
+	c2 := &Certificate{Certificate: certBuf, Ca: []byte("other")}
+	_ = c2.FullChain()
+
+	if string(first) != "leaf\nroot" {
+		t.Errorf("FullChain() = %q, want %q", first, "leaf\nroot")
+	}
+	if string(certBuf[:cap(certBuf)][4:5]) != "\x00" {
+		t.Errorf("FullChain() wrote into the spare capacity of Certificate")
+	}
+}
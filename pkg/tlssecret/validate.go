@@ -0,0 +1,66 @@
+package tlssecret
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCertificate is wrapped by the errors Validate returns.
+var ErrInvalidCertificate = errors.New("invalid certificate")
+
+// Validate checks the certificate material is safe to push to a store: tls.crt
+// and ca.crt must hold only well-formed PEM certificates, tls.key must be the
+// private key of the leaf (the first certificate of tls.crt), and every other
+// certificate must be part of the leaf's Chain, the leaf-to-root chain stores
+// send. Their order and repeats do not matter, as Chain puts them in order and
+// drops the copies.
+func (c *Certificate) Validate() error {
+	ch, unused, err := c.chain()
+	if err != nil {
+		return err
+	}
+	if len(c.Key) == 0 {
+		return fmt.Errorf("%w: tls.key is empty", ErrInvalidCertificate)
+	}
+	if _, err := tls.X509KeyPair(c.Certificate, c.Key); err != nil {
+		return fmt.Errorf("%w: tls.key: %v", ErrInvalidCertificate, err)
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("%w: %q is not part of the chain of %q, which ends at %q",
+			ErrInvalidCertificate, unused[0].Subject, ch.Leaf.Subject, ch.Last().Subject)
+	}
+	return nil
+}
+
+// parseCertificates parses the PEM certificates in data, read from the named
+// secret key. Any other PEM block or trailing data is an error.
+func parseCertificates(name string, data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%w: %s: unexpected PEM block %q", ErrInvalidCertificate, name, block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: certificate %d: %v", ErrInvalidCertificate, name, len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("%w: %s: data is not PEM encoded", ErrInvalidCertificate, name)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: %s holds no certificates", ErrInvalidCertificate, name)
+	}
+	return certs, nil
+}
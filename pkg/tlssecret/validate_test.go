@@ -0,0 +1,155 @@
+package tlssecret
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// issue creates a certificate for cn signed by parent, or self-signed when
+// parent is nil.
+func issue(t *testing.T, cn string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func keyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func TestCertificate_Validate(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	inter := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, inter)
+	other := issue(t, "other", false, inter)
+
+	tests := []struct {
+		name    string
+		cert    Certificate
+		wantErr string
+	}{
+		{
+			name: "leaf and intermediate with root CA",
+			cert: Certificate{Certificate: concat(leaf.pem, inter.pem), Key: keyPEM(t, leaf.key), Ca: root.pem},
+		},
+		{
+			name: "CA repeating the last certificate",
+			cert: Certificate{Certificate: concat(leaf.pem, inter.pem), Key: keyPEM(t, leaf.key), Ca: concat(inter.pem, root.pem)},
+		},
+		{
+			name: "self-signed",
+			cert: Certificate{Certificate: root.pem, Key: keyPEM(t, root.key), Ca: root.pem},
+		},
+		{
+			name:    "empty certificate",
+			cert:    Certificate{Key: keyPEM(t, leaf.key)},
+			wantErr: "tls.crt holds no certificates",
+		},
+		{
+			name:    "not PEM",
+			cert:    Certificate{Certificate: []byte("cert"), Key: keyPEM(t, leaf.key)},
+			wantErr: "tls.crt: data is not PEM encoded",
+		},
+		{
+			name:    "truncated certificate",
+			cert:    Certificate{Certificate: concat(leaf.pem, inter.pem[:len(inter.pem)/2]), Key: keyPEM(t, leaf.key)},
+			wantErr: "tls.crt: data is not PEM encoded",
+		},
+		{
+			name:    "key in the certificate",
+			cert:    Certificate{Certificate: concat(leaf.pem, keyPEM(t, leaf.key)), Key: keyPEM(t, leaf.key)},
+			wantErr: `tls.crt: unexpected PEM block "EC PRIVATE KEY"`,
+		},
+		{
+			name:    "missing key",
+			cert:    Certificate{Certificate: leaf.pem},
+			wantErr: "tls.key is empty",
+		},
+		{
+			name:    "mismatched key",
+			cert:    Certificate{Certificate: leaf.pem, Key: keyPEM(t, other.key)},
+			wantErr: "tls.key: tls: private key does not match public key",
+		},
+		{
+			name: "chain out of order",
+			cert: Certificate{Certificate: concat(leaf.pem, root.pem, inter.pem), Key: keyPEM(t, leaf.key)},
+		},
+		{
+			name: "repeated intermediate",
+			cert: Certificate{Certificate: concat(leaf.pem, inter.pem, inter.pem), Key: keyPEM(t, leaf.key), Ca: concat(root.pem, inter.pem)},
+		},
+		{
+			name:    "CA that did not issue the chain",
+			cert:    Certificate{Certificate: leaf.pem, Key: keyPEM(t, leaf.key), Ca: root.pem},
+			wantErr: `"CN=root" is not part of the chain of "CN=leaf", which ends at "CN=leaf"`,
+		},
+		{
+			name:    "unrelated certificate",
+			cert:    Certificate{Certificate: concat(leaf.pem, inter.pem), Key: keyPEM(t, leaf.key), Ca: concat(root.pem, other.pem)},
+			wantErr: `"CN=other" is not part of the chain of "CN=leaf", which ends at "CN=root"`,
+		},
+		{
+			name:    "invalid CA",
+			cert:    Certificate{Certificate: leaf.pem, Key: keyPEM(t, leaf.key), Ca: []byte("ca")},
+			wantErr: "ca.crt: data is not PEM encoded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cert.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidCertificate)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package webhook implements the optional validating admission webhook that
+// checks a secret's sync annotations before it is stored, so typos surface
+// at apply time instead of after a failed reconcile.
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	cmtypes "github.com/robertlestak/cert-manager-sync/internal/types"
+	"github.com/robertlestak/cert-manager-sync/pkg/backoff"
+	"github.com/robertlestak/cert-manager-sync/pkg/certmanagersync"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// operatorAnnotations returns the operator's own annotations that are not
+// store settings.
+func operatorAnnotations() []string {
+	a := []string{
+		state.SyncStateAnnotation(),
+		state.SyncStatusAnnotation(),
+		state.SyncTimeoutAnnotation(),
+		state.DeletePolicyAnnotation(),
+		state.DeleteAttemptsAnnotation(),
+		state.NextDeleteAnnotation(),
+		state.AllowedNamespacesAnnotation(),
+		state.CompleteChainAnnotation(),
+		state.DriftResyncAnnotation(),
+	}
+	for _, k := range []string{"sync-enabled", "enabled", "hash", "failed-sync-attempts", "next-retry", "max-sync-attempts"} {
+		a = append(a, state.OperatorName+"/"+k)
+	}
+	for _, k := range backoff.Keys {
+		a = append(a, state.BackoffAnnotation(k))
+	}
+	return a
+}
+
+// ValidateSecret returns the problems with the secret's sync annotations,
+// checked against each store's config schema: unknown stores or keys,
+// malformed index suffixes and, for each target, invalid values and missing
+// required keys. It returns nil for a valid secret.
+func ValidateSecret(s *corev1.Secret) []string {
+	var (
+		problems []string
+		prefix   = state.OperatorName + "/"
+		operator = operatorAnnotations()
+	)
+	keys := make([]string, 0, len(s.Annotations))
+	for k := range s.Annotations {
+		if strings.HasPrefix(k, prefix) && !slices.Contains(operator, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p := validateAnnotation(k); p != "" {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		// The targets cannot be grouped reliably until the keys parse.
+		return problems
+	}
+	syncs, err := tlssecret.SyncsForSecret(s)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid sync annotations: %v", err)}
+	}
+	for _, c := range syncs {
+		problems = append(problems, targetProblems(c)...)
+	}
+	return problems
+}
+
+// validateAnnotation checks a single "<store>-<key>[.<index>]" annotation,
+// returning "" when it is valid.
+func validateAnnotation(k string) string {
+	name := strings.TrimPrefix(k, state.OperatorName+"/")
+	store, key, ok := strings.Cut(name, "-")
+	if !ok || !cmtypes.IsValidStoreType(store) {
+		return fmt.Sprintf("%s: unknown store or annotation", k)
+	}
+	schema, err := certmanagersync.StoreSchema(cmtypes.StoreType(store))
+	if err != nil {
+		return fmt.Sprintf("%s: %v", k, err)
+	}
+	if base, index, ok := strings.Cut(key, "."); ok {
+		if n, err := strconv.Atoi(index); err != nil || n < 0 {
+			return fmt.Sprintf("%s: index %q must be a non-negative integer", k, index)
+		}
+		key = base
+	}
+	if _, ok := schema.Lookup(key); ok {
+		return ""
+	}
+	if s := closest(key, schema.Names()); s != "" {
+		return fmt.Sprintf("%s: unknown %s key %q; did you mean %q?", k, store, key, s)
+	}
+	return fmt.Sprintf("%s: unknown %s key %q", k, store, key)
+}
+
+// targetProblems returns the target's invalid values and missing required
+// keys. Missing keys are not reported for targets using a credential profile,
+// as the profile's defaults may supply them.
+func targetProblems(c *tlssecret.GenericSecretSyncConfig) []string {
+	schema, err := certmanagersync.StoreSchema(cmtypes.StoreType(c.Store))
+	if err != nil {
+		return nil
+	}
+	profile := c.Config[credentials.CredentialKey] != "" || c.Config[credentials.ClusterCredentialKey] != ""
+	suffix := ""
+	if c.Index >= 0 {
+		suffix = "." + strconv.Itoa(c.Index)
+	}
+	var problems []string
+	for _, p := range schema.Problems(c.Config) {
+		name := fmt.Sprintf("%s/%s-%s%s", state.OperatorName, c.Store, p.Key, suffix)
+		switch {
+		case p.Missing && profile:
+		case p.Missing:
+			problems = append(problems, fmt.Sprintf("%s: required by the %s target", name, c.Store))
+		default:
+			problems = append(problems, fmt.Sprintf("%s: %s", name, p.Message))
+		}
+	}
+	return problems
+}
+
+// closest returns the candidate within two edits of key, if any, for
+// suggesting a fix to a typo.
+func closest(key string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(key, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
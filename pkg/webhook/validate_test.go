@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func secretWith(annotations map[string]string) *corev1.Secret {
+	a := map[string]string{state.OperatorName + "/sync-enabled": "true"}
+	for k, v := range annotations {
+		a[state.OperatorName+"/"+k] = v
+	}
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "ns", Annotations: a}}
+}
+
+func TestValidateSecret(t *testing.T) {
+	p := state.OperatorName + "/"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name:        "valid",
+			annotations: map[string]string{"acm-region": "us-east-1", "vault-addr.0": "a", "vault-path.0": "p", "vault-role.0": "r"},
+		},
+		{
+			name:        "operator annotations are not store settings",
+			annotations: map[string]string{"acm-enabled": "true", "hash": "h", "sync-timeout": "1m", "max-sync-attempts": "3"},
+		},
+		{
+			name:        "backoff settings",
+			annotations: map[string]string{"acm-region": "us-east-1", "backoff-max": "1h", "acm-backoff-jitter": "0.1"},
+		},
+		{
+			name:        "typo suggests the known key",
+			annotations: map[string]string{"acm-certifcate-arn": "arn"},
+			want:        []string{p + `acm-certifcate-arn: unknown acm key "certifcate-arn"; did you mean "certificate-arn"?`},
+		},
+		{
+			name:        "unknown key",
+			annotations: map[string]string{"filepath-dir": "/d", "filepath-owner": "root"},
+			want:        []string{p + `filepath-owner: unknown filepath key "owner"`},
+		},
+		{
+			name:        "unknown store",
+			annotations: map[string]string{"acn-region": "us-east-1"},
+			want:        []string{p + "acn-region: unknown store or annotation"},
+		},
+		{
+			name:        "malformed index",
+			annotations: map[string]string{"acm-region.first": "us-east-1", "acm-role-arn.-1": "arn"},
+			want: []string{
+				p + `acm-region.first: index "first" must be a non-negative integer`,
+				p + `acm-role-arn.-1: index "-1" must be a non-negative integer`,
+			},
+		},
+		{
+			name:        "missing required keys",
+			annotations: map[string]string{"cloudflare-zone-id.1": "z", "heroku-secret-name": "h"},
+			want: []string{
+				p + "cloudflare-secret-name.1: required by the cloudflare target",
+				p + "heroku-app: required by the heroku target",
+			},
+		},
+		{
+			name:        "prefixed keys",
+			annotations: map[string]string{"hetznercloud-secret-name": "h", "hetznercloud-label-team": "devops"},
+		},
+		{
+			name:        "invalid values",
+			annotations: map[string]string{"hetznercloud-secret-name.0": "h", "hetznercloud-cert-id.0": "abc", "hetznercloud-enabled.0": "yes"},
+			want: []string{
+				p + `hetznercloud-enabled.0: must be true or false, got "yes"`,
+				p + `hetznercloud-cert-id.0: must be an integer, got "abc"`,
+			},
+		},
+		{
+			name:        "credential profiles may supply required keys",
+			annotations: map[string]string{"cloudflare-credential": "cf"},
+		},
+		{
+			name:        "disabled targets are not checked",
+			annotations: map[string]string{"filepath-enabled": "false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ValidateSecret(secretWith(tt.annotations)))
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("region", "region"))
+	assert.Equal(t, 1, editDistance("certifcate-arn", "certificate-arn"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+}
@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Path is the URL path the secret validation webhook is served on.
+const Path = "/validate-secret"
+
+// Webhook modes, set with WEBHOOK_MODE.
+const (
+	// ModeDeny rejects secrets with invalid sync annotations.
+	ModeDeny = "deny"
+	// ModeWarn admits them, returning the problems as admission warnings.
+	ModeWarn = "warn"
+)
+
+// maxRequestBytes bounds the size of an AdmissionReview the handler reads.
+const maxRequestBytes = 3 << 20
+
+// Enabled reports whether the webhook server should run. Set
+// ENABLE_WEBHOOK=true to enable it.
+func Enabled() bool {
+	return os.Getenv("ENABLE_WEBHOOK") == "true"
+}
+
+// Mode returns the configured WEBHOOK_MODE, defaulting to ModeDeny.
+func Mode() string {
+	if strings.EqualFold(os.Getenv("WEBHOOK_MODE"), ModeWarn) {
+		return ModeWarn
+	}
+	return ModeDeny
+}
+
+// Handler returns the http.Handler answering AdmissionReview requests for
+// secrets in the given mode.
+func Handler(mode string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := log.WithFields(log.Fields{
+			"pkg": "webhook",
+			"fn":  "Handler",
+		})
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+			return
+		}
+		review.Response = admit(review.Request, mode)
+		review.Request = nil
+		l.WithFields(log.Fields{
+			"uid":     review.Response.UID,
+			"allowed": review.Response.Allowed,
+		}).Debug("admission review")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			l.WithError(err).Warn("error writing admission response")
+		}
+	})
+}
+
+// admit validates the secret in an admission request.
+func admit(req *admissionv1.AdmissionRequest, mode string) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation == admissionv1.Delete || len(req.Object.Raw) == 0 {
+		return resp
+	}
+	s := &corev1.Secret{}
+	if err := json.Unmarshal(req.Object.Raw, s); err != nil {
+		// Not ours to reject; the API server validates the object itself.
+		return resp
+	}
+	problems := ValidateSecret(s)
+	if len(problems) == 0 {
+		return resp
+	}
+	if mode == ModeWarn {
+		resp.Warnings = problems
+		return resp
+	}
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusUnprocessableEntity,
+		Reason:  metav1.StatusReasonInvalid,
+		Message: "invalid sync annotations: " + strings.Join(problems, "; "),
+	}
+	return resp
+}
+
+// Serve runs the webhook HTTPS server until ctx is cancelled. It listens on
+// WEBHOOK_PORT (default 9443) using the tls.crt and tls.key in
+// WEBHOOK_CERT_DIR, which the serving certificate is mounted into.
+func Serve(ctx context.Context) error {
+	l := log.WithFields(log.Fields{
+		"pkg": "webhook",
+		"fn":  "Serve",
+	})
+	port := cmp.Or(os.Getenv("WEBHOOK_PORT"), "9443")
+	certDir := cmp.Or(os.Getenv("WEBHOOK_CERT_DIR"), "/tmp/k8s-webhook-server/serving-certs")
+	mode := Mode()
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler(mode))
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	stop := context.AfterFunc(ctx, func() {
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			l.WithError(err).Warn("error shutting down webhook server")
+		}
+	})
+	defer stop()
+	l.WithFields(log.Fields{"port": port, "mode": mode}).Info("starting webhook server")
+	err := srv.ListenAndServeTLS(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"))
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("webhook server: %w", err)
+	}
+	l.Debug("webhook server stopped")
+	return nil
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func review(t *testing.T, mode string, op admissionv1.Operation, annotations map[string]string) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(secretWith(annotations))
+	require.NoError(t, err)
+	in := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid-1",
+			Operation: op,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(in)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	Handler(mode).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var out admissionv1.AdmissionReview
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	assert.Equal(t, "AdmissionReview", out.Kind)
+	require.NotNil(t, out.Response)
+	assert.Equal(t, in.Request.UID, out.Response.UID)
+	return out.Response
+}
+
+func TestHandler(t *testing.T) {
+	invalid := map[string]string{"acm-certifcate-arn": "arn"}
+
+	resp := review(t, ModeDeny, admissionv1.Create, map[string]string{"acm-region": "us-east-1"})
+	assert.True(t, resp.Allowed)
+
+	resp = review(t, ModeDeny, admissionv1.Update, invalid)
+	assert.False(t, resp.Allowed)
+	require.NotNil(t, resp.Result)
+	assert.Contains(t, resp.Result.Message, `did you mean "certificate-arn"?`)
+
+	resp = review(t, ModeWarn, admissionv1.Create, invalid)
+	assert.True(t, resp.Allowed)
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "acm-certifcate-arn")
+}
+
+func TestHandler_RejectsMalformedRequests(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(ModeDeny).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader([]byte("{}"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestMode(t *testing.T) {
+	t.Setenv("WEBHOOK_MODE", "")
+	assert.Equal(t, ModeDeny, Mode())
+	t.Setenv("WEBHOOK_MODE", "Warn")
+	assert.Equal(t, ModeWarn, Mode())
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,36 +14,69 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/google/uuid"
+	cmcredentials "github.com/robertlestak/cert-manager-sync/pkg/credentials"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type ACMStore struct {
-	Region          string
-	RoleArn         string
-	CertificateArn  string
-	SecretName      string
-	SecretNamespace string
-	AccessKeyId     string
-	SecretAccessKey string
+	Region            string
+	RoleArn           string
+	CertificateArn    string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	AccessKeyId       string
+	SecretAccessKey   string
 }
 
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "acm",
+	Keys: []storeconfig.Key{
+		{Name: "region", Endpoint: true, Description: "AWS region. Defaults to AWS_REGION, then us-east-1."},
+		{Name: "role-arn", Description: "IAM role to assume before importing the certificate."},
+		{Name: "certificate-arn", Output: true, Description: "ARN of the imported certificate, re-imported on renewal."},
+		{Name: "secret-name", SecretRef: true, Description: "Secret holding AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. Not needed with IRSA."},
+		{Name: "secret-namespace", Description: "Namespace of secret-name."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *ACMStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *ACMStore) credentialRef() cmcredentials.Ref {
+	return cmcredentials.Ref{
+		Store:             "acm",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
+}
+
+// GetApiKey loads the static AWS keys. A credential profile without a secret
+// leaves them empty so the ambient credentials are used.
 func (s *ACMStore) GetApiKey(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
-	if err != nil {
-		return fmt.Errorf("failed to get AWS credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+	creds, err := cmcredentials.Resolve(ctx, s.credentialRef())
+	if errors.Is(err, cmcredentials.ErrNoSecret) {
+		return nil
 	}
-	if sc.Data["AWS_ACCESS_KEY_ID"] == nil {
-		return fmt.Errorf("AWS_ACCESS_KEY_ID not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials from %s: %w", s.credentialRef(), err)
 	}
-	if sc.Data["AWS_SECRET_ACCESS_KEY"] == nil {
-		return fmt.Errorf("AWS_SECRET_ACCESS_KEY not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err := creds.Require("AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"); err != nil {
+		return err
 	}
-	s.AccessKeyId = string(sc.Data["AWS_ACCESS_KEY_ID"])
-	s.SecretAccessKey = string(sc.Data["AWS_SECRET_ACCESS_KEY"])
+	s.AccessKeyId = creds.Get("AWS_ACCESS_KEY_ID")
+	s.SecretAccessKey = creds.Get("AWS_SECRET_ACCESS_KEY")
 	return nil
 }
 
@@ -59,7 +91,7 @@ func (s *ACMStore) awsRegion() string {
 }
 
 // createAWSSession will connect to AWS with the account's credentials from vault
-func (s *ACMStore) createAWSSession() (*session.Session, *aws.Config, error) {
+func (s *ACMStore) createAWSSession(ctx context.Context) (*session.Session, *aws.Config, error) {
 	l := log.WithFields(
 		log.Fields{
 			"action": "createAWSSession",
@@ -69,11 +101,13 @@ func (s *ACMStore) createAWSSession() (*session.Session, *aws.Config, error) {
 	cfg := &aws.Config{
 		Region: aws.String(s.awsRegion()),
 	}
-	if s.SecretName != "" {
-		if err := s.GetApiKey(context.Background()); err != nil {
+	if s.credentialRef().IsSet() {
+		if err := s.GetApiKey(ctx); err != nil {
 			l.Debugf("GetApiKey error=%v", err)
 			return nil, nil, err
 		}
+	}
+	if s.AccessKeyId != "" {
 		cfg.Credentials = credentials.NewStaticCredentials(s.AccessKeyId, s.SecretAccessKey, "")
 	}
 	sess, err := session.NewSession(cfg)
@@ -92,7 +126,7 @@ func (s *ACMStore) createAWSSession() (*session.Session, *aws.Config, error) {
 }
 
 // importCertificate imports a cert into ACM
-func (s *ACMStore) importCertificate(sess *session.Session, cfg *aws.Config, im *acm.ImportCertificateInput) error {
+func (s *ACMStore) importCertificate(ctx context.Context, sess *session.Session, cfg *aws.Config, im *acm.ImportCertificateInput) error {
 	l := log.WithFields(
 		log.Fields{
 			"action": "importCertificate",
@@ -103,10 +137,10 @@ func (s *ACMStore) importCertificate(sess *session.Session, cfg *aws.Config, im
 	if s.CertificateArn != "" {
 		im.CertificateArn = aws.String(s.CertificateArn)
 	}
-	cert, err := svc.ImportCertificate(im)
+	cert, err := svc.ImportCertificateWithContext(ctx, im)
 	if err != nil {
 		l.Debugf("awsacm.importCertificate svc.importCertificate error: %v\n", err)
-		return fmt.Errorf("failed to import certificate to ACM (region: %s, arn: %s): %w", s.awsRegion(), s.CertificateArn, err)
+		return fmt.Errorf("failed to import certificate to ACM (region: %s, arn: %s): %w", s.awsRegion(), s.CertificateArn, classify(err))
 	}
 	l.Debugf("awsacm.importCertificate svc.importCertificate success: %v\n", cert)
 	s.CertificateArn = *cert.CertificateArn
@@ -114,7 +148,7 @@ func (s *ACMStore) importCertificate(sess *session.Session, cfg *aws.Config, im
 }
 
 // replicateACMCert takes an ACM ImportCertificateInput and replicates it to AWS CertificateManager
-func (s *ACMStore) replicateACMCert(ai *acm.ImportCertificateInput) error {
+func (s *ACMStore) replicateACMCert(ctx context.Context, ai *acm.ImportCertificateInput) error {
 	l := log.WithFields(
 		log.Fields{
 			"action": "replicateACMCert",
@@ -122,12 +156,12 @@ func (s *ACMStore) replicateACMCert(ai *acm.ImportCertificateInput) error {
 	)
 	l.Debug("replicateACMCert")
 	// inefficient creation of session on each import - can be cached
-	sess, cfg, serr := s.createAWSSession()
+	sess, cfg, serr := s.createAWSSession(ctx)
 	if serr != nil {
 		l.Debugf("createAWSSession error=%v", serr)
 		return serr
 	}
-	cerr := s.importCertificate(sess, cfg, ai)
+	cerr := s.importCertificate(ctx, sess, cfg, ai)
 	if cerr != nil {
 		l.Debugf("ImportCertificate error=%v", cerr)
 		return cerr
@@ -135,24 +169,21 @@ func (s *ACMStore) replicateACMCert(ai *acm.ImportCertificateInput) error {
 	return nil
 }
 
-// separateCertsACM wraps separateCerts and returns an acm ImportCertificateInput Object
-func separateCertsACM(ca, crt, key []byte) *acm.ImportCertificateInput {
-	re := regexp.MustCompile(`(?s)(-----BEGIN CERTIFICATE-----.*?-----END CERTIFICATE-----)`)
-	certBlocks := re.FindAllString(string(crt), -1)
-	if len(certBlocks) == 0 {
-		return nil
-	}
-	nc := certBlocks[0]
-	ch := strings.Join(certBlocks[1:], "\n")
-	if len(ca) > 0 {
-		ch += "\n" + string(ca)
+// separateCertsACM splits the certificate into the leaf and its chain and
+// returns an acm ImportCertificateInput Object
+func separateCertsACM(c *tlssecret.Certificate) (*acm.ImportCertificateInput, error) {
+	ch, err := c.Chain()
+	if err != nil {
+		return nil, err
 	}
 	im := &acm.ImportCertificateInput{
-		CertificateChain: []byte(ch),
-		Certificate:      []byte(nc),
-		PrivateKey:       key,
+		Certificate: ch.LeafPEM(),
+		PrivateKey:  c.Key,
 	}
-	return im
+	if issuers := ch.IssuersPEM(tlssecret.IncludeRoot); len(issuers) > 0 {
+		im.CertificateChain = issuers
+	}
+	return im, nil
 }
 
 func (s *ACMStore) certToACMInput(c *tlssecret.Certificate) (*acm.ImportCertificateInput, error) {
@@ -162,7 +193,10 @@ func (s *ACMStore) certToACMInput(c *tlssecret.Certificate) (*acm.ImportCertific
 			"secretName": c.SecretName,
 		},
 	)
-	im := separateCertsACM(c.Ca, c.Certificate, c.Key)
+	im, err := separateCertsACM(c)
+	if err != nil {
+		return nil, err
+	}
 	if s.CertificateArn == "" {
 		// this is our first time sending to ACM, tag
 		var tags []*acm.Tag
@@ -180,7 +214,7 @@ func (s *ACMStore) certToACMInput(c *tlssecret.Certificate) (*acm.ImportCertific
 	return im, nil
 }
 
-func (s *ACMStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *ACMStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -197,6 +231,12 @@ func (s *ACMStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["secret-namespace"] != "" {
 		s.SecretNamespace = c.Config["secret-namespace"]
 	}
@@ -219,9 +259,37 @@ func isACMNotFound(err error) bool {
 	return false
 }
 
+// classify classifies an AWS error by its error code, or by the HTTP status
+// of the response for codes not listed.
+func classify(err error) error {
+	var ae awserr.Error
+	if !errors.As(err, &ae) {
+		return err
+	}
+	switch ae.Code() {
+	case acm.ErrCodeAccessDeniedException, "AccessDenied", "UnrecognizedClientException", "InvalidClientTokenId",
+		"ExpiredToken", "ExpiredTokenException", "InvalidSignatureException", "SignatureDoesNotMatch":
+		return syncerrors.New(syncerrors.Auth, err)
+	case acm.ErrCodeValidationException, acm.ErrCodeInvalidParameterException, acm.ErrCodeInvalidArnException,
+		acm.ErrCodeInvalidTagException, acm.ErrCodeTagPolicyException, acm.ErrCodeTooManyTagsException:
+		return syncerrors.New(syncerrors.InvalidInput, err)
+	case acm.ErrCodeResourceNotFoundException:
+		return syncerrors.New(syncerrors.NotFound, err)
+	case acm.ErrCodeLimitExceededException:
+		return syncerrors.New(syncerrors.Quota, err)
+	case acm.ErrCodeThrottlingException, "Throttling", "RequestLimitExceeded", "RequestError", "ServiceUnavailable", "InternalFailure":
+		return syncerrors.New(syncerrors.Transient, err)
+	}
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		return syncerrors.FromHTTPStatus(rf.StatusCode(), err)
+	}
+	return err
+}
+
 // Delete removes the certificate from ACM. ResourceNotFoundException is treated
 // as success so the operation is idempotent.
-func (s *ACMStore) Delete(_ context.Context) error {
+func (s *ACMStore) Delete(ctx context.Context) error {
 	l := log.WithFields(log.Fields{
 		"action": "acm.Delete",
 		"arn":    s.CertificateArn,
@@ -233,12 +301,12 @@ func (s *ACMStore) Delete(_ context.Context) error {
 		l.Debug("no acm certificate-arn recorded; nothing to delete")
 		return nil
 	}
-	sess, cfg, err := s.createAWSSession()
+	sess, cfg, err := s.createAWSSession(ctx)
 	if err != nil {
 		return fmt.Errorf("acm session: %w", err)
 	}
 	svc := acm.New(sess, cfg)
-	if _, err := svc.DeleteCertificate(&acm.DeleteCertificateInput{
+	if _, err := svc.DeleteCertificateWithContext(ctx, &acm.DeleteCertificateInput{
 		CertificateArn: aws.String(s.CertificateArn),
 	}); err != nil {
 		if isACMNotFound(err) {
@@ -251,7 +319,29 @@ func (s *ACMStore) Delete(_ context.Context) error {
 	return nil
 }
 
-func (s *ACMStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+// Inspect describes the certificate currently imported at CertificateArn.
+func (s *ACMStore) Inspect(ctx context.Context) (*tlssecret.RemoteCertificate, error) {
+	if s.CertificateArn == "" {
+		return nil, fmt.Errorf("no acm certificate-arn recorded: %w", tlssecret.ErrRemoteNotFound)
+	}
+	sess, cfg, err := s.createAWSSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acm session: %w", err)
+	}
+	svc := acm.New(sess, cfg)
+	out, err := svc.GetCertificateWithContext(ctx, &acm.GetCertificateInput{
+		CertificateArn: aws.String(s.CertificateArn),
+	})
+	if isACMNotFound(err) {
+		return nil, fmt.Errorf("inspect ACM certificate %s: %w", s.CertificateArn, tlssecret.ErrRemoteNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get ACM certificate %s: %w", s.CertificateArn, err)
+	}
+	return tlssecret.InspectPEM([]byte(aws.StringValue(out.Certificate)), []byte(aws.StringValue(out.CertificateChain)))
+}
+
+func (s *ACMStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -266,7 +356,7 @@ func (s *ACMStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
 		l.WithError(err).Errorf("certToACMInput error")
 		return nil, err
 	}
-	cerr := s.replicateACMCert(im)
+	cerr := s.replicateACMCert(ctx, im)
 	if cerr != nil {
 		l.WithError(cerr).Errorf("replicateACMCert error")
 		return nil, cerr
@@ -1,6 +1,7 @@
 package acm
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -17,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -73,6 +75,59 @@ func GenerateCert(key []byte) ([]byte, []byte, error) {
 	return certPem, key, nil
 }
 
+// GenerateIssuedCert generates a certificate for key issued by a new
+// self-signed CA, returning the certificate and the CA certificate.
+func GenerateIssuedCert(key []byte) ([]byte, []byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, nil, fmt.Errorf("failed to decode private key")
+	}
+
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		Subject:               pkix.Name{CommonName: "root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Subject:      pkix.Name{CommonName: "localhost"},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca, &privateKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes})
+	return certPem, caPem, nil
+}
+
 func TestIsACMNotFound(t *testing.T) {
 	assert.False(t, isACMNotFound(nil))
 	assert.False(t, isACMNotFound(errors.New("plain")))
@@ -82,12 +137,35 @@ func TestIsACMNotFound(t *testing.T) {
 	assert.False(t, isACMNotFound(other))
 }
 
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want syncerrors.Category
+	}{
+		{awserr.New(acm.ErrCodeValidationException, "unsupported key", nil), syncerrors.InvalidInput},
+		{awserr.New("UnrecognizedClientException", "bad token", nil), syncerrors.Auth},
+		{awserr.New(acm.ErrCodeLimitExceededException, "too many certificates", nil), syncerrors.Quota},
+		{awserr.New(acm.ErrCodeThrottlingException, "slow down", nil), syncerrors.Transient},
+		{awserr.NewRequestFailure(awserr.New("Unexpected", "unavailable", nil), 503, "req"), syncerrors.Transient},
+		{awserr.New("Unexpected", "?", nil), syncerrors.Unknown},
+		{errors.New("plain"), syncerrors.Unknown},
+	} {
+		assert.Equal(t, tc.want, syncerrors.Classify(classify(tc.err)), tc.err.Error())
+	}
+}
+
 func TestACMDelete_NoOpWhenArnMissing(t *testing.T) {
 	// Sync never populated certificate-arn → nothing was created → success.
 	s := &ACMStore{}
 	assert.NoError(t, s.Delete(context.Background()))
 }
 
+func TestACMInspect_RequiresArn(t *testing.T) {
+	_, err := (&ACMStore{}).Inspect(context.Background())
+	assert.ErrorContains(t, err, "no acm certificate-arn recorded")
+	assert.ErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+}
+
 func TestACMSyncSecretNamespaceDefaulting(t *testing.T) {
 	oldClient := state.KubeClient
 	state.KubeClient = fake.NewSimpleClientset()
@@ -124,12 +202,12 @@ func TestACMSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &ACMStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{"secret-name": tc.secretName},
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName:  "source",
 				Namespace:   "cert-manager",
 				Certificate: cert,
@@ -155,9 +233,9 @@ func TestSeparateCertsACM(t *testing.T) {
 		t.Fatalf("Failed to generate cert: %v", err)
 	}
 
-	ca, _, err := GenerateCert(key)
+	issued, ca, err := GenerateIssuedCert(key)
 	if err != nil {
-		t.Fatalf("Failed to generate CA cert: %v", err)
+		t.Fatalf("Failed to generate issued cert: %v", err)
 	}
 
 	tests := []struct {
@@ -177,7 +255,14 @@ func TestSeparateCertsACM(t *testing.T) {
 		{
 			name:    "Valid certificate with CA",
 			ca:      ca,
-			crt:     cert,
+			crt:     issued,
+			key:     key,
+			wantErr: false,
+		},
+		{
+			name:    "CA repeated in certificate",
+			ca:      ca,
+			crt:     append(append([]byte{}, issued...), ca...),
 			key:     key,
 			wantErr: false,
 		},
@@ -185,9 +270,9 @@ func TestSeparateCertsACM(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := separateCertsACM(tt.ca, tt.crt, tt.key)
-			if got == nil {
-				t.Fatalf("Expected non-nil result")
+			got, err := separateCertsACM(&tlssecret.Certificate{Ca: tt.ca, Certificate: tt.crt, Key: tt.key})
+			if err != nil {
+				t.Fatalf("separateCertsACM() error = %v", err)
 			}
 
 			// Validate the leaf certificate
@@ -196,7 +281,7 @@ func TestSeparateCertsACM(t *testing.T) {
 				t.Errorf("Failed to decode certificate PEM block")
 				return
 			}
-			_, err := x509.ParseCertificate(certPEM.Bytes)
+			_, err = x509.ParseCertificate(certPEM.Bytes)
 			if err != nil {
 				t.Errorf("Failed to parse certificate: %v", err)
 				return
@@ -214,6 +299,11 @@ func TestSeparateCertsACM(t *testing.T) {
 					t.Errorf("Failed to parse certificate chain: %v", err)
 					return
 				}
+				if rest := bytes.TrimSpace(got.CertificateChain[len(pem.EncodeToMemory(chainPEM)):]); len(rest) > 0 {
+					t.Errorf("Certificate chain holds more than the CA: %q", rest)
+				}
+			} else if len(got.CertificateChain) > 0 {
+				t.Errorf("Expected an empty certificate chain, got %q", got.CertificateChain)
 			}
 		})
 	}
@@ -9,34 +9,63 @@ import (
 	"github.com/cloudflare/cloudflare-go/v5"
 	"github.com/cloudflare/cloudflare-go/v5/custom_certificates"
 	"github.com/cloudflare/cloudflare-go/v5/option"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type CloudflareStore struct {
-	SecretName      string
-	SecretNamespace string
-	ApiToken        string
-	ZoneId          string
-	CertId          string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	ApiToken          string
+	ZoneId            string
+	CertId            string
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "cloudflare",
+	Keys: []storeconfig.Key{
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_token."},
+		{Name: "secret-namespace", Description: "Namespace of secret-name."},
+		{Name: "zone-id", Required: true, Description: "Zone the certificate is uploaded to."},
+		{Name: "cert-id", Output: true, Description: "ID of the custom certificate, updated in place on renewal."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *CloudflareStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *CloudflareStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "cloudflare",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
 }
 
 func (s *CloudflareStore) GetApiToken(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
-		return fmt.Errorf("failed to get Cloudflare credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return err
 	}
-	if sc.Data["api_token"] == nil {
-		return fmt.Errorf("api_token not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err := creds.Require("api_token"); err != nil {
+		return err
 	}
-	s.ApiToken = string(sc.Data["api_token"])
+	s.ApiToken = creds.Get("api_token")
 	return nil
 }
 
-func (s *CloudflareStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *CloudflareStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -44,6 +73,12 @@ func (s *CloudflareStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["secret-namespace"] != "" {
 		s.SecretNamespace = c.Config["secret-namespace"]
 	}
@@ -67,7 +102,7 @@ func (s *CloudflareStore) setDefaultSecretNamespace(namespace string) {
 	}
 }
 
-func (s *CloudflareStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *CloudflareStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	s.setDefaultSecretNamespace(c.Namespace)
 	l := log.WithFields(log.Fields{
 		"action":          "Sync",
@@ -76,40 +111,43 @@ func (s *CloudflareStore) Sync(c *tlssecret.Certificate) (map[string]string, err
 		"secretNamespace": s.SecretNamespace,
 	})
 	l.Debugf("Update")
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return nil, fmt.Errorf("secret name not found in certificate annotations")
 	}
-	ctx := context.Background()
 	if err := s.GetApiToken(ctx); err != nil {
 		l.WithError(err).Errorf("GetApiToken error")
-		return nil, fmt.Errorf("failed to get Cloudflare API token from secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return nil, fmt.Errorf("failed to get Cloudflare API token from %s: %w", s.credentialRef(), err)
 	}
 	client := cloudflare.NewClient(option.WithAPIToken(s.ApiToken))
 
 	origCertId := s.CertId
 	var cert *custom_certificates.CustomCertificate
-	var err error
+	chain, err := c.Chain()
+	if err != nil {
+		l.WithError(err).Errorf("certificate chain error")
+		return nil, err
+	}
 	if s.CertId != "" {
 		// Update existing certificate
 		cert, err = client.CustomCertificates.Edit(ctx, s.CertId, custom_certificates.CustomCertificateEditParams{
 			ZoneID:      cloudflare.F(s.ZoneId),
-			Certificate: cloudflare.F(string(c.FullChain())),
+			Certificate: cloudflare.F(string(chain.PEM(tlssecret.IncludeRoot))),
 			PrivateKey:  cloudflare.F(string(c.Key)),
 		})
 		if err != nil {
 			l.WithError(err).Errorf("cloudflare.CustomCertificates.Edit error")
-			return nil, fmt.Errorf("failed to update certificate in Cloudflare (zone: %s, cert: %s): %w", s.ZoneId, s.CertId, err)
+			return nil, fmt.Errorf("failed to update certificate in Cloudflare (zone: %s, cert: %s): %w", s.ZoneId, s.CertId, classify(err))
 		}
 	} else {
 		// Create new certificate
 		cert, err = client.CustomCertificates.New(ctx, custom_certificates.CustomCertificateNewParams{
 			ZoneID:      cloudflare.F(s.ZoneId),
-			Certificate: cloudflare.F(string(c.FullChain())),
+			Certificate: cloudflare.F(string(chain.PEM(tlssecret.IncludeRoot))),
 			PrivateKey:  cloudflare.F(string(c.Key)),
 		})
 		if err != nil {
 			l.WithError(err).Errorf("cloudflare.CustomCertificates.New error")
-			return nil, fmt.Errorf("failed to create certificate in Cloudflare (zone: %s): %w", s.ZoneId, err)
+			return nil, fmt.Errorf("failed to create certificate in Cloudflare (zone: %s): %w", s.ZoneId, classify(err))
 		}
 	}
 	s.CertId = cert.ID
@@ -124,6 +162,36 @@ func (s *CloudflareStore) Sync(c *tlssecret.Certificate) (map[string]string, err
 	return newKeys, nil
 }
 
+// Inspect describes the custom certificate at CertId. Cloudflare does not
+// return the certificate itself, so only its expiry is reported: drift is
+// detected by expiry alone, and a write is never skipped for a certificate
+// the store may already hold, which takes a fingerprint.
+func (s *CloudflareStore) Inspect(ctx context.Context) (*tlssecret.RemoteCertificate, error) {
+	if s.CertId == "" {
+		return nil, fmt.Errorf("no cloudflare cert-id recorded: %w", tlssecret.ErrRemoteNotFound)
+	}
+	if s.ZoneId == "" {
+		return nil, fmt.Errorf("cloudflare zone-id not set; cannot inspect %s", s.CertId)
+	}
+	if !s.credentialRef().IsSet() {
+		return nil, fmt.Errorf("cloudflare secret-name not set; cannot resolve API token for inspect")
+	}
+	if err := s.GetApiToken(ctx); err != nil {
+		return nil, fmt.Errorf("cloudflare credentials lookup failed: %w", err)
+	}
+	client := cloudflare.NewClient(option.WithAPIToken(s.ApiToken))
+	cert, err := client.CustomCertificates.Get(ctx, s.CertId, custom_certificates.CustomCertificateGetParams{
+		ZoneID: cloudflare.F(s.ZoneId),
+	})
+	if isCloudflareNotFound(err) {
+		return nil, fmt.Errorf("inspect Cloudflare certificate %s (zone %s): %w", s.CertId, s.ZoneId, tlssecret.ErrRemoteNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get Cloudflare certificate %s (zone %s): %w", s.CertId, s.ZoneId, err)
+	}
+	return &tlssecret.RemoteCertificate{NotAfter: cert.ExpiresOn}, nil
+}
+
 // isCloudflareNotFound returns true when the error reports a 404 from the
 // Cloudflare API.
 func isCloudflareNotFound(err error) bool {
@@ -137,6 +205,15 @@ func isCloudflareNotFound(err error) bool {
 	return false
 }
 
+// classify classifies a Cloudflare API error by its HTTP status.
+func classify(err error) error {
+	var cfErr *cloudflare.Error
+	if errors.As(err, &cfErr) {
+		return syncerrors.FromHTTPStatus(cfErr.StatusCode, err)
+	}
+	return err
+}
+
 // Delete removes the custom certificate from Cloudflare. 404 responses are
 // treated as success so the operation is idempotent.
 func (s *CloudflareStore) Delete(ctx context.Context) error {
@@ -155,7 +232,7 @@ func (s *CloudflareStore) Delete(ctx context.Context) error {
 	if s.ZoneId == "" {
 		return fmt.Errorf("cloudflare zone-id not set; cannot delete %s", s.CertId)
 	}
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return fmt.Errorf("cloudflare secret-name not set; cannot resolve API token for delete")
 	}
 	if err := s.GetApiToken(ctx); err != nil {
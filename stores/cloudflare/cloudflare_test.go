@@ -7,6 +7,7 @@ import (
 
 	"github.com/cloudflare/cloudflare-go/v5"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -21,6 +22,13 @@ func TestIsCloudflareNotFound(t *testing.T) {
 	assert.False(t, isCloudflareNotFound(other))
 }
 
+func TestClassify(t *testing.T) {
+	assert.Equal(t, syncerrors.Auth, syncerrors.Classify(classify(&cloudflare.Error{StatusCode: 401})))
+	assert.Equal(t, syncerrors.InvalidInput, syncerrors.Classify(classify(&cloudflare.Error{StatusCode: 400})))
+	assert.Equal(t, syncerrors.Transient, syncerrors.Classify(classify(&cloudflare.Error{StatusCode: 503})))
+	assert.Equal(t, syncerrors.Unknown, syncerrors.Classify(classify(errors.New("plain"))))
+}
+
 func TestCloudflareDelete_NoOpWhenCertIdMissing(t *testing.T) {
 	// Sync never populated cert-id → nothing was created → success.
 	s := &CloudflareStore{ZoneId: "z", SecretName: "n"}
@@ -46,10 +54,29 @@ func TestCloudflareDelete_RequiresOtherConfigWhenCertIdSet(t *testing.T) {
 	}
 }
 
+func TestCloudflareInspect_RequiresConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		s        *CloudflareStore
+		notFound bool
+	}{
+		{name: "missing cert id", s: &CloudflareStore{ZoneId: "z", SecretName: "n"}, notFound: true},
+		{name: "missing zone id", s: &CloudflareStore{CertId: "c", SecretName: "n"}},
+		{name: "missing secret name", s: &CloudflareStore{CertId: "c", ZoneId: "z"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.s.Inspect(context.Background())
+			assert.Error(t, err)
+			assert.Equal(t, tc.notFound, errors.Is(err, tlssecret.ErrRemoteNotFound))
+		})
+	}
+}
+
 func TestCloudflareFromConfigParsesNamespacedSecretName(t *testing.T) {
 	s := &CloudflareStore{}
 
-	err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+	err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 		Config: map[string]string{
 			"secret-name": "istio-system/cloudflare-poc",
 			"zone-id":     "zone",
@@ -113,7 +140,7 @@ func TestCloudflareSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &CloudflareStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{
 					"secret-name": tc.secretName,
 					"zone-id":     "zone",
@@ -121,7 +148,7 @@ func TestCloudflareSyncSecretNamespaceDefaulting(t *testing.T) {
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
@@ -3,39 +3,67 @@ package digitalocean
 import (
 	"context"
 	"fmt"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/digitalocean/godo"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type DigitalOceanStore struct {
-	SecretName      string
-	SecretNamespace string
-	ApiKey          string
-	CertName        string
-	CertId          string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	ApiKey            string
+	CertName          string
+	CertId            string
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "digitalocean",
+	Keys: []storeconfig.Key{
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_key."},
+		{Name: "cert-name", Description: "Unique name of the certificate in DigitalOcean."},
+		{Name: "cert-id", Output: true, Description: "ID of the certificate, replaced on renewal."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *DigitalOceanStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *DigitalOceanStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "digitalocean",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
 }
 
 func (s *DigitalOceanStore) GetApiKey(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
-		return fmt.Errorf("failed to get DigitalOcean credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return err
 	}
-	if sc.Data["api_key"] == nil {
-		return fmt.Errorf("api_key not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err := creds.Require("api_key"); err != nil {
+		return err
 	}
-	s.ApiKey = string(sc.Data["api_key"])
+	s.ApiKey = creds.Get("api_key")
 	return nil
 }
 
-func (s *DigitalOceanStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *DigitalOceanStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -43,6 +71,12 @@ func (s *DigitalOceanStore) FromConfig(c tlssecret.GenericSecretSyncConfig) erro
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["cert-name"] != "" {
 		s.CertName = c.Config["cert-name"]
 	}
@@ -57,23 +91,19 @@ func (s *DigitalOceanStore) FromConfig(c tlssecret.GenericSecretSyncConfig) erro
 	return nil
 }
 
-func separateCertsDO(ca, crt, key []byte) *godo.CertificateRequest {
-	re := regexp.MustCompile(`(?s)(-----BEGIN CERTIFICATE-----.*?-----END CERTIFICATE-----)`)
-	certBlocks := re.FindAllString(string(crt), -1)
-	if len(certBlocks) == 0 {
-		return nil
-	}
-	nc := certBlocks[0]
-	ch := strings.Join(certBlocks[1:], "\n")
-	if len(ca) > 0 {
-		ch += "\n" + string(ca)
+// separateCertsDO splits the certificate into the leaf and its chain and
+// returns a godo CertificateRequest
+func separateCertsDO(c *tlssecret.Certificate) (*godo.CertificateRequest, error) {
+	ch, err := c.Chain()
+	if err != nil {
+		return nil, err
 	}
 	im := &godo.CertificateRequest{
-		CertificateChain: ch,
-		LeafCertificate:  nc,
-		PrivateKey:       string(key),
+		CertificateChain: string(ch.IssuersPEM(tlssecret.IncludeRoot)),
+		LeafCertificate:  string(ch.LeafPEM()),
+		PrivateKey:       string(c.Key),
 	}
-	return im
+	return im, nil
 }
 
 // isDigitalOceanNotFound returns true if the godo response indicates the cert is missing.
@@ -87,6 +117,15 @@ func isDigitalOceanNotFound(resp *godo.Response, err error) bool {
 	return false
 }
 
+// classify classifies a DigitalOcean API error by the HTTP status of its
+// response.
+func classify(resp *godo.Response, err error) error {
+	if resp == nil || resp.Response == nil {
+		return err
+	}
+	return syncerrors.FromHTTPStatus(resp.StatusCode, err)
+}
+
 // Delete removes the certificate from DigitalOcean. 404 responses are treated
 // as success so the operation is idempotent.
 func (s *DigitalOceanStore) Delete(ctx context.Context) error {
@@ -101,7 +140,7 @@ func (s *DigitalOceanStore) Delete(ctx context.Context) error {
 		l.Debug("no digitalocean cert-id recorded; nothing to delete")
 		return nil
 	}
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return fmt.Errorf("digitalocean secret-name not set; cannot resolve API key for delete")
 	}
 	if err := s.GetApiKey(ctx); err != nil {
@@ -121,7 +160,35 @@ func (s *DigitalOceanStore) Delete(ctx context.Context) error {
 	return nil
 }
 
-func (s *DigitalOceanStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+// Inspect describes the certificate currently stored at CertId. DigitalOcean
+// does not return the certificate itself, only its SHA-1 fingerprint.
+func (s *DigitalOceanStore) Inspect(ctx context.Context) (*tlssecret.RemoteCertificate, error) {
+	if s.CertId == "" {
+		return nil, fmt.Errorf("no digitalocean cert-id recorded: %w", tlssecret.ErrRemoteNotFound)
+	}
+	if !s.credentialRef().IsSet() {
+		return nil, fmt.Errorf("digitalocean secret-name not set; cannot resolve API key for inspect")
+	}
+	if err := s.GetApiKey(ctx); err != nil {
+		return nil, fmt.Errorf("digitalocean credentials lookup failed: %w", err)
+	}
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: s.ApiKey})
+	client := godo.NewClient(oauth2.NewClient(ctx, tokenSource))
+	cert, resp, err := client.Certificates.Get(ctx, s.CertId)
+	if isDigitalOceanNotFound(resp, err) {
+		return nil, fmt.Errorf("inspect DigitalOcean certificate %s: %w", s.CertId, tlssecret.ErrRemoteNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get DigitalOcean certificate %s: %w", s.CertId, err)
+	}
+	r := &tlssecret.RemoteCertificate{SHA1Fingerprint: cert.SHA1Fingerprint}
+	if notAfter, err := time.Parse(time.RFC3339, cert.NotAfter); err == nil {
+		r.NotAfter = notAfter
+	}
+	return r, nil
+}
+
+func (s *DigitalOceanStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -133,33 +200,36 @@ func (s *DigitalOceanStore) Sync(c *tlssecret.Certificate) (map[string]string, e
 		"secretNamespace": s.SecretNamespace,
 	})
 	l.Debugf("Update")
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return nil, fmt.Errorf("secret name not found in certificate annotations")
 	}
-	ctx := context.Background()
 	if err := s.GetApiKey(ctx); err != nil {
 		l.WithError(err).Errorf("GetApiKey error")
-		return nil, fmt.Errorf("failed to get DigitalOcean API key from secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return nil, fmt.Errorf("failed to get DigitalOcean API key from %s: %w", s.credentialRef(), err)
 	}
 	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: s.ApiKey})
-	oauthClient := oauth2.NewClient(context.Background(), tokenSource)
+	oauthClient := oauth2.NewClient(ctx, tokenSource)
 	client := godo.NewClient(oauthClient)
-	certRequest := separateCertsDO(c.Ca, c.Certificate, c.Key)
+	certRequest, err := separateCertsDO(c)
+	if err != nil {
+		l.WithError(err).Errorf("separateCertsDO error")
+		return nil, err
+	}
 	certRequest.Name = s.CertName
 	origCertId := s.CertId
 	if s.CertId != "" {
 		l.WithField("id", s.CertId).Debugf("deleting certificate")
-		_, err := client.Certificates.Delete(context.Background(), s.CertId)
+		resp, err := client.Certificates.Delete(ctx, s.CertId)
 		if err != nil {
 			l.WithError(err).Errorf("cannot delete certificate")
-			return nil, fmt.Errorf("failed to delete existing DigitalOcean certificate %s (id: %s): %w", s.CertName, s.CertId, err)
+			return nil, fmt.Errorf("failed to delete existing DigitalOcean certificate %s (id: %s): %w", s.CertName, s.CertId, classify(resp, err))
 		}
 		l.WithField("id", s.CertId).Debugf("certificate deleted")
 	}
-	certificate, _, err := client.Certificates.Create(context.Background(), certRequest)
+	certificate, resp, err := client.Certificates.Create(ctx, certRequest)
 	if err != nil {
 		l.WithError(err).Errorf("cannot create certificate")
-		return nil, fmt.Errorf("failed to create DigitalOcean certificate %s: %w", s.CertName, err)
+		return nil, fmt.Errorf("failed to create DigitalOcean certificate %s: %w", s.CertName, classify(resp, err))
 	}
 	l = l.WithField("id", certificate.ID)
 	s.CertId = certificate.ID
@@ -1,6 +1,7 @@
 package digitalocean
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/digitalocean/godo"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -73,6 +75,59 @@ func GenerateCert(key []byte) ([]byte, []byte, error) {
 	return certPem, key, nil
 }
 
+// GenerateIssuedCert generates a certificate for key issued by a new
+// self-signed CA, returning the certificate and the CA certificate.
+func GenerateIssuedCert(key []byte) ([]byte, []byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, nil, fmt.Errorf("failed to decode private key")
+	}
+
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		Subject:               pkix.Name{CommonName: "root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Subject:      pkix.Name{CommonName: "localhost"},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca, &privateKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes})
+	return certPem, caPem, nil
+}
+
 func TestIsDigitalOceanNotFound(t *testing.T) {
 	assert.False(t, isDigitalOceanNotFound(nil, nil))
 	assert.False(t, isDigitalOceanNotFound(nil, errors.New("plain")))
@@ -82,6 +137,15 @@ func TestIsDigitalOceanNotFound(t *testing.T) {
 	assert.False(t, isDigitalOceanNotFound(resp500, errors.New("boom")))
 }
 
+func TestClassify(t *testing.T) {
+	err := errors.New("failed")
+	assert.Same(t, err, classify(nil, err))
+	resp := &godo.Response{Response: &http.Response{StatusCode: 429}}
+	assert.Equal(t, syncerrors.Quota, syncerrors.Classify(classify(resp, err)))
+	resp = &godo.Response{Response: &http.Response{StatusCode: 422}}
+	assert.Equal(t, syncerrors.InvalidInput, syncerrors.Classify(classify(resp, err)))
+}
+
 func TestDigitalOceanDelete_NoOpWhenCertIdMissing(t *testing.T) {
 	// Sync never populated cert-id → nothing was created remotely → success.
 	s := &DigitalOceanStore{}
@@ -96,6 +160,15 @@ func TestDigitalOceanDelete_RequiresSecretNameWhenCertIdSet(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestDigitalOceanInspect_RequiresConfig(t *testing.T) {
+	_, err := (&DigitalOceanStore{}).Inspect(context.Background())
+	assert.ErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+
+	_, err = (&DigitalOceanStore{CertId: "abc"}).Inspect(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+}
+
 func TestDigitalOceanSyncSecretNamespaceDefaulting(t *testing.T) {
 	oldClient := state.KubeClient
 	state.KubeClient = fake.NewSimpleClientset()
@@ -127,12 +200,12 @@ func TestDigitalOceanSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &DigitalOceanStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{"secret-name": tc.secretName},
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
@@ -156,9 +229,9 @@ func TestSeparateCertsDO(t *testing.T) {
 		t.Fatalf("Failed to generate cert: %v", err)
 	}
 
-	ca, _, err := GenerateCert(key)
+	issued, ca, err := GenerateIssuedCert(key)
 	if err != nil {
-		t.Fatalf("Failed to generate CA cert: %v", err)
+		t.Fatalf("Failed to generate issued cert: %v", err)
 	}
 
 	tests := []struct {
@@ -178,7 +251,14 @@ func TestSeparateCertsDO(t *testing.T) {
 		{
 			name:    "Valid certificate with CA",
 			ca:      ca,
-			crt:     cert,
+			crt:     issued,
+			key:     key,
+			wantErr: false,
+		},
+		{
+			name:    "CA repeated in certificate",
+			ca:      ca,
+			crt:     append(append([]byte{}, issued...), ca...),
 			key:     key,
 			wantErr: false,
 		},
@@ -186,9 +266,9 @@ func TestSeparateCertsDO(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := separateCertsDO(tt.ca, tt.crt, tt.key)
-			if got == nil {
-				t.Fatalf("Expected non-nil result")
+			got, err := separateCertsDO(&tlssecret.Certificate{Ca: tt.ca, Certificate: tt.crt, Key: tt.key})
+			if err != nil {
+				t.Fatalf("separateCertsDO() error = %v", err)
 			}
 
 			// Validate the leaf certificate
@@ -197,7 +277,7 @@ func TestSeparateCertsDO(t *testing.T) {
 				t.Errorf("Failed to decode certificate PEM block")
 				return
 			}
-			_, err := x509.ParseCertificate(certPEM.Bytes)
+			_, err = x509.ParseCertificate(certPEM.Bytes)
 			if err != nil {
 				t.Errorf("Failed to parse certificate: %v", err)
 				return
@@ -215,6 +295,11 @@ func TestSeparateCertsDO(t *testing.T) {
 					t.Errorf("Failed to parse certificate chain: %v", err)
 					return
 				}
+				if rest := bytes.TrimSpace([]byte(got.CertificateChain)[len(pem.EncodeToMemory(chainPEM)):]); len(rest) > 0 {
+					t.Errorf("Certificate chain holds more than the CA: %q", rest)
+				}
+			} else if len(got.CertificateChain) > 0 {
+				t.Errorf("Expected an empty certificate chain, got %q", got.CertificateChain)
 			}
 		})
 	}
@@ -8,6 +8,7 @@ import (
 
 	fp "path/filepath"
 
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,7 +20,23 @@ type FilepathStore struct {
 	KeyFile   string
 }
 
-func (s *FilepathStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "filepath",
+	Keys: []storeconfig.Key{
+		{Name: "dir", Required: true, Description: "Directory the files are written to."},
+		{Name: "cert", Default: "tls.crt", Description: "File name of the certificate."},
+		{Name: "key", Default: "tls.key", Description: "File name of the private key."},
+		{Name: "ca", Default: "ca.crt", Description: "File name of the CA certificate."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *FilepathStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+func (s *FilepathStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -45,7 +62,7 @@ func (s *FilepathStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	return nil
 }
 
-func (s *FilepathStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *FilepathStore) Sync(_ context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	l := log.WithFields(log.Fields{
 		"action":          "Sync",
 		"store":           "filepath",
@@ -126,3 +143,32 @@ func (s *FilepathStore) Delete(_ context.Context) error {
 	l.Info("certificate files removed")
 	return nil
 }
+
+// Inspect describes the certificate and CA files written by Sync.
+func (s *FilepathStore) Inspect(_ context.Context) (*tlssecret.RemoteCertificate, error) {
+	if s.Directory == "" {
+		return nil, fmt.Errorf("filepath-dir annotation is required")
+	}
+	cert := s.CertFile
+	if cert == "" {
+		cert = "tls.crt"
+	}
+	path := fp.Join(s.Directory, cert)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, tlssecret.ErrRemoteNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	ca := s.CAFile
+	if ca == "" {
+		ca = "ca.crt"
+	}
+	caPath := fp.Join(s.Directory, ca)
+	caData, err := os.ReadFile(caPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", caPath, err)
+	}
+	return tlssecret.InspectPEM(data, caData)
+}
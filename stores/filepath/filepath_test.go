@@ -2,10 +2,19 @@ package filepath
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"os"
 	fp "path/filepath"
 	"testing"
+	"time"
 
+	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -75,3 +84,28 @@ func TestDelete_ErrorsOnPermissionDenied(t *testing.T) {
 	// On most systems removing a file from a read-only dir fails with EACCES.
 	assert.Error(t, err)
 }
+
+func TestInspect(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	s := &FilepathStore{Directory: dir, CertFile: "my.crt"}
+	_, err = s.Inspect(context.Background())
+	assert.ErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+
+	require.NoError(t, os.WriteFile(fp.Join(dir, "my.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	r, err := s.Inspect(context.Background())
+	require.NoError(t, err)
+	assert.True(t, r.Matches(cert))
+}
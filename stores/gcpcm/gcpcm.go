@@ -2,50 +2,88 @@ package gcpcm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	certificatemanager "cloud.google.com/go/certificatemanager/apiv1"
 	"cloud.google.com/go/certificatemanager/apiv1/certificatemanagerpb"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 	"google.golang.org/genproto/protobuf/field_mask"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type GCPStore struct {
-	CertificateName string
-	ProjectID       string
-	Location        string
-	SecretName      string
-	SecretNamespace string
-	CredentialsJSON string
+	CertificateName   string
+	ProjectID         string
+	Location          string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	CredentialsJSON   string
 	// unexported
 	client *certificatemanager.Client
 }
 
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "gcp",
+	Keys: []storeconfig.Key{
+		{Name: "project", Required: true, Description: "GCP project of the certificate."},
+		{Name: "location", Required: true, Description: "Certificate Manager location, such as global."},
+		{Name: "certificate-name", Output: true, Description: "Full resource name of the certificate, updated in place on renewal."},
+		{Name: "secret-name", SecretRef: true, Description: "Secret holding GOOGLE_APPLICATION_CREDENTIALS. Not needed with Workload Identity."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *GCPStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *GCPStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "gcp",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
+}
+
+// GetApiKey loads the service account JSON. A credential profile without a
+// secret leaves CredentialsJSON empty so the ambient credentials are used.
 func (s *GCPStore) GetApiKey(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
+	if errors.Is(err, credentials.ErrNoSecret) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get GCP credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return fmt.Errorf("failed to get GCP credentials from %s: %w", s.credentialRef(), err)
 	}
-	if sc.Data["GOOGLE_APPLICATION_CREDENTIALS"] == nil {
-		return fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err := creds.Require("GOOGLE_APPLICATION_CREDENTIALS"); err != nil {
+		return err
 	}
-	s.CredentialsJSON = string(sc.Data["GOOGLE_APPLICATION_CREDENTIALS"])
+	s.CredentialsJSON = creds.Get("GOOGLE_APPLICATION_CREDENTIALS")
 	return nil
 }
 
 // secretToGCPInput converts a k8s secret to a properly-formatted GCP Import object
-func (s *GCPStore) certToGCPCert(c *tlssecret.Certificate) *certificatemanagerpb.Certificate {
-	fullChain := c.FullChain()
+func (s *GCPStore) certToGCPCert(c *tlssecret.Certificate) (*certificatemanagerpb.Certificate, error) {
+	chain, err := c.Chain()
+	if err != nil {
+		return nil, err
+	}
 	sm_cert := &certificatemanagerpb.Certificate_SelfManagedCertificate{
-		PemCertificate: string(fullChain),
+		PemCertificate: string(chain.PEM(tlssecret.IncludeRoot)),
 		PemPrivateKey:  string(c.Key),
 	}
 	if s.CertificateName == "" {
@@ -53,10 +91,10 @@ func (s *GCPStore) certToGCPCert(c *tlssecret.Certificate) *certificatemanagerpb
 	}
 	return &certificatemanagerpb.Certificate{
 		Name: s.CertificateName,
-		Type: &certificatemanagerpb.Certificate_SelfManaged{SelfManaged: sm_cert}}
+		Type: &certificatemanagerpb.Certificate_SelfManaged{SelfManaged: sm_cert}}, nil
 }
 
-func (s *GCPStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *GCPStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -73,6 +111,12 @@ func (s *GCPStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	// if secret name is in the format of "namespace/secretname" then parse it
 	if strings.Contains(s.SecretName, "/") {
 		s.SecretNamespace = strings.Split(s.SecretName, "/")[0]
@@ -97,13 +141,13 @@ func (s *GCPStore) CreateCert(ctx context.Context, gcert *certificatemanagerpb.C
 	if err != nil {
 		// TODO: Handle error.
 		l.Errorf("cannot create cert because of %s", err)
-		return fmt.Errorf("failed to create GCP certificate in project %s, location %s: %w", s.ProjectID, s.Location, err)
+		return fmt.Errorf("failed to create GCP certificate in project %s, location %s: %w", s.ProjectID, s.Location, classify(err))
 	}
 	resp, err := op.Wait(ctx)
 	if err != nil {
 		// TODO: Handle error.
 		l.Errorf("cannot complete creating cert because of %s", err)
-		return fmt.Errorf("failed to complete GCP certificate creation (project: %s, location: %s): %w", s.ProjectID, s.Location, err)
+		return fmt.Errorf("failed to complete GCP certificate creation (project: %s, location: %s): %w", s.ProjectID, s.Location, classify(err))
 	}
 	l.WithField("name", resp.Name).Debugf("Cert created in GCP as %s", resp.Name)
 	s.CertificateName = resp.Name
@@ -124,12 +168,12 @@ func (s *GCPStore) UpdateCert(ctx context.Context, gcert *certificatemanagerpb.C
 	op, err := s.client.UpdateCertificate(ctx, req)
 	if err != nil {
 		l.Errorf("cannot update cert because of %s", err)
-		return fmt.Errorf("failed to update GCP certificate %s (project: %s, location: %s): %w", s.CertificateName, s.ProjectID, s.Location, err)
+		return fmt.Errorf("failed to update GCP certificate %s (project: %s, location: %s): %w", s.CertificateName, s.ProjectID, s.Location, classify(err))
 	}
 	resp, err := op.Wait(ctx)
 	if err != nil {
 		l.Errorf("cannot complete updating cert because of %s", err)
-		return fmt.Errorf("failed to complete GCP certificate update for %s (project: %s, location: %s): %w", s.CertificateName, s.ProjectID, s.Location, err)
+		return fmt.Errorf("failed to complete GCP certificate update for %s (project: %s, location: %s): %w", s.CertificateName, s.ProjectID, s.Location, classify(err))
 	}
 	l.WithField("name", resp.Name).Debugf("Cert updated in GCP as %s", resp.Name)
 	return nil
@@ -144,6 +188,23 @@ func isGCPNotFound(err error) bool {
 	return status.Code(err) == codes.NotFound
 }
 
+// classify classifies an error by its gRPC status code.
+func classify(err error) error {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return syncerrors.New(syncerrors.Auth, err)
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.AlreadyExists, codes.OutOfRange:
+		return syncerrors.New(syncerrors.InvalidInput, err)
+	case codes.NotFound:
+		return syncerrors.New(syncerrors.NotFound, err)
+	case codes.ResourceExhausted:
+		return syncerrors.New(syncerrors.Quota, err)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+		return syncerrors.New(syncerrors.Transient, err)
+	}
+	return err
+}
+
 // Delete removes the certificate from GCP Certificate Manager. Treats
 // NotFound as success so the operation is idempotent.
 func (s *GCPStore) Delete(ctx context.Context) error {
@@ -159,10 +220,12 @@ func (s *GCPStore) Delete(ctx context.Context) error {
 		return nil
 	}
 	var clientOpts []option.ClientOption
-	if s.SecretName != "" {
+	if s.credentialRef().IsSet() {
 		if err := s.GetApiKey(ctx); err != nil {
 			return fmt.Errorf("gcp credentials lookup failed: %w", err)
 		}
+	}
+	if s.CredentialsJSON != "" {
 		clientOpts = append(clientOpts, option.WithCredentialsJSON([]byte(s.CredentialsJSON)))
 	}
 	client, err := certificatemanager.NewClient(ctx, clientOpts...)
@@ -191,7 +254,7 @@ func (s *GCPStore) Delete(ctx context.Context) error {
 	return nil
 }
 
-func (s *GCPStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *GCPStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -203,14 +266,19 @@ func (s *GCPStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
 	})
 	l.Debugf("Update")
 	isNewCert := s.CertificateName == ""
-	gcert := s.certToGCPCert(c)
-	ctx := context.Background()
 	var clientOpts []option.ClientOption
-	if s.SecretName != "" {
+	if s.credentialRef().IsSet() {
 		if err := s.GetApiKey(ctx); err != nil {
 			l.WithError(err).Errorf("gcp.GetApiKey error")
 			return nil, err
 		}
+	}
+	gcert, err := s.certToGCPCert(c)
+	if err != nil {
+		l.WithError(err).Errorf("certToGCPCert error")
+		return nil, err
+	}
+	if s.CredentialsJSON != "" {
 		opt := option.WithCredentialsJSON([]byte(s.CredentialsJSON))
 		clientOpts = append(clientOpts, opt)
 	}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
@@ -20,6 +21,14 @@ func TestIsGCPNotFound(t *testing.T) {
 	assert.False(t, isGCPNotFound(status.Error(codes.Internal, "boom")))
 }
 
+func TestClassify(t *testing.T) {
+	assert.Equal(t, syncerrors.Auth, syncerrors.Classify(classify(status.Error(codes.PermissionDenied, "denied"))))
+	assert.Equal(t, syncerrors.InvalidInput, syncerrors.Classify(classify(status.Error(codes.InvalidArgument, "bad pem"))))
+	assert.Equal(t, syncerrors.Quota, syncerrors.Classify(classify(status.Error(codes.ResourceExhausted, "quota"))))
+	assert.Equal(t, syncerrors.Transient, syncerrors.Classify(classify(status.Error(codes.Unavailable, "down"))))
+	assert.Equal(t, syncerrors.Unknown, syncerrors.Classify(classify(errors.New("plain"))))
+}
+
 func TestDelete_NoOpWhenCertificateNameMissing(t *testing.T) {
 	// Sync never populated certificate-name → nothing was created → success.
 	s := &GCPStore{}
@@ -57,7 +66,7 @@ func TestGCPSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &GCPStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{
 					"secret-name": tc.secretName,
 					"project":     "project",
@@ -66,7 +75,7 @@ func TestGCPSyncSecretNamespaceDefaulting(t *testing.T) {
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
@@ -8,34 +8,62 @@ import (
 	"strings"
 
 	heroku "github.com/heroku/heroku-go/v5"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type HerokuStore struct {
-	SecretName      string
-	SecretNamespace string
-	ApiKey          string
-	AppName         string
-	CertName        string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	ApiKey            string
+	AppName           string
+	CertName          string
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "heroku",
+	Keys: []storeconfig.Key{
+		{Name: "app", Required: true, Description: "Heroku app the SNI endpoint belongs to."},
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_key."},
+		{Name: "cert-name", Output: true, Description: "Name of the SNI endpoint, updated in place on renewal."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *HerokuStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *HerokuStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "heroku",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
 }
 
 func (s *HerokuStore) GetApiKey(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
 		return err
 	}
-	if sc.Data["api_key"] == nil {
-		return fmt.Errorf("api_key not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+	if err := creds.Require("api_key"); err != nil {
+		return err
 	}
-	s.ApiKey = string(sc.Data["api_key"])
+	s.ApiKey = creds.Get("api_key")
 	return nil
 }
 
-func (s *HerokuStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *HerokuStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -43,6 +71,12 @@ func (s *HerokuStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["app"] != "" {
 		s.AppName = c.Config["app"]
 	}
@@ -57,7 +91,7 @@ func (s *HerokuStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	return nil
 }
 
-func (s *HerokuStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *HerokuStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -69,41 +103,45 @@ func (s *HerokuStore) Sync(c *tlssecret.Certificate) (map[string]string, error)
 		"secretNamespace": s.SecretNamespace,
 	})
 	l.Debugf("Update")
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return nil, fmt.Errorf("secret name not found in certificate annotations")
 	}
-	ctx := context.Background()
 	if err := s.GetApiKey(ctx); err != nil {
 		l.WithError(err).Errorf("GetApiKey error")
-		return nil, fmt.Errorf("failed to get Heroku API key from secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return nil, fmt.Errorf("failed to get Heroku API key from %s: %w", s.credentialRef(), err)
 	}
 	client := heroku.NewService(&http.Client{
 		Transport: &heroku.Transport{
 			BearerToken: s.ApiKey,
 		},
 	})
+	chain, err := c.Chain()
+	if err != nil {
+		l.WithError(err).Errorf("certificate chain error")
+		return nil, err
+	}
 	origCertName := s.CertName
 	if s.CertName == "" {
 		sniOpts := heroku.SniEndpointCreateOpts{
-			CertificateChain: string(c.FullChain()),
+			CertificateChain: string(chain.PEM(tlssecret.IncludeRoot)),
 			PrivateKey:       string(c.Key),
 		}
 		ep, err := client.SniEndpointCreate(ctx, s.AppName, sniOpts)
 		if err != nil {
 			l.WithError(err).Errorf("heroku.SniEndpointCreate error")
-			return nil, fmt.Errorf("failed to create Heroku SNI endpoint for app %s: %w", s.AppName, err)
+			return nil, fmt.Errorf("failed to create Heroku SNI endpoint for app %s: %w", s.AppName, classify(err))
 		}
 		l.Debugf("heroku.SniEndpointCreate success: %s", ep.Name)
 		s.CertName = ep.Name
 	} else {
 		sniOpts := heroku.SniEndpointUpdateOpts{
-			CertificateChain: string(c.FullChain()),
+			CertificateChain: string(chain.PEM(tlssecret.IncludeRoot)),
 			PrivateKey:       string(c.Key),
 		}
 		ep, err := client.SniEndpointUpdate(ctx, s.AppName, s.CertName, sniOpts)
 		if err != nil {
 			l.WithError(err).Errorf("heroku.SniEndpointUpdate error")
-			return nil, fmt.Errorf("failed to update Heroku SNI endpoint %s for app %s: %w", s.CertName, s.AppName, err)
+			return nil, fmt.Errorf("failed to update Heroku SNI endpoint %s for app %s: %w", s.CertName, s.AppName, classify(err))
 		}
 		l.Debugf("heroku.SniEndpointUpdate success: %s", ep.Name)
 		s.CertName = ep.Name
@@ -133,6 +171,15 @@ func isHerokuNotFound(err error) bool {
 	return false
 }
 
+// classify classifies a Heroku API error by its HTTP status.
+func classify(err error) error {
+	var he heroku.Error
+	if errors.As(err, &he) {
+		return syncerrors.FromHTTPStatus(he.StatusCode, err)
+	}
+	return err
+}
+
 // Delete removes the SNI endpoint from the Heroku app. 404 responses are
 // treated as success so the operation is idempotent.
 func (s *HerokuStore) Delete(ctx context.Context) error {
@@ -152,7 +199,7 @@ func (s *HerokuStore) Delete(ctx context.Context) error {
 	if s.AppName == "" {
 		return fmt.Errorf("heroku app not set; cannot delete %s", s.CertName)
 	}
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return fmt.Errorf("heroku secret-name not set; cannot resolve API key for delete")
 	}
 	if err := s.GetApiKey(ctx); err != nil {
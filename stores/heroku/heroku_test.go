@@ -7,6 +7,7 @@ import (
 
 	heroku "github.com/heroku/heroku-go/v5"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -21,6 +22,12 @@ func TestIsHerokuNotFound(t *testing.T) {
 	assert.False(t, isHerokuNotFound(other))
 }
 
+func TestClassify(t *testing.T) {
+	assert.Equal(t, syncerrors.Auth, syncerrors.Classify(classify(heroku.Error{StatusCode: 401})))
+	assert.Equal(t, syncerrors.InvalidInput, syncerrors.Classify(classify(heroku.Error{StatusCode: 422})))
+	assert.Equal(t, syncerrors.Unknown, syncerrors.Classify(classify(errors.New("plain"))))
+}
+
 func TestHerokuDelete_NoOpWhenCertNameMissing(t *testing.T) {
 	// Sync never populated cert-name → no SNI endpoint exists → success.
 	s := &HerokuStore{AppName: "a", SecretName: "n"}
@@ -74,7 +81,7 @@ func TestHerokuSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &HerokuStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{
 					"secret-name": tc.secretName,
 					"app":         "app",
@@ -82,7 +89,7 @@ func TestHerokuSyncSecretNamespaceDefaulting(t *testing.T) {
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
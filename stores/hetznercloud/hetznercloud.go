@@ -7,40 +7,69 @@ import (
 	"strings"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type HetznerCloudStore struct {
-	SecretName      string
-	SecretNamespace string
-	ApiToken        string
-	CertName        string
-	CertId          int64
-	Labels          map[string]string
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	ApiToken          string
+	CertName          string
+	CertId            int64
+	Labels            map[string]string
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "hetznercloud",
+	Keys: []storeconfig.Key{
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_token."},
+		{Name: "cert-name", Description: "Name of the certificate in Hetzner Cloud. Defaults to the secret name."},
+		{Name: "cert-id", Type: storeconfig.Int, Output: true, Description: "ID of the certificate, replaced on renewal."},
+		{Name: "label-", Prefix: true, Description: "Labels set on the certificate, such as label-environment."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *HetznerCloudStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *HetznerCloudStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "hetznercloud",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
 }
 
 func (s *HetznerCloudStore) GetApiToken(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
-		return fmt.Errorf("failed to get Hetzner Cloud credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
-	}
-	if sc.Data["api_token"] == nil && sc.Data["token"] == nil {
-		return fmt.Errorf("api_token or token not found in secret %s/%s", s.SecretNamespace, s.SecretName)
+		return err
 	}
 	// Support both "api_token" and "token" for compatibility
-	if sc.Data["api_token"] != nil {
-		s.ApiToken = string(sc.Data["api_token"])
-	} else {
-		s.ApiToken = string(sc.Data["token"])
+	switch {
+	case creds.Has("api_token"):
+		s.ApiToken = creds.Get("api_token")
+	case creds.Has("token"):
+		s.ApiToken = creds.Get("token")
+	default:
+		return fmt.Errorf("api_token or token not found in secret %s/%s", creds.SecretNamespace, creds.SecretName)
 	}
 	return nil
 }
 
-func (s *HetznerCloudStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *HetznerCloudStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 		"store":  "hetznercloud",
@@ -50,6 +79,12 @@ func (s *HetznerCloudStore) FromConfig(c tlssecret.GenericSecretSyncConfig) erro
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["cert-name"] != "" {
 		s.CertName = c.Config["cert-name"]
 	}
@@ -80,7 +115,7 @@ func (s *HetznerCloudStore) FromConfig(c tlssecret.GenericSecretSyncConfig) erro
 	return nil
 }
 
-func (s *HetznerCloudStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *HetznerCloudStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -93,11 +128,10 @@ func (s *HetznerCloudStore) Sync(c *tlssecret.Certificate) (map[string]string, e
 	})
 	l.Debugf("Update")
 
-	if s.SecretName == "" && s.ApiToken == "" {
+	if !s.credentialRef().IsSet() && s.ApiToken == "" {
 		return nil, fmt.Errorf("secret name not found in certificate annotations")
 	}
 
-	ctx := context.Background()
 	// Only get API token from K8s secret if not already set (e.g., for testing)
 	if s.ApiToken == "" {
 		if err := s.GetApiToken(ctx); err != nil {
@@ -160,7 +194,7 @@ func (s *HetznerCloudStore) Sync(c *tlssecret.Certificate) (map[string]string, e
 	cert, _, err := client.Certificate.Create(ctx, createOpts)
 	if err != nil {
 		l.WithError(err).Errorf("failed to create certificate")
-		return nil, err
+		return nil, classify(err)
 	}
 
 	l = l.WithField("id", cert.ID)
@@ -178,6 +212,47 @@ func (s *HetznerCloudStore) Sync(c *tlssecret.Certificate) (map[string]string, e
 	return newKeys, nil
 }
 
+// classify classifies a Hetzner Cloud API error by its error code.
+func classify(err error) error {
+	switch {
+	case hcloud.IsError(err, hcloud.ErrorCodeUnauthorized, hcloud.ErrorCodeForbidden):
+		return syncerrors.New(syncerrors.Auth, err)
+	case hcloud.IsError(err, hcloud.ErrorCodeInvalidInput, hcloud.ErrorCodeUniquenessError):
+		return syncerrors.New(syncerrors.InvalidInput, err)
+	case hcloud.IsError(err, hcloud.ErrorCodeNotFound):
+		return syncerrors.New(syncerrors.NotFound, err)
+	case hcloud.IsError(err, hcloud.ErrorCodeRateLimitExceeded):
+		return syncerrors.New(syncerrors.Quota, err)
+	case hcloud.IsError(err, hcloud.ErrorCodeServiceError, hcloud.ErrorCodeTimeout, hcloud.ErrorCodeMaintenance, hcloud.ErrorCodeConflict, hcloud.ErrorCodeLocked):
+		return syncerrors.New(syncerrors.Transient, err)
+	}
+	return err
+}
+
+// Inspect describes the certificate currently stored at CertId.
+func (s *HetznerCloudStore) Inspect(ctx context.Context) (*tlssecret.RemoteCertificate, error) {
+	if s.CertId == 0 {
+		return nil, fmt.Errorf("no hetznercloud cert-id recorded: %w", tlssecret.ErrRemoteNotFound)
+	}
+	if s.ApiToken == "" {
+		if !s.credentialRef().IsSet() {
+			return nil, fmt.Errorf("hetznercloud secret-name not set; cannot resolve API token for inspect")
+		}
+		if err := s.GetApiToken(ctx); err != nil {
+			return nil, fmt.Errorf("hetznercloud credentials lookup failed: %w", err)
+		}
+	}
+	client := hcloud.NewClient(hcloud.WithToken(s.ApiToken))
+	cert, _, err := client.Certificate.GetByID(ctx, s.CertId)
+	if err != nil {
+		return nil, fmt.Errorf("get Hetzner certificate %d: %w", s.CertId, err)
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("inspect Hetzner certificate %d: %w", s.CertId, tlssecret.ErrRemoteNotFound)
+	}
+	return tlssecret.InspectPEM([]byte(cert.Certificate), nil)
+}
+
 // Delete removes the certificate from Hetzner Cloud. NotFound responses are
 // treated as success so the operation is idempotent.
 func (s *HetznerCloudStore) Delete(ctx context.Context) error {
@@ -193,7 +268,7 @@ func (s *HetznerCloudStore) Delete(ctx context.Context) error {
 		return nil
 	}
 	if s.ApiToken == "" {
-		if s.SecretName == "" {
+		if !s.credentialRef().IsSet() {
 			return fmt.Errorf("hetznercloud secret-name not set; cannot resolve API token for delete")
 		}
 		if err := s.GetApiToken(ctx); err != nil {
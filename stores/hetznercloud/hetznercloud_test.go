@@ -17,6 +17,7 @@ import (
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -100,12 +101,12 @@ func TestHetznerCloudSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &HetznerCloudStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{"secret-name": tc.secretName},
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
@@ -118,6 +119,30 @@ func TestHetznerCloudSyncSecretNamespaceDefaulting(t *testing.T) {
 	}
 }
 
+func TestHetznerInspect_RequiresConfig(t *testing.T) {
+	_, err := (&HetznerCloudStore{}).Inspect(context.Background())
+	assert.ErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+
+	_, err = (&HetznerCloudStore{CertId: 5}).Inspect(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, tlssecret.ErrRemoteNotFound)
+}
+
+func TestClassify(t *testing.T) {
+	for code, want := range map[hcloud.ErrorCode]syncerrors.Category{
+		hcloud.ErrorCodeUnauthorized:      syncerrors.Auth,
+		hcloud.ErrorCodeInvalidInput:      syncerrors.InvalidInput,
+		hcloud.ErrorCodeUniquenessError:   syncerrors.InvalidInput,
+		hcloud.ErrorCodeRateLimitExceeded: syncerrors.Quota,
+		hcloud.ErrorCodeServiceError:      syncerrors.Transient,
+	} {
+		err := classify(hcloud.Error{Code: code, Message: "failed"})
+		assert.Equal(t, want, syncerrors.Classify(err), code)
+		assert.True(t, hcloud.IsError(err, code), "the hcloud error is kept")
+	}
+	assert.Equal(t, syncerrors.Unknown, syncerrors.Classify(classify(fmt.Errorf("plain"))))
+}
+
 // TestIntegrationSyncWithLabels tests the full sync process with labels using a real Hetzner Cloud API
 // This test is skipped by default and only runs when HETZNER_TEST_TOKEN is set
 func TestIntegrationSyncWithLabels(t *testing.T) {
@@ -199,7 +224,7 @@ func TestIntegrationSyncWithLabels(t *testing.T) {
 			}
 
 			// Sync certificate
-			updates, err := s.Sync(c)
+			updates, err := s.Sync(context.Background(), c)
 			if tc.valid && err != nil {
 				t.Fatalf("Expected successful sync with labels %v, but got error: %v", tc.labels, err)
 			}
@@ -274,7 +299,7 @@ func TestIntegrationSync(t *testing.T) {
 	}
 
 	// Sync certificate
-	updates, err := s.Sync(c)
+	updates, err := s.Sync(context.Background(), c)
 	if err != nil {
 		t.Fatalf("Sync failed: %v", err)
 	}
@@ -434,7 +459,7 @@ func TestIntegrationCertificateInUse(t *testing.T) {
 	}
 
 	// Sync initial certificate
-	updates1, err := s.Sync(c1)
+	updates1, err := s.Sync(context.Background(), c1)
 	if err != nil {
 		t.Fatalf("Failed to sync initial certificate: %v", err)
 	}
@@ -571,7 +596,7 @@ func TestIntegrationCertificateInUse(t *testing.T) {
 	}
 
 	t.Log("Attempting to update certificate while it's attached to Load Balancer...")
-	updates2, err := s.Sync(c2)
+	updates2, err := s.Sync(context.Background(), c2)
 	if err != nil {
 		t.Fatalf("Failed to sync updated certificate: %v", err)
 	}
@@ -666,7 +691,7 @@ func TestIntegrationCertificateUpdate(t *testing.T) {
 	}
 
 	// Sync initial certificate
-	updates1, err := s.Sync(c1)
+	updates1, err := s.Sync(context.Background(), c1)
 	if err != nil {
 		t.Fatalf("Failed to sync initial certificate: %v", err)
 	}
@@ -688,7 +713,7 @@ func TestIntegrationCertificateUpdate(t *testing.T) {
 	}
 
 	t.Log("Attempting to update certificate (not in use)...")
-	updates2, err := s.Sync(c2)
+	updates2, err := s.Sync(context.Background(), c2)
 	if err != nil {
 		t.Fatalf("Failed to sync updated certificate: %v", err)
 	}
@@ -11,36 +11,64 @@ import (
 	"net/url"
 	"strings"
 
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type ImpervaStore struct {
-	ID              string `json:"api_id"`
-	SiteID          string `json:"site_id"`
-	Key             string `json:"api_key"`
-	AuthType        string `json:"auth_type"`
-	SecretName      string
-	SecretNamespace string
+	ID                string `json:"api_id"`
+	SiteID            string `json:"site_id"`
+	Key               string `json:"api_key"`
+	AuthType          string `json:"auth_type"`
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "imperva",
+	Keys: []storeconfig.Key{
+		{Name: "site-id", Required: true, Description: "Imperva site the certificate is attached to."},
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_id and api_key."},
+		{Name: "auth-type", Default: "RSA", Description: "Authentication type of the certificate, RSA or ECC."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *ImpervaStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
+// credentialRef returns the store's reference to its credentials.
+func (s *ImpervaStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "imperva",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
 }
 
 func (s *ImpervaStore) GetApiKey(ctx context.Context) error {
-	gopt := metav1.GetOptions{}
-	if s.SecretName == "" {
+	if !s.credentialRef().IsSet() {
 		return fmt.Errorf("secret name not set")
 	}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
-		return fmt.Errorf("failed to get Imperva credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return fmt.Errorf("failed to get Imperva credentials from %s: %w", s.credentialRef(), err)
 	}
-	s.ID = string(sc.Data["api_id"])
-	s.Key = string(sc.Data["api_key"])
+	s.ID = creds.Get("api_id")
+	s.Key = creds.Get("api_key")
 	return nil
 }
 
-func (s *ImpervaStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *ImpervaStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -51,6 +79,12 @@ func (s *ImpervaStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["auth-type"] != "" {
 		s.AuthType = c.Config["auth-type"]
 	} else {
@@ -78,7 +112,7 @@ type ImpervaCertUpload struct {
 }
 
 // UploadImpervaCert syncs a certificate with Imperva site
-func (s *ImpervaStore) UploadImpervaCert(cert *tlssecret.Certificate) error {
+func (s *ImpervaStore) UploadImpervaCert(ctx context.Context, cert *tlssecret.Certificate) error {
 	l := log.WithFields(
 		log.Fields{
 			"action": "UploadImpervaCert",
@@ -86,8 +120,11 @@ func (s *ImpervaStore) UploadImpervaCert(cert *tlssecret.Certificate) error {
 		},
 	)
 	l.Debugf("UploadImpervaCert")
-	var err error
-	bCert := base64.StdEncoding.EncodeToString(cert.FullChain())
+	chain, err := cert.Chain()
+	if err != nil {
+		return err
+	}
+	bCert := base64.StdEncoding.EncodeToString(chain.PEM(tlssecret.IncludeRoot))
 	bKey := base64.StdEncoding.EncodeToString(cert.Key)
 	c := http.Client{}
 	iurl := "https://my.imperva.com/api/prov/v2/sites/" + s.SiteID + "/customCertificate"
@@ -102,7 +139,7 @@ func (s *ImpervaStore) UploadImpervaCert(cert *tlssecret.Certificate) error {
 		return fmt.Errorf("failed to marshal Imperva certificate upload request: %w", err)
 	}
 	l.Debugf("url=%s data=%s", iurl, string(jd))
-	req, rerr := http.NewRequest("PUT", iurl, strings.NewReader(string(jd)))
+	req, rerr := http.NewRequestWithContext(ctx, "PUT", iurl, strings.NewReader(string(jd)))
 	if rerr != nil {
 		l.WithError(rerr).Errorf("http.NewRequest error")
 		return fmt.Errorf("failed to create Imperva API request for site %s: %w", s.SiteID, rerr)
@@ -123,7 +160,7 @@ func (s *ImpervaStore) UploadImpervaCert(cert *tlssecret.Certificate) error {
 	}
 	if res.StatusCode != 200 {
 		l.Debugf("status=%v body=%s", res.StatusCode, string(bd))
-		return fmt.Errorf("imperva upload failed for site %s (status: %d, secret: %s/%s): %s", s.SiteID, res.StatusCode, s.SecretNamespace, s.SecretName, string(bd))
+		return syncerrors.FromHTTPStatus(res.StatusCode, fmt.Errorf("imperva upload failed for site %s (status: %d, %s): %s", s.SiteID, res.StatusCode, s.credentialRef(), string(bd)))
 	}
 	ir := &ImpervaResponse{}
 	if err = json.Unmarshal(bd, ir); err != nil {
@@ -135,13 +172,13 @@ func (s *ImpervaStore) UploadImpervaCert(cert *tlssecret.Certificate) error {
 	l.Debugf("imperva statusCode=%d response=%v", res.StatusCode, string(bd))
 	if ir.Res != 0 {
 		l.Debugf("status=%v body=%s", res.StatusCode, string(bd))
-		return fmt.Errorf("imperva upload failed for site %s (secret: %s/%s): %s", s.SiteID, s.SecretNamespace, s.SecretName, string(bd))
+		return fmt.Errorf("imperva upload failed for site %s (%s): %s", s.SiteID, s.credentialRef(), string(bd))
 	}
 	l.Debugf("imperva response=%v", string(bd))
 	return err
 }
 
-func (s *ImpervaStore) GetImpervaSiteStatus() (string, error) {
+func (s *ImpervaStore) GetImpervaSiteStatus(ctx context.Context) (string, error) {
 	l := log.WithFields(
 		log.Fields{
 			"action": "GetImpervaSiteStatus",
@@ -157,7 +194,7 @@ func (s *ImpervaStore) GetImpervaSiteStatus() (string, error) {
 	data.Set("tests", "services")
 	d := strings.NewReader(data.Encode())
 	l.Debugf("url=%s data=%s", iurl, data.Encode())
-	req, rerr := http.NewRequest("POST", iurl, d)
+	req, rerr := http.NewRequestWithContext(ctx, "POST", iurl, d)
 	if rerr != nil {
 		l.WithError(rerr).Errorf("http.NewRequest error")
 		return "", rerr
@@ -190,7 +227,7 @@ func (s *ImpervaStore) GetImpervaSiteStatus() (string, error) {
 	return string(bd), err
 }
 
-func (s *ImpervaStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *ImpervaStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -205,19 +242,18 @@ func (s *ImpervaStore) Sync(c *tlssecret.Certificate) (map[string]string, error)
 	l = l.WithFields(log.Fields{
 		"id": s.SiteID,
 	})
-	ctx := context.Background()
 	if err := s.GetApiKey(ctx); err != nil {
 		l.WithError(err).Errorf("imperva.GetApiKey error")
 		l.WithError(err).Errorf("sync error")
 		return nil, err
 	}
-	bd, err := s.GetImpervaSiteStatus()
+	bd, err := s.GetImpervaSiteStatus(ctx)
 	if err != nil {
 		l.WithError(err).Errorf("imperva.GetImpervaSiteStatus error: %s", bd)
 		l.WithError(err).Errorf("sync error")
 		return nil, err
 	}
-	if err := s.UploadImpervaCert(c); err != nil {
+	if err := s.UploadImpervaCert(ctx, c); err != nil {
 		l.WithError(err).Errorf("imperva.UploadImpervaCert error")
 		l.WithError(err).Errorf("sync error")
 		return nil, err
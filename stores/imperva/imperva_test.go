@@ -1,6 +1,7 @@
 package imperva
 
 import (
+	"context"
 	"testing"
 
 	"github.com/robertlestak/cert-manager-sync/pkg/state"
@@ -40,7 +41,7 @@ func TestImpervaSyncSecretNamespaceDefaulting(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &ImpervaStore{}
-			err := s.FromConfig(tlssecret.GenericSecretSyncConfig{
+			err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{
 				Config: map[string]string{
 					"secret-name": tc.secretName,
 					"site-id":     "site",
@@ -48,7 +49,7 @@ func TestImpervaSyncSecretNamespaceDefaulting(t *testing.T) {
 			})
 			assert.NoError(t, err)
 
-			_, err = s.Sync(&tlssecret.Certificate{
+			_, err = s.Sync(context.Background(), &tlssecret.Certificate{
 				SecretName: "source",
 				Namespace:  "cert-manager",
 			})
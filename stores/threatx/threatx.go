@@ -12,19 +12,36 @@ import (
 	"os"
 	"strings"
 
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type ThreatXStore struct {
-	SecretName      string
-	SecretNamespace string
-	APIToken        string `json:"api_token"`
-	CustomerName    string `json:"customer_name"`
-	AuthToken       string `json:"auth_token"`
-	Hostname        string `json:"hostname"`
+	SecretName        string
+	SecretNamespace   string
+	Credential        string
+	ClusterCredential string
+	APIToken          string `json:"api_token"`
+	CustomerName      string `json:"customer_name"`
+	AuthToken         string `json:"auth_token"`
+	Hostname          string `json:"hostname"`
+}
+
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "threatx",
+	Keys: []storeconfig.Key{
+		{Name: "hostname", Required: true, Description: "ThreatX site hostname the certificate is attached to."},
+		{Name: "secret-name", Required: true, SecretRef: true, Description: "Secret holding api_token and customer_name."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *ThreatXStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
 }
 
 type ThreatXSite struct {
@@ -69,19 +86,29 @@ type ThreatXGetRequest struct {
 	Name         string `json:"name"`
 }
 
+// credentialRef returns the store's reference to its credentials.
+func (s *ThreatXStore) credentialRef() credentials.Ref {
+	return credentials.Ref{
+		Store:             "threatx",
+		SecretName:        s.SecretName,
+		SecretNamespace:   s.SecretNamespace,
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
+}
+
 func (s *ThreatXStore) GetAPIKey(ctx context.Context) error {
 	l := log.WithFields(log.Fields{
 		"func":    "ThreatXStore.GetAPIKey",
 		"context": ctx,
 	})
 	l.Debug("start")
-	gopt := metav1.GetOptions{}
-	sc, err := state.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, s.SecretName, gopt)
+	creds, err := credentials.Resolve(ctx, s.credentialRef())
 	if err != nil {
-		return fmt.Errorf("failed to get ThreatX credentials secret %s/%s: %w", s.SecretNamespace, s.SecretName, err)
+		return fmt.Errorf("failed to get ThreatX credentials from %s: %w", s.credentialRef(), err)
 	}
-	s.APIToken = string(sc.Data["api_token"])
-	s.CustomerName = string(sc.Data["customer_name"])
+	s.APIToken = creds.Get("api_token")
+	s.CustomerName = creds.Get("customer_name")
 	l.Debug("end")
 	return nil
 }
@@ -111,7 +138,7 @@ func (s *ThreatXStore) ThreatxLogin(ctx context.Context) error {
 	}
 	l.Debugf("request=%s", string(jd))
 	c := &http.Client{}
-	req, err := http.NewRequest("POST", threatxApiBase()+"/v1/login", bytes.NewBuffer(jd))
+	req, err := http.NewRequestWithContext(ctx, "POST", threatxApiBase()+"/v1/login", bytes.NewBuffer(jd))
 	if err != nil {
 		l.Error(err)
 		return fmt.Errorf("failed to create ThreatX login request: %w", err)
@@ -124,7 +151,7 @@ func (s *ThreatXStore) ThreatxLogin(ctx context.Context) error {
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		l.Error(err)
-		return fmt.Errorf("ThreatX authentication failed with status %d", resp.StatusCode)
+		return syncerrors.FromHTTPStatus(resp.StatusCode, fmt.Errorf("ThreatX authentication failed with status %d", resp.StatusCode))
 	}
 	bd, berr := io.ReadAll(resp.Body)
 	if berr != nil {
@@ -174,7 +201,7 @@ func (s *ThreatXStore) GetSite(ctx context.Context) (ThreatXSite, error) {
 	}
 	l.Debugf("request=%s", string(jd))
 	c := &http.Client{}
-	req, err := http.NewRequest("POST", threatxApiBase()+"/v2/sites", bytes.NewBuffer(jd))
+	req, err := http.NewRequestWithContext(ctx, "POST", threatxApiBase()+"/v2/sites", bytes.NewBuffer(jd))
 	if err != nil {
 		l.Error(err)
 		return tx, err
@@ -241,7 +268,7 @@ func (s *ThreatXStore) UpdateSite(ctx context.Context, tx ThreatXSite) error {
 	}
 	l.Debugf("request=%s", string(jd))
 	c := &http.Client{}
-	req, err := http.NewRequest("POST", threatxApiBase()+"/v2/sites", bytes.NewBuffer(jd))
+	req, err := http.NewRequestWithContext(ctx, "POST", threatxApiBase()+"/v2/sites", bytes.NewBuffer(jd))
 	if err != nil {
 		l.Error(err)
 		return fmt.Errorf("failed to create ThreatX site update request for hostname %s: %w", s.Hostname, err)
@@ -261,13 +288,13 @@ func (s *ThreatXStore) UpdateSite(ctx context.Context, tx ThreatXSite) error {
 	if resp.StatusCode != 200 {
 		l.Errorf("response=%d", resp.StatusCode)
 		l.Errorf("response_body=%s", bd)
-		return fmt.Errorf("ThreatX site update failed for hostname %s (status: %d): %s", s.Hostname, resp.StatusCode, string(bd))
+		return syncerrors.FromHTTPStatus(resp.StatusCode, fmt.Errorf("ThreatX site update failed for hostname %s (status: %d): %s", s.Hostname, resp.StatusCode, string(bd)))
 	}
 	l.Debugf("response=%s", string(bd))
 	return nil
 }
 
-func (s *ThreatXStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *ThreatXStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"func": "ThreatXStore.FromConfig",
 	})
@@ -275,6 +302,12 @@ func (s *ThreatXStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["secret-name"] != "" {
 		s.SecretName = c.Config["secret-name"]
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	if c.Config["hostname"] != "" {
 		s.Hostname = c.Config["hostname"]
 	}
@@ -285,7 +318,7 @@ func (s *ThreatXStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	return nil
 }
 
-func (s *ThreatXStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+func (s *ThreatXStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	if s.SecretNamespace == "" {
 		s.SecretNamespace = c.Namespace
 	}
@@ -299,7 +332,6 @@ func (s *ThreatXStore) Sync(c *tlssecret.Certificate) (map[string]string, error)
 	l = l.WithFields(log.Fields{
 		"id": s.Hostname,
 	})
-	ctx := context.Background()
 	if err := s.GetAPIKey(ctx); err != nil {
 		l.Error(err)
 		return nil, err
@@ -313,7 +345,12 @@ func (s *ThreatXStore) Sync(c *tlssecret.Certificate) (map[string]string, error)
 		l.Error(err)
 		return nil, err
 	}
-	site.SslBlob = string(c.FullChain()) + string(c.Key)
+	chain, err := c.Chain()
+	if err != nil {
+		l.Error(err)
+		return nil, err
+	}
+	site.SslBlob = string(chain.PEM(tlssecret.IncludeRoot)) + string(c.Key)
 	site.SslEnabled = true
 	if err := s.UpdateSite(ctx, site); err != nil {
 		l.WithError(err).Error("sync error")
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/hashicorp/vault/api"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -59,6 +60,12 @@ func TestIsVaultNotFound(t *testing.T) {
 	assert.False(t, isVaultNotFound(&api.ResponseError{StatusCode: 500}))
 }
 
+func TestClassify(t *testing.T) {
+	assert.Equal(t, syncerrors.Auth, syncerrors.Classify(classify(&wrappedErr{err: &api.ResponseError{StatusCode: 403}})))
+	assert.Equal(t, syncerrors.Transient, syncerrors.Classify(classify(&api.ResponseError{StatusCode: 503})))
+	assert.Equal(t, syncerrors.Unknown, syncerrors.Classify(classify(errors.New("plain error"))))
+}
+
 type wrappedErr struct{ err error }
 
 func (w *wrappedErr) Error() string { return w.err.Error() }
@@ -12,10 +12,11 @@ import (
 	"strings"
 
 	"github.com/hashicorp/vault/api"
-	"github.com/robertlestak/cert-manager-sync/pkg/state"
+	"github.com/robertlestak/cert-manager-sync/pkg/credentials"
+	"github.com/robertlestak/cert-manager-sync/pkg/storeconfig"
+	"github.com/robertlestak/cert-manager-sync/pkg/syncerrors"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"software.sslmate.com/src/go-pkcs12"
 )
 
@@ -30,17 +31,42 @@ type VaultStore struct {
 	PKCS12PassSecret          string      // Name of the secret containing the password
 	PKCS12PassSecretKey       string      // Key in the secret containing the password
 	PKCS12PassSecretNamespace string      // Namespace of the secret containing the password
+	Credential                string      // StoreCredential holding the password, when no secret is named
+	ClusterCredential         string      // ClusterStoreCredential holding the password, when no secret is named
 	KubeToken                 string      // auto-filled
 	Client                    *api.Client // auto-filled
 	Token                     string      // auto-filled
 }
 
+// Schema describes the configuration keys the store reads.
+var Schema = storeconfig.Schema{
+	Store: "vault",
+	Keys: []storeconfig.Key{
+		{Name: "addr", Required: true, Endpoint: true, Description: "Vault address."},
+		{Name: "path", Required: true, Description: "KV path the certificate is written to."},
+		{Name: "namespace", Description: "Vault Enterprise namespace."},
+		{Name: "role", Description: "Role used to log in with the Kubernetes auth method."},
+		{Name: "auth-method", Description: "Mount path of the Kubernetes auth method."},
+		{Name: "base64-decode", Type: storeconfig.Bool, Default: "false", Description: "Write the PEM data as strings rather than base64-encoded bytes."},
+		{Name: "b64dec", Type: storeconfig.Bool, Default: "false", Description: "Alias of base64-decode."},
+		{Name: "pkcs12", Type: storeconfig.Bool, Default: "false", Description: "Also write the certificate as a PKCS#12 bundle."},
+		{Name: "pkcs12-password-secret", SecretRef: true, Description: "Secret holding the PKCS#12 password. Defaults to the secret of the credential profile; a random password is generated without either."},
+		{Name: "pkcs12-password-secret-key", Default: "password", Description: "Key of the password in pkcs12-password-secret."},
+		{Name: "pkcs12-password-secret-namespace", Description: "Namespace of pkcs12-password-secret. Defaults to the secret's namespace."},
+	},
+}
+
+// ConfigSchema returns the configuration keys the store reads.
+func (s *VaultStore) ConfigSchema() *storeconfig.Schema {
+	return &Schema
+}
+
 func kubeToken() string {
 	return cmp.Or(os.Getenv("KUBE_TOKEN"), "/var/run/secrets/kubernetes.io/serviceaccount/token")
 }
 
 // NewClients creates and returns a new vault client with a valid token or error
-func (s *VaultStore) NewClient() (*api.Client, error) {
+func (s *VaultStore) NewClient(ctx context.Context) (*api.Client, error) {
 	l := log.WithFields(log.Fields{
 		"vaultAddr": s.Addr,
 		"action":    "vault.NewClient",
@@ -68,7 +94,7 @@ func (s *VaultStore) NewClient() (*api.Client, error) {
 		}
 		s.KubeToken = string(fd)
 	}
-	_, terr := s.NewToken()
+	_, terr := s.NewToken(ctx)
 	if terr != nil {
 		l.WithError(terr).Errorf("vault.NewClient error")
 		return s.Client, terr
@@ -77,7 +103,7 @@ func (s *VaultStore) NewClient() (*api.Client, error) {
 }
 
 // Login creates a vault token with the k8s auth provider
-func (s *VaultStore) Login() (string, error) {
+func (s *VaultStore) Login(ctx context.Context) (string, error) {
 	l := log.WithFields(log.Fields{
 		"vaultAddr":  s.Addr,
 		"action":     "vault.Login",
@@ -90,7 +116,7 @@ func (s *VaultStore) Login() (string, error) {
 		"jwt":  s.KubeToken,
 	}
 	path := fmt.Sprintf("auth/%s/login", s.AuthMethod)
-	secret, err := s.Client.Logical().Write(path, options)
+	secret, err := s.Client.Logical().WriteWithContext(ctx, path, options)
 	if err != nil {
 		l.WithError(err).Errorf("vault.Login error")
 		return "", fmt.Errorf("failed to authenticate with Vault (addr: %s, role: %s, auth method: %s): %w", s.Addr, s.Role, s.AuthMethod, err)
@@ -103,7 +129,7 @@ func (s *VaultStore) Login() (string, error) {
 
 // NewToken generate a new token for session. If LOCAL env var is set and the token is as well, the login is
 // skipped and the token is used instead.
-func (s *VaultStore) NewToken() (string, error) {
+func (s *VaultStore) NewToken(ctx context.Context) (string, error) {
 	l := log.WithFields(log.Fields{
 		"vaultAddr": s.Addr,
 		"action":    "vault.NewToken",
@@ -116,7 +142,7 @@ func (s *VaultStore) NewToken() (string, error) {
 		return s.Token, nil
 	}
 	l.Debugf("vault.NewToken using login")
-	return s.Login()
+	return s.Login(ctx)
 }
 
 func insertSliceString(a []string, index int, value string) []string {
@@ -129,7 +155,7 @@ func insertSliceString(a []string, index int, value string) []string {
 }
 
 // WriteSecret writes a secret to Vault VaultClient at path p with secret value s
-func (s *VaultStore) WriteSecret(sec map[string]interface{}) (map[string]interface{}, error) {
+func (s *VaultStore) WriteSecret(ctx context.Context, sec map[string]interface{}) (map[string]interface{}, error) {
 	if s == nil {
 		return nil, errors.New("vault client required")
 	}
@@ -159,7 +185,7 @@ func (s *VaultStore) WriteSecret(sec map[string]interface{}) (map[string]interfa
 	vd := map[string]interface{}{
 		"data": sec,
 	}
-	_, err := s.Client.Logical().Write(s.Path, vd)
+	_, err := s.Client.Logical().WriteWithContext(ctx, s.Path, vd)
 	if err != nil {
 		l.WithError(err).Errorf("vault.WriteSecret error")
 		return secrets, fmt.Errorf("failed to write certificate to Vault path %s: %w", s.Path, err)
@@ -167,7 +193,7 @@ func (s *VaultStore) WriteSecret(sec map[string]interface{}) (map[string]interfa
 	return secrets, nil
 }
 
-func (s *VaultStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
+func (s *VaultStore) FromConfig(_ context.Context, c tlssecret.GenericSecretSyncConfig) error {
 	l := log.WithFields(log.Fields{
 		"action": "FromConfig",
 	})
@@ -193,6 +219,12 @@ func (s *VaultStore) FromConfig(c tlssecret.GenericSecretSyncConfig) error {
 	if c.Config["pkcs12"] == "true" {
 		s.PKCS12 = true
 	}
+	if c.Config["credential"] != "" {
+		s.Credential = c.Config["credential"]
+	}
+	if c.Config["cluster-credential"] != "" {
+		s.ClusterCredential = c.Config["cluster-credential"]
+	}
 	// Secret reference for password
 	if c.Config["pkcs12-password-secret"] != "" {
 		s.PKCS12PassSecret = c.Config["pkcs12-password-secret"]
@@ -219,41 +251,51 @@ func writeSecretValue(value []byte, asString bool) any {
 	return value
 }
 
-// getPasswordFromSecret retrieves the PKCS#12 password from a Kubernetes secret
-func (s *VaultStore) getPasswordFromSecret(c *tlssecret.Certificate) (string, error) {
+// credentialRef returns the store's reference to the secret holding the
+// PKCS#12 password: pkcs12-password-secret, or else the credential profile.
+func (s *VaultStore) credentialRef() credentials.Ref {
+	if s.PKCS12PassSecret != "" {
+		return credentials.Ref{
+			Store:           "vault",
+			SecretName:      s.PKCS12PassSecret,
+			SecretNamespace: s.PKCS12PassSecretNamespace,
+		}
+	}
+	return credentials.Ref{
+		Store:             "vault",
+		Credential:        s.Credential,
+		ClusterCredential: s.ClusterCredential,
+	}
+}
+
+// getPasswordFromSecret retrieves the PKCS#12 password from the secret
+// credentialRef names. It returns an empty string when there is none, or the
+// profile has no secretRef.
+func (s *VaultStore) getPasswordFromSecret(ctx context.Context, c *tlssecret.Certificate) (string, error) {
+	ref := s.credentialRef()
 	l := log.WithFields(log.Fields{
-		"action":    "getPasswordFromSecret",
-		"secret":    s.PKCS12PassSecret,
-		"namespace": s.PKCS12PassSecretNamespace,
+		"action":      "getPasswordFromSecret",
+		"credentials": ref.String(),
 	})
 	l.Debug("Retrieving PKCS#12 password from secret")
 
-	// If no secret is specified, return empty string
-	if s.PKCS12PassSecret == "" {
+	if !ref.IsSet() {
+		return "", nil
+	}
+	creds, err := credentials.Resolve(ctx, ref)
+	if errors.Is(err, credentials.ErrNoSecret) {
 		return "", nil
 	}
-
-	// Get the secret from Kubernetes
-	secret, err := state.KubeClient.CoreV1().Secrets(s.PKCS12PassSecretNamespace).Get(
-		context.Background(),
-		s.PKCS12PassSecret,
-		metav1.GetOptions{},
-	)
 	if err != nil {
 		l.WithError(err).Error("Failed to get secret containing PKCS#12 password")
 		return "", err
 	}
-
-	// Get the password from the secret
-	passwordBytes, ok := secret.Data[s.PKCS12PassSecretKey]
-	if !ok {
-		err := fmt.Errorf("key %s not found in secret %s/%s",
-			s.PKCS12PassSecretKey, s.PKCS12PassSecretNamespace, s.PKCS12PassSecret)
+	key := cmp.Or(s.PKCS12PassSecretKey, "password")
+	if err := creds.Require(key); err != nil {
 		l.WithError(err).Error("Failed to get PKCS#12 password from secret")
 		return "", err
 	}
-
-	return string(passwordBytes), nil
+	return creds.Get(key), nil
 }
 
 // convertToPKCS12WithPassword converts PEM certificate and key to PKCS#12 format with the given password
@@ -336,14 +378,14 @@ func (s *VaultStore) convertToPKCS12WithPassword(cert []byte, key []byte, ca []b
 }
 
 // convertToPKCS12 converts PEM certificate and key to PKCS#12 format
-func (s *VaultStore) convertToPKCS12(cert []byte, key []byte, ca []byte, c *tlssecret.Certificate) ([]byte, string, error) {
+func (s *VaultStore) convertToPKCS12(ctx context.Context, cert []byte, key []byte, ca []byte, c *tlssecret.Certificate) ([]byte, string, error) {
 	l := log.WithFields(log.Fields{
 		"action": "convertToPKCS12",
 	})
 	l.Debug("Converting certificate to PKCS#12 format")
 
 	// Try to get password from secret
-	password, err := s.getPasswordFromSecret(c)
+	password, err := s.getPasswordFromSecret(ctx, c)
 	if err != nil {
 		l.WithError(err).Error("Failed to get password from secret")
 		return nil, "", fmt.Errorf("failed to get password from secret: %v", err)
@@ -385,10 +427,10 @@ func (s *VaultStore) Delete(ctx context.Context) error {
 		l.Debug("no vault path configured; nothing to delete")
 		return nil
 	}
-	if _, cerr := s.NewClient(); cerr != nil {
+	if _, cerr := s.NewClient(ctx); cerr != nil {
 		return fmt.Errorf("vault client init failed: %w", cerr)
 	}
-	if _, terr := s.NewToken(); terr != nil {
+	if _, terr := s.NewToken(ctx); terr != nil {
 		return fmt.Errorf("vault auth failed: %w", terr)
 	}
 	apiPath, err := deletePath(s.Path)
@@ -418,7 +460,16 @@ func isVaultNotFound(err error) bool {
 	return false
 }
 
-func (s *VaultStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
+// classify classifies a Vault API error by its HTTP status.
+func classify(err error) error {
+	var re *api.ResponseError
+	if errors.As(err, &re) {
+		return syncerrors.FromHTTPStatus(re.StatusCode, err)
+	}
+	return err
+}
+
+func (s *VaultStore) Sync(ctx context.Context, c *tlssecret.Certificate) (map[string]string, error) {
 	l := log.WithFields(log.Fields{
 		"action":          "Update",
 		"store":           "vault",
@@ -447,15 +498,15 @@ func (s *VaultStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
 		s.PKCS12PassSecretNamespace = c.Namespace
 	}
 
-	_, cerr := s.NewClient()
+	_, cerr := s.NewClient(ctx)
 	if cerr != nil {
 		l.WithError(cerr).Errorf("vault.NewClient error")
 		return nil, cerr
 	}
-	_, err := s.NewToken()
+	_, err := s.NewToken(ctx)
 	if err != nil {
 		l.WithError(err).Errorf("vault.NewToken error")
-		return nil, err
+		return nil, classify(err)
 	}
 
 	cd := map[string]interface{}{}
@@ -470,7 +521,7 @@ func (s *VaultStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
 	// If PKCS#12 is enabled, convert and store the certificate in PKCS#12 format
 	if s.PKCS12 {
 		l.Debug("Converting certificate to PKCS#12 format")
-		pkcs12Data, password, err := s.convertToPKCS12(c.Certificate, c.Key, c.Ca, c)
+		pkcs12Data, password, err := s.convertToPKCS12(ctx, c.Certificate, c.Key, c.Ca, c)
 		if err != nil {
 			l.WithError(err).Errorf("PKCS#12 conversion error")
 			return nil, err
@@ -484,10 +535,10 @@ func (s *VaultStore) Sync(c *tlssecret.Certificate) (map[string]string, error) {
 		}
 	}
 
-	_, err = s.WriteSecret(cd)
+	_, err = s.WriteSecret(ctx, cd)
 	if err != nil {
 		l.WithError(err).Errorf("sync error")
-		return nil, err
+		return nil, classify(err)
 	}
 	l.Info("certificate synced")
 	return nil, nil
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -13,7 +14,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/robertlestak/cert-manager-sync/pkg/apis/v1alpha1"
+	"github.com/robertlestak/cert-manager-sync/pkg/state"
 	"github.com/robertlestak/cert-manager-sync/pkg/tlssecret"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 	"software.sslmate.com/src/go-pkcs12"
 )
 
@@ -495,7 +504,7 @@ func TestFromConfig(t *testing.T) {
 			config := tlssecret.GenericSecretSyncConfig{
 				Config: tt.config,
 			}
-			err := store.FromConfig(config)
+			err := store.FromConfig(context.Background(), config)
 			if err != nil {
 				t.Fatalf("FromConfig() error = %v", err)
 			}
@@ -597,3 +606,53 @@ func TestWriteSecretValue(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPasswordFromSecret(t *testing.T) {
+	kc, dc := state.KubeClient, state.DynamicClient
+	t.Cleanup(func() { state.KubeClient, state.DynamicClient = kc, dc })
+	state.KubeClient = fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pfx", Namespace: "ns"},
+			Data:       map[string][]byte{"password": []byte("direct")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "ns"},
+			Data:       map[string][]byte{"pfx-pass": []byte("from-profile")},
+		},
+	)
+	state.DynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+		"kind":       v1alpha1.StoreCredentialKind,
+		"metadata":   map[string]interface{}{"name": "vault", "namespace": "ns"},
+		"spec": map[string]interface{}{
+			"store":     "vault",
+			"secretRef": map[string]interface{}{"name": "shared"},
+			"keys":      map[string]interface{}{"password": "pfx-pass"},
+		},
+	}})
+
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   string
+	}{
+		{name: "secret", config: map[string]string{"pkcs12-password-secret": "pfx", "pkcs12-password-secret-namespace": "ns"}, want: "direct"},
+		{name: "profile", config: map[string]string{"credential": "ns/vault"}, want: "from-profile"},
+		{name: "none", config: map[string]string{}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &VaultStore{}
+			if err := s.FromConfig(context.Background(), tlssecret.GenericSecretSyncConfig{Config: tt.config}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.getPasswordFromSecret(context.Background(), &tlssecret.Certificate{Namespace: "ns"})
+			if err != nil {
+				t.Fatalf("getPasswordFromSecret() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getPasswordFromSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}